@@ -24,8 +24,30 @@ func ApplyJobDeployerServiceAccount(ctx context.Context, kubeConfigPath string,
 	return nil
 }
 
-func ApplySystemNodeClusterRoleBinding(ctx context.Context, kubeConfigPath string, k8sWrapTransport k8s.WrapTransport) error {
-	log.Infof(ctx, "[authz] Creating system:node ClusterRoleBinding")
+// ApplyKubeletTLSBootstrapClusterRoleBindings lets bootstrap token holders request node client certificates and
+// nodes renew them, new client certificates are only approved by RKE
+func ApplyKubeletTLSBootstrapClusterRoleBindings(ctx context.Context, kubeConfigPath string, k8sWrapTransport k8s.WrapTransport) error {
+	log.Infof(ctx, "[authz] Creating kubelet TLS bootstrap ClusterRoleBindings")
+	k8sClient, err := k8s.NewClient(kubeConfigPath, k8sWrapTransport)
+	if err != nil {
+		return err
+	}
+	for _, clusterRoleBinding := range []string{
+		templates.KubeletBootstrapClusterRoleBinding,
+		templates.KubeletRenewApproveClusterRoleBinding,
+	} {
+		if err := k8s.UpdateClusterRoleBindingFromYaml(k8sClient, clusterRoleBinding); err != nil {
+			return err
+		}
+	}
+	log.Infof(ctx, "[authz] Kubelet TLS bootstrap ClusterRoleBindings created successfully")
+	return nil
+}
+
+// ResetSystemNodeClusterRoleBinding removes the system:nodes group RKE used to bind to the system:node ClusterRole,
+// every kubelet has its own identity and is limited to its node by the Node authorizer
+func ResetSystemNodeClusterRoleBinding(ctx context.Context, kubeConfigPath string, k8sWrapTransport k8s.WrapTransport) error {
+	log.Infof(ctx, "[authz] Resetting system:node ClusterRoleBinding")
 	k8sClient, err := k8s.NewClient(kubeConfigPath, k8sWrapTransport)
 	if err != nil {
 		return err
@@ -33,6 +55,23 @@ func ApplySystemNodeClusterRoleBinding(ctx context.Context, kubeConfigPath strin
 	if err := k8s.UpdateClusterRoleBindingFromYaml(k8sClient, templates.SystemNodeClusterRoleBinding); err != nil {
 		return err
 	}
-	log.Infof(ctx, "[authz] system:node ClusterRoleBinding created successfully")
+	log.Infof(ctx, "[authz] system:node ClusterRoleBinding reset successfully")
+	return nil
+}
+
+// ApplyCNIClusterRoleBinding grants the CNI plugins identity the access they need to set up pod networking
+func ApplyCNIClusterRoleBinding(ctx context.Context, kubeConfigPath string, k8sWrapTransport k8s.WrapTransport) error {
+	log.Infof(ctx, "[authz] Creating CNI ClusterRole and ClusterRoleBinding")
+	k8sClient, err := k8s.NewClient(kubeConfigPath, k8sWrapTransport)
+	if err != nil {
+		return err
+	}
+	if err := k8s.UpdateClusterRoleFromYaml(k8sClient, templates.CNIClusterRole); err != nil {
+		return err
+	}
+	if err := k8s.UpdateClusterRoleBindingFromYaml(k8sClient, templates.CNIClusterRoleBinding); err != nil {
+		return err
+	}
+	log.Infof(ctx, "[authz] CNI ClusterRole and ClusterRoleBinding created successfully")
 	return nil
 }
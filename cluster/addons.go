@@ -10,6 +10,7 @@ import (
 
 	"io/ioutil"
 	"net/http"
+	"regexp"
 	"strings"
 
 	"github.com/rancher/rke/addons"
@@ -226,6 +227,38 @@ func isFilePath(addonPath string) bool {
 	return true
 }
 
+// addonImageRegexp matches the image fields of the addon manifests
+var addonImageRegexp = regexp.MustCompile(`(?m)^[\s-]*image:\s*["']?([^\s"'#]+)`)
+
+// GetUserAddonImages returns the images used by the user addons and the included addons
+func (c *Cluster) GetUserAddonImages() ([]string, error) {
+	manifests := []string{c.Addons}
+	for _, addon := range c.AddonsInclude {
+		if strings.HasPrefix(addon, "http") {
+			addonYAML, err := getAddonFromURL(addon)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to get addon from url %s: %v", addon, err)
+			}
+			manifests = append(manifests, string(addonYAML))
+		} else if isFilePath(addon) {
+			addonYAML, err := ioutil.ReadFile(addon)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, string(addonYAML))
+		} else {
+			logrus.Warnf("[addons] Unable to determine if %s is a file path or url, skipping", addon)
+		}
+	}
+	images := []string{}
+	for _, manifest := range manifests {
+		for _, match := range addonImageRegexp.FindAllStringSubmatch(manifest, -1) {
+			images = append(images, match[1])
+		}
+	}
+	return images, nil
+}
+
 func getAddonFromURL(yamlURL string) ([]byte, error) {
 	resp, err := http.Get(yamlURL)
 
@@ -342,6 +375,13 @@ func (c *Cluster) deployWithKubectl(ctx context.Context, addonYaml string) error
 }
 
 func (c *Cluster) doAddonDeploy(ctx context.Context, addonYaml, resourceName string, isCritical bool) error {
+	done := log.Track(ctx, log.Event{Phase: log.PhaseAddons, Component: resourceName, Action: log.ActionDeploy})
+	err := c.doDeployAddon(ctx, addonYaml, resourceName, isCritical)
+	done(err)
+	return err
+}
+
+func (c *Cluster) doDeployAddon(ctx context.Context, addonYaml, resourceName string, isCritical bool) error {
 	if c.UseKubectlDeploy {
 		if err := c.deployWithKubectl(ctx, addonYaml); err != nil {
 			return &addonError{fmt.Sprintf("%v", err), isCritical}
@@ -2,15 +2,18 @@ package cluster
 
 import (
 	"context"
-	"crypto/rsa"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/k8s"
 	"github.com/rancher/rke/log"
 	"github.com/rancher/rke/pki"
 	"github.com/rancher/rke/services"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
 	"github.com/sirupsen/logrus"
 	"k8s.io/client-go/util/cert"
 )
@@ -30,7 +33,7 @@ func regenerateAPICertificate(c *Cluster, certificates map[string]pki.Certificat
 	caCrt := certificates[pki.CACertName].Certificate
 	caKey := certificates[pki.CACertName].Key
 	kubeAPIKey := certificates[pki.KubeAPICertName].Key
-	kubeAPICert, _, err := pki.GenerateSignedCertAndKey(caCrt, caKey, true, pki.KubeAPICertName, kubeAPIAltNames, kubeAPIKey, nil)
+	kubeAPICert, _, err := pki.GenerateSignedCertAndKey(caCrt, caKey, true, pki.KubeAPICertName, kubeAPIAltNames, kubeAPIKey, pki.GetComponentKeyAlgorithm(c.RancherKubernetesEngineConfig), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -86,7 +89,7 @@ func GetClusterCertsFromKubernetes(ctx context.Context, kubeCluster *Cluster) (m
 		if err != nil {
 			return nil, fmt.Errorf("Failed to parse certificate of %s: %v", certName, err)
 		}
-		secretKey, err := cert.ParsePrivateKeyPEM(secret.Data["Key"])
+		secretKey, err := pki.ParsePrivateKeyPEM(secret.Data["Key"])
 		if err != nil {
 			return nil, fmt.Errorf("Failed to parse private key of %s: %v", certName, err)
 		}
@@ -95,11 +98,11 @@ func GetClusterCertsFromKubernetes(ctx context.Context, kubeCluster *Cluster) (m
 			return nil, fmt.Errorf("certificate or key of %s is not found", certName)
 		}
 		certificatePEM := string(cert.EncodeCertPEM(secretCert[0]))
-		keyPEM := string(cert.EncodePrivateKeyPEM(secretKey.(*rsa.PrivateKey)))
+		keyPEM := string(pki.EncodePrivateKeyPEM(secretKey))
 
 		certMap[certName] = pki.CertificatePKI{
 			Certificate:    secretCert[0],
-			Key:            secretKey.(*rsa.PrivateKey),
+			Key:            secretKey,
 			CertificatePEM: certificatePEM,
 			KeyPEM:         keyPEM,
 			Config:         secretConfig,
@@ -134,7 +137,7 @@ func (c *Cluster) getBackupHosts() []*hosts.Host {
 
 func regenerateAPIAggregationCerts(c *Cluster, certificates map[string]pki.CertificatePKI) (map[string]pki.CertificatePKI, error) {
 	logrus.Debugf("[certificates] Regenerating Kubernetes API server aggregation layer requestheader client CA certificates")
-	requestHeaderCACrt, requestHeaderCAKey, err := pki.GenerateCACertAndKey(pki.RequestHeaderCACertName, nil)
+	requestHeaderCACrt, requestHeaderCAKey, err := pki.GenerateCACertAndKey(pki.RequestHeaderCACertName, nil, pki.GetCAKeyAlgorithm(c.RancherKubernetesEngineConfig))
 	if err != nil {
 		return nil, err
 	}
@@ -142,7 +145,7 @@ func regenerateAPIAggregationCerts(c *Cluster, certificates map[string]pki.Certi
 
 	//generate API server proxy client key and certs
 	logrus.Debugf("[certificates] Regenerating Kubernetes API server proxy client certificates")
-	apiserverProxyClientCrt, apiserverProxyClientKey, err := pki.GenerateSignedCertAndKey(requestHeaderCACrt, requestHeaderCAKey, true, pki.APIProxyClientCertName, nil, nil, nil)
+	apiserverProxyClientCrt, apiserverProxyClientKey, err := pki.GenerateSignedCertAndKey(requestHeaderCACrt, requestHeaderCAKey, true, pki.APIProxyClientCertName, nil, nil, pki.GetComponentKeyAlgorithm(c.RancherKubernetesEngineConfig), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -154,25 +157,41 @@ func RotateRKECertificates(ctx context.Context, c *Cluster, flags ExternalFlags,
 	var (
 		serviceAccountTokenKey string
 	)
-	componentsCertsFuncMap := map[string]pki.GenFunc{
-		services.KubeAPIContainerName:        pki.GenerateKubeAPICertificate,
-		services.KubeControllerContainerName: pki.GenerateKubeControllerCertificate,
-		services.SchedulerContainerName:      pki.GenerateKubeSchedulerCertificate,
-		services.KubeproxyContainerName:      pki.GenerateKubeProxyCertificate,
-		services.KubeletContainerName:        pki.GenerateKubeNodeCertificate,
-		services.EtcdContainerName:           pki.GenerateEtcdCertificates,
+	componentsCertsFuncMap := map[string][]pki.GenFunc{
+		services.KubeAPIContainerName:        []pki.GenFunc{pki.GenerateKubeAPICertificate},
+		services.KubeControllerContainerName: []pki.GenFunc{pki.GenerateKubeControllerCertificate},
+		services.SchedulerContainerName:      []pki.GenFunc{pki.GenerateKubeSchedulerCertificate},
+		services.KubeproxyContainerName:      []pki.GenFunc{pki.GenerateKubeProxyCertificate},
+		services.KubeletContainerName:        []pki.GenFunc{pki.GenerateKubeNodeCertificate, pki.GenerateKubeletCertificates, pki.GenerateKubeCNICertificate},
+		services.EtcdContainerName:           []pki.GenFunc{pki.GenerateEtcdCertificates},
 	}
 	rotateFlags := c.RancherKubernetesEngineConfig.RotateCertificates
+	if len(rotateFlags.Nodes) > 0 {
+		if IsKubeletTLSBootstrapEnabled(&c.RancherKubernetesEngineConfig) {
+			return fmt.Errorf("Kubelets with TLS bootstrap rotate their own certificates")
+		}
+		// rotate the kubelet certificates of the given nodes only
+		for _, address := range rotateFlags.Nodes {
+			node, err := getNodeByAddress(c.Nodes, address)
+			if err != nil {
+				return err
+			}
+			if err := pki.GenerateKubeletCertificate(ctx, c.Certificates, c.RancherKubernetesEngineConfig, node, true); err != nil {
+				return err
+			}
+		}
+		clusterState.DesiredState.CertificatesBundle = c.Certificates
+		return nil
+	}
 	if rotateFlags.CACertificates {
 		// rotate CA cert and RequestHeader CA cert
-		if err := pki.GenerateRKECACerts(ctx, c.Certificates, flags.ClusterFilePath, flags.ConfigDir); err != nil {
+		if err := pki.GenerateRKECACerts(ctx, c.Certificates, c.RancherKubernetesEngineConfig, flags.ClusterFilePath, flags.ConfigDir); err != nil {
 			return err
 		}
 		rotateFlags.Services = nil
 	}
 	for _, k8sComponent := range rotateFlags.Services {
-		genFunc := componentsCertsFuncMap[k8sComponent]
-		if genFunc != nil {
+		for _, genFunc := range componentsCertsFuncMap[k8sComponent] {
 			if err := genFunc(ctx, c.Certificates, c.RancherKubernetesEngineConfig, flags.ClusterFilePath, flags.ConfigDir, true); err != nil {
 				return err
 			}
@@ -182,13 +201,13 @@ func RotateRKECertificates(ctx context.Context, c *Cluster, flags ExternalFlags,
 	if len(rotateFlags.Services) == 0 || (len(rotateFlags.Services) == 1 && rotateFlags.Services[0] == "") {
 		// do not rotate service account token
 		if c.Certificates[pki.ServiceAccountTokenKeyName].Key != nil {
-			serviceAccountTokenKey = string(cert.EncodePrivateKeyPEM(c.Certificates[pki.ServiceAccountTokenKeyName].Key))
+			serviceAccountTokenKey = string(pki.EncodePrivateKeyPEM(c.Certificates[pki.ServiceAccountTokenKeyName].Key))
 		}
 		if err := pki.GenerateRKEServicesCerts(ctx, c.Certificates, c.RancherKubernetesEngineConfig, flags.ClusterFilePath, flags.ConfigDir, true); err != nil {
 			return err
 		}
 		if serviceAccountTokenKey != "" {
-			privateKey, err := cert.ParsePrivateKeyPEM([]byte(serviceAccountTokenKey))
+			privateKey, err := pki.ParsePrivateKeyPEM([]byte(serviceAccountTokenKey))
 			if err != nil {
 				return err
 			}
@@ -197,7 +216,7 @@ func RotateRKECertificates(ctx context.Context, c *Cluster, flags ExternalFlags,
 				pki.ServiceAccountTokenKeyName,
 				"",
 				c.Certificates[pki.ServiceAccountTokenKeyName].Certificate,
-				privateKey.(*rsa.PrivateKey), nil)
+				privateKey, nil)
 		}
 	}
 	clusterState.DesiredState.CertificatesBundle = c.Certificates
@@ -241,5 +260,136 @@ func compareCerts(ctx context.Context, kubeCluster, currentCluster *Cluster) {
 				return
 			}
 		}
+		// kubelet certificates are regenerated when the node is renamed
+		for _, node := range kubeCluster.Nodes {
+			certName := pki.GetKubeletCrtName(node.Address)
+			currentCert := currentCluster.Certificates[certName]
+			desiredCert := kubeCluster.Certificates[certName]
+			if currentCert.Certificate != nil && desiredCert.CertificatePEM != currentCert.CertificatePEM {
+				log.Infof(ctx, "[certificates] %s certificate changed, force deploying certs", certName)
+				kubeCluster.ForceDeployCerts = true
+				return
+			}
+		}
+	}
+}
+
+// ParseCertificateExpiryThreshold parses an expiry threshold given in days (example, 30d) or as a duration (example, 720h)
+func ParseCertificateExpiryThreshold(threshold string) (time.Duration, error) {
+	if strings.HasSuffix(threshold, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(threshold, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("Invalid certificate expiry threshold [%s]", threshold)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(threshold)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("Invalid certificate expiry threshold [%s]", threshold)
+	}
+	return duration, nil
+}
+
+// GetExpiringCertificates returns the names of the certificates in the bundle that expire within threshold
+func GetExpiringCertificates(certs map[string]pki.CertificatePKI, threshold time.Duration) []string {
+	expiring := []string{}
+	deadline := time.Now().Add(threshold)
+	for certName, certificate := range certs {
+		// the service account token is a key, its certificate is the kube-apiserver one
+		if certName == pki.ServiceAccountTokenKeyName || certificate.Certificate == nil {
+			continue
+		}
+		if certificate.Certificate.NotAfter.Before(deadline) {
+			expiring = append(expiring, certName)
+		}
+	}
+	sort.Strings(expiring)
+	return expiring
+}
+
+// SetAutoRotateCertificates turns rotate_certificates.auto_if_expiring_within into a rotation of the services with
+// certificates expiring within the threshold, or into no rotation at all when none are. Explicit rotations are left as is.
+func SetAutoRotateCertificates(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfig, fullState *FullState) error {
+	rotateFlags := rkeConfig.RotateCertificates
+	if rotateFlags == nil || len(rotateFlags.AutoIfExpiringWithin) == 0 || rotateFlags.CACertificates || len(rotateFlags.Services) > 0 || len(rotateFlags.Nodes) > 0 {
+		return nil
+	}
+	threshold, err := ParseCertificateExpiryThreshold(rotateFlags.AutoIfExpiringWithin)
+	if err != nil {
+		return fmt.Errorf("Failed to parse rotate_certificates.auto_if_expiring_within: %v", err)
+	}
+	rkeConfig.RotateCertificates = nil
+	if fullState == nil || fullState.DesiredState.CertificatesBundle == nil {
+		return nil
+	}
+	expiring := GetExpiringCertificates(fullState.DesiredState.CertificatesBundle, threshold)
+	if len(expiring) == 0 {
+		logrus.Debugf("[certificates] No certificates expire within [%s]", rotateFlags.AutoIfExpiringWithin)
+		return nil
+	}
+	servicesMap := map[string]bool{}
+	rotateAll := false
+	for _, certName := range expiring {
+		switch {
+		case certName == pki.CACertName || certName == pki.RequestHeaderCACertName:
+			log.Warnf(ctx, "[certificates] CA certificate [%s] expires within [%s], it's only rotated with 'rke cert rotate --rotate-ca'", certName, rotateFlags.AutoIfExpiringWithin)
+		case certName == pki.EtcdClientCACertName || certName == pki.EtcdClientCertName:
+			log.Warnf(ctx, "[certificates] External etcd certificate [%s] expires within [%s], it has to be replaced in the cluster file", certName, rotateFlags.AutoIfExpiringWithin)
+		default:
+			service := getCertificateService(certName)
+			if len(service) == 0 {
+				// certificates that don't belong to a single service are only rotated with all service certificates
+				rotateAll = true
+				continue
+			}
+			servicesMap[service] = true
+		}
+		log.Infof(ctx, "[certificates] Certificate [%s] expires within [%s]", certName, rotateFlags.AutoIfExpiringWithin)
+	}
+	if !rotateAll && len(servicesMap) == 0 {
+		return nil
+	}
+	rkeConfig.RotateCertificates = &v3.RotateCertificates{
+		AutoIfExpiringWithin: rotateFlags.AutoIfExpiringWithin,
+	}
+	if !rotateAll {
+		for service := range servicesMap {
+			rkeConfig.RotateCertificates.Services = append(rkeConfig.RotateCertificates.Services, service)
+		}
+		sort.Strings(rkeConfig.RotateCertificates.Services)
+	}
+	log.Infof(ctx, "[certificates] Rotating certificates of services %v", rkeConfig.RotateCertificates.Services)
+	return nil
+}
+
+// getCertificateService returns the service a certificate is rotated with, or an empty string if it isn't specific to one
+func getCertificateService(certName string) string {
+	switch certName {
+	case pki.KubeAPICertName:
+		return services.KubeAPIContainerName
+	case pki.KubeControllerCertName:
+		return services.KubeControllerContainerName
+	case pki.KubeSchedulerCertName:
+		return services.SchedulerContainerName
+	case pki.KubeProxyCertName:
+		return services.KubeproxyContainerName
+	case pki.KubeNodeCertName, pki.KubeCNICertName:
+		return services.KubeletContainerName
+	}
+	if strings.HasPrefix(certName, pki.KubeletCertName) {
+		return services.KubeletContainerName
+	}
+	if strings.HasPrefix(certName, pki.EtcdCertName) {
+		return services.EtcdContainerName
+	}
+	return ""
+}
+
+func getNodeByAddress(nodes []v3.RKEConfigNode, address string) (v3.RKEConfigNode, error) {
+	for _, node := range nodes {
+		if node.Address == address {
+			return node, nil
+		}
 	}
+	return v3.RKEConfigNode{}, fmt.Errorf("Node [%s] is not found in the cluster file", address)
 }
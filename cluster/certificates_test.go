@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"context"
+	"crypto/x509"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rancher/rke/pki"
+	"github.com/rancher/rke/services"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+func TestSetAutoRotateCertificates(t *testing.T) {
+	now := time.Now()
+	newCert := func(notAfter time.Time) pki.CertificatePKI {
+		return pki.CertificatePKI{Certificate: &x509.Certificate{NotAfter: notAfter}}
+	}
+	tests := []struct {
+		name             string
+		certs            map[string]pki.CertificatePKI
+		expectedExpiring []string
+		expectedRotate   *v3.RotateCertificates
+	}{
+		{
+			name: "nothing expiring",
+			certs: map[string]pki.CertificatePKI{
+				pki.CACertName:        newCert(now.Add(10 * 365 * 24 * time.Hour)),
+				pki.KubeProxyCertName: newCert(now.Add(365 * 24 * time.Hour)),
+			},
+			expectedExpiring: []string{},
+			expectedRotate:   nil,
+		},
+		{
+			name: "expired and expiring worker certificates",
+			certs: map[string]pki.CertificatePKI{
+				pki.CACertName:                   newCert(now.Add(10 * 365 * 24 * time.Hour)),
+				pki.KubeAPICertName:              newCert(now.Add(365 * 24 * time.Hour)),
+				pki.KubeProxyCertName:            newCert(now.Add(-time.Hour)),
+				pki.GetKubeletCrtName("2.2.2.2"): newCert(now.Add(5 * 24 * time.Hour)),
+				pki.ServiceAccountTokenKeyName:   newCert(now.Add(-time.Hour)),
+				pki.EtcdClientCertName:           {},
+			},
+			expectedExpiring: []string{pki.GetKubeletCrtName("2.2.2.2"), pki.KubeProxyCertName},
+			expectedRotate: &v3.RotateCertificates{
+				AutoIfExpiringWithin: "30d",
+				Services:             []string{services.KubeproxyContainerName, services.KubeletContainerName},
+			},
+		},
+		{
+			name: "expiring certificate of all services",
+			certs: map[string]pki.CertificatePKI{
+				pki.KubeAdminCertName: newCert(now.Add(24 * time.Hour)),
+				pki.KubeProxyCertName: newCert(now.Add(24 * time.Hour)),
+			},
+			expectedExpiring: []string{pki.KubeAdminCertName, pki.KubeProxyCertName},
+			expectedRotate:   &v3.RotateCertificates{AutoIfExpiringWithin: "30d"},
+		},
+	}
+	for _, test := range tests {
+		if expiring := GetExpiringCertificates(test.certs, 30*24*time.Hour); !reflect.DeepEqual(expiring, test.expectedExpiring) {
+			t.Errorf("[%s] expected expiring certificates %v, got %v", test.name, test.expectedExpiring, expiring)
+		}
+		rkeConfig := &v3.RancherKubernetesEngineConfig{RotateCertificates: &v3.RotateCertificates{AutoIfExpiringWithin: "30d"}}
+		fullState := &FullState{DesiredState: State{CertificatesBundle: test.certs}}
+		if err := SetAutoRotateCertificates(context.Background(), rkeConfig, fullState); err != nil {
+			t.Fatalf("[%s] Failed to set certificate rotation: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(rkeConfig.RotateCertificates, test.expectedRotate) {
+			t.Errorf("[%s] expected rotation %+v, got %+v", test.name, test.expectedRotate, rkeConfig.RotateCertificates)
+		}
+	}
+}
@@ -43,13 +43,17 @@ type Cluster struct {
 	EtcdHosts                        []*hosts.Host
 	EtcdReadyHosts                   []*hosts.Host
 	ForceDeployCerts                 bool
+	HostKeyVerifier                  *hosts.HostKeyVerifier
 	InactiveHosts                    []*hosts.Host
 	K8sWrapTransport                 k8s.WrapTransport
 	KubeClient                       *kubernetes.Clientset
+	KubeletBootstrapTokens           map[string]string
 	KubernetesServiceIP              net.IP
 	LocalKubeConfigPath              string
 	LocalConnDialerFactory           hosts.DialerFactory
 	PrivateRegistriesMap             map[string]v3.PrivateRegistry
+	SSHConfig                        *hosts.SSHConfig
+	SecretsEncryptionKeys            []SecretsEncryptionKey
 	StateFilePath                    string
 	UpdateWorkersOnly                bool
 	UseKubectlDeploy                 bool
@@ -120,6 +124,15 @@ func (c *Cluster) DeployControlPlane(ctx context.Context) error {
 }
 
 func (c *Cluster) DeployWorkerPlane(ctx context.Context) error {
+	// kubelets with TLS bootstrap need their token before they start
+	if err := c.deployKubeletBootstrapToken(ctx); err != nil {
+		return err
+	}
+	stopKubeletCSRApprover, err := c.startKubeletCSRApprover(ctx)
+	if err != nil {
+		return err
+	}
+	defer stopKubeletCSRApprover()
 	// Deploy Worker plane
 	workerNodePlanMap := make(map[string]v3.RKEConfigNodePlan)
 	// Build cp node plan map
@@ -127,6 +140,23 @@ func (c *Cluster) DeployWorkerPlane(ctx context.Context) error {
 	for _, workerHost := range allHosts {
 		workerNodePlanMap[workerHost.Address] = BuildRKEConfigNodePlan(ctx, c, workerHost, workerHost.DockerInfo)
 	}
+	if c.UpgradeStrategy != nil {
+		kubeClient, err := k8s.NewClient(c.LocalKubeConfigPath, c.K8sWrapTransport)
+		if err != nil {
+			return fmt.Errorf("Failed to initialize new kubernetes client: %v", err)
+		}
+		if err := services.UpgradeWorkerPlane(ctx, kubeClient, allHosts,
+			c.LocalConnDialerFactory,
+			c.PrivateRegistriesMap,
+			workerNodePlanMap,
+			c.Certificates,
+			c.UpdateWorkersOnly,
+			c.SystemImages.Alpine,
+			c.UpgradeStrategy); err != nil {
+			return fmt.Errorf("[workerPlane] Failed to upgrade Worker Plane: %v", err)
+		}
+		return nil
+	}
 	if err := services.RunWorkerPlane(ctx, allHosts,
 		c.LocalConnDialerFactory,
 		c.PrivateRegistriesMap,
@@ -145,9 +175,73 @@ func ParseConfig(clusterFile string) (*v3.RancherKubernetesEngineConfig, error)
 	if err := yaml.Unmarshal([]byte(clusterFile), &rkeConfig); err != nil {
 		return nil, err
 	}
+	if err := setDrainGracePeriodDefault(clusterFile, &rkeConfig); err != nil {
+		return nil, err
+	}
+	if err := setAuditLogRotationDefaults(clusterFile, &rkeConfig); err != nil {
+		return nil, err
+	}
+	if err := ExpandNodePools(&rkeConfig); err != nil {
+		return nil, err
+	}
 	return &rkeConfig, nil
 }
 
+// setDrainGracePeriodDefault sets the default grace period of the node drain input when it's left out of the cluster file.
+// grace_period: 0 deletes the pods right away, so it can't be treated as unset.
+func setDrainGracePeriodDefault(clusterFile string, rkeConfig *v3.RancherKubernetesEngineConfig) error {
+	if rkeConfig.UpgradeStrategy == nil || rkeConfig.UpgradeStrategy.DrainInput == nil {
+		return nil
+	}
+	drainConfig := struct {
+		UpgradeStrategy struct {
+			DrainInput struct {
+				GracePeriod *int `yaml:"grace_period"`
+			} `yaml:"node_drain_input"`
+		} `yaml:"upgrade_strategy"`
+	}{}
+	if err := yaml.Unmarshal([]byte(clusterFile), &drainConfig); err != nil {
+		return err
+	}
+	if drainConfig.UpgradeStrategy.DrainInput.GracePeriod == nil {
+		rkeConfig.UpgradeStrategy.DrainInput.GracePeriod = DefaultNodeDrainGracePeriod
+	}
+	return nil
+}
+
+// setAuditLogRotationDefaults sets the default audit log rotation limits that are left out of the cluster file.
+// A limit of 0 turns it off, so it can't be treated as unset.
+func setAuditLogRotationDefaults(clusterFile string, rkeConfig *v3.RancherKubernetesEngineConfig) error {
+	auditLog := rkeConfig.Services.KubeAPI.AuditLog
+	if auditLog == nil {
+		return nil
+	}
+	auditLogConfig := struct {
+		Services struct {
+			KubeAPI struct {
+				AuditLog struct {
+					MaxAge    *int `yaml:"max_age"`
+					MaxBackup *int `yaml:"max_backup"`
+					MaxSize   *int `yaml:"max_size"`
+				} `yaml:"audit_log"`
+			} `yaml:"kube-api"`
+		} `yaml:"services"`
+	}{}
+	if err := yaml.Unmarshal([]byte(clusterFile), &auditLogConfig); err != nil {
+		return err
+	}
+	if auditLogConfig.Services.KubeAPI.AuditLog.MaxAge == nil {
+		auditLog.MaxAge = DefaultAuditLogMaxAge
+	}
+	if auditLogConfig.Services.KubeAPI.AuditLog.MaxBackup == nil {
+		auditLog.MaxBackup = DefaultAuditLogMaxBackup
+	}
+	if auditLogConfig.Services.KubeAPI.AuditLog.MaxSize == nil {
+		auditLog.MaxSize = DefaultAuditLogMaxSize
+	}
+	return nil
+}
+
 func InitClusterObject(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfig, flags ExternalFlags) (*Cluster, error) {
 	// basic cluster object from rkeConfig
 	c := &Cluster{
@@ -170,11 +264,23 @@ func InitClusterObject(ctx context.Context, rkeConfig *v3.RancherKubernetesEngin
 		c.CertificateDir = GetCertificateDirPath(c.ConfigPath, c.ConfigDir)
 	}
 
+	// node pools are expanded by ParseConfig, configs that didn't go through it are expanded here
+	if err := ExpandNodePools(&c.RancherKubernetesEngineConfig); err != nil {
+		return nil, err
+	}
 	// Setting cluster Defaults
 	err := c.setClusterDefaults(ctx, flags)
 	if err != nil {
 		return nil, err
 	}
+	if err := c.setHostKeyVerifier(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to set SSH host key checking: %v", err)
+	}
+	if len(c.SSHConfigPath) > 0 {
+		if c.SSHConfig, err = hosts.LoadSSHConfig(c.SSHConfigPath); err != nil {
+			return nil, fmt.Errorf("Failed to load SSH config: %v", err)
+		}
+	}
 	// extract cluster network configuration
 	c.setNetworkOptions()
 
@@ -209,13 +315,16 @@ func (c *Cluster) SetupDialers(ctx context.Context, dailersOptions hosts.Dialers
 	c.DockerDialerFactory = dailersOptions.DockerDialerFactory
 	c.LocalConnDialerFactory = dailersOptions.LocalConnDialerFactory
 	c.K8sWrapTransport = dailersOptions.K8sWrapTransport
-	// Create k8s wrap transport for bastion host
-	if len(c.BastionHost.Address) > 0 {
-		var err error
-		c.K8sWrapTransport, err = hosts.BastionHostWrapTransport(c.BastionHost)
+	// Create k8s wrap transport for the bastion hosts of the control plane host the kube config points to,
+	// the ssh config can add bastion hosts with ProxyJump
+	if len(c.ControlPlaneHosts) > 0 && (len(c.ControlPlaneHosts[0].BastionHosts) > 0 || c.SSHConfig != nil) {
+		bastionWrapTransport, err := hosts.BastionHostWrapTransport(c.ControlPlaneHosts[0])
 		if err != nil {
 			return err
 		}
+		if bastionWrapTransport != nil {
+			c.K8sWrapTransport = bastionWrapTransport
+		}
 	}
 	return nil
 }
@@ -231,16 +340,15 @@ func rebuildLocalAdminConfig(ctx context.Context, kubeCluster *Cluster) error {
 	log.Infof(ctx, "[reconcile] Rebuilding and updating local kube config")
 	var workingConfig, newConfig string
 	currentKubeConfig := kubeCluster.Certificates[pki.KubeAdminCertName]
-	caCrt := kubeCluster.Certificates[pki.CACertName].Certificate
+	caData := kubeCluster.Certificates[pki.CACertName].CertificatePEM
 	for _, cpHost := range kubeCluster.ControlPlaneHosts {
 		if (currentKubeConfig == pki.CertificatePKI{}) {
 			kubeCluster.Certificates = make(map[string]pki.CertificatePKI)
 			newConfig = getLocalAdminConfigWithNewAddress(kubeCluster.LocalKubeConfigPath, cpHost.Address, kubeCluster.ClusterName)
 		} else {
 			kubeURL := fmt.Sprintf("https://%s:6443", cpHost.Address)
-			caData := string(cert.EncodeCertPEM(caCrt))
 			crtData := string(cert.EncodeCertPEM(currentKubeConfig.Certificate))
-			keyData := string(cert.EncodePrivateKeyPEM(currentKubeConfig.Key))
+			keyData := string(pki.EncodePrivateKeyPEM(currentKubeConfig.Key))
 			newConfig = pki.GetKubeConfigX509WithData(kubeURL, kubeCluster.ClusterName, pki.KubeAdminCertName, caData, crtData, keyData)
 		}
 		if err := pki.DeployAdminConfig(ctx, newConfig, kubeCluster.LocalKubeConfigPath); err != nil {
@@ -309,8 +417,8 @@ func ApplyAuthzResources(ctx context.Context, rkeConfig v3.RancherKubernetesEngi
 		return nil
 	}
 	if kubeCluster.Authorization.Mode == services.RBACAuthorizationMode {
-		if err := authz.ApplySystemNodeClusterRoleBinding(ctx, kubeCluster.LocalKubeConfigPath, kubeCluster.K8sWrapTransport); err != nil {
-			return fmt.Errorf("Failed to apply the ClusterRoleBinding needed for node authorization: %v", err)
+		if err := authz.ApplyCNIClusterRoleBinding(ctx, kubeCluster.LocalKubeConfigPath, kubeCluster.K8sWrapTransport); err != nil {
+			return fmt.Errorf("Failed to apply the ClusterRoleBinding needed for the CNI plugins: %v", err)
 		}
 	}
 	if kubeCluster.Authorization.Mode == services.RBACAuthorizationMode && kubeCluster.Services.KubeAPI.PodSecurityPolicy {
@@ -471,6 +579,12 @@ func ConfigureCluster(
 			}
 			log.Warnf(ctx, "Failed to deploy addon execute job [%s]: %v", NetworkPluginResourceName, err)
 		}
+		// reset once the kubelets and the CNI plugins switched to their own identities
+		if kubeCluster.Authorization.Mode == services.RBACAuthorizationMode {
+			if err := authz.ResetSystemNodeClusterRoleBinding(ctx, kubeCluster.LocalKubeConfigPath, kubeCluster.K8sWrapTransport); err != nil {
+				return fmt.Errorf("Failed to reset the system:node ClusterRoleBinding: %v", err)
+			}
+		}
 		if err := kubeCluster.deployAddons(ctx); err != nil {
 			return err
 		}
@@ -550,3 +664,7 @@ func IsLegacyKubeAPI(ctx context.Context, kubeCluster *Cluster) (bool, error) {
 	}
 	return false, nil
 }
+
+func (c *Cluster) IsAuditLogEnabled() bool {
+	return c.Services.KubeAPI.AuditLog != nil && c.Services.KubeAPI.AuditLog.Enabled
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/rancher/rke/cloudprovider"
 	"github.com/rancher/rke/docker"
+	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/k8s"
 	"github.com/rancher/rke/log"
 	"github.com/rancher/rke/services"
@@ -36,6 +37,13 @@ const (
 	DefaultAuthnWebhookFile  = templates.AuthnWebhook
 	DefaultAuthnCacheTimeout = "5s"
 
+	DefaultAuditPolicyFile   = templates.AuditPolicy
+	DefaultAuditLogPath      = "/var/log/kube-audit/audit-log.json"
+	DefaultAuditLogMaxAge    = 30
+	DefaultAuditLogMaxBackup = 10
+	DefaultAuditLogMaxSize   = 100
+	DefaultAuditLogFormat    = "json"
+
 	DefaultNetworkPlugin        = "canal"
 	DefaultNetworkCloudProvider = "none"
 
@@ -55,6 +63,12 @@ const (
 	DefaultEtcdElectionTimeoutName    = "election-timeout"
 	DefaultEtcdElectionTimeoutValue   = "5000"
 
+	DefaultMaxUnavailable            = "10%"
+	DefaultNodeDrainTimeout          = 120
+	DefaultNodeDrainGracePeriod      = -1
+	DefaultNodeDrainIgnoreDaemonsets = true
+	DefaultNodeReadyTimeout          = 300
+
 	DefaultFlannelBackendVxLan     = "vxlan"
 	DefaultFlannelBackendVxLanPort = "8472"
 	DefaultFlannelBackendVxLanVNI  = "1"
@@ -78,6 +92,16 @@ func setDefaultIfEmptyMapValue(configMap map[string]string, key string, value st
 	}
 }
 
+func (c *Cluster) setBastionHostDefaults(bastionHost *v3.BastionHost) {
+	if len(bastionHost.Port) == 0 && len(c.SSHConfigPath) == 0 {
+		bastionHost.Port = DefaultSSHPort
+	}
+	if len(bastionHost.SSHKeyPath) == 0 {
+		bastionHost.SSHKeyPath = c.SSHKeyPath
+	}
+	bastionHost.SSHAgentAuth = c.SSHAgentAuth
+}
+
 func setDefaultIfEmpty(varName *string, defaultValue string) {
 	if len(*varName) == 0 {
 		*varName = defaultValue
@@ -85,23 +109,26 @@ func setDefaultIfEmpty(varName *string, defaultValue string) {
 }
 
 func (c *Cluster) setClusterDefaults(ctx context.Context, flags ExternalFlags) error {
-	if len(c.SSHKeyPath) == 0 {
+	// the SSH key path and port of the hosts are left empty for the ssh config to fill in, the dialer defaults them
+	if len(c.SSHKeyPath) == 0 && len(c.SSHConfigPath) == 0 {
 		c.SSHKeyPath = DefaultClusterSSHKeyPath
 	}
+	if len(c.SSHHostKeyChecking) == 0 {
+		c.SSHHostKeyChecking = hosts.HostKeyCheckingNone
+	}
+	if c.SSHHostKeyChecking == hosts.HostKeyCheckingKnownHosts && len(c.SSHKnownHostsPath) == 0 {
+		c.SSHKnownHostsPath = hosts.DefaultKnownHostsPath
+	}
 	// Default Path prefix
 	if len(c.PrefixPath) == 0 {
 		c.PrefixPath = "/"
 	}
 	// Set bastion/jump host defaults
 	if len(c.BastionHost.Address) > 0 {
-		if len(c.BastionHost.Port) == 0 {
-			c.BastionHost.Port = DefaultSSHPort
-		}
-		if len(c.BastionHost.SSHKeyPath) == 0 {
-			c.BastionHost.SSHKeyPath = c.SSHKeyPath
-		}
-		c.BastionHost.SSHAgentAuth = c.SSHAgentAuth
-
+		c.setBastionHostDefaults(&c.BastionHost)
+	}
+	for i := range c.BastionHosts {
+		c.setBastionHostDefaults(&c.BastionHosts[i])
 	}
 	for i, host := range c.Nodes {
 		if len(host.InternalAddress) == 0 {
@@ -114,9 +141,12 @@ func (c *Cluster) setClusterDefaults(ctx context.Context, flags ExternalFlags) e
 		if len(host.SSHKeyPath) == 0 {
 			c.Nodes[i].SSHKeyPath = c.SSHKeyPath
 		}
-		if len(host.Port) == 0 {
+		if len(host.Port) == 0 && len(c.SSHConfigPath) == 0 {
 			c.Nodes[i].Port = DefaultSSHPort
 		}
+		for j := range host.BastionHosts {
+			c.setBastionHostDefaults(&c.Nodes[i].BastionHosts[j])
+		}
 
 		c.Nodes[i].HostnameOverride = strings.ToLower(c.Nodes[i].HostnameOverride)
 		// For now, you can set at the global level only.
@@ -170,10 +200,33 @@ func (c *Cluster) setClusterDefaults(ctx context.Context, flags ExternalFlags) e
 	c.setClusterServicesDefaults()
 	c.setClusterNetworkDefaults()
 	c.setClusterAuthnDefaults()
+	c.setClusterUpgradeStrategyDefaults()
 
 	return nil
 }
 
+func (c *Cluster) setClusterUpgradeStrategyDefaults() {
+	if c.UpgradeStrategy == nil {
+		return
+	}
+	setDefaultIfEmpty(&c.UpgradeStrategy.MaxUnavailable, DefaultMaxUnavailable)
+	if c.UpgradeStrategy.NodeReadyTimeout == 0 {
+		c.UpgradeStrategy.NodeReadyTimeout = DefaultNodeReadyTimeout
+	}
+	if !c.UpgradeStrategy.Drain {
+		return
+	}
+	if c.UpgradeStrategy.DrainInput == nil {
+		c.UpgradeStrategy.DrainInput = &v3.NodeDrainInput{
+			IgnoreDaemonSets: DefaultNodeDrainIgnoreDaemonsets,
+			GracePeriod:      DefaultNodeDrainGracePeriod,
+		}
+	}
+	if c.UpgradeStrategy.DrainInput.Timeout == 0 {
+		c.UpgradeStrategy.DrainInput.Timeout = DefaultNodeDrainTimeout
+	}
+}
+
 func (c *Cluster) setClusterServicesDefaults() {
 	// We don't accept per service images anymore.
 	c.Services.KubeAPI.Image = c.SystemImages.Kubernetes
@@ -224,6 +277,18 @@ func (c *Cluster) setClusterServicesDefaults() {
 			c.Services.Etcd.BackupConfig.Retention = DefaultEtcdBackupConfigRetention
 		}
 	}
+
+	if c.Services.KubeAPI.AuditLog != nil && c.Services.KubeAPI.AuditLog.Enabled {
+		auditLogConfigDefaultsMap := map[*string]string{
+			&c.Services.KubeAPI.AuditLog.Path:   DefaultAuditLogPath,
+			&c.Services.KubeAPI.AuditLog.Format: DefaultAuditLogFormat,
+			&c.Services.KubeAPI.AuditLog.Policy: DefaultAuditPolicyFile,
+		}
+		for k, v := range auditLogConfigDefaultsMap {
+			setDefaultIfEmpty(k, v)
+		}
+		// the rotation limits are set by ParseConfig when they're left out, 0 turns a limit off
+	}
 }
 
 func (c *Cluster) setClusterImageDefaults() error {
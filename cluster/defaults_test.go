@@ -0,0 +1,65 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+)
+
+const upgradeStrategyClusterFile = `
+nodes:
+- address: 1.1.1.1
+  user: rancher
+  role: [controlplane, etcd, worker]
+upgrade_strategy:
+  drain: true
+`
+
+func TestUpgradeStrategyDefaults(t *testing.T) {
+	tests := []struct {
+		name                string
+		strategy            string
+		expectedGracePeriod int
+		expectedTimeout     int
+	}{
+		{
+			name:                "no drain input",
+			strategy:            "",
+			expectedGracePeriod: DefaultNodeDrainGracePeriod,
+			expectedTimeout:     DefaultNodeReadyTimeout,
+		},
+		{
+			name:                "unset grace period",
+			strategy:            "  node_drain_input:\n    timeout: 30\n",
+			expectedGracePeriod: DefaultNodeDrainGracePeriod,
+			expectedTimeout:     DefaultNodeReadyTimeout,
+		},
+		{
+			name:                "zero grace period",
+			strategy:            "  node_drain_input:\n    grace_period: 0\n  node_ready_timeout: 600\n",
+			expectedGracePeriod: 0,
+			expectedTimeout:     600,
+		},
+		{
+			name:                "grace period",
+			strategy:            "  node_drain_input:\n    grace_period: 30\n",
+			expectedGracePeriod: 30,
+			expectedTimeout:     DefaultNodeReadyTimeout,
+		},
+	}
+	for _, test := range tests {
+		rkeConfig, err := ParseConfig(upgradeStrategyClusterFile + test.strategy)
+		if err != nil {
+			t.Fatalf("[%s] Failed to parse cluster file: %v", test.name, err)
+		}
+		c, err := InitClusterObject(context.Background(), rkeConfig, ExternalFlags{})
+		if err != nil {
+			t.Fatalf("[%s] Failed to init cluster object: %v", test.name, err)
+		}
+		if gracePeriod := c.UpgradeStrategy.DrainInput.GracePeriod; gracePeriod != test.expectedGracePeriod {
+			t.Errorf("[%s] expected grace period [%d], got [%d]", test.name, test.expectedGracePeriod, gracePeriod)
+		}
+		if timeout := c.UpgradeStrategy.NodeReadyTimeout; timeout != test.expectedTimeout {
+			t.Errorf("[%s] expected node ready timeout [%d], got [%d]", test.name, test.expectedTimeout, timeout)
+		}
+	}
+}
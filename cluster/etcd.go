@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/rancher/rke/docker"
 	"github.com/rancher/rke/hosts"
@@ -12,6 +15,7 @@ import (
 	"github.com/rancher/rke/services"
 	"github.com/rancher/rke/util"
 	"golang.org/x/sync/errgroup"
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 const (
@@ -144,6 +148,9 @@ func (c *Cluster) RemoveEtcdSnapshot(ctx context.Context, snapshotName string) e
 			return err
 		}
 	}
+	if c.Services.Etcd.BackupConfig != nil && c.Services.Etcd.BackupConfig.S3BackupConfig != nil {
+		return services.RemoveEtcdSnapshotFromS3(ctx, c.Services.Etcd.BackupConfig.S3BackupConfig, snapshotName)
+	}
 	return nil
 }
 
@@ -183,3 +190,109 @@ func IsLocalSnapshot(name string) bool {
 	re := regexp.MustCompile("^c-[a-z0-9].*?-.l-")
 	return re.MatchString(name)
 }
+
+// EtcdSnapshotInfo describes a snapshot across all etcd hosts and the s3 bucket
+type EtcdSnapshotInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	// Checksums maps the etcd host address to the checksum of its snapshot copy
+	Checksums map[string]string
+	InS3      bool
+}
+
+// ChecksumMatch is true when the snapshot exists on every etcd host with the same checksum
+func (s EtcdSnapshotInfo) ChecksumMatch(etcdHostCount int) bool {
+	if len(s.Checksums) != etcdHostCount {
+		return false
+	}
+	checksums := sets.NewString()
+	for _, checksum := range s.Checksums {
+		checksums.Insert(checksum)
+	}
+	return checksums.Len() == 1
+}
+
+func (c *Cluster) ListEtcdSnapshots(ctx context.Context) ([]EtcdSnapshotInfo, error) {
+	backupImage := c.getBackupImage()
+	snapshotsMap := make(map[string]*EtcdSnapshotInfo)
+	var snapshotsLock sync.Mutex
+
+	var errgrp errgroup.Group
+	hostsQueue := util.GetObjectQueue(c.EtcdHosts)
+	for w := 0; w < WorkerThreads; w++ {
+		errgrp.Go(func() error {
+			var errList []error
+			for host := range hostsQueue {
+				runHost := host.(*hosts.Host)
+				snapshots, err := services.ListEtcdSnapshots(ctx, runHost, c.PrivateRegistriesMap, backupImage)
+				if err != nil {
+					errList = append(errList, err)
+					continue
+				}
+				for _, snapshot := range snapshots {
+					checksum, err := services.GetEtcdSnapshotChecksum(ctx, runHost, c.PrivateRegistriesMap, backupImage, snapshot.Name)
+					if err != nil {
+						log.Warnf(ctx, "[etcd] Failed to get checksum of snapshot [%s] on host [%s]: %v", snapshot.Name, runHost.Address, err)
+					}
+					snapshotsLock.Lock()
+					info := getOrAddSnapshotInfo(snapshotsMap, snapshot)
+					info.Checksums[runHost.Address] = checksum
+					snapshotsLock.Unlock()
+				}
+			}
+			return util.ErrList(errList)
+		})
+	}
+	if err := errgrp.Wait(); err != nil {
+		return nil, err
+	}
+
+	if c.Services.Etcd.BackupConfig != nil && c.Services.Etcd.BackupConfig.S3BackupConfig != nil {
+		s3Snapshots, err := services.ListEtcdSnapshotsFromS3(ctx, c.Services.Etcd.BackupConfig.S3BackupConfig)
+		if err != nil {
+			return nil, err
+		}
+		for _, snapshot := range s3Snapshots {
+			getOrAddSnapshotInfo(snapshotsMap, snapshot).InS3 = true
+		}
+	}
+
+	snapshotsInfo := []EtcdSnapshotInfo{}
+	for _, info := range snapshotsMap {
+		snapshotsInfo = append(snapshotsInfo, *info)
+	}
+	sort.Slice(snapshotsInfo, func(i, j int) bool {
+		return snapshotsInfo[i].ModTime.Before(snapshotsInfo[j].ModTime)
+	})
+	return snapshotsInfo, nil
+}
+
+func (c *Cluster) InspectEtcdSnapshot(ctx context.Context, snapshotName string) (services.EtcdSnapshotStatus, error) {
+	var errList []error
+	// the snapshot status is the same on every host holding a consistent copy, the first one that can read it wins
+	for _, host := range c.EtcdHosts {
+		snapshotStatus, err := services.InspectEtcdSnapshot(ctx, host, c.PrivateRegistriesMap, c.SystemImages.Etcd, snapshotName)
+		if err != nil {
+			log.Warnf(ctx, "[etcd] %v", err)
+			errList = append(errList, err)
+			continue
+		}
+		return snapshotStatus, nil
+	}
+	return services.EtcdSnapshotStatus{}, fmt.Errorf("Failed to inspect snapshot [%s] on all etcd hosts: %v", snapshotName, util.ErrList(errList))
+}
+
+func getOrAddSnapshotInfo(snapshotsMap map[string]*EtcdSnapshotInfo, snapshot services.EtcdSnapshot) *EtcdSnapshotInfo {
+	info, ok := snapshotsMap[snapshot.Name]
+	if !ok {
+		info = &EtcdSnapshotInfo{
+			Name:      snapshot.Name,
+			Size:      snapshot.Size,
+			ModTime:   snapshot.ModTime,
+			Checksums: make(map[string]string),
+		}
+		snapshotsMap[snapshot.Name] = info
+	}
+	return info
+}
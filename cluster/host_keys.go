@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rancher/rke/hosts"
+	"github.com/sirupsen/logrus"
+)
+
+// setHostKeyVerifier sets the SSH host key checks of the nodes and the bastion host,
+// the host keys trusted on first use are loaded from the current state
+func (c *Cluster) setHostKeyVerifier(ctx context.Context) error {
+	var trustedKeys map[string]string
+	if c.SSHHostKeyChecking == hosts.HostKeyCheckingTrustOnFirstUse {
+		clusterState, err := ReadStateFile(ctx, c.StateFilePath)
+		if err != nil {
+			if IsStateEncryptionError(err) {
+				return err
+			}
+			logrus.Debugf("[hosts] No trusted SSH host keys loaded from the state file: %v", err)
+		} else {
+			trustedKeys = clusterState.CurrentState.SSHHostKeys
+		}
+	}
+	c.HostKeyVerifier = hosts.NewHostKeyVerifier(c.SSHHostKeyChecking, c.SSHKnownHostsPath, trustedKeys)
+	return nil
+}
+
+// getTrustedHostKeys returns the host keys trusted on first use of the cluster nodes and bastion hosts
+func (c *Cluster) getTrustedHostKeys() map[string]string {
+	if c.HostKeyVerifier == nil || c.SSHHostKeyChecking != hosts.HostKeyCheckingTrustOnFirstUse {
+		return nil
+	}
+	trustedKeys := c.HostKeyVerifier.TrustedKeys()
+	// the ssh config can change the addresses and add jump hosts, the keys of the removed hosts are only pruned without it
+	if len(c.SSHConfigPath) == 0 {
+		clusterAddresses := map[string]bool{}
+		for _, node := range c.Nodes {
+			clusterAddresses[fmt.Sprintf("%s:%s", node.Address, node.Port)] = true
+			for _, bastionHost := range c.getBastionHosts(node) {
+				clusterAddresses[fmt.Sprintf("%s:%s", bastionHost.Address, bastionHost.Port)] = true
+			}
+		}
+		for address := range trustedKeys {
+			if !clusterAddresses[address] {
+				delete(trustedKeys, address)
+			}
+		}
+	}
+	if len(trustedKeys) == 0 {
+		return nil
+	}
+	return trustedKeys
+}
+
+// saveTrustedHostKeys adds the host keys trusted on first use to the current state right after they're accepted,
+// a failed run keeps them so the next one checks the hosts against them
+func (c *Cluster) saveTrustedHostKeys(ctx context.Context) error {
+	if c.HostKeyVerifier == nil || c.SSHHostKeyChecking != hosts.HostKeyCheckingTrustOnFirstUse {
+		return nil
+	}
+	clusterState, err := ReadStateFile(ctx, c.StateFilePath)
+	if err != nil {
+		if IsStateEncryptionError(err) {
+			return err
+		}
+		logrus.Debugf("[hosts] Trusted SSH host keys aren't saved, there is no state file: %v", err)
+		return nil
+	}
+	trustedKeys := c.HostKeyVerifier.TrustedKeys()
+	changed := false
+	for address, key := range trustedKeys {
+		if clusterState.CurrentState.SSHHostKeys[address] != key {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	// the keys of the hosts removed from the cluster are only pruned once the cluster state is updated
+	for address, key := range clusterState.CurrentState.SSHHostKeys {
+		if _, ok := trustedKeys[address]; !ok {
+			trustedKeys[address] = key
+		}
+	}
+	clusterState.CurrentState.SSHHostKeys = trustedKeys
+	if err := clusterState.WriteStateFile(ctx, c.StateFilePath); err != nil {
+		return fmt.Errorf("Failed to save the trusted SSH host keys to the state file: %v", err)
+	}
+	return nil
+}
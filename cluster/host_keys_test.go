@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/rke/hosts"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSaveTrustedHostKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rke-host-keys")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	c := &Cluster{
+		StateFilePath:      filepath.Join(dir, "cluster.rkestate"),
+		SSHHostKeyChecking: hosts.HostKeyCheckingTrustOnFirstUse,
+	}
+
+	// without a state file there is nothing to save the keys to
+	if err := c.setHostKeyVerifier(ctx); err != nil {
+		t.Fatalf("Failed to set host key verifier: %v", err)
+	}
+	trustTestHostKey(t, c.HostKeyVerifier, "10.0.0.1:22")
+	if err := c.saveTrustedHostKeys(ctx); err != nil {
+		t.Fatalf("Expected no error without a state file, got: %v", err)
+	}
+
+	removedHostKey := "ecdsa-sha2-nistp256 removed"
+	state := &FullState{CurrentState: State{SSHHostKeys: map[string]string{"10.0.0.9:22": removedHostKey}}}
+	if err := state.WriteStateFile(ctx, c.StateFilePath); err != nil {
+		t.Fatalf("Failed to write state file: %v", err)
+	}
+	if err := c.setHostKeyVerifier(ctx); err != nil {
+		t.Fatalf("Failed to set host key verifier: %v", err)
+	}
+	nodeKey := trustTestHostKey(t, c.HostKeyVerifier, "10.0.0.1:22")
+	if err := c.saveTrustedHostKeys(ctx); err != nil {
+		t.Fatalf("Failed to save trusted host keys: %v", err)
+	}
+	state, err = ReadStateFile(ctx, c.StateFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read state file: %v", err)
+	}
+	if state.CurrentState.SSHHostKeys["10.0.0.1:22"] != nodeKey || state.CurrentState.SSHHostKeys["10.0.0.9:22"] != removedHostKey {
+		t.Fatalf("Expected the host key trusted on first use to be added to the state, got %v", state.CurrentState.SSHHostKeys)
+	}
+
+	// the next run checks the host against the saved key
+	if err := c.setHostKeyVerifier(ctx); err != nil {
+		t.Fatalf("Failed to set host key verifier: %v", err)
+	}
+	callback, _ := c.HostKeyVerifier.HostKeyCallback("10.0.0.1:22", "")
+	if err := callback("10.0.0.1:22", nil, newTestSSHHostKey(t)); err == nil || !strings.Contains(err.Error(), hosts.HostKeyVerificationError) {
+		t.Fatalf("Expected a changed host key to be rejected, got: %v", err)
+	}
+}
+
+func trustTestHostKey(t *testing.T, verifier *hosts.HostKeyVerifier, address string) string {
+	key := newTestSSHHostKey(t)
+	callback, _ := verifier.HostKeyCallback(address, "")
+	if err := callback(address, nil, key); err != nil {
+		t.Fatalf("Expected the host key of [%s] to be trusted on first use, got: %v", address, err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func newTestSSHHostKey(t *testing.T) ssh.PublicKey {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to convert host key: %v", err)
+	}
+	return key
+}
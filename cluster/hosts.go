@@ -18,11 +18,13 @@ import (
 )
 
 const (
-	etcdRoleLabel         = "node-role.kubernetes.io/etcd"
-	controlplaneRoleLabel = "node-role.kubernetes.io/controlplane"
-	workerRoleLabel       = "node-role.kubernetes.io/worker"
-	cloudConfigFileName   = "/etc/kubernetes/cloud-config"
-	authnWebhookFileName  = "/etc/kubernetes/kube-api-authn-webhook.yaml"
+	etcdRoleLabel            = "node-role.kubernetes.io/etcd"
+	controlplaneRoleLabel    = "node-role.kubernetes.io/controlplane"
+	workerRoleLabel          = "node-role.kubernetes.io/worker"
+	cloudConfigFileName      = "/etc/kubernetes/cloud-config"
+	authnWebhookFileName     = "/etc/kubernetes/kube-api-authn-webhook.yaml"
+	encryptionConfigFileName = "/etc/kubernetes/encryption.yaml"
+	auditPolicyFileName      = "/etc/kubernetes/audit-policy.yaml"
 )
 
 func (c *Cluster) TunnelHosts(ctx context.Context, flags ExternalFlags) error {
@@ -39,8 +41,8 @@ func (c *Cluster) TunnelHosts(ctx context.Context, flags ExternalFlags) error {
 		runHost := uniqueHost
 		errgrp.Go(func() error {
 			if err := runHost.TunnelUp(ctx, c.DockerDialerFactory, c.PrefixPath, c.Version); err != nil {
-				// Unsupported Docker version is NOT a connectivity problem that we can recover! So we bail out on it
-				if strings.Contains(err.Error(), "Unsupported Docker version found") {
+				// Unsupported Docker version and host key verification failures are NOT connectivity problems that we can recover! So we bail out on them
+				if strings.Contains(err.Error(), "Unsupported Docker version found") || strings.Contains(err.Error(), hosts.HostKeyVerificationError) {
 					return err
 				}
 				log.Warnf(ctx, "Failed to set up SSH tunneling for host [%s]: %v", runHost.Address, err)
@@ -49,7 +51,16 @@ func (c *Cluster) TunnelHosts(ctx context.Context, flags ExternalFlags) error {
 			return nil
 		})
 	}
-	if err := errgrp.Wait(); err != nil {
+	err := errgrp.Wait()
+	// keys trusted on first use are saved even if a host failed, the next run must check the hosts against them
+	if saveErr := c.saveTrustedHostKeys(ctx); saveErr != nil {
+		if err != nil {
+			log.Warnf(ctx, "%v", saveErr)
+		} else {
+			return saveErr
+		}
+	}
+	if err != nil {
 		return err
 	}
 	for _, host := range c.InactiveHosts {
@@ -63,6 +74,17 @@ func (c *Cluster) TunnelHosts(ctx context.Context, flags ExternalFlags) error {
 
 }
 
+// getBastionHosts returns the chain of bastion hosts of a node, the node bastion hosts override the cluster ones
+func (c *Cluster) getBastionHosts(node v3.RKEConfigNode) []v3.BastionHost {
+	if len(node.BastionHosts) > 0 {
+		return node.BastionHosts
+	}
+	if len(c.BastionHost.Address) > 0 {
+		return []v3.BastionHost{c.BastionHost}
+	}
+	return c.BastionHosts
+}
+
 func (c *Cluster) InvertIndexHosts() error {
 	c.EtcdHosts = make([]*hosts.Host, 0)
 	c.WorkerHosts = make([]*hosts.Host, 0)
@@ -81,11 +103,19 @@ func (c *Cluster) InvertIndexHosts() error {
 		for k, v := range host.Labels {
 			newHost.ToAddLabels[k] = v
 		}
-		newHost.IgnoreDockerVersion = c.IgnoreDockerVersion
-		if c.BastionHost.Address != "" {
-			// Add the bastion host information to each host object
-			newHost.BastionHost = c.BastionHost
+		for _, taint := range host.Taints {
+			newHost.ToAddTaints = append(newHost.ToAddTaints, getTaintString(taint))
 		}
+		newHost.IgnoreDockerVersion = c.IgnoreDockerVersion
+		newHost.HostKeyVerifier = c.HostKeyVerifier
+		newHost.SSHMaxChannels = c.SSHMaxChannels
+		newHost.SSHConfig = c.SSHConfig
+		// Add the bastion hosts information to each host object, the hosts with a Docker endpoint aren't reached over SSH
+		if len(host.DockerEndpoint) == 0 {
+			newHost.BastionHosts = c.getBastionHosts(host)
+		}
+		newHost.PortForwardImage = c.SystemImages.Alpine
+		newHost.PrivateRegistriesMap = c.PrivateRegistriesMap
 		for _, role := range host.Role {
 			logrus.Debugf("Host: " + host.Address + " has role: " + role)
 			switch role {
@@ -157,6 +187,21 @@ func (c *Cluster) SetUpHosts(ctx context.Context, flags ExternalFlags) error {
 			}
 			log.Infof(ctx, "[%s] Successfully deployed authentication webhook config Cluster nodes", authnWebhookFileName)
 		}
+
+		if c.IsAuditLogEnabled() {
+			if err := deployFile(ctx, c.ControlPlaneHosts, c.SystemImages.Alpine, c.PrivateRegistriesMap, auditPolicyFileName, c.Services.KubeAPI.AuditLog.Policy); err != nil {
+				return err
+			}
+			log.Infof(ctx, "[%s] Successfully deployed audit policy to Controller Plane nodes", auditPolicyFileName)
+		}
+
+		if err := c.deployEncryptionProviderConfig(ctx); err != nil {
+			return err
+		}
+
+		if err := c.deployKubeletBootstrapConfig(ctx); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -173,7 +173,7 @@ func (c *Cluster) doFlannelDeploy(ctx context.Context) error {
 }
 
 func (c *Cluster) doCalicoDeploy(ctx context.Context) error {
-	clientConfig := pki.GetConfigPath(pki.KubeNodeCertName)
+	clientConfig := c.getCNIKubeConfigPath()
 	calicoConfig := map[string]interface{}{
 		KubeCfg:       clientConfig,
 		ClusterCIDR:   c.ClusterCIDR,
@@ -200,11 +200,11 @@ func (c *Cluster) doCanalDeploy(ctx context.Context) error {
 		return err
 	}
 
-	clientConfig := pki.GetConfigPath(pki.KubeNodeCertName)
+	clientConfig := c.getCNIKubeConfigPath()
 	canalConfig := map[string]interface{}{
-		ClientCertPath:  pki.GetCertPath(pki.KubeNodeCertName),
+		ClientCertPath:  pki.GetCertPath(pki.KubeCNICertName),
 		APIRoot:         "https://127.0.0.1:6443",
-		ClientKeyPath:   pki.GetKeyPath(pki.KubeNodeCertName),
+		ClientKeyPath:   pki.GetKeyPath(pki.KubeCNICertName),
 		ClientCAPath:    pki.GetCertPath(pki.CACertName),
 		KubeCfg:         clientConfig,
 		ClusterCIDR:     c.ClusterCIDR,
@@ -277,7 +277,7 @@ func (c *Cluster) CheckClusterPorts(ctx context.Context, currentCluster *Cluster
 		return err
 	}
 	// Skip kubeapi check if we are using custom k8s dialer or bastion/jump host
-	if c.K8sWrapTransport == nil && len(c.BastionHost.Address) == 0 {
+	if c.K8sWrapTransport == nil {
 		if err := c.checkKubeAPIPort(ctx); err != nil {
 			return err
 		}
@@ -556,3 +556,8 @@ func atoiWithDefault(val string, defaultVal int) (int, error) {
 
 	return ret, nil
 }
+
+// getCNIKubeConfigPath returns the kubeconfig the CNI plugins use, they have their own identity instead of the node one
+func (c *Cluster) getCNIKubeConfigPath() string {
+	return pki.GetConfigPath(pki.KubeCNICertName)
+}
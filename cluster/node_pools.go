@@ -0,0 +1,102 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	v3 "github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+const (
+	nodePoolIndexPlaceholder   = "{index}"
+	nodePoolAddressPlaceholder = "{address}"
+	// maxAddressRangeSize caps address_range so a typo can't expand into millions of nodes
+	maxAddressRangeSize = 1024
+)
+
+// ExpandNodePools adds the nodes of the node pools to the cluster nodes. The pools are dropped once expanded
+// so the cluster state only keeps the resulting node list and later diffs compare nodes only.
+func ExpandNodePools(rkeConfig *v3.RancherKubernetesEngineConfig) error {
+	if len(rkeConfig.NodePools) == 0 {
+		return nil
+	}
+	nodes := append([]v3.RKEConfigNode{}, rkeConfig.Nodes...)
+	for i, pool := range rkeConfig.NodePools {
+		if len(pool.Name) == 0 {
+			return fmt.Errorf("Name for node pool (%d) is not provided", i+1)
+		}
+		poolNodes, err := getNodePoolNodes(pool)
+		if err != nil {
+			return fmt.Errorf("Failed to expand node pool [%s]: %v", pool.Name, err)
+		}
+		nodes = append(nodes, poolNodes...)
+	}
+	rkeConfig.Nodes = nodes
+	rkeConfig.NodePools = nil
+	return nil
+}
+
+func getNodePoolNodes(pool v3.RKENodePool) ([]v3.RKEConfigNode, error) {
+	if len(pool.Address) > 0 || len(pool.InternalAddress) > 0 || len(pool.HostnameOverride) > 0 || len(pool.NodeName) > 0 {
+		return nil, fmt.Errorf("address, internal_address, hostname_override and node_name can't be set on a node pool")
+	}
+	addresses := append([]string{}, pool.Addresses...)
+	if len(pool.AddressRange) > 0 {
+		rangeAddresses, err := getAddressRange(pool.AddressRange)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, rangeAddresses...)
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("addresses or address_range must be provided")
+	}
+	nodes := []v3.RKEConfigNode{}
+	// {index} is the position in the pool's address list, so adding or removing an earlier address
+	// renumbers the hostnames of the nodes after it. {address} keeps hostnames stable.
+	for i, address := range addresses {
+		node := pool.RKEConfigNode.DeepCopy()
+		node.Address = address
+		if len(pool.HostnamePattern) > 0 {
+			hostname := strings.Replace(pool.HostnamePattern, nodePoolIndexPlaceholder, strconv.Itoa(i+1), -1)
+			node.HostnameOverride = strings.Replace(hostname, nodePoolAddressPlaceholder, strings.Replace(address, ".", "-", -1), -1)
+		}
+		nodes = append(nodes, *node)
+	}
+	return nodes, nil
+}
+
+// getAddressRange returns the IPv4 addresses of a start-end range, both ends included, up to maxAddressRangeSize addresses
+func getAddressRange(addressRange string) ([]string, error) {
+	rangeParts := strings.SplitN(addressRange, "-", 2)
+	if len(rangeParts) != 2 {
+		return nil, fmt.Errorf("address_range [%s] must be in the start-end format", addressRange)
+	}
+	start := net.ParseIP(strings.TrimSpace(rangeParts[0])).To4()
+	end := net.ParseIP(strings.TrimSpace(rangeParts[1])).To4()
+	if start == nil || end == nil {
+		return nil, fmt.Errorf("address_range [%s] must be a range of IPv4 addresses", addressRange)
+	}
+	startIndex := binary.BigEndian.Uint32(start)
+	endIndex := binary.BigEndian.Uint32(end)
+	if startIndex > endIndex {
+		return nil, fmt.Errorf("address_range [%s] start address is after the end address", addressRange)
+	}
+	if endIndex-startIndex >= maxAddressRangeSize {
+		return nil, fmt.Errorf("address_range [%s] has more than %d addresses", addressRange, maxAddressRangeSize)
+	}
+	addresses := []string{}
+	// the loop stops on the end address so it can't overflow on 255.255.255.255
+	for i := startIndex; ; i++ {
+		address := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(address, i)
+		addresses = append(addresses, address.String())
+		if i == endIndex {
+			break
+		}
+	}
+	return addresses, nil
+}
@@ -0,0 +1,104 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	v3 "github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+const nodePoolsClusterFile = `
+nodes:
+- address: 1.1.1.1
+  user: rancher
+  role: [controlplane, etcd]
+node_pools:
+- name: gpu
+  user: ubuntu
+  role: [worker]
+  labels:
+    gpu: "true"
+  taints:
+  - key: gpu
+    value: "true"
+    effect: NoSchedule
+  addresses: [10.0.1.5]
+  address_range: 10.0.0.254-10.0.1.1
+  hostname_pattern: gpu-{index}-{address}
+`
+
+func TestExpandNodePools(t *testing.T) {
+	rkeConfig, err := ParseConfig(nodePoolsClusterFile)
+	if err != nil {
+		t.Fatalf("Failed to parse cluster file: %v", err)
+	}
+	if len(rkeConfig.NodePools) != 0 {
+		t.Fatalf("Expected the node pools to be dropped once expanded, got %v", rkeConfig.NodePools)
+	}
+	expected := map[string]string{
+		"1.1.1.1":    "",
+		"10.0.1.5":   "gpu-1-10-0-1-5",
+		"10.0.0.254": "gpu-2-10-0-0-254",
+		"10.0.0.255": "gpu-3-10-0-0-255",
+		"10.0.1.0":   "gpu-4-10-0-1-0",
+		"10.0.1.1":   "gpu-5-10-0-1-1",
+	}
+	if len(rkeConfig.Nodes) != len(expected) {
+		t.Fatalf("Expected %d nodes, got %d", len(expected), len(rkeConfig.Nodes))
+	}
+	for _, node := range rkeConfig.Nodes {
+		hostname, ok := expected[node.Address]
+		if !ok || node.HostnameOverride != hostname {
+			t.Fatalf("Unexpected node [%s] with hostname [%s]", node.Address, node.HostnameOverride)
+		}
+		if node.Address == "1.1.1.1" {
+			continue
+		}
+		if node.User != "ubuntu" || node.Labels["gpu"] != "true" || len(node.Taints) != 1 || len(node.Role) != 1 {
+			t.Fatalf("Expected the pool settings on node [%s], got %+v", node.Address, node)
+		}
+	}
+	// pool nodes don't share the labels map
+	rkeConfig.Nodes[1].Labels["gpu"] = "false"
+	if rkeConfig.Nodes[2].Labels["gpu"] != "true" {
+		t.Fatalf("Expected the pool nodes to have their own labels")
+	}
+
+	if _, err := InitClusterObject(context.Background(), rkeConfig, ExternalFlags{}); err != nil {
+		t.Fatalf("Failed to init cluster object: %v", err)
+	}
+	rkeConfig.Nodes = append(rkeConfig.Nodes, rkeConfig.Nodes[1])
+	if _, err := InitClusterObject(context.Background(), rkeConfig, ExternalFlags{}); err == nil {
+		t.Fatalf("Expected duplicate pool nodes to fail validation")
+	}
+
+	if _, err := ParseConfig("node_pools:\n- name: bad\n  address_range: 10.0.0.5-10.0.0.1\n"); err == nil {
+		t.Fatalf("Expected an inverted address range to fail")
+	}
+	if _, err := ParseConfig("node_pools:\n- name: big\n  address_range: 10.0.0.0-10.0.4.0\n"); err == nil {
+		t.Fatalf("Expected an address range over the size cap to fail")
+	}
+}
+
+func TestNodePoolIndexHostnames(t *testing.T) {
+	pool := v3.RKENodePool{
+		Addresses:       []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		HostnamePattern: "node-{index}",
+	}
+	nodes, err := getNodePoolNodes(pool)
+	if err != nil {
+		t.Fatalf("Failed to get node pool nodes: %v", err)
+	}
+	if nodes[2].Address != "10.0.0.3" || nodes[2].HostnameOverride != "node-3" {
+		t.Fatalf("Expected node [10.0.0.3] to be node-3, got %s", nodes[2].HostnameOverride)
+	}
+	// removing an earlier address renumbers the nodes after it
+	pool.Addresses = pool.Addresses[1:]
+	nodes, err = getNodePoolNodes(pool)
+	if err != nil {
+		t.Fatalf("Failed to get node pool nodes: %v", err)
+	}
+	if nodes[1].Address != "10.0.0.3" || nodes[1].HostnameOverride != "node-2" {
+		t.Fatalf("Expected node [10.0.0.3] to become node-2, got %s", nodes[1].HostnameOverride)
+	}
+}
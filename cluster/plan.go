@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -20,12 +21,15 @@ import (
 	"github.com/rancher/rke/util"
 	v3 "github.com/rancher/types/apis/management.cattle.io/v3"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
 	EtcdPathPrefix     = "/registry"
 	ContainerNameLabel = "io.rancher.rke.container.name"
 	CloudConfigSumEnv  = "RKE_CLOUD_CONFIG_CHECKSUM"
+	EncryptionSumEnv   = "RKE_ENCRYPTION_CONFIG_CHECKSUM"
+	AuditPolicySumEnv  = "RKE_AUDIT_POLICY_CHECKSUM"
 
 	DefaultToolsEntrypoint        = "/opt/rke-tools/entrypoint.sh"
 	DefaultToolsEntrypointVersion = "0.1.13"
@@ -73,8 +77,8 @@ func BuildRKEConfigNodePlan(ctx context.Context, myCluster *Cluster, host *hosts
 	}
 	if host.IsControl {
 		processes[services.KubeAPIContainerName] = myCluster.BuildKubeAPIProcess(host, prefixPath)
-		processes[services.KubeControllerContainerName] = myCluster.BuildKubeControllerProcess(prefixPath)
-		processes[services.SchedulerContainerName] = myCluster.BuildSchedulerProcess(prefixPath)
+		processes[services.KubeControllerContainerName] = myCluster.BuildKubeControllerProcess(host, prefixPath)
+		processes[services.SchedulerContainerName] = myCluster.BuildSchedulerProcess(host, prefixPath)
 
 		portChecks = append(portChecks, BuildPortChecksFromPortList(host, ControlPlanePortList, ProtocolTCP)...)
 	}
@@ -83,15 +87,23 @@ func BuildRKEConfigNodePlan(ctx context.Context, myCluster *Cluster, host *hosts
 
 		portChecks = append(portChecks, BuildPortChecksFromPortList(host, EtcdPortList, ProtocolTCP)...)
 	}
-	cloudConfig := v3.File{
-		Name:     cloudConfigFileName,
-		Contents: b64.StdEncoding.EncodeToString([]byte(myCluster.CloudConfigFile)),
+	files := []v3.File{
+		{
+			Name:     cloudConfigFileName,
+			Contents: b64.StdEncoding.EncodeToString([]byte(myCluster.CloudConfigFile)),
+		},
+	}
+	if host.IsControl && myCluster.IsAuditLogEnabled() {
+		files = append(files, v3.File{
+			Name:     auditPolicyFileName,
+			Contents: b64.StdEncoding.EncodeToString([]byte(myCluster.Services.KubeAPI.AuditLog.Policy)),
+		})
 	}
 	return v3.RKEConfigNodePlan{
 		Address:    host.Address,
 		Processes:  processes,
 		PortChecks: portChecks,
-		Files:      []v3.File{cloudConfig},
+		Files:      files,
 		Annotations: map[string]string{
 			k8s.ExternalAddressAnnotation: host.Address,
 			k8s.InternalAddressAnnotation: host.InternalAddress,
@@ -101,6 +113,7 @@ func BuildRKEConfigNodePlan(ctx context.Context, myCluster *Cluster, host *hosts
 }
 
 func (c *Cluster) BuildKubeAPIProcess(host *hosts.Host, prefixPath string) v3.Process {
+	kubeAPIService := getNodeService(c.Services.KubeAPI.BaseService, getNodeServices(host).KubeAPI)
 	// check if external etcd is used
 	etcdConnectionString := services.GetEtcdConnString(c.EtcdHosts, host.InternalAddress)
 	etcdPathPrefix := EtcdPathPrefix
@@ -164,15 +177,33 @@ func (c *Cluster) BuildKubeAPIProcess(host *hosts.Host, prefixPath string) v3.Pr
 	if len(c.CloudProvider.Name) > 0 {
 		CommandArgs["cloud-config"] = cloudConfigFileName
 	}
+	if IsKubeletTLSBootstrapEnabled(&c.RancherKubernetesEngineConfig) {
+		CommandArgs["enable-bootstrap-token-auth"] = "true"
+	}
 	if c.Authentication.Webhook != nil {
 		CommandArgs["authentication-token-webhook-config-file"] = authnWebhookFileName
 		CommandArgs["authentication-token-webhook-cache-ttl"] = c.Authentication.Webhook.CacheTimeout
 	}
 	if len(c.CloudProvider.Name) > 0 {
-		c.Services.KubeAPI.ExtraEnv = append(
-			c.Services.KubeAPI.ExtraEnv,
+		kubeAPIService.ExtraEnv = append(
+			kubeAPIService.ExtraEnv,
 			fmt.Sprintf("%s=%s", CloudConfigSumEnv, getCloudConfigChecksum(c.CloudConfigFile)))
 	}
+	Env := kubeAPIService.ExtraEnv
+	if len(c.SecretsEncryptionKeys) > 0 {
+		CommandArgs["encryption-provider-config"] = encryptionConfigFileName
+		// kept out of ExtraEnv, the config changes between the steps of a key rotation
+		Env = append([]string{fmt.Sprintf("%s=%s", EncryptionSumEnv, getCloudConfigChecksum(c.getEncryptionProviderConfig()))}, Env...)
+	}
+	if c.IsAuditLogEnabled() {
+		CommandArgs["audit-policy-file"] = auditPolicyFileName
+		CommandArgs["audit-log-path"] = c.Services.KubeAPI.AuditLog.Path
+		CommandArgs["audit-log-maxage"] = strconv.Itoa(c.Services.KubeAPI.AuditLog.MaxAge)
+		CommandArgs["audit-log-maxbackup"] = strconv.Itoa(c.Services.KubeAPI.AuditLog.MaxBackup)
+		CommandArgs["audit-log-maxsize"] = strconv.Itoa(c.Services.KubeAPI.AuditLog.MaxSize)
+		CommandArgs["audit-log-format"] = c.Services.KubeAPI.AuditLog.Format
+		Env = append([]string{fmt.Sprintf("%s=%s", AuditPolicySumEnv, getCloudConfigChecksum(c.Services.KubeAPI.AuditLog.Policy))}, Env...)
+	}
 	// check if our version has specific options for this component
 	serviceOptions := c.GetKubernetesServicesOptions()
 	if serviceOptions.KubeAPI != nil {
@@ -240,10 +271,14 @@ func (c *Cluster) BuildKubeAPIProcess(host *hosts.Host, prefixPath string) v3.Pr
 	Binds := []string{
 		fmt.Sprintf("%s:/etc/kubernetes:z", path.Join(prefixPath, "/etc/kubernetes")),
 	}
+	if c.IsAuditLogEnabled() {
+		auditLogDir := path.Dir(c.Services.KubeAPI.AuditLog.Path)
+		Binds = append(Binds, fmt.Sprintf("%s:%s:z", path.Join(prefixPath, auditLogDir), auditLogDir))
+	}
 
 	// Override args if they exist, add additional args
-	for arg, value := range c.Services.KubeAPI.ExtraArgs {
-		if _, ok := c.Services.KubeAPI.ExtraArgs[arg]; ok {
+	for arg, value := range kubeAPIService.ExtraArgs {
+		if _, ok := kubeAPIService.ExtraArgs[arg]; ok {
 			CommandArgs[arg] = value
 		}
 	}
@@ -253,22 +288,22 @@ func (c *Cluster) BuildKubeAPIProcess(host *hosts.Host, prefixPath string) v3.Pr
 		Command = append(Command, cmd)
 	}
 
-	Binds = append(Binds, c.Services.KubeAPI.ExtraBinds...)
+	Binds = append(Binds, kubeAPIService.ExtraBinds...)
 
 	healthCheck := v3.HealthCheck{
 		URL: services.GetHealthCheckURL(true, services.KubeAPIPort),
 	}
-	registryAuthConfig, _, _ := docker.GetImageRegistryConfig(c.Services.KubeAPI.Image, c.PrivateRegistriesMap)
+	registryAuthConfig, _, _ := docker.GetImageRegistryConfig(kubeAPIService.Image, c.PrivateRegistriesMap)
 
 	return v3.Process{
 		Name:                    services.KubeAPIContainerName,
 		Command:                 Command,
 		VolumesFrom:             VolumesFrom,
 		Binds:                   getUniqStringList(Binds),
-		Env:                     getUniqStringList(c.Services.KubeAPI.ExtraEnv),
+		Env:                     getUniqStringList(Env),
 		NetworkMode:             "host",
 		RestartPolicy:           "always",
-		Image:                   c.Services.KubeAPI.Image,
+		Image:                   kubeAPIService.Image,
 		HealthCheck:             healthCheck,
 		ImageRegistryAuthConfig: registryAuthConfig,
 		Labels: map[string]string{
@@ -277,7 +312,8 @@ func (c *Cluster) BuildKubeAPIProcess(host *hosts.Host, prefixPath string) v3.Pr
 	}
 }
 
-func (c *Cluster) BuildKubeControllerProcess(prefixPath string) v3.Process {
+func (c *Cluster) BuildKubeControllerProcess(host *hosts.Host, prefixPath string) v3.Process {
+	kubeControllerService := getNodeService(c.Services.KubeController.BaseService, getNodeServices(host).KubeController)
 	Command := []string{
 		c.getRKEToolsEntryPoint(),
 		"kube-controller-manager",
@@ -309,9 +345,14 @@ func (c *Cluster) BuildKubeControllerProcess(prefixPath string) v3.Process {
 	if len(c.CloudProvider.Name) > 0 {
 		CommandArgs["cloud-config"] = cloudConfigFileName
 	}
+	// kubelet certificates requested with TLS bootstrap are signed by the cluster CA
+	if IsKubeletTLSBootstrapEnabled(&c.RancherKubernetesEngineConfig) {
+		CommandArgs["cluster-signing-cert-file"] = pki.GetCertPath(pki.CACertName)
+		CommandArgs["cluster-signing-key-file"] = pki.GetKeyPath(pki.CACertName)
+	}
 	if len(c.CloudProvider.Name) > 0 {
-		c.Services.KubeController.ExtraEnv = append(
-			c.Services.KubeController.ExtraEnv,
+		kubeControllerService.ExtraEnv = append(
+			kubeControllerService.ExtraEnv,
 			fmt.Sprintf("%s=%s", CloudConfigSumEnv, getCloudConfigChecksum(c.CloudConfigFile)))
 	}
 	// check if our version has specific options for this component
@@ -338,8 +379,8 @@ func (c *Cluster) BuildKubeControllerProcess(prefixPath string) v3.Process {
 		fmt.Sprintf("%s:/etc/kubernetes:z", path.Join(prefixPath, "/etc/kubernetes")),
 	}
 
-	for arg, value := range c.Services.KubeController.ExtraArgs {
-		if _, ok := c.Services.KubeController.ExtraArgs[arg]; ok {
+	for arg, value := range kubeControllerService.ExtraArgs {
+		if _, ok := kubeControllerService.ExtraArgs[arg]; ok {
 			CommandArgs[arg] = value
 		}
 	}
@@ -349,23 +390,23 @@ func (c *Cluster) BuildKubeControllerProcess(prefixPath string) v3.Process {
 		Command = append(Command, cmd)
 	}
 
-	Binds = append(Binds, c.Services.KubeController.ExtraBinds...)
+	Binds = append(Binds, kubeControllerService.ExtraBinds...)
 
 	healthCheck := v3.HealthCheck{
 		URL: services.GetHealthCheckURL(false, services.KubeControllerPort),
 	}
 
-	registryAuthConfig, _, _ := docker.GetImageRegistryConfig(c.Services.KubeController.Image, c.PrivateRegistriesMap)
+	registryAuthConfig, _, _ := docker.GetImageRegistryConfig(kubeControllerService.Image, c.PrivateRegistriesMap)
 	return v3.Process{
 		Name:                    services.KubeControllerContainerName,
 		Command:                 Command,
 		Args:                    args,
 		VolumesFrom:             VolumesFrom,
 		Binds:                   getUniqStringList(Binds),
-		Env:                     getUniqStringList(c.Services.KubeController.ExtraEnv),
+		Env:                     getUniqStringList(kubeControllerService.ExtraEnv),
 		NetworkMode:             "host",
 		RestartPolicy:           "always",
-		Image:                   c.Services.KubeController.Image,
+		Image:                   kubeControllerService.Image,
 		HealthCheck:             healthCheck,
 		ImageRegistryAuthConfig: registryAuthConfig,
 		Labels: map[string]string{
@@ -375,7 +416,7 @@ func (c *Cluster) BuildKubeControllerProcess(prefixPath string) v3.Process {
 }
 
 func (c *Cluster) BuildKubeletProcess(host *hosts.Host, prefixPath string) v3.Process {
-
+	kubeletService := getNodeService(c.Services.Kubelet.BaseService, getNodeServices(host).Kubelet)
 	Command := []string{
 		c.getRKEToolsEntryPoint(),
 		"kubelet",
@@ -396,7 +437,7 @@ func (c *Cluster) BuildKubeletProcess(host *hosts.Host, prefixPath string) v3.Pr
 		"event-qps":                         "0",
 		"fail-swap-on":                      strconv.FormatBool(c.Services.Kubelet.FailSwapOn),
 		"hostname-override":                 host.HostnameOverride,
-		"kubeconfig":                        pki.GetConfigPath(pki.KubeNodeCertName),
+		"kubeconfig":                        pki.GetConfigPath(pki.GetKubeletCrtName(host.Address)),
 		"make-iptables-util-chains":         "true",
 		"network-plugin":                    "cni",
 		"pod-infra-container-image":         c.Services.Kubelet.InfraContainerImage,
@@ -407,8 +448,22 @@ func (c *Cluster) BuildKubeletProcess(host *hosts.Host, prefixPath string) v3.Pr
 		"volume-plugin-dir":                 "/var/lib/kubelet/volumeplugins",
 		"v":                                 "2",
 	}
+	registerTaints := []string{}
 	if host.IsControl && !host.IsWorker {
-		CommandArgs["register-with-taints"] = unschedulableControlTaint
+		registerTaints = append(registerTaints, unschedulableControlTaint)
+	}
+	for _, taint := range host.Taints {
+		registerTaints = append(registerTaints, getTaintString(taint))
+	}
+	if len(registerTaints) > 0 {
+		CommandArgs["register-with-taints"] = strings.Join(registerTaints, ",")
+	}
+	if IsKubeletTLSBootstrapEnabled(&c.RancherKubernetesEngineConfig) {
+		CommandArgs["bootstrap-kubeconfig"] = kubeletBootstrapConfigFileName
+		CommandArgs["kubeconfig"] = kubeletBootstrappedConfigFileName
+		CommandArgs["cert-dir"] = kubeletBootstrapCertDir
+		CommandArgs["rotate-certificates"] = "true"
+		CommandArgs["rotate-server-certificates"] = "true"
 	}
 	if host.Address != host.InternalAddress {
 		CommandArgs["node-ip"] = host.InternalAddress
@@ -417,19 +472,19 @@ func (c *Cluster) BuildKubeletProcess(host *hosts.Host, prefixPath string) v3.Pr
 		CommandArgs["cloud-config"] = cloudConfigFileName
 	}
 	if len(c.CloudProvider.Name) > 0 {
-		c.Services.Kubelet.ExtraEnv = append(
-			c.Services.Kubelet.ExtraEnv,
+		kubeletService.ExtraEnv = append(
+			kubeletService.ExtraEnv,
 			fmt.Sprintf("%s=%s", CloudConfigSumEnv, getCloudConfigChecksum(c.CloudConfigFile)))
 	}
 	if len(c.PrivateRegistriesMap) > 0 {
 		kubeletDockerConfig, _ := docker.GetKubeletDockerConfig(c.PrivateRegistriesMap)
-		c.Services.Kubelet.ExtraEnv = append(
-			c.Services.Kubelet.ExtraEnv,
+		kubeletService.ExtraEnv = append(
+			kubeletService.ExtraEnv,
 			fmt.Sprintf("%s=%s", KubeletDockerConfigEnv,
 				b64.StdEncoding.EncodeToString([]byte(kubeletDockerConfig))))
 
-		c.Services.Kubelet.ExtraEnv = append(
-			c.Services.Kubelet.ExtraEnv,
+		kubeletService.ExtraEnv = append(
+			kubeletService.ExtraEnv,
 			fmt.Sprintf("%s=%s", KubeletDockerConfigFileEnv, path.Join(prefixPath, KubeletDockerConfigPath)))
 	}
 	// allow-privileged is removed in k8s 1.15
@@ -478,8 +533,8 @@ func (c *Cluster) BuildKubeletProcess(host *hosts.Host, prefixPath string) v3.Pr
 		Binds = append(Binds, "/var/lib/kubelet/volumeplugins:/var/lib/kubelet/volumeplugins:shared,z")
 	}
 
-	for arg, value := range c.Services.Kubelet.ExtraArgs {
-		if _, ok := c.Services.Kubelet.ExtraArgs[arg]; ok {
+	for arg, value := range kubeletService.ExtraArgs {
+		if _, ok := kubeletService.ExtraArgs[arg]; ok {
 			CommandArgs[arg] = value
 		}
 	}
@@ -489,22 +544,22 @@ func (c *Cluster) BuildKubeletProcess(host *hosts.Host, prefixPath string) v3.Pr
 		Command = append(Command, cmd)
 	}
 
-	Binds = append(Binds, c.Services.Kubelet.ExtraBinds...)
+	Binds = append(Binds, kubeletService.ExtraBinds...)
 
 	healthCheck := v3.HealthCheck{
 		URL: services.GetHealthCheckURL(true, services.KubeletPort),
 	}
-	registryAuthConfig, _, _ := docker.GetImageRegistryConfig(c.Services.Kubelet.Image, c.PrivateRegistriesMap)
+	registryAuthConfig, _, _ := docker.GetImageRegistryConfig(kubeletService.Image, c.PrivateRegistriesMap)
 
 	return v3.Process{
 		Name:                    services.KubeletContainerName,
 		Command:                 Command,
 		VolumesFrom:             VolumesFrom,
 		Binds:                   getUniqStringList(Binds),
-		Env:                     getUniqStringList(c.Services.Kubelet.ExtraEnv),
+		Env:                     getUniqStringList(kubeletService.ExtraEnv),
 		NetworkMode:             "host",
 		RestartPolicy:           "always",
-		Image:                   c.Services.Kubelet.Image,
+		Image:                   kubeletService.Image,
 		PidMode:                 "host",
 		Privileged:              true,
 		HealthCheck:             healthCheck,
@@ -516,6 +571,7 @@ func (c *Cluster) BuildKubeletProcess(host *hosts.Host, prefixPath string) v3.Pr
 }
 
 func (c *Cluster) BuildKubeProxyProcess(host *hosts.Host, prefixPath string) v3.Process {
+	kubeproxyService := getNodeService(c.Services.Kubeproxy.BaseService, getNodeServices(host).Kubeproxy)
 	Command := []string{
 		c.getRKEToolsEntryPoint(),
 		"kube-proxy",
@@ -553,8 +609,8 @@ func (c *Cluster) BuildKubeProxyProcess(host *hosts.Host, prefixPath string) v3.
 		"/run:/run",
 	}
 
-	for arg, value := range c.Services.Kubeproxy.ExtraArgs {
-		if _, ok := c.Services.Kubeproxy.ExtraArgs[arg]; ok {
+	for arg, value := range kubeproxyService.ExtraArgs {
+		if _, ok := kubeproxyService.ExtraArgs[arg]; ok {
 			CommandArgs[arg] = value
 		}
 	}
@@ -564,24 +620,24 @@ func (c *Cluster) BuildKubeProxyProcess(host *hosts.Host, prefixPath string) v3.
 		Command = append(Command, cmd)
 	}
 
-	Binds = append(Binds, c.Services.Kubeproxy.ExtraBinds...)
+	Binds = append(Binds, kubeproxyService.ExtraBinds...)
 
 	healthCheck := v3.HealthCheck{
 		URL: services.GetHealthCheckURL(false, services.KubeproxyPort),
 	}
-	registryAuthConfig, _, _ := docker.GetImageRegistryConfig(c.Services.Kubeproxy.Image, c.PrivateRegistriesMap)
+	registryAuthConfig, _, _ := docker.GetImageRegistryConfig(kubeproxyService.Image, c.PrivateRegistriesMap)
 	return v3.Process{
 		Name:                    services.KubeproxyContainerName,
 		Command:                 Command,
 		VolumesFrom:             VolumesFrom,
 		Binds:                   getUniqStringList(Binds),
-		Env:                     c.Services.Kubeproxy.ExtraEnv,
+		Env:                     kubeproxyService.ExtraEnv,
 		NetworkMode:             "host",
 		RestartPolicy:           "always",
 		PidMode:                 "host",
 		Privileged:              true,
 		HealthCheck:             healthCheck,
-		Image:                   c.Services.Kubeproxy.Image,
+		Image:                   kubeproxyService.Image,
 		ImageRegistryAuthConfig: registryAuthConfig,
 		Labels: map[string]string{
 			ContainerNameLabel: services.KubeproxyContainerName,
@@ -617,7 +673,8 @@ func (c *Cluster) BuildProxyProcess() v3.Process {
 	}
 }
 
-func (c *Cluster) BuildSchedulerProcess(prefixPath string) v3.Process {
+func (c *Cluster) BuildSchedulerProcess(host *hosts.Host, prefixPath string) v3.Process {
+	schedulerService := getNodeService(c.Services.Scheduler.BaseService, getNodeServices(host).Scheduler)
 	Command := []string{
 		c.getRKEToolsEntryPoint(),
 		"kube-scheduler",
@@ -656,8 +713,8 @@ func (c *Cluster) BuildSchedulerProcess(prefixPath string) v3.Process {
 		fmt.Sprintf("%s:/etc/kubernetes:z", path.Join(prefixPath, "/etc/kubernetes")),
 	}
 
-	for arg, value := range c.Services.Scheduler.ExtraArgs {
-		if _, ok := c.Services.Scheduler.ExtraArgs[arg]; ok {
+	for arg, value := range schedulerService.ExtraArgs {
+		if _, ok := schedulerService.ExtraArgs[arg]; ok {
 			CommandArgs[arg] = value
 		}
 	}
@@ -667,21 +724,21 @@ func (c *Cluster) BuildSchedulerProcess(prefixPath string) v3.Process {
 		Command = append(Command, cmd)
 	}
 
-	Binds = append(Binds, c.Services.Scheduler.ExtraBinds...)
+	Binds = append(Binds, schedulerService.ExtraBinds...)
 
 	healthCheck := v3.HealthCheck{
 		URL: services.GetHealthCheckURL(false, services.SchedulerPort),
 	}
-	registryAuthConfig, _, _ := docker.GetImageRegistryConfig(c.Services.Scheduler.Image, c.PrivateRegistriesMap)
+	registryAuthConfig, _, _ := docker.GetImageRegistryConfig(schedulerService.Image, c.PrivateRegistriesMap)
 	return v3.Process{
 		Name:                    services.SchedulerContainerName,
 		Command:                 Command,
 		Binds:                   getUniqStringList(Binds),
-		Env:                     c.Services.Scheduler.ExtraEnv,
+		Env:                     schedulerService.ExtraEnv,
 		VolumesFrom:             VolumesFrom,
 		NetworkMode:             "host",
 		RestartPolicy:           "always",
-		Image:                   c.Services.Scheduler.Image,
+		Image:                   schedulerService.Image,
 		HealthCheck:             healthCheck,
 		ImageRegistryAuthConfig: registryAuthConfig,
 		Labels: map[string]string{
@@ -706,6 +763,7 @@ func (c *Cluster) BuildSidecarProcess() v3.Process {
 }
 
 func (c *Cluster) BuildEtcdProcess(host *hosts.Host, etcdHosts []*hosts.Host, prefixPath string) v3.Process {
+	etcdService := getNodeService(c.Services.Etcd.BaseService, getNodeServices(host).Etcd)
 	nodeName := pki.GetEtcdCrtName(host.InternalAddress)
 	initCluster := ""
 	architecture := "amd64"
@@ -758,8 +816,8 @@ func (c *Cluster) BuildEtcdProcess(host *hosts.Host, etcdHosts []*hosts.Host, pr
 		fmt.Sprintf("%s:/etc/kubernetes:z", path.Join(prefixPath, "/etc/kubernetes")),
 	}
 
-	for arg, value := range c.Services.Etcd.ExtraArgs {
-		if _, ok := c.Services.Etcd.ExtraArgs[arg]; ok {
+	for arg, value := range etcdService.ExtraArgs {
+		if _, ok := etcdService.ExtraArgs[arg]; ok {
 			CommandArgs[arg] = value
 		}
 	}
@@ -769,14 +827,14 @@ func (c *Cluster) BuildEtcdProcess(host *hosts.Host, etcdHosts []*hosts.Host, pr
 		args = append(args, cmd)
 	}
 
-	Binds = append(Binds, c.Services.Etcd.ExtraBinds...)
+	Binds = append(Binds, etcdService.ExtraBinds...)
 	healthCheck := v3.HealthCheck{
 		URL: fmt.Sprintf("https://%s:2379/health", host.InternalAddress),
 	}
-	registryAuthConfig, _, _ := docker.GetImageRegistryConfig(c.Services.Etcd.Image, c.PrivateRegistriesMap)
+	registryAuthConfig, _, _ := docker.GetImageRegistryConfig(etcdService.Image, c.PrivateRegistriesMap)
 
 	// Determine etcd version for correct etcdctl environment variables
-	etcdTag, err := util.GetImageTagFromImage(c.Services.Etcd.Image)
+	etcdTag, err := util.GetImageTagFromImage(etcdService.Image)
 	if err != nil {
 		logrus.Warn(err)
 	}
@@ -816,7 +874,7 @@ func (c *Cluster) BuildEtcdProcess(host *hosts.Host, etcdHosts []*hosts.Host, pr
 	}
 	Env = append(Env, fmt.Sprintf("ETCD_UNSUPPORTED_ARCH=%s", architecture))
 
-	Env = append(Env, c.Services.Etcd.ExtraEnv...)
+	Env = append(Env, etcdService.ExtraEnv...)
 
 	return v3.Process{
 		Name:                    services.EtcdContainerName,
@@ -825,7 +883,7 @@ func (c *Cluster) BuildEtcdProcess(host *hosts.Host, etcdHosts []*hosts.Host, pr
 		Env:                     Env,
 		NetworkMode:             "host",
 		RestartPolicy:           "always",
-		Image:                   c.Services.Etcd.Image,
+		Image:                   etcdService.Image,
 		HealthCheck:             healthCheck,
 		ImageRegistryAuthConfig: registryAuthConfig,
 		Labels: map[string]string{
@@ -872,6 +930,44 @@ func getCloudConfigChecksum(config string) string {
 	return fmt.Sprintf("%x", configByteSum)
 }
 
+// getNodeServices returns the services configuration of the node, empty when the node doesn't override any service
+func getNodeServices(host *hosts.Host) v3.RKEConfigNodeServices {
+	if host == nil || host.Services == nil {
+		return v3.RKEConfigNodeServices{}
+	}
+	return *host.Services
+}
+
+// getNodeService merges the node service configuration on top of the cluster service: the node image and
+// extra_args replace the cluster values, extra_binds are added and extra_env replaces variables of the same name
+func getNodeService(clusterService, nodeService v3.BaseService) v3.BaseService {
+	service := v3.BaseService{
+		Image:      clusterService.Image,
+		ExtraArgs:  map[string]string{},
+		ExtraBinds: append(append([]string{}, clusterService.ExtraBinds...), nodeService.ExtraBinds...),
+	}
+	if len(nodeService.Image) > 0 {
+		service.Image = nodeService.Image
+	}
+	for arg, value := range clusterService.ExtraArgs {
+		service.ExtraArgs[arg] = value
+	}
+	for arg, value := range nodeService.ExtraArgs {
+		service.ExtraArgs[arg] = value
+	}
+	nodeEnvNames := map[string]bool{}
+	for _, env := range nodeService.ExtraEnv {
+		nodeEnvNames[strings.SplitN(env, "=", 2)[0]] = true
+	}
+	for _, env := range clusterService.ExtraEnv {
+		if !nodeEnvNames[strings.SplitN(env, "=", 2)[0]] {
+			service.ExtraEnv = append(service.ExtraEnv, env)
+		}
+	}
+	service.ExtraEnv = append(service.ExtraEnv, nodeService.ExtraEnv...)
+	return service
+}
+
 func getUniqStringList(l []string) []string {
 	m := map[string]bool{}
 	ul := []string{}
@@ -902,3 +998,89 @@ func (c *Cluster) getRKEToolsEntryPoint() string {
 	}
 	return DefaultToolsEntrypoint
 }
+
+// NodePlanDiff holds the container changes rke up would make on a single host
+type NodePlanDiff struct {
+	Address    string
+	Containers []docker.ContainerDiff
+}
+
+// PlanDiff holds the changes rke up would make to the cluster
+type PlanDiff struct {
+	Nodes []NodePlanDiff
+	// ToAddHosts and ToDeleteHosts map a role to the addresses reconcile would add or remove
+	ToAddHosts    map[string][]string
+	ToDeleteHosts map[string][]string
+	InactiveHosts []string
+}
+
+// DiffPlan compares the node plans of the cluster with the running containers on every host without changing anything
+func (c *Cluster) DiffPlan(ctx context.Context, currentCluster *Cluster) (PlanDiff, error) {
+	planDiff := PlanDiff{
+		ToAddHosts:    make(map[string][]string),
+		ToDeleteHosts: make(map[string][]string),
+	}
+	var currentEtcdHosts, currentControlPlaneHosts, currentWorkerHosts []*hosts.Host
+	if currentCluster != nil {
+		currentEtcdHosts = currentCluster.EtcdHosts
+		currentControlPlaneHosts = currentCluster.ControlPlaneHosts
+		currentWorkerHosts = currentCluster.WorkerHosts
+	}
+	planDiff.setHostChanges(services.ETCDRole, currentEtcdHosts, c.EtcdHosts, c.InactiveHosts)
+	planDiff.setHostChanges(services.ControlRole, currentControlPlaneHosts, c.ControlPlaneHosts, c.InactiveHosts)
+	planDiff.setHostChanges(services.WorkerRole, currentWorkerHosts, c.WorkerHosts, c.InactiveHosts)
+	for _, host := range c.InactiveHosts {
+		planDiff.InactiveHosts = append(planDiff.InactiveHosts, host.Address)
+	}
+	// reconcile marks the etcd cluster as existing once new members are added
+	if currentCluster != nil && len(planDiff.ToAddHosts[services.ETCDRole]) > 0 {
+		c.setReadyEtcdHosts()
+	}
+
+	uniqHosts := hosts.GetUniqueHostList(c.EtcdHosts, c.ControlPlaneHosts, c.WorkerHosts)
+	planDiff.Nodes = make([]NodePlanDiff, len(uniqHosts))
+	var errgrp errgroup.Group
+	for i, uniqHost := range uniqHosts {
+		runHost := uniqHost
+		nodeIndex := i
+		errgrp.Go(func() error {
+			nodeDiff, err := diffNodePlan(ctx, runHost, BuildRKEConfigNodePlan(ctx, c, runHost, runHost.DockerInfo))
+			if err != nil {
+				return err
+			}
+			planDiff.Nodes[nodeIndex] = nodeDiff
+			return nil
+		})
+	}
+	if err := errgrp.Wait(); err != nil {
+		return planDiff, err
+	}
+	return planDiff, nil
+}
+
+func (p *PlanDiff) setHostChanges(role string, currentHosts, configHosts, inactiveHosts []*hosts.Host) {
+	for _, host := range hosts.GetToAddHosts(currentHosts, configHosts) {
+		p.ToAddHosts[role] = append(p.ToAddHosts[role], host.Address)
+	}
+	for _, host := range hosts.GetToDeleteHosts(currentHosts, configHosts, inactiveHosts, false) {
+		p.ToDeleteHosts[role] = append(p.ToDeleteHosts[role], host.Address)
+	}
+}
+
+func diffNodePlan(ctx context.Context, host *hosts.Host, nodePlan v3.RKEConfigNodePlan) (NodePlanDiff, error) {
+	nodeDiff := NodePlanDiff{Address: host.Address}
+	containerNames := make([]string, 0, len(nodePlan.Processes))
+	for containerName := range nodePlan.Processes {
+		containerNames = append(containerNames, containerName)
+	}
+	sort.Strings(containerNames)
+	for _, containerName := range containerNames {
+		imageCfg, hostCfg, _ := services.GetProcessConfig(nodePlan.Processes[containerName])
+		containerDiff, err := docker.GetContainerDiff(ctx, host.DClient, imageCfg, hostCfg, containerName, host.Address, "plan")
+		if err != nil {
+			return nodeDiff, err
+		}
+		nodeDiff.Containers = append(nodeDiff.Containers, containerDiff)
+	}
+	return nodeDiff, nil
+}
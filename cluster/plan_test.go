@@ -0,0 +1,227 @@
+package cluster
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/pki"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+const auditLogClusterFile = `
+nodes:
+- address: 1.1.1.1
+  user: rancher
+  role: [controlplane, etcd, worker]
+services:
+  kube-api:
+    audit_log:
+      enabled: true
+`
+
+func TestKubeAPIAuditLog(t *testing.T) {
+	rkeConfig, err := ParseConfig(auditLogClusterFile)
+	if err != nil {
+		t.Fatalf("Failed to parse cluster file: %v", err)
+	}
+	c, err := InitClusterObject(context.Background(), rkeConfig, ExternalFlags{})
+	if err != nil {
+		t.Fatalf("Failed to init cluster object: %v", err)
+	}
+	host := &hosts.Host{RKEConfigNode: rkeConfig.Nodes[0], IsControl: true}
+	process := c.BuildKubeAPIProcess(host, "/")
+	command := strings.Join(process.Command, " ")
+	for _, arg := range []string{"--audit-policy-file=" + auditPolicyFileName, "--audit-log-path=" + DefaultAuditLogPath, "--audit-log-maxage=30", "--audit-log-maxbackup=10", "--audit-log-maxsize=100"} {
+		if !strings.Contains(command, arg) {
+			t.Fatalf("Expected kube-apiserver arg [%s] in [%s]", arg, command)
+		}
+	}
+	if !containsString(process.Binds, "/var/log/kube-audit:/var/log/kube-audit:z") {
+		t.Fatalf("Expected audit log dir bind, got %v", process.Binds)
+	}
+	checksumEnv := getProcessEnv(process, AuditPolicySumEnv)
+	if len(checksumEnv) == 0 {
+		t.Fatalf("Expected [%s] in kube-apiserver env, got %v", AuditPolicySumEnv, process.Env)
+	}
+
+	// a policy change must change the container env so the container gets upgraded
+	c.Services.KubeAPI.AuditLog.Policy = "apiVersion: audit.k8s.io/v1beta1\nkind: Policy\nrules:\n- level: Request\n"
+	if getProcessEnv(c.BuildKubeAPIProcess(host, "/"), AuditPolicySumEnv) == checksumEnv {
+		t.Fatalf("Expected the audit policy checksum to change with the policy")
+	}
+}
+
+func TestKubeAPIAuditLogRotationLimits(t *testing.T) {
+	// a limit of 0 turns it off and must not be replaced by the default
+	rkeConfig, err := ParseConfig(auditLogClusterFile + "      max_age: 0\n      max_backup: 0\n      max_size: 50\n")
+	if err != nil {
+		t.Fatalf("Failed to parse cluster file: %v", err)
+	}
+	c, err := InitClusterObject(context.Background(), rkeConfig, ExternalFlags{})
+	if err != nil {
+		t.Fatalf("Failed to init cluster object: %v", err)
+	}
+	host := &hosts.Host{RKEConfigNode: rkeConfig.Nodes[0], IsControl: true}
+	command := strings.Join(c.BuildKubeAPIProcess(host, "/").Command, " ")
+	for _, arg := range []string{"--audit-log-maxage=0", "--audit-log-maxbackup=0", "--audit-log-maxsize=50"} {
+		if !strings.Contains(command, arg) {
+			t.Fatalf("Expected kube-apiserver arg [%s] in [%s]", arg, command)
+		}
+	}
+}
+
+func TestKubeletTLSBootstrap(t *testing.T) {
+	rkeConfig := &v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			{Address: "1.1.1.1", User: "rancher", Role: []string{"controlplane", "etcd"}},
+			{Address: "2.2.2.2", User: "rancher", Role: []string{"worker"}},
+		},
+	}
+	rkeConfig.Services.Kubelet.TLSBootstrap = true
+	c, err := InitClusterObject(context.Background(), rkeConfig, ExternalFlags{})
+	if err != nil {
+		t.Fatalf("Failed to init cluster object: %v", err)
+	}
+	worker := &hosts.Host{RKEConfigNode: rkeConfig.Nodes[1], IsWorker: true}
+	kubeletCommand := strings.Join(c.BuildKubeletProcess(worker, "/").Command, " ")
+	for _, arg := range []string{"--bootstrap-kubeconfig=" + kubeletBootstrapConfigFileName, "--kubeconfig=" + kubeletBootstrappedConfigFileName, "--rotate-server-certificates=true"} {
+		if !strings.Contains(kubeletCommand, arg) {
+			t.Fatalf("Expected kubelet arg [%s] in [%s]", arg, kubeletCommand)
+		}
+	}
+	kubeProxyCommand := strings.Join(c.BuildKubeProxyProcess(worker, "/").Command, " ")
+	if !strings.Contains(kubeProxyCommand, "--kubeconfig="+pki.GetConfigPath(pki.KubeProxyCertName)) {
+		t.Fatalf("Expected kube-proxy to use its own kubeconfig in [%s]", kubeProxyCommand)
+	}
+	controllerCommand := strings.Join(c.BuildKubeControllerProcess(c.ControlPlaneHosts[0], "/").Command, " ")
+	if !strings.Contains(controllerCommand, "--cluster-signing-key-file="+pki.GetKeyPath(pki.CACertName)) {
+		t.Fatalf("Expected kube-controller-manager to sign with the cluster CA in [%s]", controllerCommand)
+	}
+
+	// worker only nodes don't get a kubelet certificate, the kubelet requests its own
+	certs, err := pki.GenerateRKECerts(context.Background(), c.RancherKubernetesEngineConfig, "", "")
+	if err != nil {
+		t.Fatalf("Failed to generate certificates: %v", err)
+	}
+	workerCerts := pki.GenerateRKENodeCerts(context.Background(), c.RancherKubernetesEngineConfig, worker.Address, certs)
+	if len(workerCerts) != 3 || workerCerts[pki.CACertName].Key != nil || workerCerts[pki.KubeProxyCertName].Certificate == nil || workerCerts[pki.KubeCNICertName].Certificate == nil {
+		t.Fatalf("Expected the CA certificate without key, kube-proxy and CNI certificates on worker nodes, got %d certificates", len(workerCerts))
+	}
+
+	// every node gets its own token
+	if err := c.generateKubeletBootstrapTokens(); err != nil {
+		t.Fatalf("Failed to generate kubelet bootstrap tokens: %v", err)
+	}
+	if len(c.KubeletBootstrapTokens) != 2 || c.KubeletBootstrapTokens["1.1.1.1"] == c.KubeletBootstrapTokens["2.2.2.2"] {
+		t.Fatalf("Expected a distinct kubelet bootstrap token per node, got %v", c.KubeletBootstrapTokens)
+	}
+	for address, token := range c.KubeletBootstrapTokens {
+		if len(token) != bootstrapTokenIDSize+1+bootstrapTokenSecretSize || token[bootstrapTokenIDSize] != '.' {
+			t.Fatalf("Unexpected kubelet bootstrap token format [%s] for node [%s]", token, address)
+		}
+	}
+}
+
+func getProcessEnv(process v3.Process, name string) string {
+	for _, env := range process.Env {
+		if strings.HasPrefix(env, name+"=") {
+			return env
+		}
+	}
+	return ""
+}
+
+func containsString(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNodeTaints(t *testing.T) {
+	gpuTaint := v3.RKETaint{Key: "gpu", Value: "true", Effect: "NoSchedule"}
+	dedicatedTaint := v3.RKETaint{Key: "dedicated", Value: "ingress", Effect: "NoExecute"}
+	rkeConfig := &v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			{Address: "1.1.1.1", User: "rancher", Role: []string{"controlplane", "etcd"}, Taints: []v3.RKETaint{gpuTaint}},
+		},
+	}
+	c, err := InitClusterObject(context.Background(), rkeConfig, ExternalFlags{})
+	if err != nil {
+		t.Fatalf("Failed to init cluster object: %v", err)
+	}
+	host := c.ControlPlaneHosts[0]
+	if !containsString(host.ToAddTaints, "gpu=true:NoSchedule") {
+		t.Fatalf("Expected node taint to be added, got %v", host.ToAddTaints)
+	}
+	kubeletCommand := strings.Join(c.BuildKubeletProcess(host, "/").Command, " ")
+	expected := "--register-with-taints=" + unschedulableControlTaint + ",gpu=true:NoSchedule"
+	if !strings.Contains(kubeletCommand, expected) {
+		t.Fatalf("Expected kubelet arg [%s] in [%s]", expected, kubeletCommand)
+	}
+
+	// taints removed from the config are removed from the node
+	currentConfig := rkeConfig.DeepCopy()
+	currentConfig.Nodes[0].Taints = []v3.RKETaint{gpuTaint, dedicatedTaint}
+	currentCluster, err := InitClusterObject(context.Background(), currentConfig, ExternalFlags{})
+	if err != nil {
+		t.Fatalf("Failed to init cluster object: %v", err)
+	}
+	syncTaints(context.Background(), currentCluster, c)
+	if len(host.ToDelTaints) != 1 || host.ToDelTaints[0] != "dedicated=ingress:NoExecute" {
+		t.Fatalf("Expected only the removed taint to be deleted, got %v", host.ToDelTaints)
+	}
+}
+
+func TestNodeServices(t *testing.T) {
+	rkeConfig := &v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			{Address: "1.1.1.1", User: "rancher", Role: []string{"controlplane", "etcd", "worker"}},
+			{Address: "2.2.2.2", User: "rancher", Role: []string{"worker"}},
+		},
+	}
+	rkeConfig.Services.Kubelet.ExtraArgs = map[string]string{"max-pods": "110", "v": "4"}
+	rkeConfig.Services.Kubelet.ExtraBinds = []string{"/opt/a:/opt/a"}
+	rkeConfig.Services.Kubelet.ExtraEnv = []string{"A=1", "B=1"}
+	rkeConfig.Nodes[1].Services = &v3.RKEConfigNodeServices{
+		Kubelet: v3.BaseService{
+			Image:      "rancher/hyperkube:custom",
+			ExtraArgs:  map[string]string{"max-pods": "250", "root-dir": "/data/kubelet"},
+			ExtraBinds: []string{"/opt/nvidia:/opt/nvidia"},
+			ExtraEnv:   []string{"B=2"},
+		},
+	}
+	c, err := InitClusterObject(context.Background(), rkeConfig, ExternalFlags{})
+	if err != nil {
+		t.Fatalf("Failed to init cluster object: %v", err)
+	}
+	process := c.BuildKubeletProcess(c.WorkerHosts[1], "/")
+	command := strings.Join(process.Command, " ")
+	for _, arg := range []string{"--max-pods=250", "--root-dir=/data/kubelet", "--v=4"} {
+		if !strings.Contains(command, arg) {
+			t.Fatalf("Expected kubelet arg [%s] in [%s]", arg, command)
+		}
+	}
+	if process.Image != "rancher/hyperkube:custom" {
+		t.Fatalf("Expected the node kubelet image, got [%s]", process.Image)
+	}
+	if !containsString(process.Binds, "/opt/a:/opt/a") || !containsString(process.Binds, "/opt/nvidia:/opt/nvidia") {
+		t.Fatalf("Expected the cluster and node binds, got %v", process.Binds)
+	}
+	if !containsString(process.Env, "A=1") || !containsString(process.Env, "B=2") || containsString(process.Env, "B=1") {
+		t.Fatalf("Expected the node env to override the cluster env, got %v", process.Env)
+	}
+
+	// nodes without overrides keep the cluster values
+	process = c.BuildKubeletProcess(c.WorkerHosts[0], "/")
+	if !strings.Contains(strings.Join(process.Command, " "), "--max-pods=110") || process.Image != c.Services.Kubelet.Image {
+		t.Fatalf("Expected the cluster kubelet configuration, got %v", process.Command)
+	}
+	if containsString(process.Binds, "/opt/nvidia:/opt/nvidia") {
+		t.Fatalf("Expected no node binds, got %v", process.Binds)
+	}
+}
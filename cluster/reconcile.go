@@ -37,8 +37,9 @@ func ReconcileCluster(ctx context.Context, kubeCluster, currentCluster *Cluster,
 	if err != nil {
 		return fmt.Errorf("Failed to initialize new kubernetes client: %v", err)
 	}
-	// sync node labels to define the toDelete labels
+	// sync node labels and taints to define the toDelete labels and taints
 	syncLabels(ctx, currentCluster, kubeCluster)
+	syncTaints(ctx, currentCluster, kubeCluster)
 
 	if err := reconcileEtcd(ctx, currentCluster, kubeCluster, kubeClient); err != nil {
 		return fmt.Errorf("Failed to reconcile etcd plane: %v", err)
@@ -173,7 +174,7 @@ func reconcileEtcd(ctx context.Context, currentCluster, kubeCluster *Cluster, ku
 	}
 	// get tls for the first current etcd host
 	clientCert := cert.EncodeCertPEM(currentCluster.Certificates[pki.KubeNodeCertName].Certificate)
-	clientkey := cert.EncodePrivateKeyPEM(currentCluster.Certificates[pki.KubeNodeCertName].Key)
+	clientkey := pki.EncodePrivateKeyPEM(currentCluster.Certificates[pki.KubeNodeCertName].Key)
 
 	etcdToDelete := hosts.GetToDeleteHosts(currentCluster.EtcdHosts, kubeCluster.EtcdHosts, kubeCluster.InactiveHosts, false)
 	for _, etcdHost := range etcdToDelete {
@@ -241,6 +242,37 @@ func syncLabels(ctx context.Context, currentCluster, kubeCluster *Cluster) {
 	}
 }
 
+func syncTaints(ctx context.Context, currentCluster, kubeCluster *Cluster) {
+	currentHosts := hosts.GetUniqueHostList(currentCluster.EtcdHosts, currentCluster.ControlPlaneHosts, currentCluster.WorkerHosts)
+	configHosts := hosts.GetUniqueHostList(kubeCluster.EtcdHosts, kubeCluster.ControlPlaneHosts, kubeCluster.WorkerHosts)
+	for _, host := range configHosts {
+		for _, currentHost := range currentHosts {
+			if host.Address == currentHost.Address {
+				for _, taint := range currentHost.Taints {
+					if !hasTaint(host.Taints, taint) {
+						host.ToDelTaints = append(host.ToDelTaints, getTaintString(taint))
+					}
+				}
+				break
+			}
+		}
+	}
+}
+
+func hasTaint(taints []v3.RKETaint, taint v3.RKETaint) bool {
+	for _, t := range taints {
+		if t == taint {
+			return true
+		}
+	}
+	return false
+}
+
+// getTaintString returns the taint in the key=value:effect format used by the kubelet and k8s.SyncNodeTaints
+func getTaintString(taint v3.RKETaint) string {
+	return fmt.Sprintf("%s=%s:%s", taint.Key, taint.Value, taint.Effect)
+}
+
 func (c *Cluster) setReadyEtcdHosts() {
 	c.EtcdReadyHosts = []*hosts.Host{}
 	for _, host := range c.EtcdHosts {
@@ -304,6 +336,23 @@ func restartComponentsWhenCertChanges(ctx context.Context, currentCluster, kubeC
 		}
 	}
 
+	for _, host := range allHosts {
+		kubeletCertName := pki.GetKubeletCrtName(host.Address)
+		// kubelet of a new node or a node without its own certificate yet is (re)created with the new kubeconfig
+		if AllCertsMap[pki.CACertName] || AllCertsMap[pki.KubeNodeCertName] || currentCluster.Certificates[kubeletCertName].Certificate == nil {
+			continue
+		}
+		certMap := map[string]bool{
+			kubeletCertName: false,
+		}
+		checkCertificateChanges(ctx, currentCluster, kubeCluster, certMap)
+		if certMap[kubeletCertName] {
+			if err := services.RestartKubelet(ctx, host); err != nil {
+				return err
+			}
+		}
+	}
+
 	for _, host := range kubeCluster.EtcdHosts {
 		etcdCertName := pki.GetEtcdCrtName(host.Address)
 		certMap := map[string]bool{
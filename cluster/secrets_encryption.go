@@ -0,0 +1,252 @@
+package cluster
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/rancher/rke/k8s"
+	"github.com/rancher/rke/log"
+	"github.com/rancher/rke/services"
+	"github.com/rancher/rke/util"
+	v3 "github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	AESCBCEncryptionProvider         = "aescbc"
+	SecretboxEncryptionProvider      = "secretbox"
+	DefaultSecretsEncryptionProvider = AESCBCEncryptionProvider
+	// MinSecretsEncryptionVersion is the first version with the non experimental encryption-provider-config flag
+	MinSecretsEncryptionVersion = "1.13.0"
+
+	encryptionConfigAPIVersion = "apiserver.config.k8s.io/v1"
+	encryptionConfigKind       = "EncryptionConfiguration"
+	identityEncryptionProvider = "identity"
+	secretsEncryptionKeySize   = 32
+	// secretsEncryptionKeySuffixSize is the number of random bytes appended to the key name
+	secretsEncryptionKeySuffixSize = 4
+)
+
+// SecretsEncryptionKey is a kube-apiserver encryption key kept in the cluster state, the first key encrypts new writes
+type SecretsEncryptionKey struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	Secret   string `json:"secret"`
+}
+
+type encryptionConfiguration struct {
+	APIVersion string                            `yaml:"apiVersion"`
+	Kind       string                            `yaml:"kind"`
+	Resources  []encryptionResourceConfiguration `yaml:"resources"`
+}
+
+type encryptionResourceConfiguration struct {
+	Resources []string                 `yaml:"resources"`
+	Providers []map[string]interface{} `yaml:"providers"`
+}
+
+type encryptionKeysConfiguration struct {
+	Keys []encryptionKey `yaml:"keys"`
+}
+
+type encryptionKey struct {
+	Name   string `yaml:"name"`
+	Secret string `yaml:"secret"`
+}
+
+// NewSecretsEncryptionKey generates a key whose name isn't used by any of the existing keys,
+// the name has a random suffix so keys generated within the same second don't collide
+func NewSecretsEncryptionKey(provider string, existingKeys []SecretsEncryptionKey) (SecretsEncryptionKey, error) {
+	secret := make([]byte, secretsEncryptionKeySize)
+	if _, err := cryptorand.Read(secret); err != nil {
+		return SecretsEncryptionKey{}, fmt.Errorf("Failed to generate secrets encryption key: %v", err)
+	}
+	suffix := make([]byte, secretsEncryptionKeySuffixSize)
+	if _, err := cryptorand.Read(suffix); err != nil {
+		return SecretsEncryptionKey{}, fmt.Errorf("Failed to generate secrets encryption key name: %v", err)
+	}
+	name := fmt.Sprintf("key-%s-%s", time.Now().UTC().Format("20060102150405"), hex.EncodeToString(suffix))
+	for _, key := range existingKeys {
+		if key.Name == name {
+			return SecretsEncryptionKey{}, fmt.Errorf("Failed to generate secrets encryption key: name [%s] is already used", name)
+		}
+	}
+	return SecretsEncryptionKey{
+		Name:     name,
+		Provider: provider,
+		Secret:   base64.StdEncoding.EncodeToString(secret),
+	}, nil
+}
+
+func IsSecretsEncryptionEnabled(rkeConfig *v3.RancherKubernetesEngineConfig) bool {
+	return rkeConfig.Services.KubeAPI.SecretsEncryption != nil && rkeConfig.Services.KubeAPI.SecretsEncryption.Enabled
+}
+
+func GetSecretsEncryptionProvider(rkeConfig *v3.RancherKubernetesEngineConfig) string {
+	if rkeConfig.Services.KubeAPI.SecretsEncryption == nil || len(rkeConfig.Services.KubeAPI.SecretsEncryption.Provider) == 0 {
+		return DefaultSecretsEncryptionProvider
+	}
+	return rkeConfig.Services.KubeAPI.SecretsEncryption.Provider
+}
+
+func SetUpSecretsEncryption(kubeCluster *Cluster, fullState *FullState) {
+	kubeCluster.SecretsEncryptionKeys = fullState.DesiredState.SecretsEncryptionKeys
+}
+
+// rebuildSecretsEncryptionKeys generates the first key when encryption is enabled, existing keys are only changed by a key rotation
+func rebuildSecretsEncryptionKeys(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfig, keys []SecretsEncryptionKey) ([]SecretsEncryptionKey, error) {
+	if !IsSecretsEncryptionEnabled(rkeConfig) {
+		if len(keys) > 0 {
+			log.Warnf(ctx, "[secrets-encryption] Secrets encryption is disabled, new secrets are stored unencrypted but existing secrets stay encrypted until they are updated")
+		}
+		return keys, nil
+	}
+	provider := GetSecretsEncryptionProvider(rkeConfig)
+	if len(keys) == 0 {
+		log.Infof(ctx, "[secrets-encryption] Generating [%s] secrets encryption key", provider)
+		key, err := NewSecretsEncryptionKey(provider, keys)
+		if err != nil {
+			return nil, err
+		}
+		return []SecretsEncryptionKey{key}, nil
+	}
+	if keys[0].Provider != provider {
+		log.Warnf(ctx, "[secrets-encryption] Secrets are encrypted with [%s] but [%s] is configured, run 'rke encrypt rotate-key' to switch provider", keys[0].Provider, provider)
+	}
+	return keys, nil
+}
+
+// getEncryptionProviderConfig renders the kube-apiserver EncryptionConfiguration of the cluster keys.
+// identity is kept as the last provider so secrets written before encryption was enabled can still be read,
+// it comes first when encryption is disabled so the keys are only used to read the existing secrets.
+func (c *Cluster) getEncryptionProviderConfig() string {
+	providers := []map[string]interface{}{}
+	var lastProvider string
+	for _, key := range c.SecretsEncryptionKeys {
+		configKey := encryptionKey{Name: key.Name, Secret: key.Secret}
+		if key.Provider == lastProvider {
+			keysConfig := providers[len(providers)-1][key.Provider].(encryptionKeysConfiguration)
+			keysConfig.Keys = append(keysConfig.Keys, configKey)
+			providers[len(providers)-1][key.Provider] = keysConfig
+			continue
+		}
+		providers = append(providers, map[string]interface{}{
+			key.Provider: encryptionKeysConfiguration{Keys: []encryptionKey{configKey}},
+		})
+		lastProvider = key.Provider
+	}
+	identity := map[string]interface{}{identityEncryptionProvider: struct{}{}}
+	if IsSecretsEncryptionEnabled(&c.RancherKubernetesEngineConfig) {
+		providers = append(providers, identity)
+	} else {
+		providers = append([]map[string]interface{}{identity}, providers...)
+	}
+	config := encryptionConfiguration{
+		APIVersion: encryptionConfigAPIVersion,
+		Kind:       encryptionConfigKind,
+		Resources: []encryptionResourceConfiguration{
+			{
+				Resources: []string{"secrets"},
+				Providers: providers,
+			},
+		},
+	}
+	configYaml, err := yaml.Marshal(config)
+	if err != nil {
+		// only plain types are marshalled here
+		logrus.Warnf("Failed to marshal encryption provider config: %v", err)
+	}
+	return string(configYaml)
+}
+
+func (c *Cluster) deployEncryptionProviderConfig(ctx context.Context) error {
+	if len(c.SecretsEncryptionKeys) == 0 {
+		return nil
+	}
+	if err := deployFile(ctx, c.ControlPlaneHosts, c.SystemImages.Alpine, c.PrivateRegistriesMap, encryptionConfigFileName, c.getEncryptionProviderConfig()); err != nil {
+		return err
+	}
+	log.Infof(ctx, "[%s] Successfully deployed encryption provider config to Controller Plane nodes", encryptionConfigFileName)
+	return nil
+}
+
+// RotateSecretsEncryptionKey replaces the cluster keys with a new key of the configured provider.
+// The new key is first added as a read key, then made the write key once every kube-apiserver can read it,
+// all secrets are rewritten with it and the old keys are dropped. The state is saved after each step.
+func RotateSecretsEncryptionKey(ctx context.Context, kubeCluster *Cluster, fullState *FullState) error {
+	newKey, err := NewSecretsEncryptionKey(GetSecretsEncryptionProvider(&kubeCluster.RancherKubernetesEngineConfig), kubeCluster.SecretsEncryptionKeys)
+	if err != nil {
+		return err
+	}
+	oldKeys := kubeCluster.SecretsEncryptionKeys
+	log.Infof(ctx, "[secrets-encryption] Adding secrets encryption key [%s]", newKey.Name)
+	if err := updateSecretsEncryptionKeys(ctx, kubeCluster, fullState, append(append([]SecretsEncryptionKey{}, oldKeys...), newKey)); err != nil {
+		return err
+	}
+	log.Infof(ctx, "[secrets-encryption] Encrypting new secrets with key [%s]", newKey.Name)
+	if err := updateSecretsEncryptionKeys(ctx, kubeCluster, fullState, append([]SecretsEncryptionKey{newKey}, oldKeys...)); err != nil {
+		return err
+	}
+	if err := RewriteSecrets(ctx, kubeCluster); err != nil {
+		return err
+	}
+	log.Infof(ctx, "[secrets-encryption] Removing old secrets encryption keys")
+	return updateSecretsEncryptionKeys(ctx, kubeCluster, fullState, []SecretsEncryptionKey{newKey})
+}
+
+// updateSecretsEncryptionKeys deploys the keys and recreates kube-apiserver one control plane host at a time
+func updateSecretsEncryptionKeys(ctx context.Context, kubeCluster *Cluster, fullState *FullState, keys []SecretsEncryptionKey) error {
+	// the keys are saved before they're deployed, a failed run must not lose a key that secrets may already be encrypted with
+	kubeCluster.SecretsEncryptionKeys = keys
+	fullState.DesiredState.SecretsEncryptionKeys = keys
+	if err := fullState.WriteStateFile(ctx, kubeCluster.StateFilePath); err != nil {
+		return err
+	}
+	if err := kubeCluster.deployEncryptionProviderConfig(ctx); err != nil {
+		return err
+	}
+	cpNodePlanMap := make(map[string]v3.RKEConfigNodePlan)
+	for _, cpHost := range kubeCluster.ControlPlaneHosts {
+		cpNodePlanMap[cpHost.Address] = BuildRKEConfigNodePlan(ctx, kubeCluster, cpHost, cpHost.DockerInfo)
+	}
+	if err := services.RunControlPlane(ctx, kubeCluster.ControlPlaneHosts,
+		kubeCluster.LocalConnDialerFactory,
+		kubeCluster.PrivateRegistriesMap,
+		cpNodePlanMap,
+		false,
+		kubeCluster.SystemImages.Alpine,
+		kubeCluster.Certificates); err != nil {
+		return fmt.Errorf("[controlPlane] Failed to redeploy Control Plane: %v", err)
+	}
+	return kubeCluster.UpdateClusterCurrentState(ctx, fullState)
+}
+
+// RewriteSecrets updates every secret so it's stored again with the current write key
+func RewriteSecrets(ctx context.Context, kubeCluster *Cluster) error {
+	log.Infof(ctx, "[secrets-encryption] Rewriting all secrets")
+	k8sClient, err := k8s.NewClient(kubeCluster.LocalKubeConfigPath, kubeCluster.K8sWrapTransport)
+	if err != nil {
+		return fmt.Errorf("Failed to create Kubernetes Client: %v", err)
+	}
+	secrets, err := k8s.ListAllSecrets(k8sClient)
+	if err != nil {
+		return fmt.Errorf("Failed to list secrets: %v", err)
+	}
+	var errList []error
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if err := k8s.RewriteSecret(k8sClient, secret); err != nil {
+			errList = append(errList, fmt.Errorf("Failed to rewrite secret [%s/%s]: %v", secret.Namespace, secret.Name, err))
+		}
+	}
+	if err := util.ErrList(errList); err != nil {
+		return err
+	}
+	log.Infof(ctx, "[secrets-encryption] Successfully rewrote [%d] secrets", len(secrets.Items))
+	return nil
+}
@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSecretsEncryptionConfig(t *testing.T) {
+	rkeConfig := v3.RancherKubernetesEngineConfig{}
+	rkeConfig.Services.KubeAPI.SecretsEncryption = &v3.SecretsEncryptionConfig{Enabled: true}
+
+	keys, err := rebuildSecretsEncryptionKeys(context.Background(), &rkeConfig, nil)
+	if err != nil {
+		t.Fatalf("Failed to generate secrets encryption key: %v", err)
+	}
+	if len(keys) != 1 || keys[0].Provider != DefaultSecretsEncryptionProvider {
+		t.Fatalf("Expected a single [%s] key, got %v", DefaultSecretsEncryptionProvider, keys)
+	}
+	rebuiltKeys, err := rebuildSecretsEncryptionKeys(context.Background(), &rkeConfig, keys)
+	if err != nil || len(rebuiltKeys) != 1 || rebuiltKeys[0] != keys[0] {
+		t.Fatalf("Expected the existing key to be kept, got %v: %v", rebuiltKeys, err)
+	}
+
+	newKey, err := NewSecretsEncryptionKey(SecretboxEncryptionProvider, keys)
+	if err != nil {
+		t.Fatalf("Failed to generate secrets encryption key: %v", err)
+	}
+	newKey.Name = "new"
+	c := &Cluster{
+		RancherKubernetesEngineConfig: rkeConfig,
+		SecretsEncryptionKeys:         []SecretsEncryptionKey{newKey, keys[0]},
+	}
+	getProviders := func() []string {
+		config := encryptionConfiguration{}
+		if err := yaml.Unmarshal([]byte(c.getEncryptionProviderConfig()), &config); err != nil {
+			t.Fatalf("Failed to parse encryption provider config: %v", err)
+		}
+		if config.Kind != encryptionConfigKind || len(config.Resources) != 1 || config.Resources[0].Resources[0] != "secrets" {
+			t.Fatalf("Unexpected encryption provider config: %+v", config)
+		}
+		var providers []string
+		for _, provider := range config.Resources[0].Providers {
+			for name := range provider {
+				providers = append(providers, name)
+			}
+		}
+		return providers
+	}
+	expected := []string{SecretboxEncryptionProvider, AESCBCEncryptionProvider, identityEncryptionProvider}
+	if providers := getProviders(); !equalStrings(providers, expected) {
+		t.Fatalf("Expected providers %v, got %v", expected, providers)
+	}
+
+	// disabled encryption keeps the keys to read existing secrets but writes unencrypted
+	c.Services.KubeAPI.SecretsEncryption.Enabled = false
+	expected = []string{identityEncryptionProvider, SecretboxEncryptionProvider, AESCBCEncryptionProvider}
+	if providers := getProviders(); !equalStrings(providers, expected) {
+		t.Fatalf("Expected providers %v, got %v", expected, providers)
+	}
+}
+
+func TestNewSecretsEncryptionKeyNames(t *testing.T) {
+	var keys []SecretsEncryptionKey
+	names := map[string]bool{}
+	// keys generated within the same second must not share a name
+	for i := 0; i < 100; i++ {
+		key, err := NewSecretsEncryptionKey(DefaultSecretsEncryptionProvider, keys)
+		if err != nil {
+			t.Fatalf("Failed to generate secrets encryption key: %v", err)
+		}
+		if names[key.Name] {
+			t.Fatalf("Duplicate secrets encryption key name [%s]", key.Name)
+		}
+		names[key.Name] = true
+		keys = append(keys, key)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -4,8 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -34,11 +32,15 @@ type FullState struct {
 type State struct {
 	RancherKubernetesEngineConfig *v3.RancherKubernetesEngineConfig `json:"rkeConfig,omitempty"`
 	CertificatesBundle            map[string]pki.CertificatePKI     `json:"certificatesBundle,omitempty"`
+	SecretsEncryptionKeys         []SecretsEncryptionKey            `json:"secretsEncryptionKeys,omitempty"`
+	SSHHostKeys                   map[string]string                 `json:"sshHostKeys,omitempty"`
 }
 
 func (c *Cluster) UpdateClusterCurrentState(ctx context.Context, fullState *FullState) error {
 	fullState.CurrentState.RancherKubernetesEngineConfig = c.RancherKubernetesEngineConfig.DeepCopy()
 	fullState.CurrentState.CertificatesBundle = c.Certificates
+	fullState.CurrentState.SecretsEncryptionKeys = c.SecretsEncryptionKeys
+	fullState.CurrentState.SSHHostKeys = c.getTrustedHostKeys()
 	return fullState.WriteStateFile(ctx, c.StateFilePath)
 }
 
@@ -55,6 +57,7 @@ func (c *Cluster) GetClusterState(ctx context.Context, fullState *FullState) (*C
 		return nil, err
 	}
 	currentCluster.Certificates = fullState.CurrentState.CertificatesBundle
+	currentCluster.SecretsEncryptionKeys = fullState.CurrentState.SecretsEncryptionKeys
 
 	// resetup dialers
 	dialerOptions := hosts.GetDialerOptions(c.DockerDialerFactory, c.LocalConnDialerFactory, c.K8sWrapTransport)
@@ -151,12 +154,20 @@ func RebuildState(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConf
 			RancherKubernetesEngineConfig: rkeConfig.DeepCopy(),
 		},
 	}
+	secretsEncryptionKeys, err := rebuildSecretsEncryptionKeys(ctx, rkeConfig, oldState.DesiredState.SecretsEncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+	newState.DesiredState.SecretsEncryptionKeys = secretsEncryptionKeys
 
 	if flags.CustomCerts {
 		certBundle, err := pki.ReadCertsAndKeysFromDir(flags.CertificateDir)
 		if err != nil {
 			return nil, fmt.Errorf("Failed to read certificates from dir [%s]: %v", flags.CertificateDir, err)
 		}
+		if err := migrateCustomClientCerts(ctx, rkeConfig, certBundle, oldState.DesiredState.CertificatesBundle); err != nil {
+			return nil, err
+		}
 		// make sure all custom certs are included
 		if err := pki.ValidateBundleContent(rkeConfig, certBundle, flags.ClusterFilePath, flags.ConfigDir); err != nil {
 			return nil, fmt.Errorf("Failed to validates certificates from dir [%s]: %v", flags.CertificateDir, err)
@@ -176,9 +187,17 @@ func RebuildState(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConf
 		newState.DesiredState.CertificatesBundle = certBundle
 	} else {
 		pkiCertBundle := oldState.DesiredState.CertificatesBundle
+		// the CA is reused, changing it requires a CA rotation
+		if rkeConfig.PKI != nil && rkeConfig.PKI.IntermediateCA != nil {
+			if !pki.IsIntermediateCA(pkiCertBundle, rkeConfig.PKI.IntermediateCA) {
+				log.Warnf(ctx, "[certificates] Cluster CA isn't the configured intermediate CA, run 'rke cert rotate --rotate-ca' to switch to it")
+			}
+		} else if caKey := pkiCertBundle[pki.CACertName].Key; caKey != nil && pki.GetPrivateKeyAlgorithm(caKey) != pki.GetCAKeyAlgorithm(*rkeConfig) {
+			log.Warnf(ctx, "[certificates] CA key algorithm is [%s] but [%s] is configured, run 'rke cert rotate --rotate-ca' to migrate", pki.GetPrivateKeyAlgorithm(caKey), pki.GetCAKeyAlgorithm(*rkeConfig))
+		}
 		// check for legacy clusters prior to requestheaderca
 		if pkiCertBundle[pki.RequestHeaderCACertName].Certificate == nil {
-			if err := pki.GenerateRKERequestHeaderCACert(ctx, pkiCertBundle, flags.ClusterFilePath, flags.ConfigDir); err != nil {
+			if err := pki.GenerateRKERequestHeaderCACert(ctx, pkiCertBundle, *rkeConfig, flags.ClusterFilePath, flags.ConfigDir); err != nil {
 				return nil, err
 			}
 		}
@@ -191,16 +210,54 @@ func RebuildState(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConf
 	return newState, nil
 }
 
+// migrateCustomClientCerts adds the per node kubelet certificates and the CNI certificate missing from a custom certificates directory,
+// they're kept from the previous state or signed with the custom CA if its key is available
+func migrateCustomClientCerts(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfig, certBundle, oldCertBundle map[string]pki.CertificatePKI) error {
+	caCrt := certBundle[pki.CACertName].Certificate
+	if caCrt == nil || certBundle[pki.CACertName].Key == nil {
+		return nil
+	}
+	if certBundle[pki.KubeCNICertName].Certificate == nil {
+		if oldCrt := oldCertBundle[pki.KubeCNICertName].Certificate; oldCrt != nil && oldCrt.CheckSignatureFrom(caCrt) == nil {
+			certBundle[pki.KubeCNICertName] = oldCertBundle[pki.KubeCNICertName]
+		}
+		if err := pki.GenerateKubeCNICertificate(ctx, certBundle, *rkeConfig, "", "", false); err != nil {
+			return err
+		}
+	}
+	for _, node := range pki.GetKubeletCertNodes(*rkeConfig) {
+		kubeletName := pki.GetKubeletCrtName(node.Address)
+		if certBundle[kubeletName].Certificate != nil {
+			continue
+		}
+		if oldCrt := oldCertBundle[kubeletName].Certificate; oldCrt != nil && oldCrt.CheckSignatureFrom(caCrt) == nil {
+			certBundle[kubeletName] = oldCertBundle[kubeletName]
+		}
+		if err := pki.GenerateKubeletCertificate(ctx, certBundle, *rkeConfig, node, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *FullState) WriteStateFile(ctx context.Context, statePath string) error {
 	stateFile, err := json.MarshalIndent(s, "", "  ")
 	if err != nil {
 		return fmt.Errorf("Failed to Marshal state object: %v", err)
 	}
-	logrus.Debugf("Writing state file: %s", stateFile)
-	if err := ioutil.WriteFile(statePath, stateFile, 0640); err != nil {
+	if provider := GetStateKeyProvider(ctx); provider != nil {
+		logrus.Debugf("Encrypting state file with [%s] key provider", provider.Name())
+		if stateFile, err = encryptState(provider, stateFile); err != nil {
+			return fmt.Errorf("Failed to encrypt state file: %v", err)
+		}
+	} else {
+		logrus.Debugf("Writing state file: %s", stateFile)
+	}
+	backend := GetStateBackend(ctx)
+	if err := backend.WriteState(ctx, statePath, stateFile); err != nil {
 		return fmt.Errorf("Failed to write state file: %v", err)
 	}
-	log.Infof(ctx, "Successfully Deployed state file at [%s]", statePath)
+	log.Infof(ctx, "Successfully Deployed state file at [%s]", backend.Location(statePath))
 	return nil
 }
 
@@ -236,18 +293,14 @@ func GetCertificateDirPath(configPath, configDir string) string {
 
 func ReadStateFile(ctx context.Context, statePath string) (*FullState, error) {
 	rkeFullState := &FullState{}
-	fp, err := filepath.Abs(statePath)
-	if err != nil {
-		return rkeFullState, fmt.Errorf("failed to lookup current directory name: %v", err)
-	}
-	file, err := os.Open(fp)
+	buf, err := GetStateBackend(ctx).ReadState(ctx, statePath)
 	if err != nil {
-		return rkeFullState, fmt.Errorf("Can not find RKE state file: %v", err)
+		return rkeFullState, err
 	}
-	defer file.Close()
-	buf, err := ioutil.ReadAll(file)
-	if err != nil {
-		return rkeFullState, fmt.Errorf("failed to read state file: %v", err)
+	if IsStateEncrypted(buf) {
+		if buf, err = decryptState(GetStateKeyProvider(ctx), buf); err != nil {
+			return rkeFullState, err
+		}
 	}
 	if err := json.Unmarshal(buf, rkeFullState); err != nil {
 		return rkeFullState, fmt.Errorf("failed to unmarshal the state file: %v", err)
@@ -258,8 +311,9 @@ func ReadStateFile(ctx context.Context, statePath string) (*FullState, error) {
 }
 
 func removeStateFile(ctx context.Context, statePath string) {
-	log.Infof(ctx, "Removing state file: %s", statePath)
-	if err := os.Remove(statePath); err != nil {
+	backend := GetStateBackend(ctx)
+	log.Infof(ctx, "Removing state file: %s", backend.Location(statePath))
+	if err := backend.RemoveState(ctx, statePath); err != nil {
 		logrus.Warningf("Failed to remove state file: %v", err)
 		return
 	}
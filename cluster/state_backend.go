@@ -0,0 +1,203 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/rancher/rke/log"
+	"github.com/sirupsen/logrus"
+)
+
+type stateBackendKey string
+
+const (
+	stateBackendCtxKey stateBackendKey = "rke-state-backend"
+
+	LocalStateBackendName = "local"
+	stateLockFileExt      = ".lock"
+	stateLockRetryPeriod  = 2 * time.Second
+)
+
+// StateLock is an advisory lock on the cluster state, held for the duration of an operation
+type StateLock struct {
+	ID        string    `json:"id"`
+	Operation string    `json:"operation"`
+	Who       string    `json:"who"`
+	Created   time.Time `json:"created"`
+}
+
+// StateBackend stores the cluster state. The state path identifies the cluster, remote backends derive their object names from it.
+type StateBackend interface {
+	Name() string
+	// Location is a readable description of where the state is stored
+	Location(statePath string) string
+	ReadState(ctx context.Context, statePath string) ([]byte, error)
+	WriteState(ctx context.Context, statePath string, data []byte) error
+	RemoveState(ctx context.Context, statePath string) error
+	// TryLock takes the lock if it's free, otherwise it returns the current lock
+	TryLock(ctx context.Context, statePath string, lock StateLock) (*StateLock, error)
+	// Unlock releases a lock only if it's still held with lockID
+	Unlock(ctx context.Context, statePath, lockID string) error
+	ForceUnlock(ctx context.Context, statePath string) error
+}
+
+func SetStateBackend(ctx context.Context, backend StateBackend) context.Context {
+	return context.WithValue(ctx, stateBackendCtxKey, backend)
+}
+
+// GetStateBackend returns the backend set in the context, the local file backend by default
+func GetStateBackend(ctx context.Context) StateBackend {
+	backend, _ := ctx.Value(stateBackendCtxKey).(StateBackend)
+	if backend == nil {
+		return &LocalStateBackend{}
+	}
+	return backend
+}
+
+func (l *StateLock) String() string {
+	return fmt.Sprintf("[%s] by [%s] since [%s], lock ID [%s]", l.Operation, l.Who, l.Created.Format(time.RFC3339), l.ID)
+}
+
+// LockState takes the state lock for an operation, waiting up to timeout while the state is locked by another run.
+// It returns a func to release the lock.
+func LockState(ctx context.Context, statePath, operation string, timeout time.Duration) (func(), error) {
+	backend := GetStateBackend(ctx)
+	lock, err := newStateLock(operation)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		holder, err := backend.TryLock(ctx, statePath, lock)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to lock cluster state at [%s]: %v", backend.Location(statePath), err)
+		}
+		if holder == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Cluster state at [%s] is locked for %s, if that run is no longer active use 'rke state force-unlock'", backend.Location(statePath), holder)
+		}
+		logrus.Debugf("[state] Waiting for state lock held for %s", holder)
+		time.Sleep(stateLockRetryPeriod)
+	}
+	logrus.Debugf("[state] Acquired state lock [%s] for [%s]", lock.ID, operation)
+	return func() {
+		if err := backend.Unlock(ctx, statePath, lock.ID); err != nil {
+			log.Warnf(ctx, "[state] Failed to release state lock [%s]: %v", lock.ID, err)
+		}
+	}, nil
+}
+
+func newStateLock(operation string) (StateLock, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return StateLock{}, fmt.Errorf("Failed to generate state lock ID: %v", err)
+	}
+	who := "unknown"
+	if currentUser, err := user.Current(); err == nil {
+		who = currentUser.Username
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		who = who + "@" + hostname
+	}
+	return StateLock{
+		ID:        hex.EncodeToString(id),
+		Operation: operation,
+		Who:       who,
+		Created:   time.Now().UTC(),
+	}, nil
+}
+
+func unmarshalStateLock(buf []byte) *StateLock {
+	lock := &StateLock{}
+	if err := json.Unmarshal(buf, lock); err != nil {
+		return &StateLock{ID: "unknown", Operation: "unknown", Who: "unknown"}
+	}
+	return lock
+}
+
+// LocalStateBackend keeps the state in a file next to the cluster file
+type LocalStateBackend struct{}
+
+func (l *LocalStateBackend) Name() string {
+	return LocalStateBackendName
+}
+
+func (l *LocalStateBackend) Location(statePath string) string {
+	return statePath
+}
+
+func (l *LocalStateBackend) ReadState(ctx context.Context, statePath string) ([]byte, error) {
+	fp, err := filepath.Abs(statePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup current directory name: %v", err)
+	}
+	file, err := os.Open(fp)
+	if err != nil {
+		return nil, fmt.Errorf("Can not find RKE state file: %v", err)
+	}
+	defer file.Close()
+	buf, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+	return buf, nil
+}
+
+func (l *LocalStateBackend) WriteState(ctx context.Context, statePath string, data []byte) error {
+	return ioutil.WriteFile(statePath, data, 0640)
+}
+
+func (l *LocalStateBackend) RemoveState(ctx context.Context, statePath string) error {
+	return os.Remove(statePath)
+}
+
+func (l *LocalStateBackend) TryLock(ctx context.Context, statePath string, lock StateLock) (*StateLock, error) {
+	lockPath := statePath + stateLockFileExt
+	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		buf, err := ioutil.ReadFile(lockPath)
+		if err != nil {
+			return nil, err
+		}
+		return unmarshalStateLock(buf), nil
+	}
+	defer lockFile.Close()
+	buf, err := json.Marshal(lock)
+	if err != nil {
+		return nil, err
+	}
+	_, err = lockFile.Write(buf)
+	return nil, err
+}
+
+func (l *LocalStateBackend) Unlock(ctx context.Context, statePath, lockID string) error {
+	lockPath := statePath + stateLockFileExt
+	buf, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		return err
+	}
+	if holder := unmarshalStateLock(buf); holder.ID != lockID {
+		return fmt.Errorf("state is locked for %s", holder)
+	}
+	return os.Remove(lockPath)
+}
+
+func (l *LocalStateBackend) ForceUnlock(ctx context.Context, statePath string) error {
+	if err := os.Remove(statePath + stateLockFileExt); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
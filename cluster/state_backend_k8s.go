@@ -0,0 +1,188 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rancher/rke/k8s"
+	"github.com/rancher/rke/log"
+	"github.com/rancher/rke/pki"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	KubernetesStateBackendName = "kubernetes"
+	StateSecretName            = "rke-cluster-state"
+	StateLockSecretName        = "rke-cluster-state-lock"
+	stateSecretKey             = "state"
+	stateLockSecretKey         = "lock"
+)
+
+// KubernetesStateBackend keeps the state in a secret in the cluster itself. Until there is a kube config, on the first
+// run, it falls back to the local state file, which is also kept up to date as a copy. Once there is a kube config the
+// cluster must be reachable: a stale local copy or a local lock could be used while another run holds the secrets.
+type KubernetesStateBackend struct {
+	// KubeConfigPath defaults to the kube config file rke writes next to the cluster file
+	KubeConfigPath   string
+	K8sWrapTransport k8s.WrapTransport
+	local            LocalStateBackend
+}
+
+func (k *KubernetesStateBackend) Name() string {
+	return KubernetesStateBackendName
+}
+
+func (k *KubernetesStateBackend) Location(statePath string) string {
+	return fmt.Sprintf("secret kube-system/%s", StateSecretName)
+}
+
+func (k *KubernetesStateBackend) ReadState(ctx context.Context, statePath string) ([]byte, error) {
+	k8sClient, err := k.getClient(statePath)
+	if err != nil {
+		return nil, err
+	}
+	if k8sClient == nil {
+		return k.local.ReadState(ctx, statePath)
+	}
+	secret, err := k8s.GetSecret(k8sClient, StateSecretName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			logrus.Debugf("[state] State secret doesn't exist yet, reading local state file")
+			return k.local.ReadState(ctx, statePath)
+		}
+		return nil, fmt.Errorf("Failed to get state secret: %v", err)
+	}
+	return secret.Data[stateSecretKey], nil
+}
+
+func (k *KubernetesStateBackend) WriteState(ctx context.Context, statePath string, data []byte) error {
+	if err := k.local.WriteState(ctx, statePath, data); err != nil {
+		return err
+	}
+	k8sClient, err := k.getClient(statePath)
+	if err != nil {
+		return err
+	}
+	if k8sClient == nil {
+		log.Infof(ctx, "[state] There is no kube config yet, state is only saved to [%s]", statePath)
+		return nil
+	}
+	if err := k8s.UpdateSecret(k8sClient, map[string][]byte{stateSecretKey: data}, StateSecretName); err != nil {
+		return fmt.Errorf("Failed to save state secret: %v", err)
+	}
+	return nil
+}
+
+func (k *KubernetesStateBackend) RemoveState(ctx context.Context, statePath string) error {
+	if err := k.local.RemoveState(ctx, statePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	k8sClient, err := k.getClient(statePath)
+	if err != nil || k8sClient == nil {
+		return err
+	}
+	if err := k8s.DeleteSecret(k8sClient, StateSecretName); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (k *KubernetesStateBackend) TryLock(ctx context.Context, statePath string, lock StateLock) (*StateLock, error) {
+	k8sClient, err := k.getClient(statePath)
+	if err != nil {
+		return nil, err
+	}
+	if k8sClient == nil {
+		return k.local.TryLock(ctx, statePath, lock)
+	}
+	lockData, err := json.Marshal(lock)
+	if err != nil {
+		return nil, err
+	}
+	err = k8s.CreateSecret(k8sClient, map[string][]byte{stateLockSecretKey: lockData}, StateLockSecretName)
+	if err == nil {
+		return nil, nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	secret, err := k8s.GetSecret(k8sClient, StateLockSecretName)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalStateLock(secret.Data[stateLockSecretKey]), nil
+}
+
+func (k *KubernetesStateBackend) Unlock(ctx context.Context, statePath, lockID string) error {
+	k8sClient, err := k.getClient(statePath)
+	if err != nil {
+		return err
+	}
+	if k8sClient == nil {
+		return k.local.Unlock(ctx, statePath, lockID)
+	}
+	secret, err := k8s.GetSecret(k8sClient, StateLockSecretName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// the lock was taken before the cluster was up
+			return k.local.Unlock(ctx, statePath, lockID)
+		}
+		return err
+	}
+	if holder := unmarshalStateLock(secret.Data[stateLockSecretKey]); holder.ID != lockID {
+		return fmt.Errorf("state is locked for %s", holder)
+	}
+	return k8s.DeleteSecret(k8sClient, StateLockSecretName)
+}
+
+func (k *KubernetesStateBackend) ForceUnlock(ctx context.Context, statePath string) error {
+	if err := k.local.ForceUnlock(ctx, statePath); err != nil {
+		return err
+	}
+	k8sClient, err := k.getClient(statePath)
+	if err != nil || k8sClient == nil {
+		return err
+	}
+	if err := k8s.DeleteSecret(k8sClient, StateLockSecretName); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// getClient returns nil if there is no kube config yet, the cluster isn't bootstrapped and the local state file is used.
+// Once there is a kube config, failing to reach the cluster is an error.
+func (k *KubernetesStateBackend) getClient(statePath string) (*kubernetes.Clientset, error) {
+	kubeConfigPath := k.KubeConfigPath
+	if len(kubeConfigPath) == 0 {
+		kubeConfigPath = getStateKubeConfigPath(statePath)
+	}
+	if _, err := os.Stat(kubeConfigPath); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Failed to read kube config [%s] of the state backend: %v", kubeConfigPath, err)
+		}
+		logrus.Debugf("[state] Kube config [%s] not found, using local state file", kubeConfigPath)
+		return nil, nil
+	}
+	k8sClient, err := k8s.NewClient(kubeConfigPath, k.K8sWrapTransport)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create Kubernetes client for the state backend: %v", err)
+	}
+	if _, err := k8sClient.Discovery().ServerVersion(); err != nil {
+		return nil, fmt.Errorf("Kubernetes isn't reachable with kube config [%s], the state secrets can't be used: %v", kubeConfigPath, err)
+	}
+	return k8sClient, nil
+}
+
+func getStateKubeConfigPath(statePath string) string {
+	clusterFilePath := strings.TrimSuffix(statePath, stateFileExt)
+	kubeConfigPath := pki.GetLocalKubeConfig(clusterFilePath+".yml", "")
+	if _, err := os.Stat(kubeConfigPath); err != nil {
+		return pki.GetLocalKubeConfig(clusterFilePath+".yaml", "")
+	}
+	return kubeConfigPath
+}
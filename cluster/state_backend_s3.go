@@ -0,0 +1,196 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/rke/util"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+const S3StateBackendName = "s3"
+
+// S3StateBackend keeps the state in an S3 compatible bucket, the object is named after the local state file
+type S3StateBackend struct {
+	Config *v3.S3BackupConfig
+	// Folder is prepended to the object names. It's required and must be unique to the cluster, clusters sharing
+	// a bucket would otherwise share the state and the lock of the default cluster.rkestate object.
+	Folder string
+}
+
+func (s *S3StateBackend) Name() string {
+	return S3StateBackendName
+}
+
+func (s *S3StateBackend) Location(statePath string) string {
+	return fmt.Sprintf("s3://%s/%s", s.Config.BucketName, path.Join(s.Folder, filepath.Base(statePath)))
+}
+
+func (s *S3StateBackend) objectKey(statePath string) (string, error) {
+	folder := strings.Trim(s.Folder, "/")
+	if len(folder) == 0 {
+		return "", fmt.Errorf("The s3 state backend requires a folder unique to the cluster, set it with --state-s3-folder")
+	}
+	return path.Join(folder, filepath.Base(statePath)), nil
+}
+
+func (s *S3StateBackend) lockKey(statePath string) (string, error) {
+	objectKey, err := s.objectKey(statePath)
+	if err != nil {
+		return "", err
+	}
+	return objectKey + stateLockFileExt, nil
+}
+
+func (s *S3StateBackend) ReadState(ctx context.Context, statePath string) ([]byte, error) {
+	objectKey, err := s.objectKey(statePath)
+	if err != nil {
+		return nil, err
+	}
+	buf, found, err := s.getObject(ctx, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("Can not find RKE state file: [%s] doesn't exist", s.Location(statePath))
+	}
+	return buf, nil
+}
+
+func (s *S3StateBackend) WriteState(ctx context.Context, statePath string, data []byte) error {
+	objectKey, err := s.objectKey(statePath)
+	if err != nil {
+		return err
+	}
+	_, err = s.putObject(ctx, objectKey, data, false)
+	return err
+}
+
+func (s *S3StateBackend) RemoveState(ctx context.Context, statePath string) error {
+	objectKey, err := s.objectKey(statePath)
+	if err != nil {
+		return err
+	}
+	return s.deleteObject(ctx, objectKey)
+}
+
+func (s *S3StateBackend) TryLock(ctx context.Context, statePath string, lock StateLock) (*StateLock, error) {
+	lockKey, err := s.lockKey(statePath)
+	if err != nil {
+		return nil, err
+	}
+	buf, found, err := s.getObject(ctx, lockKey)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return unmarshalStateLock(buf), nil
+	}
+	lockData, err := json.Marshal(lock)
+	if err != nil {
+		return nil, err
+	}
+	// the conditional put closes the gap between the check and the write on servers that support it
+	created, err := s.putObject(ctx, lockKey, lockData, true)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		buf, _, err := s.getObject(ctx, lockKey)
+		if err != nil {
+			return nil, err
+		}
+		return unmarshalStateLock(buf), nil
+	}
+	return nil, nil
+}
+
+func (s *S3StateBackend) Unlock(ctx context.Context, statePath, lockID string) error {
+	lockKey, err := s.lockKey(statePath)
+	if err != nil {
+		return err
+	}
+	buf, found, err := s.getObject(ctx, lockKey)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("state lock [%s] doesn't exist", lockID)
+	}
+	if holder := unmarshalStateLock(buf); holder.ID != lockID {
+		return fmt.Errorf("state is locked for %s", holder)
+	}
+	return s.deleteObject(ctx, lockKey)
+}
+
+func (s *S3StateBackend) ForceUnlock(ctx context.Context, statePath string) error {
+	lockKey, err := s.lockKey(statePath)
+	if err != nil {
+		return err
+	}
+	return s.deleteObject(ctx, lockKey)
+}
+
+func (s *S3StateBackend) getObject(ctx context.Context, objectKey string) ([]byte, bool, error) {
+	resp, err := util.DoS3Request(ctx, s.Config, http.MethodGet, objectKey, url.Values{}, nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to read s3 response: %v", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return body, true, nil
+	case http.StatusNotFound:
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("Failed to get [%s] from s3 bucket [%s], status [%s]: %s", objectKey, s.Config.BucketName, resp.Status, body)
+	}
+}
+
+// putObject returns false if ifNoneMatch is set and the object already exists
+func (s *S3StateBackend) putObject(ctx context.Context, objectKey string, data []byte, ifNoneMatch bool) (bool, error) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	if ifNoneMatch {
+		header.Set("If-None-Match", "*")
+	}
+	resp, err := util.DoS3Request(ctx, s.Config, http.MethodPut, objectKey, url.Values{}, header, data)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		if ifNoneMatch {
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("Failed to put [%s] to s3 bucket [%s], status [%s]: %s", objectKey, s.Config.BucketName, resp.Status, body)
+}
+
+func (s *S3StateBackend) deleteObject(ctx context.Context, objectKey string) error {
+	resp, err := util.DoS3Request(ctx, s.Config, http.MethodDelete, objectKey, url.Values{}, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("Failed to delete [%s] from s3 bucket [%s], status [%s]: %s", objectKey, s.Config.BucketName, resp.Status, body)
+	}
+	return nil
+}
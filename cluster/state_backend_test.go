@@ -0,0 +1,231 @@
+package cluster
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+// fakeS3 is a minimal in memory stand-in for an S3 compatible server
+type fakeS3 struct {
+	sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case http.MethodPut:
+		if _, ok := f.objects[r.URL.Path]; ok && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		f.objects[r.URL.Path] = data
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3StateBackend(t *testing.T) {
+	server := httptest.NewServer(&fakeS3{objects: map[string][]byte{}})
+	defer server.Close()
+	backend := &S3StateBackend{
+		Config: &v3.S3BackupConfig{
+			Endpoint:   server.URL,
+			BucketName: "rke",
+			AccessKey:  "access",
+			SecretKey:  "secret",
+		},
+		Folder: "clusters",
+	}
+	ctx := SetStateBackend(context.Background(), backend)
+	statePath := "/some/dir/cluster.rkestate"
+
+	if _, err := ReadStateFile(ctx, statePath); err == nil {
+		t.Fatalf("Expected an error reading a missing state")
+	}
+	state := &FullState{DesiredState: State{RancherKubernetesEngineConfig: &v3.RancherKubernetesEngineConfig{ClusterName: "test"}}}
+	if err := state.WriteStateFile(ctx, statePath); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+	readState, err := ReadStateFile(ctx, statePath)
+	if err != nil {
+		t.Fatalf("Failed to read state: %v", err)
+	}
+	if readState.DesiredState.RancherKubernetesEngineConfig.ClusterName != "test" {
+		t.Fatalf("Expected cluster name [test], got [%s]", readState.DesiredState.RancherKubernetesEngineConfig.ClusterName)
+	}
+	if location := backend.Location(statePath); location != "s3://rke/clusters/cluster.rkestate" {
+		t.Fatalf("Unexpected state location [%s]", location)
+	}
+	testStateLocking(t, ctx, statePath)
+}
+
+func TestS3StateBackendClusterIsolation(t *testing.T) {
+	server := httptest.NewServer(&fakeS3{objects: map[string][]byte{}})
+	defer server.Close()
+	newBackend := func(folder string) *S3StateBackend {
+		return &S3StateBackend{
+			Config: &v3.S3BackupConfig{
+				Endpoint:   server.URL,
+				BucketName: "rke",
+				AccessKey:  "access",
+				SecretKey:  "secret",
+			},
+			Folder: folder,
+		}
+	}
+	// both clusters use the default state file name in the same bucket
+	statePath := "cluster.rkestate"
+	ctxA := SetStateBackend(context.Background(), newBackend("cluster-a"))
+	ctxB := SetStateBackend(context.Background(), newBackend("cluster-b"))
+
+	for ctx, name := range map[context.Context]string{ctxA: "a", ctxB: "b"} {
+		state := &FullState{DesiredState: State{RancherKubernetesEngineConfig: &v3.RancherKubernetesEngineConfig{ClusterName: name}}}
+		if err := state.WriteStateFile(ctx, statePath); err != nil {
+			t.Fatalf("Failed to write state of cluster [%s]: %v", name, err)
+		}
+	}
+	for ctx, name := range map[context.Context]string{ctxA: "a", ctxB: "b"} {
+		readState, err := ReadStateFile(ctx, statePath)
+		if err != nil {
+			t.Fatalf("Failed to read state of cluster [%s]: %v", name, err)
+		}
+		if clusterName := readState.DesiredState.RancherKubernetesEngineConfig.ClusterName; clusterName != name {
+			t.Fatalf("Expected the state of cluster [%s], got the state of cluster [%s]", name, clusterName)
+		}
+	}
+
+	unlock, err := LockState(ctxA, statePath, "up", 0)
+	if err != nil {
+		t.Fatalf("Failed to lock state of cluster [a]: %v", err)
+	}
+	defer unlock()
+	unlockB, err := LockState(ctxB, statePath, "up", 0)
+	if err != nil {
+		t.Fatalf("Expected the lock of cluster [a] not to block cluster [b], got: %v", err)
+	}
+	unlockB()
+
+	if err := GetStateBackend(ctxB).RemoveState(ctxB, statePath); err != nil {
+		t.Fatalf("Failed to remove state of cluster [b]: %v", err)
+	}
+	if _, err := ReadStateFile(ctxA, statePath); err != nil {
+		t.Fatalf("Expected the state of cluster [a] to survive the removal of cluster [b], got: %v", err)
+	}
+}
+
+func TestS3StateBackendRequiresFolder(t *testing.T) {
+	backend := &S3StateBackend{Config: &v3.S3BackupConfig{Endpoint: "http://127.0.0.1:1", BucketName: "rke"}}
+	ctx := SetStateBackend(context.Background(), backend)
+	state := &FullState{}
+	if err := state.WriteStateFile(ctx, "cluster.rkestate"); err == nil || !strings.Contains(err.Error(), "--state-s3-folder") {
+		t.Fatalf("Expected an error asking for the state folder, got: %v", err)
+	}
+	if _, err := LockState(ctx, "cluster.rkestate", "up", 0); err == nil {
+		t.Fatalf("Expected locking to fail without a state folder")
+	}
+}
+
+func TestLocalStateBackendLocking(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rke-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testStateLocking(t, context.Background(), filepath.Join(dir, "cluster.rkestate"))
+}
+
+func testStateLocking(t *testing.T, ctx context.Context, statePath string) {
+	unlock, err := LockState(ctx, statePath, "up", 0)
+	if err != nil {
+		t.Fatalf("Failed to lock state: %v", err)
+	}
+	if _, err := LockState(ctx, statePath, "remove", 0); err == nil || !strings.Contains(err.Error(), "[up]") {
+		t.Fatalf("Expected the second lock to be refused, got: %v", err)
+	}
+	unlock()
+	if _, err := LockState(ctx, statePath, "remove", 0); err != nil {
+		t.Fatalf("Failed to lock released state: %v", err)
+	}
+	if err := GetStateBackend(ctx).ForceUnlock(ctx, statePath); err != nil {
+		t.Fatalf("Failed to force unlock state: %v", err)
+	}
+	if _, err := LockState(ctx, statePath, "up", 0); err != nil {
+		t.Fatalf("Failed to lock state after force unlock: %v", err)
+	}
+}
+
+func TestKubernetesStateBackendUnreachable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rke-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	statePath := filepath.Join(dir, "cluster.rkestate")
+	backend := &KubernetesStateBackend{KubeConfigPath: filepath.Join(dir, "kube_config_cluster.yml")}
+
+	// without a kube config the cluster isn't bootstrapped yet and the local state file is used
+	if err := backend.WriteState(ctx, statePath, []byte("state")); err != nil {
+		t.Fatalf("Failed to write state without a kube config: %v", err)
+	}
+	if data, err := backend.ReadState(ctx, statePath); err != nil || string(data) != "state" {
+		t.Fatalf("Expected to read the local state without a kube config, got [%s]: %v", data, err)
+	}
+
+	// a server that is down
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	kubeConfig := `apiVersion: v1
+kind: Config
+clusters:
+- name: local
+  cluster:
+    server: ` + server.URL + `
+contexts:
+- name: local
+  context:
+    cluster: local
+    user: kube-admin
+current-context: local
+users:
+- name: kube-admin
+  user:
+    token: token
+`
+	if err := ioutil.WriteFile(backend.KubeConfigPath, []byte(kubeConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.ReadState(ctx, statePath); err == nil {
+		t.Errorf("Expected reading the state to fail when the cluster isn't reachable")
+	}
+	if err := backend.WriteState(ctx, statePath, []byte("state")); err == nil {
+		t.Errorf("Expected writing the state to fail when the cluster isn't reachable")
+	}
+	if _, err := backend.TryLock(ctx, statePath, StateLock{ID: "id"}); err == nil {
+		t.Errorf("Expected locking the state to fail when the cluster isn't reachable")
+	}
+}
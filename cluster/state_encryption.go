@@ -0,0 +1,297 @@
+package cluster
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+type stateKeyProviderKey string
+
+const (
+	stateKeyProviderCtxKey stateKeyProviderKey = "rke-state-key-provider"
+
+	StateEncryptionVersion         = 1
+	PassphraseKeyProviderName      = "passphrase"
+	KeyFileKeyProviderName         = "keyfile"
+	stateDataKeySize               = 32
+	statePassphraseSaltSize        = 16
+	statePassphraseIterations      = 600000
+	stateKeyFileMinSize            = 32
+	statePassphraseSaltParam       = "salt"
+	statePassphraseIterationsParam = "iterations"
+)
+
+// StateKeyProvider protects the data key of an encrypted state file. The data key encrypts the state itself,
+// the provider only wraps and unwraps it so providers backed by an external KMS can be added without changing the file format.
+type StateKeyProvider interface {
+	// Name is stored in the state file to pick the matching provider on read
+	Name() string
+	// WrapKey encrypts the data key, the returned params are stored next to it and passed back to UnwrapKey
+	WrapKey(dataKey []byte) ([]byte, map[string]string, error)
+	UnwrapKey(wrappedKey []byte, params map[string]string) ([]byte, error)
+}
+
+type encryptedState struct {
+	Encryption *stateEncryption `json:"encryption"`
+	Data       []byte           `json:"data"`
+}
+
+type stateEncryption struct {
+	Version    int               `json:"version"`
+	Provider   string            `json:"provider"`
+	Params     map[string]string `json:"params,omitempty"`
+	WrappedKey []byte            `json:"wrappedKey"`
+	Nonce      []byte            `json:"nonce"`
+}
+
+type stateEncryptionError struct {
+	err string
+}
+
+func (e *stateEncryptionError) Error() string {
+	return e.err
+}
+
+// IsStateEncryptionError is true when a state file exists but can't be decrypted,
+// callers that treat a missing state file as a new cluster must not overwrite it
+func IsStateEncryptionError(err error) bool {
+	_, ok := err.(*stateEncryptionError)
+	return ok
+}
+
+func SetStateKeyProvider(ctx context.Context, provider StateKeyProvider) context.Context {
+	return context.WithValue(ctx, stateKeyProviderCtxKey, provider)
+}
+
+func GetStateKeyProvider(ctx context.Context) StateKeyProvider {
+	provider, _ := ctx.Value(stateKeyProviderCtxKey).(StateKeyProvider)
+	return provider
+}
+
+func IsStateEncrypted(buf []byte) bool {
+	state := encryptedState{}
+	if err := json.Unmarshal(buf, &state); err != nil {
+		return false
+	}
+	return state.Encryption != nil
+}
+
+func encryptState(provider StateKeyProvider, plainState []byte) ([]byte, error) {
+	dataKey := make([]byte, stateDataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("Failed to generate state data key: %v", err)
+	}
+	gcm, err := newStateCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("Failed to generate state nonce: %v", err)
+	}
+	wrappedKey, params, err := provider.WrapKey(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to wrap state data key with [%s] provider: %v", provider.Name(), err)
+	}
+	encryption := &stateEncryption{
+		Version:    StateEncryptionVersion,
+		Provider:   provider.Name(),
+		Params:     params,
+		WrappedKey: wrappedKey,
+		Nonce:      nonce,
+	}
+	return json.MarshalIndent(encryptedState{
+		Encryption: encryption,
+		Data:       gcm.Seal(nil, nonce, plainState, encryption.additionalData()),
+	}, "", "  ")
+}
+
+func decryptState(provider StateKeyProvider, buf []byte) ([]byte, error) {
+	state := encryptedState{}
+	if err := json.Unmarshal(buf, &state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the encrypted state file: %v", err)
+	}
+	if state.Encryption.Version != StateEncryptionVersion {
+		return nil, &stateEncryptionError{fmt.Sprintf("Unsupported state file encryption version [%d]", state.Encryption.Version)}
+	}
+	if provider == nil {
+		return nil, &stateEncryptionError{fmt.Sprintf("State file is encrypted with the [%s] key provider, but no state key was provided", state.Encryption.Provider)}
+	}
+	if provider.Name() != state.Encryption.Provider {
+		return nil, &stateEncryptionError{fmt.Sprintf("State file is encrypted with the [%s] key provider, can't decrypt it with [%s]", state.Encryption.Provider, provider.Name())}
+	}
+	dataKey, err := provider.UnwrapKey(state.Encryption.WrappedKey, state.Encryption.Params)
+	if err != nil {
+		return nil, &stateEncryptionError{fmt.Sprintf("Failed to unwrap state data key: %v", err)}
+	}
+	gcm, err := newStateCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	plainState, err := gcm.Open(nil, state.Encryption.Nonce, state.Data, state.Encryption.additionalData())
+	if err != nil {
+		return nil, &stateEncryptionError{fmt.Sprintf("Failed to decrypt state file: %v", err)}
+	}
+	return plainState, nil
+}
+
+// additionalData binds the encryption header to the ciphertext
+func (e *stateEncryption) additionalData() []byte {
+	return []byte(fmt.Sprintf("%d:%s", e.Version, e.Provider))
+}
+
+func newStateCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create state cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// PassphraseKeyProvider derives the key encryption key from a passphrase with PBKDF2-SHA256
+type PassphraseKeyProvider struct {
+	Passphrase string
+}
+
+func (p *PassphraseKeyProvider) Name() string {
+	return PassphraseKeyProviderName
+}
+
+func (p *PassphraseKeyProvider) WrapKey(dataKey []byte) ([]byte, map[string]string, error) {
+	salt := make([]byte, statePassphraseSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, nil, err
+	}
+	kek := pbkdf2SHA256([]byte(p.Passphrase), salt, statePassphraseIterations, stateDataKeySize)
+	wrappedKey, err := wrapKey(kek, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return wrappedKey, map[string]string{
+		statePassphraseSaltParam:       base64.StdEncoding.EncodeToString(salt),
+		statePassphraseIterationsParam: strconv.Itoa(statePassphraseIterations),
+	}, nil
+}
+
+func (p *PassphraseKeyProvider) UnwrapKey(wrappedKey []byte, params map[string]string) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(params[statePassphraseSaltParam])
+	if err != nil {
+		return nil, fmt.Errorf("invalid passphrase salt: %v", err)
+	}
+	iterations, err := strconv.Atoi(params[statePassphraseIterationsParam])
+	if err != nil || iterations <= 0 {
+		return nil, fmt.Errorf("invalid passphrase iterations [%s]", params[statePassphraseIterationsParam])
+	}
+	kek := pbkdf2SHA256([]byte(p.Passphrase), salt, iterations, stateDataKeySize)
+	return unwrapKey(kek, wrappedKey)
+}
+
+// KeyFileKeyProvider uses the SHA-256 of a key file as the key encryption key, the file is read on each use
+type KeyFileKeyProvider struct {
+	Path string
+}
+
+func (k *KeyFileKeyProvider) Name() string {
+	return KeyFileKeyProviderName
+}
+
+func (k *KeyFileKeyProvider) WrapKey(dataKey []byte) ([]byte, map[string]string, error) {
+	kek, err := k.getKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	wrappedKey, err := wrapKey(kek, dataKey)
+	return wrappedKey, nil, err
+}
+
+func (k *KeyFileKeyProvider) UnwrapKey(wrappedKey []byte, params map[string]string) ([]byte, error) {
+	kek, err := k.getKey()
+	if err != nil {
+		return nil, err
+	}
+	return unwrapKey(kek, wrappedKey)
+}
+
+func (k *KeyFileKeyProvider) getKey() ([]byte, error) {
+	buf, err := ioutil.ReadFile(k.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read state key file: %v", err)
+	}
+	buf = []byte(strings.TrimSpace(string(buf)))
+	if len(buf) < stateKeyFileMinSize {
+		return nil, fmt.Errorf("State key file [%s] must contain at least %d bytes", k.Path, stateKeyFileMinSize)
+	}
+	kek := sha256.Sum256(buf)
+	return kek[:], nil
+}
+
+// wrapKey encrypts a data key with AES-GCM, the nonce is prepended to the result
+func wrapKey(kek, dataKey []byte) ([]byte, error) {
+	gcm, err := newStateCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+func unwrapKey(kek, wrappedKey []byte) ([]byte, error) {
+	gcm, err := newStateCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrappedKey) < gcm.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+	dataKey, err := gcm.Open(nil, wrappedKey[:gcm.NonceSize()], wrappedKey[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrong state key")
+	}
+	return dataKey, nil
+}
+
+// pbkdf2SHA256 implements PBKDF2 from RFC 8018 with HMAC-SHA256
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	derivedKey := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:])
+		derivedKey = prf.Sum(derivedKey)
+		t := derivedKey[len(derivedKey)-hashLen:]
+		copy(u, t)
+
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return derivedKey[:keyLen]
+}
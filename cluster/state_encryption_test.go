@@ -0,0 +1,176 @@
+package cluster
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testStatePassphrase = "correct horse battery staple"
+	testPlainState      = `{"desiredState":{"rkeConfig":{"kubernetes_version":"v1.11.1-rancher1-1"}},"currentState":{}}`
+)
+
+// testEncryptedState is a version 1 state file encrypted with testStatePassphrase, it must keep decrypting
+// so state files written by older releases stay readable
+const testEncryptedState = `{
+  "encryption": {
+    "version": 1,
+    "provider": "passphrase",
+    "params": {
+      "iterations": "1000",
+      "salt": "cmtlLXN0YXRlLXNhbHQhIQ=="
+    },
+    "wrappedKey": "Xm9bflTGL8Mymk99PvQ/CfwfqcgKbK0GGYuHdG+DLclYQYZO3TyRC/+jn5KM7ZWWgBbkx0rVS6ckah+m",
+    "nonce": "Zml4ZWQtbm9uY2Uh"
+  },
+  "data": "nqQIBi2s4pOuD4ZkurC5fP7l0hqQEYrf8pEGuEaBMvnrO8cGfm6iJtkYu3P4LZIs5pD0G8eA3NljIxX28J9ghTDAaFxulzfPyBnt+ezzHbi/m/6/GIBFLodWtdsWvQhOUP3R4mjZlG1jBo9V"
+}`
+
+// renamedKeyProvider is a passphrase provider registered under another name
+type renamedKeyProvider struct {
+	PassphraseKeyProvider
+	name string
+}
+
+func (r *renamedKeyProvider) Name() string {
+	return r.name
+}
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// test vectors of RFC 7914, section 11
+	tests := []struct {
+		password   string
+		salt       string
+		iterations int
+		expected   string
+	}{
+		{
+			password:   "passwd",
+			salt:       "salt",
+			iterations: 1,
+			expected:   "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		},
+		{
+			password:   "Password",
+			salt:       "NaCl",
+			iterations: 80000,
+			expected:   "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d",
+		},
+	}
+	for _, test := range tests {
+		derivedKey := pbkdf2SHA256([]byte(test.password), []byte(test.salt), test.iterations, len(test.expected)/2)
+		if hex.EncodeToString(derivedKey) != test.expected {
+			t.Errorf("Expected PBKDF2 of [%s] with [%d] iterations to be [%s], got [%x]", test.password, test.iterations, test.expected, derivedKey)
+		}
+	}
+}
+
+func TestDecryptStateFormat(t *testing.T) {
+	plainState, err := decryptState(&PassphraseKeyProvider{Passphrase: testStatePassphrase}, []byte(testEncryptedState))
+	if err != nil {
+		t.Fatalf("Failed to decrypt state fixture: %v", err)
+	}
+	if string(plainState) != testPlainState {
+		t.Fatalf("Expected decrypted state [%s], got [%s]", testPlainState, plainState)
+	}
+}
+
+func TestStateEncryptionRoundTrip(t *testing.T) {
+	keyDir, err := ioutil.TempDir("", "rke-state-key")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(keyDir)
+	keyFile := filepath.Join(keyDir, "state.key")
+	if err := ioutil.WriteFile(keyFile, []byte("0123456789abcdef0123456789abcdef\n"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	for _, provider := range []StateKeyProvider{
+		&PassphraseKeyProvider{Passphrase: testStatePassphrase},
+		&KeyFileKeyProvider{Path: keyFile},
+	} {
+		encrypted, err := encryptState(provider, []byte(testPlainState))
+		if err != nil {
+			t.Fatalf("[%s] Failed to encrypt state: %v", provider.Name(), err)
+		}
+		if !IsStateEncrypted(encrypted) || IsStateEncrypted([]byte(testPlainState)) {
+			t.Fatalf("[%s] Expected only the encrypted state to be detected as encrypted", provider.Name())
+		}
+		plainState, err := decryptState(provider, encrypted)
+		if err != nil {
+			t.Fatalf("[%s] Failed to decrypt state: %v", provider.Name(), err)
+		}
+		if string(plainState) != testPlainState {
+			t.Fatalf("[%s] Expected decrypted state [%s], got [%s]", provider.Name(), testPlainState, plainState)
+		}
+	}
+}
+
+func TestDecryptStateErrors(t *testing.T) {
+	provider := &PassphraseKeyProvider{Passphrase: testStatePassphrase}
+	tamper := func(change func(state *encryptedState)) []byte {
+		state := encryptedState{}
+		if err := json.Unmarshal([]byte(testEncryptedState), &state); err != nil {
+			t.Fatalf("Failed to unmarshal state fixture: %v", err)
+		}
+		change(&state)
+		buf, err := json.Marshal(state)
+		if err != nil {
+			t.Fatalf("Failed to marshal state: %v", err)
+		}
+		return buf
+	}
+
+	tests := []struct {
+		name     string
+		provider StateKeyProvider
+		state    []byte
+	}{
+		{
+			name:     "wrong passphrase",
+			provider: &PassphraseKeyProvider{Passphrase: "wrong passphrase"},
+			state:    []byte(testEncryptedState),
+		},
+		{
+			name:     "no key provider",
+			provider: nil,
+			state:    []byte(testEncryptedState),
+		},
+		{
+			name:     "tampered ciphertext",
+			provider: provider,
+			state:    tamper(func(state *encryptedState) { state.Data[0] ^= 0xff }),
+		},
+		{
+			name:     "tampered nonce",
+			provider: provider,
+			state:    tamper(func(state *encryptedState) { state.Encryption.Nonce[0] ^= 0xff }),
+		},
+		{
+			name:     "tampered wrapped key",
+			provider: provider,
+			state:    tamper(func(state *encryptedState) { state.Encryption.WrappedKey[len(state.Encryption.WrappedKey)-1] ^= 0xff }),
+		},
+		{
+			// the provider is part of the additional data, a matching provider with another name can't open the data
+			name:     "tampered additional data",
+			provider: &renamedKeyProvider{PassphraseKeyProvider: *provider, name: "renamed"},
+			state:    tamper(func(state *encryptedState) { state.Encryption.Provider = "renamed" }),
+		},
+		{
+			name:     "unsupported version",
+			provider: provider,
+			state:    tamper(func(state *encryptedState) { state.Encryption.Version = StateEncryptionVersion + 1 }),
+		},
+	}
+	for _, test := range tests {
+		if _, err := decryptState(test.provider, test.state); !IsStateEncryptionError(err) {
+			t.Errorf("[%s] expected a state encryption error, got [%v]", test.name, err)
+		}
+	}
+}
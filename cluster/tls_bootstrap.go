@@ -0,0 +1,333 @@
+package cluster
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/rancher/rke/authz"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/k8s"
+	"github.com/rancher/rke/log"
+	"github.com/rancher/rke/pki"
+	"github.com/rancher/rke/services"
+	v3 "github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/sirupsen/logrus"
+	certificatesv1beta1 "k8s.io/api/certificates/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// MinKubeletTLSBootstrapVersion is the first version with serving certificate rotation enabled by default
+	MinKubeletTLSBootstrapVersion = "1.12.0"
+
+	kubeletBootstrapConfigFileName = "/etc/kubernetes/kubelet-bootstrap.yaml"
+	// the kubelet writes its kubeconfig and certificates once the client certificate is issued
+	kubeletBootstrappedConfigFileName = "/etc/kubernetes/kubelet.yaml"
+	kubeletBootstrapCertDir           = "/etc/kubernetes/kubelet-pki"
+
+	bootstrapTokenSecretPrefix = "bootstrap-token-"
+	bootstrapTokenSecretType   = "bootstrap.kubernetes.io/token"
+	bootstrapTokenUserPrefix   = "system:bootstrap:"
+	bootstrapTokenChars        = "0123456789abcdefghijklmnopqrstuvwxyz"
+	bootstrapTokenIDSize       = 6
+	bootstrapTokenSecretSize   = 16
+	bootstrapTokenDescription  = "RKE kubelet bootstrap token"
+	// a token is only needed until the kubelet has its client certificate, a new one is issued on every run
+	bootstrapTokenTTL = 24 * time.Hour
+
+	kubeletCSRApproveReason   = "RKEKubeletCSRApprove"
+	kubeletCSRApproveInterval = 5 * time.Second
+)
+
+func IsKubeletTLSBootstrapEnabled(rkeConfig *v3.RancherKubernetesEngineConfig) bool {
+	return rkeConfig.Services.Kubelet.TLSBootstrap
+}
+
+// NewKubeletBootstrapToken generates a bootstrap token in the [a-z0-9]{6}.[a-z0-9]{16} format
+func NewKubeletBootstrapToken() (string, error) {
+	tokenID, err := randomBootstrapTokenString(bootstrapTokenIDSize)
+	if err != nil {
+		return "", fmt.Errorf("Failed to generate kubelet bootstrap token: %v", err)
+	}
+	tokenSecret, err := randomBootstrapTokenString(bootstrapTokenSecretSize)
+	if err != nil {
+		return "", fmt.Errorf("Failed to generate kubelet bootstrap token: %v", err)
+	}
+	return tokenID + "." + tokenSecret, nil
+}
+
+func randomBootstrapTokenString(size int) (string, error) {
+	token := make([]byte, size)
+	for i := range token {
+		n, err := cryptorand.Int(cryptorand.Reader, big.NewInt(int64(len(bootstrapTokenChars))))
+		if err != nil {
+			return "", err
+		}
+		token[i] = bootstrapTokenChars[n.Int64()]
+	}
+	return string(token), nil
+}
+
+// generateKubeletBootstrapTokens generates a bootstrap token per node, the token id is how the node's certificate requests are recognized
+func (c *Cluster) generateKubeletBootstrapTokens() error {
+	c.KubeletBootstrapTokens = map[string]string{}
+	for _, host := range hosts.GetUniqueHostList(c.EtcdHosts, c.ControlPlaneHosts, c.WorkerHosts) {
+		token, err := NewKubeletBootstrapToken()
+		if err != nil {
+			return err
+		}
+		c.KubeletBootstrapTokens[host.Address] = token
+	}
+	return nil
+}
+
+func (c *Cluster) getKubeletBootstrapConfig(token string) string {
+	return pki.GetKubeConfigToken("https://127.0.0.1:6443", "local", "kubelet-bootstrap", pki.GetCertPath(pki.CACertName), token)
+}
+
+func (c *Cluster) deployKubeletBootstrapConfig(ctx context.Context) error {
+	if !IsKubeletTLSBootstrapEnabled(&c.RancherKubernetesEngineConfig) {
+		return nil
+	}
+	if err := c.generateKubeletBootstrapTokens(); err != nil {
+		return err
+	}
+	for _, host := range hosts.GetUniqueHostList(c.EtcdHosts, c.ControlPlaneHosts, c.WorkerHosts) {
+		if err := deployFile(ctx, []*hosts.Host{host}, c.SystemImages.Alpine, c.PrivateRegistriesMap, kubeletBootstrapConfigFileName, c.getKubeletBootstrapConfig(c.KubeletBootstrapTokens[host.Address])); err != nil {
+			return err
+		}
+	}
+	log.Infof(ctx, "[%s] Successfully deployed kubelet bootstrap config to Cluster nodes", kubeletBootstrapConfigFileName)
+	return nil
+}
+
+// deployKubeletBootstrapToken creates the bootstrap token secrets of the nodes and the roles the kubelets need to request
+// their certificates. The tokens expire, the ones of earlier runs are removed.
+func (c *Cluster) deployKubeletBootstrapToken(ctx context.Context) error {
+	if !IsKubeletTLSBootstrapEnabled(&c.RancherKubernetesEngineConfig) {
+		return nil
+	}
+	log.Infof(ctx, "[tls-bootstrap] Creating kubelet bootstrap tokens")
+	k8sClient, err := k8s.NewClient(c.LocalKubeConfigPath, c.K8sWrapTransport)
+	if err != nil {
+		return fmt.Errorf("Failed to create Kubernetes Client: %v", err)
+	}
+	expiration := time.Now().Add(bootstrapTokenTTL).UTC().Format(time.RFC3339)
+	secretNames := map[string]bool{}
+	for address, token := range c.KubeletBootstrapTokens {
+		tokenParts := strings.SplitN(token, ".", 2)
+		if len(tokenParts) != 2 {
+			return fmt.Errorf("Kubelet bootstrap token of node [%s] is malformed", address)
+		}
+		secretData := map[string][]byte{
+			"description":                    []byte(fmt.Sprintf("%s for node [%s]", bootstrapTokenDescription, address)),
+			"token-id":                       []byte(tokenParts[0]),
+			"token-secret":                   []byte(tokenParts[1]),
+			"expiration":                     []byte(expiration),
+			"usage-bootstrap-authentication": []byte("true"),
+		}
+		secretName := bootstrapTokenSecretPrefix + tokenParts[0]
+		if err := k8s.UpdateSecretWithType(k8sClient, secretData, secretName, bootstrapTokenSecretType); err != nil {
+			return fmt.Errorf("Failed to create kubelet bootstrap token secret: %v", err)
+		}
+		secretNames[secretName] = true
+	}
+	secrets, err := k8s.ListSecretsWithType(k8sClient, bootstrapTokenSecretType)
+	if err != nil {
+		return fmt.Errorf("Failed to list kubelet bootstrap token secrets: %v", err)
+	}
+	for _, secret := range secrets {
+		if secretNames[secret.Name] || !strings.HasPrefix(string(secret.Data["description"]), bootstrapTokenDescription) {
+			continue
+		}
+		if err := k8s.DeleteSecret(k8sClient, secret.Name); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("Failed to remove kubelet bootstrap token secret [%s]: %v", secret.Name, err)
+		}
+	}
+	if c.Authorization.Mode == services.RBACAuthorizationMode {
+		if err := authz.ApplyKubeletTLSBootstrapClusterRoleBindings(ctx, c.LocalKubeConfigPath, c.K8sWrapTransport); err != nil {
+			return fmt.Errorf("Failed to apply the ClusterRoleBindings needed for kubelet TLS bootstrap: %v", err)
+		}
+	}
+	return nil
+}
+
+// kubeletCSRNode is what a kubelet certificate request of a node may contain
+type kubeletCSRNode struct {
+	commonName string
+	// bootstrapTokenID is the token the node requests its client certificate with
+	bootstrapTokenID string
+	// sans are the addresses and the hostname the serving certificate may be issued for
+	sans map[string]bool
+}
+
+func (c *Cluster) getKubeletCSRNodes() []kubeletCSRNode {
+	csrNodes := []kubeletCSRNode{}
+	for _, host := range hosts.GetUniqueHostList(c.EtcdHosts, c.ControlPlaneHosts, c.WorkerHosts) {
+		nodeName := strings.ToLower(host.HostnameOverride)
+		csrNode := kubeletCSRNode{
+			commonName: fmt.Sprintf("%s:%s", pki.KubeNodeCommonName, nodeName),
+			sans: map[string]bool{
+				host.Address:         true,
+				host.InternalAddress: true,
+				nodeName:             true,
+			},
+		}
+		if token := c.KubeletBootstrapTokens[host.Address]; len(token) > 0 {
+			csrNode.bootstrapTokenID = strings.SplitN(token, ".", 2)[0]
+		}
+		csrNodes = append(csrNodes, csrNode)
+	}
+	return csrNodes
+}
+
+// validateKubeletCSR only accepts the client certificate request a node makes with its own bootstrap token and
+// the serving certificate request of a node for its addresses and hostname
+func validateKubeletCSR(csr *certificatesv1beta1.CertificateSigningRequest, csrNodes []kubeletCSRNode) error {
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return fmt.Errorf("request isn't a PEM encoded certificate request")
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate request: %v", err)
+	}
+	if err := request.CheckSignature(); err != nil {
+		return fmt.Errorf("invalid certificate request signature: %v", err)
+	}
+	var csrNode *kubeletCSRNode
+	for i := range csrNodes {
+		if csrNodes[i].commonName == request.Subject.CommonName {
+			csrNode = &csrNodes[i]
+			break
+		}
+	}
+	if csrNode == nil {
+		return fmt.Errorf("common name [%s] isn't a node of the cluster", request.Subject.CommonName)
+	}
+	if len(request.Subject.Organization) != 1 || request.Subject.Organization[0] != pki.KubeNodeOrganizationName {
+		return fmt.Errorf("organization %v isn't [%s]", request.Subject.Organization, pki.KubeNodeOrganizationName)
+	}
+	if len(request.EmailAddresses) > 0 || len(request.URIs) > 0 {
+		return fmt.Errorf("email and URI subject alternative names aren't allowed")
+	}
+	usage, err := getKubeletCSRUsage(csr.Spec.Usages)
+	if err != nil {
+		return err
+	}
+	switch usage {
+	case certificatesv1beta1.UsageClientAuth:
+		if len(csrNode.bootstrapTokenID) == 0 || csr.Spec.Username != bootstrapTokenUserPrefix+csrNode.bootstrapTokenID {
+			return fmt.Errorf("client certificate for [%s] isn't requested with the node bootstrap token but by [%s]", csrNode.commonName, csr.Spec.Username)
+		}
+		if len(request.DNSNames) > 0 || len(request.IPAddresses) > 0 {
+			return fmt.Errorf("client certificate can't have subject alternative names")
+		}
+	case certificatesv1beta1.UsageServerAuth:
+		if csr.Spec.Username != csrNode.commonName {
+			return fmt.Errorf("serving certificate for [%s] is requested by [%s]", csrNode.commonName, csr.Spec.Username)
+		}
+		if len(request.DNSNames) == 0 && len(request.IPAddresses) == 0 {
+			return fmt.Errorf("serving certificate has no subject alternative names")
+		}
+		for _, dnsName := range request.DNSNames {
+			if !csrNode.sans[dnsName] {
+				return fmt.Errorf("DNS name [%s] isn't the hostname of node [%s]", dnsName, csrNode.commonName)
+			}
+		}
+		for _, ip := range request.IPAddresses {
+			if !csrNode.sans[ip.String()] {
+				return fmt.Errorf("IP address [%s] isn't an address of node [%s]", ip, csrNode.commonName)
+			}
+		}
+	}
+	return nil
+}
+
+// getKubeletCSRUsage returns whether the usages are the ones of a kubelet client or serving certificate
+func getKubeletCSRUsage(usages []certificatesv1beta1.KeyUsage) (certificatesv1beta1.KeyUsage, error) {
+	var usage certificatesv1beta1.KeyUsage
+	for _, u := range usages {
+		switch u {
+		case certificatesv1beta1.UsageDigitalSignature, certificatesv1beta1.UsageKeyEncipherment:
+		case certificatesv1beta1.UsageClientAuth, certificatesv1beta1.UsageServerAuth:
+			if len(usage) > 0 && usage != u {
+				return "", fmt.Errorf("certificate can't be both a client and a serving certificate")
+			}
+			usage = u
+		default:
+			return "", fmt.Errorf("usage [%s] isn't allowed", u)
+		}
+	}
+	if len(usage) == 0 {
+		return "", fmt.Errorf("certificate is neither a client nor a serving certificate")
+	}
+	return usage, nil
+}
+
+// approveKubeletCSRs approves the pending certificate requests of the cluster kubelets, other requests are left alone
+func (c *Cluster) approveKubeletCSRs(ctx context.Context, k8sClient *kubernetes.Clientset) error {
+	csrs, err := k8s.ListPendingCSRs(k8sClient)
+	if err != nil {
+		return fmt.Errorf("Failed to list certificate signing requests: %v", err)
+	}
+	csrNodes := c.getKubeletCSRNodes()
+	for i := range csrs {
+		csr := &csrs[i]
+		if !strings.HasPrefix(csr.Spec.Username, bootstrapTokenUserPrefix) && !strings.HasPrefix(csr.Spec.Username, pki.KubeNodeCommonName+":") {
+			continue
+		}
+		if err := validateKubeletCSR(csr, csrNodes); err != nil {
+			logrus.Warnf("[tls-bootstrap] Not approving certificate signing request [%s]: %v", csr.Name, err)
+			continue
+		}
+		if err := k8s.ApproveCSR(k8sClient, csr, kubeletCSRApproveReason, "Approved by RKE for a node of the cluster"); err != nil {
+			return fmt.Errorf("Failed to approve certificate signing request [%s]: %v", csr.Name, err)
+		}
+		log.Infof(ctx, "[tls-bootstrap] Approved certificate signing request [%s] of [%s]", csr.Name, csr.Spec.Username)
+	}
+	return nil
+}
+
+// startKubeletCSRApprover approves the kubelet certificate requests while the worker plane is deployed,
+// the returned function makes a last pass and stops it. Serving certificate renewals are approved on the next run.
+func (c *Cluster) startKubeletCSRApprover(ctx context.Context) (func(), error) {
+	if !IsKubeletTLSBootstrapEnabled(&c.RancherKubernetesEngineConfig) {
+		return func() {}, nil
+	}
+	k8sClient, err := k8s.NewClient(c.LocalKubeConfigPath, c.K8sWrapTransport)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create Kubernetes Client: %v", err)
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(kubeletCSRApproveInterval)
+		defer ticker.Stop()
+		for {
+			if err := c.approveKubeletCSRs(ctx, k8sClient); err != nil {
+				logrus.Warnf("[tls-bootstrap] %v", err)
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		if err := c.approveKubeletCSRs(ctx, k8sClient); err != nil {
+			logrus.Warnf("[tls-bootstrap] %v", err)
+		}
+	}, nil
+}
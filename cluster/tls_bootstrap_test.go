@@ -0,0 +1,177 @@
+package cluster
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"testing"
+
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/pki"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	certificatesv1beta1 "k8s.io/api/certificates/v1beta1"
+)
+
+func TestValidateKubeletCSR(t *testing.T) {
+	c := &Cluster{
+		ControlPlaneHosts: []*hosts.Host{
+			{RKEConfigNode: v3.RKEConfigNode{Address: "1.1.1.1", InternalAddress: "10.0.0.1", HostnameOverride: "Node1"}},
+		},
+		WorkerHosts: []*hosts.Host{
+			{RKEConfigNode: v3.RKEConfigNode{Address: "2.2.2.2", InternalAddress: "10.0.0.2", HostnameOverride: "node2"}},
+		},
+		KubeletBootstrapTokens: map[string]string{
+			"1.1.1.1": "abcdef.0123456789abcdef",
+			"2.2.2.2": "ghijkl.0123456789abcdef",
+		},
+	}
+	clientUsages := []certificatesv1beta1.KeyUsage{certificatesv1beta1.UsageDigitalSignature, certificatesv1beta1.UsageKeyEncipherment, certificatesv1beta1.UsageClientAuth}
+	servingUsages := []certificatesv1beta1.KeyUsage{certificatesv1beta1.UsageDigitalSignature, certificatesv1beta1.UsageKeyEncipherment, certificatesv1beta1.UsageServerAuth}
+	tests := []struct {
+		name        string
+		username    string
+		usages      []certificatesv1beta1.KeyUsage
+		template    x509.CertificateRequest
+		expectedErr bool
+	}{
+		{
+			name:     "client certificate with the node token",
+			username: "system:bootstrap:abcdef",
+			usages:   clientUsages,
+			template: x509.CertificateRequest{Subject: pkix.Name{CommonName: "system:node:node1", Organization: []string{pki.KubeNodeOrganizationName}}},
+		},
+		{
+			name:        "client certificate with the token of another node",
+			username:    "system:bootstrap:ghijkl",
+			usages:      clientUsages,
+			template:    x509.CertificateRequest{Subject: pkix.Name{CommonName: "system:node:node1", Organization: []string{pki.KubeNodeOrganizationName}}},
+			expectedErr: true,
+		},
+		{
+			name:        "client certificate of an unknown node",
+			username:    "system:bootstrap:abcdef",
+			usages:      clientUsages,
+			template:    x509.CertificateRequest{Subject: pkix.Name{CommonName: "system:node:node3", Organization: []string{pki.KubeNodeOrganizationName}}},
+			expectedErr: true,
+		},
+		{
+			name:        "client certificate for another group",
+			username:    "system:bootstrap:abcdef",
+			usages:      clientUsages,
+			template:    x509.CertificateRequest{Subject: pkix.Name{CommonName: "system:node:node1", Organization: []string{pki.KubeNodeOrganizationName, "system:masters"}}},
+			expectedErr: true,
+		},
+		{
+			name:        "client certificate with subject alternative names",
+			username:    "system:bootstrap:abcdef",
+			usages:      clientUsages,
+			template:    x509.CertificateRequest{Subject: pkix.Name{CommonName: "system:node:node1", Organization: []string{pki.KubeNodeOrganizationName}}, DNSNames: []string{"node1"}},
+			expectedErr: true,
+		},
+		{
+			name:     "serving certificate for the node addresses and hostname",
+			username: "system:node:node2",
+			usages:   servingUsages,
+			template: x509.CertificateRequest{
+				Subject:     pkix.Name{CommonName: "system:node:node2", Organization: []string{pki.KubeNodeOrganizationName}},
+				DNSNames:    []string{"node2"},
+				IPAddresses: []net.IP{net.ParseIP("2.2.2.2"), net.ParseIP("10.0.0.2")},
+			},
+		},
+		{
+			name:     "serving certificate for another hostname",
+			username: "system:node:node2",
+			usages:   servingUsages,
+			template: x509.CertificateRequest{
+				Subject:  pkix.Name{CommonName: "system:node:node2", Organization: []string{pki.KubeNodeOrganizationName}},
+				DNSNames: []string{"node2", "kubernetes.default"},
+			},
+			expectedErr: true,
+		},
+		{
+			name:     "serving certificate for the address of another node",
+			username: "system:node:node2",
+			usages:   servingUsages,
+			template: x509.CertificateRequest{
+				Subject:     pkix.Name{CommonName: "system:node:node2", Organization: []string{pki.KubeNodeOrganizationName}},
+				IPAddresses: []net.IP{net.ParseIP("1.1.1.1")},
+			},
+			expectedErr: true,
+		},
+		{
+			name:     "serving certificate requested by another node",
+			username: "system:node:node1",
+			usages:   servingUsages,
+			template: x509.CertificateRequest{
+				Subject:  pkix.Name{CommonName: "system:node:node2", Organization: []string{pki.KubeNodeOrganizationName}},
+				DNSNames: []string{"node2"},
+			},
+			expectedErr: true,
+		},
+		{
+			name:     "serving certificate requested with a bootstrap token",
+			username: "system:bootstrap:ghijkl",
+			usages:   servingUsages,
+			template: x509.CertificateRequest{
+				Subject:  pkix.Name{CommonName: "system:node:node2", Organization: []string{pki.KubeNodeOrganizationName}},
+				DNSNames: []string{"node2"},
+			},
+			expectedErr: true,
+		},
+		{
+			name:     "client and serving certificate",
+			username: "system:node:node2",
+			usages:   append(clientUsages, certificatesv1beta1.UsageServerAuth),
+			template: x509.CertificateRequest{
+				Subject:  pkix.Name{CommonName: "system:node:node2", Organization: []string{pki.KubeNodeOrganizationName}},
+				DNSNames: []string{"node2"},
+			},
+			expectedErr: true,
+		},
+	}
+	csrNodes := c.getKubeletCSRNodes()
+	for _, test := range tests {
+		csr := &certificatesv1beta1.CertificateSigningRequest{
+			Spec: certificatesv1beta1.CertificateSigningRequestSpec{
+				Request:  newTestCSR(t, &test.template),
+				Username: test.username,
+				Usages:   test.usages,
+			},
+		}
+		err := validateKubeletCSR(csr, csrNodes)
+		if test.expectedErr && err == nil {
+			t.Errorf("[%s] expected the request to be rejected", test.name)
+		}
+		if !test.expectedErr && err != nil {
+			t.Errorf("[%s] expected the request to be approved, got: %v", test.name, err)
+		}
+	}
+
+	// a request that isn't a CSR is rejected
+	csr := &certificatesv1beta1.CertificateSigningRequest{
+		Spec: certificatesv1beta1.CertificateSigningRequestSpec{
+			Request:  []byte("not a request"),
+			Username: "system:bootstrap:abcdef",
+			Usages:   clientUsages,
+		},
+	}
+	if err := validateKubeletCSR(csr, csrNodes); err == nil {
+		t.Errorf("Expected a malformed request to be rejected")
+	}
+}
+
+func newTestCSR(t *testing.T, template *x509.CertificateRequest) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.CreateCertificateRequest(cryptorand.Reader, template, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate request: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
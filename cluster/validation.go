@@ -3,13 +3,16 @@ package cluster
 import (
 	"context"
 	"fmt"
+	"path"
 	"strings"
 
+	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/log"
 	"github.com/rancher/rke/pki"
 	"github.com/rancher/rke/services"
 	"github.com/rancher/rke/util"
 	v3 "github.com/rancher/types/apis/management.cattle.io/v3"
+	"gopkg.in/yaml.v2"
 	"k8s.io/apimachinery/pkg/util/validation"
 )
 
@@ -45,6 +48,26 @@ func (c *Cluster) ValidateCluster(ctx context.Context) error {
 		return err
 	}
 
+	// validate upgrade strategy options
+	if err := validateUpgradeStrategyOptions(c); err != nil {
+		return err
+	}
+
+	// validate pki options
+	if err := validatePKIOptions(c); err != nil {
+		return err
+	}
+
+	// validate secrets encryption options
+	if err := validateSecretsEncryptionOptions(c); err != nil {
+		return err
+	}
+
+	// validate kubelet TLS bootstrap options
+	if err := validateKubeletTLSBootstrapOptions(c); err != nil {
+		return err
+	}
+
 	// validate services options
 	return validateServicesOptions(c)
 }
@@ -73,11 +96,30 @@ func validateNetworkOptions(c *Cluster) error {
 }
 
 func validateHostsOptions(c *Cluster) error {
+	switch c.SSHHostKeyChecking {
+	case hosts.HostKeyCheckingNone, hosts.HostKeyCheckingKnownHosts, hosts.HostKeyCheckingTrustOnFirstUse:
+	default:
+		return fmt.Errorf("SSH host key checking [%s] is not supported, must be one of [%s, %s, %s]", c.SSHHostKeyChecking, hosts.HostKeyCheckingNone, hosts.HostKeyCheckingKnownHosts, hosts.HostKeyCheckingTrustOnFirstUse)
+	}
+	if c.SSHMaxChannels < 0 {
+		return fmt.Errorf("SSH max channels [%d] is not valid, must be 0 for no limit or a positive number", c.SSHMaxChannels)
+	}
+	if len(c.BastionHost.Address) > 0 && len(c.BastionHosts) > 0 {
+		return fmt.Errorf("Bastion host and bastion hosts can't be both set, move the bastion host to the bastion hosts")
+	}
+	bastionHosts := c.BastionHosts
+	if len(c.BastionHost.Address) > 0 {
+		bastionHosts = []v3.BastionHost{c.BastionHost}
+	}
+	if err := validateBastionHosts(bastionHosts, "cluster"); err != nil {
+		return err
+	}
 	for i, host := range c.Nodes {
 		if len(host.Address) == 0 {
 			return fmt.Errorf("Address for host (%d) is not provided", i+1)
 		}
-		if len(host.User) == 0 {
+		// the user can come from the ssh config, there is no user for the hosts with a Docker endpoint
+		if len(host.User) == 0 && len(c.SSHConfigPath) == 0 && len(host.DockerEndpoint) == 0 {
 			return fmt.Errorf("User for host (%d) is not provided", i+1)
 		}
 		if len(host.Role) == 0 {
@@ -91,6 +133,60 @@ func validateHostsOptions(c *Cluster) error {
 				return fmt.Errorf("Role [%s] for host (%d) is not recognized", role, i+1)
 			}
 		}
+		if len(host.SSHHostKeyFingerprint) > 0 && !strings.HasPrefix(host.SSHHostKeyFingerprint, hosts.SSHFingerprintPrefix) {
+			return fmt.Errorf("SSH host key fingerprint [%s] for host (%d) is not valid, must be a %s fingerprint", host.SSHHostKeyFingerprint, i+1, hosts.SSHFingerprintPrefix)
+		}
+		if err := validateBastionHosts(host.BastionHosts, fmt.Sprintf("host (%d)", i+1)); err != nil {
+			return err
+		}
+		if err := validateDockerEndpoint(host, i); err != nil {
+			return err
+		}
+		for _, taint := range host.Taints {
+			if errs := validation.IsQualifiedName(taint.Key); len(errs) > 0 {
+				return fmt.Errorf("Taint key [%s] for host (%d) is not valid: %v", taint.Key, i+1, errs)
+			}
+			if errs := validation.IsValidLabelValue(taint.Value); len(errs) > 0 {
+				return fmt.Errorf("Taint value [%s] for host (%d) is not valid: %v", taint.Value, i+1, errs)
+			}
+			if taint.Effect != "NoSchedule" && taint.Effect != "PreferNoSchedule" && taint.Effect != "NoExecute" {
+				return fmt.Errorf("Taint effect [%s] for host (%d) is not supported, must be one of [NoSchedule, PreferNoSchedule, NoExecute]", taint.Effect, i+1)
+			}
+		}
+	}
+	return nil
+}
+
+func validateDockerEndpoint(host v3.RKEConfigNode, i int) error {
+	if len(host.DockerEndpoint) == 0 {
+		return nil
+	}
+	if err := hosts.ValidateDockerEndpoint(host.DockerEndpoint); err != nil {
+		return fmt.Errorf("Docker endpoint [%s] for host (%d) is not valid: %v", host.DockerEndpoint, i+1, err)
+	}
+	if len(host.BastionHosts) > 0 {
+		return fmt.Errorf("Bastion hosts for host (%d) can't be used with a Docker endpoint", i+1)
+	}
+	if len(host.DockerCACert) == 0 && len(host.DockerCACertPath) == 0 {
+		return fmt.Errorf("Docker CA certificate for host (%d) is not provided, set docker_ca_cert or docker_ca_cert_path", i+1)
+	}
+	if len(host.DockerCert) == 0 && len(host.DockerCertPath) == 0 {
+		return fmt.Errorf("Docker client certificate for host (%d) is not provided, set docker_cert or docker_cert_path", i+1)
+	}
+	if len(host.DockerKey) == 0 && len(host.DockerKeyPath) == 0 {
+		return fmt.Errorf("Docker client key for host (%d) is not provided, set docker_key or docker_key_path", i+1)
+	}
+	return nil
+}
+
+func validateBastionHosts(bastionHosts []v3.BastionHost, owner string) error {
+	for i, bastionHost := range bastionHosts {
+		if len(bastionHost.Address) == 0 {
+			return fmt.Errorf("Address for bastion host (%d) of %s is not provided", i+1, owner)
+		}
+		if len(bastionHost.SSHHostKeyFingerprint) > 0 && !strings.HasPrefix(bastionHost.SSHHostKeyFingerprint, hosts.SSHFingerprintPrefix) {
+			return fmt.Errorf("SSH host key fingerprint [%s] for bastion host of %s is not valid, must be a %s fingerprint", bastionHost.SSHHostKeyFingerprint, owner, hosts.SSHFingerprintPrefix)
+		}
 	}
 	return nil
 }
@@ -136,6 +232,11 @@ func validateServicesOptions(c *Cluster) error {
 		return err
 	}
 
+	// validate kube-api audit log configurations
+	if err := validateAuditLogOptions(c); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -158,6 +259,104 @@ func validateEtcdBackupOptions(c *Cluster) error {
 	return nil
 }
 
+func validateAuditLogOptions(c *Cluster) error {
+	if !c.IsAuditLogEnabled() {
+		return nil
+	}
+	auditLog := c.Services.KubeAPI.AuditLog
+	if !path.IsAbs(auditLog.Path) {
+		return fmt.Errorf("kube-api audit log path [%s] must be absolute", auditLog.Path)
+	}
+	if auditLog.Format != "json" && auditLog.Format != "legacy" {
+		return fmt.Errorf("kube-api audit log format [%s] is not supported, must be one of [json, legacy]", auditLog.Format)
+	}
+	if auditLog.MaxAge < 0 || auditLog.MaxBackup < 0 || auditLog.MaxSize < 0 {
+		return fmt.Errorf("kube-api audit log max_age, max_backup and max_size can't be negative")
+	}
+	policy := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(auditLog.Policy), &policy); err != nil {
+		return fmt.Errorf("Failed to parse kube-api audit policy: %v", err)
+	}
+	if policy["kind"] != "Policy" {
+		return fmt.Errorf("kube-api audit policy kind must be [Policy]")
+	}
+	return nil
+}
+
+func validateUpgradeStrategyOptions(c *Cluster) error {
+	if c.UpgradeStrategy == nil {
+		return nil
+	}
+	if _, err := services.GetMaxUnavailable(c.UpgradeStrategy.MaxUnavailable, len(c.WorkerHosts)); err != nil {
+		return err
+	}
+	if c.UpgradeStrategy.DrainInput != nil && c.UpgradeStrategy.DrainInput.Timeout < 0 {
+		return fmt.Errorf("Node drain timeout can't be negative")
+	}
+	if c.UpgradeStrategy.NodeReadyTimeout < 0 {
+		return fmt.Errorf("Node ready timeout can't be negative")
+	}
+	return nil
+}
+
+func validatePKIOptions(c *Cluster) error {
+	if c.PKI == nil {
+		return nil
+	}
+	if err := pki.ValidateKeyAlgorithm(c.PKI.CAKeyAlgorithm); err != nil {
+		return fmt.Errorf("Invalid pki.ca_key_algorithm: %v", err)
+	}
+	if err := pki.ValidateKeyAlgorithm(c.PKI.KeyAlgorithm); err != nil {
+		return fmt.Errorf("Invalid pki.key_algorithm: %v", err)
+	}
+	if c.PKI.IntermediateCA != nil && (len(c.PKI.IntermediateCA.Cert) == 0 || len(c.PKI.IntermediateCA.Key) == 0) {
+		return fmt.Errorf("pki.intermediate_ca requires both cert and key")
+	}
+	return nil
+}
+
+func validateSecretsEncryptionOptions(c *Cluster) error {
+	if !IsSecretsEncryptionEnabled(&c.RancherKubernetesEngineConfig) {
+		return nil
+	}
+	if provider := GetSecretsEncryptionProvider(&c.RancherKubernetesEngineConfig); provider != AESCBCEncryptionProvider && provider != SecretboxEncryptionProvider {
+		return fmt.Errorf("Secrets encryption provider [%s] is not supported, must be one of [%s, %s]", provider, AESCBCEncryptionProvider, SecretboxEncryptionProvider)
+	}
+	clusterSemVer, err := util.StrToSemVer(c.Version)
+	if err != nil {
+		return err
+	}
+	minSemVer, err := util.StrToSemVer(MinSecretsEncryptionVersion)
+	if err != nil {
+		return err
+	}
+	// compare without the -rancher suffix, it would make v1.13.x older than 1.13.0
+	clusterSemVer.PreRelease = ""
+	if clusterSemVer.LessThan(*minSemVer) {
+		return fmt.Errorf("Secrets encryption requires Kubernetes version [%s] or later", MinSecretsEncryptionVersion)
+	}
+	return nil
+}
+
+func validateKubeletTLSBootstrapOptions(c *Cluster) error {
+	if !IsKubeletTLSBootstrapEnabled(&c.RancherKubernetesEngineConfig) {
+		return nil
+	}
+	clusterSemVer, err := util.StrToSemVer(c.Version)
+	if err != nil {
+		return err
+	}
+	minSemVer, err := util.StrToSemVer(MinKubeletTLSBootstrapVersion)
+	if err != nil {
+		return err
+	}
+	clusterSemVer.PreRelease = ""
+	if clusterSemVer.LessThan(*minSemVer) {
+		return fmt.Errorf("Kubelet TLS bootstrap requires Kubernetes version [%s] or later", MinKubeletTLSBootstrapVersion)
+	}
+	return nil
+}
+
 func validateIngressOptions(c *Cluster) error {
 	// Should be changed when adding more ingress types
 	if c.Ingress.Provider != DefaultIngressController && c.Ingress.Provider != "none" {
@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+func TestValidatePKIOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		pkiConfig   *v3.PKIConfig
+		expectedErr string
+	}{
+		{name: "defaults", pkiConfig: nil},
+		{name: "rsa", pkiConfig: &v3.PKIConfig{CAKeyAlgorithm: "rsa", KeyAlgorithm: "rsa"}},
+		{name: "ecdsa", pkiConfig: &v3.PKIConfig{CAKeyAlgorithm: "ecdsa", KeyAlgorithm: "ecdsa"}},
+		{
+			name:        "ed25519 ca",
+			pkiConfig:   &v3.PKIConfig{CAKeyAlgorithm: "ed25519"},
+			expectedErr: "pki.ca_key_algorithm: key algorithm [ed25519] is not supported by Kubernetes and etcd",
+		},
+		{
+			name:        "ed25519 components",
+			pkiConfig:   &v3.PKIConfig{KeyAlgorithm: "ed25519"},
+			expectedErr: "pki.key_algorithm: key algorithm [ed25519] is not supported by Kubernetes and etcd",
+		},
+		{
+			name:        "unknown",
+			pkiConfig:   &v3.PKIConfig{KeyAlgorithm: "dsa"},
+			expectedErr: "unsupported key algorithm [dsa]",
+		},
+	}
+	for _, test := range tests {
+		rkeConfig := &v3.RancherKubernetesEngineConfig{
+			Nodes: []v3.RKEConfigNode{
+				{Address: "1.1.1.1", User: "rancher", Role: []string{"controlplane", "etcd", "worker"}},
+			},
+			PKI: test.pkiConfig,
+		}
+		// the cluster object is validated when it's initialized
+		_, err := InitClusterObject(context.Background(), rkeConfig, ExternalFlags{})
+		if len(test.expectedErr) == 0 {
+			if err != nil {
+				t.Errorf("[%s] unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+			t.Errorf("[%s] expected error [%s], got [%v]", test.name, test.expectedErr, err)
+		}
+	}
+}
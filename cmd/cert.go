@@ -2,7 +2,14 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/rancher/rke/cluster"
 	"github.com/rancher/rke/hosts"
@@ -14,6 +21,8 @@ import (
 	"k8s.io/client-go/util/cert"
 )
 
+const stateCertificatesSource = "state"
+
 func CertificateCommand() cli.Command {
 	return cli.Command{
 		Name:  "cert",
@@ -43,7 +52,29 @@ func CertificateCommand() cli.Command {
 					},
 					cli.BoolFlag{
 						Name:  "rotate-ca",
-						Usage: "Rotate all certificates including CA certs",
+						Usage: "Rotate all certificates including CA certs, keys are regenerated with the configured pki key algorithms",
+					},
+					cli.StringSliceFlag{
+						Name:  "node",
+						Usage: "Specify the address of a node to rotate its kubelet certificate only",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "check",
+				Usage:  "Report the expiry of RKE cluster certificates in the state file and on the nodes",
+				Action: checkRKECertificatesFromCli,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "config",
+						Usage:  "Specify an alternate cluster YAML file",
+						Value:  pki.ClusterConfig,
+						EnvVar: "RKE_CONFIG",
+					},
+					cli.StringFlag{
+						Name:  "threshold",
+						Usage: "Fail if a certificate expires within this period, in days (example, 30d) or as a duration (example, 720h)",
+						Value: "30d",
 					},
 				},
 			},
@@ -71,6 +102,10 @@ func CertificateCommand() cli.Command {
 func rotateRKECertificatesFromCli(ctx *cli.Context) error {
 	k8sComponents := ctx.StringSlice("service")
 	rotateCACerts := ctx.Bool("rotate-ca")
+	nodes := ctx.StringSlice("node")
+	if len(nodes) > 0 && (len(k8sComponents) > 0 || rotateCACerts) {
+		return fmt.Errorf("--node can't be used with --service or --rotate-ca")
+	}
 	clusterFile, filePath, err := resolveClusterFile(ctx)
 	if err != nil {
 		return fmt.Errorf("Failed to resolve cluster file: %v", err)
@@ -90,14 +125,173 @@ func rotateRKECertificatesFromCli(ctx *cli.Context) error {
 	rkeConfig.RotateCertificates = &v3.RotateCertificates{
 		CACertificates: rotateCACerts,
 		Services:       k8sComponents,
+		Nodes:          nodes,
+	}
+	unlock, err := lockClusterState(ctx, cluster.GetStateFilePath(externalFlags.ClusterFilePath, externalFlags.ConfigDir), "cert rotate")
+	if err != nil {
+		return err
 	}
-	if err := ClusterInit(context.Background(), rkeConfig, hosts.DialersOptions{}, externalFlags); err != nil {
+	defer unlock()
+	if err := ClusterInit(newContext(ctx), rkeConfig, hosts.DialersOptions{}, externalFlags); err != nil {
 		return err
 	}
-	_, _, _, _, _, err = ClusterUp(context.Background(), hosts.DialersOptions{}, externalFlags)
+	_, _, _, _, _, err = ClusterUp(newContext(ctx), hosts.DialersOptions{}, externalFlags)
 	return err
 }
 
+func checkRKECertificatesFromCli(ctx *cli.Context) error {
+	threshold, err := cluster.ParseCertificateExpiryThreshold(ctx.String("threshold"))
+	if err != nil {
+		return err
+	}
+	clusterFile, filePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve cluster file: %v", err)
+	}
+
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	if err != nil {
+		return fmt.Errorf("Failed to parse cluster file: %v", err)
+	}
+	rkeConfig, err = setOptionsFromCLI(ctx, rkeConfig)
+	if err != nil {
+		return err
+	}
+	// setting up the flags
+	externalFlags := cluster.GetExternalFlags(false, false, false, "", filePath)
+
+	checks, err := CheckRKECertificates(newContext(ctx), rkeConfig, hosts.DialersOptions{}, externalFlags)
+	if err != nil {
+		return err
+	}
+	if err := printCertificateChecks(checks, ctx.GlobalString("output")); err != nil {
+		return err
+	}
+	if expiring := getExpiringChecks(checks, threshold); len(expiring) > 0 {
+		return fmt.Errorf("%d certificates expire within [%s]", len(expiring), ctx.String("threshold"))
+	}
+	return nil
+}
+
+// getExpiringChecks returns the checks of the certificates that expire within threshold, or already expired
+func getExpiringChecks(checks []CertificateCheck, threshold time.Duration) []CertificateCheck {
+	expiring := []CertificateCheck{}
+	deadline := time.Now().Add(threshold)
+	for _, check := range checks {
+		if check.NotAfter.Before(deadline) {
+			expiring = append(expiring, check)
+		}
+	}
+	return expiring
+}
+
+// CertificateCheck describes a certificate found in the state file or deployed on a node
+type CertificateCheck struct {
+	// Source is either the state file or the address of the node
+	Source       string    `json:"source"`
+	Name         string    `json:"name"`
+	Subject      string    `json:"subject"`
+	SANs         []string  `json:"sans,omitempty"`
+	Issuer       string    `json:"issuer"`
+	NotAfter     time.Time `json:"notAfter"`
+	DaysToExpiry int       `json:"daysToExpiry"`
+	// Mismatch is set for node certificates that differ from the state file
+	Mismatch bool `json:"mismatch,omitempty"`
+}
+
+// CheckRKECertificates lists the certificates of the state file and the ones deployed on the nodes
+func CheckRKECertificates(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfig, dialersOptions hosts.DialersOptions, flags cluster.ExternalFlags) ([]CertificateCheck, error) {
+	log.Infof(ctx, "Checking Kubernetes cluster certificates")
+	rkeFullState, err := cluster.ReadStateFile(ctx, cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir))
+	if err != nil {
+		return nil, err
+	}
+	stateCerts := rkeFullState.DesiredState.CertificatesBundle
+	checks := getCertificateChecks(stateCertificatesSource, stateCerts, nil)
+
+	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig, flags)
+	if err != nil {
+		return nil, err
+	}
+	if err := kubeCluster.SetupDialers(ctx, dialersOptions); err != nil {
+		return nil, err
+	}
+	if err := kubeCluster.TunnelHosts(ctx, flags); err != nil {
+		return nil, err
+	}
+	for _, host := range hosts.GetUniqueHostList(kubeCluster.EtcdHosts, kubeCluster.ControlPlaneHosts, kubeCluster.WorkerHosts) {
+		certNames := pki.GetNodeCertificateNames(kubeCluster.RancherKubernetesEngineConfig, host.Address)
+		nodeCerts, err := pki.FetchDeployedCertificates(ctx, host, certNames, kubeCluster.SystemImages.Alpine, kubeCluster.PrivateRegistriesMap)
+		if err != nil {
+			log.Warnf(ctx, "[certificates] Failed to fetch certificates from host [%s]: %v", host.Address, err)
+			continue
+		}
+		if len(nodeCerts) == 0 {
+			log.Warnf(ctx, "[certificates] No certificates found on host [%s]", host.Address)
+			continue
+		}
+		hostChecks := getCertificateChecks(host.Address, nodeCerts, stateCerts)
+		for _, check := range hostChecks {
+			if check.Mismatch {
+				log.Warnf(ctx, "[certificates] Certificate [%s] on host [%s] doesn't match the state file", check.Name, host.Address)
+			}
+		}
+		checks = append(checks, hostChecks...)
+	}
+	return checks, nil
+}
+
+// getCertificateChecks flags certificates that differ from stateCerts when it's set
+func getCertificateChecks(source string, certs, stateCerts map[string]pki.CertificatePKI) []CertificateCheck {
+	checks := []CertificateCheck{}
+	certNames := []string{}
+	for certName := range certs {
+		certNames = append(certNames, certName)
+	}
+	sort.Strings(certNames)
+	for _, certName := range certNames {
+		certificate := certs[certName].Certificate
+		// the service account token is a key, its certificate is the kube-apiserver one
+		if certName == pki.ServiceAccountTokenKeyName || certificate == nil {
+			continue
+		}
+		check := CertificateCheck{
+			Source:       source,
+			Name:         certName,
+			Subject:      certificate.Subject.String(),
+			Issuer:       certificate.Issuer.String(),
+			NotAfter:     certificate.NotAfter,
+			DaysToExpiry: int(math.Floor(time.Until(certificate.NotAfter).Hours() / 24)),
+		}
+		check.SANs = append(check.SANs, certificate.DNSNames...)
+		for _, ip := range certificate.IPAddresses {
+			check.SANs = append(check.SANs, ip.String())
+		}
+		if stateCerts != nil {
+			stateCert := stateCerts[certName].Certificate
+			check.Mismatch = stateCert == nil || !stateCert.Equal(certificate)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func printCertificateChecks(checks []CertificateCheck, output string) error {
+	if output == OutputJSON {
+		return json.NewEncoder(os.Stdout).Encode(checks)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tNAME\tSUBJECT\tSANS\tISSUER\tDAYS TO EXPIRY\tMISMATCH")
+	for _, check := range checks {
+		mismatch := ""
+		if check.Mismatch {
+			mismatch = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", check.Source, check.Name, check.Subject, strings.Join(check.SANs, ","), check.Issuer, check.DaysToExpiry, mismatch)
+	}
+	return w.Flush()
+}
+
 func generateCSRFromCli(ctx *cli.Context) error {
 	clusterFile, filePath, err := resolveClusterFile(ctx)
 	if err != nil {
@@ -117,7 +311,7 @@ func generateCSRFromCli(ctx *cli.Context) error {
 	externalFlags.CertificateDir = ctx.String("cert-dir")
 	externalFlags.CustomCerts = ctx.Bool("custom-certs")
 
-	return GenerateRKECSRs(context.Background(), rkeConfig, externalFlags)
+	return GenerateRKECSRs(newContext(ctx), rkeConfig, externalFlags)
 }
 
 func showRKECertificatesFromCli(ctx *cli.Context) error {
@@ -149,10 +343,11 @@ func rebuildClusterWithRotatedCertificates(ctx context.Context,
 	if err := cluster.SetUpAuthentication(ctx, kubeCluster, nil, clusterState); err != nil {
 		return APIURL, caCrt, clientCert, clientKey, nil, err
 	}
+	cluster.SetUpSecretsEncryption(kubeCluster, clusterState)
 	APIURL = fmt.Sprintf("https://" + kubeCluster.ControlPlaneHosts[0].Address + ":6443")
 	clientCert = string(cert.EncodeCertPEM(kubeCluster.Certificates[pki.KubeAdminCertName].Certificate))
-	clientKey = string(cert.EncodePrivateKeyPEM(kubeCluster.Certificates[pki.KubeAdminCertName].Key))
-	caCrt = string(cert.EncodeCertPEM(kubeCluster.Certificates[pki.CACertName].Certificate))
+	clientKey = string(pki.EncodePrivateKeyPEM(kubeCluster.Certificates[pki.KubeAdminCertName].Key))
+	caCrt = kubeCluster.Certificates[pki.CACertName].CertificatePEM
 
 	if err := kubeCluster.SetUpHosts(ctx, flags); err != nil {
 		return APIURL, caCrt, clientCert, clientKey, nil, err
@@ -162,6 +357,13 @@ func rebuildClusterWithRotatedCertificates(ctx context.Context,
 		return APIURL, caCrt, clientCert, clientKey, nil, err
 	}
 
+	if len(kubeCluster.RotateCertificates.Nodes) > 0 {
+		if err := restartRotatedKubelets(ctx, kubeCluster); err != nil {
+			return APIURL, caCrt, clientCert, clientKey, nil, err
+		}
+		return APIURL, caCrt, clientCert, clientKey, kubeCluster.Certificates, nil
+	}
+
 	// Restarting Kubernetes components
 	servicesMap := make(map[string]bool)
 	for _, component := range kubeCluster.RotateCertificates.Services {
@@ -200,6 +402,24 @@ func rebuildClusterWithRotatedCertificates(ctx context.Context,
 	return APIURL, caCrt, clientCert, clientKey, kubeCluster.Certificates, nil
 }
 
+// restartRotatedKubelets restarts the kubelet of the nodes with rotated kubelet certificates
+func restartRotatedKubelets(ctx context.Context, kubeCluster *cluster.Cluster) error {
+	nodesMap := make(map[string]bool)
+	for _, address := range kubeCluster.RotateCertificates.Nodes {
+		nodesMap[address] = true
+	}
+	allHosts := hosts.GetUniqueHostList(kubeCluster.EtcdHosts, kubeCluster.ControlPlaneHosts, kubeCluster.WorkerHosts)
+	for _, host := range allHosts {
+		if !nodesMap[host.Address] {
+			continue
+		}
+		if err := services.RestartKubelet(ctx, host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func rotateRKECertificates(ctx context.Context, kubeCluster *cluster.Cluster, flags cluster.ExternalFlags, rkeFullState *cluster.FullState) (*cluster.FullState, error) {
 	log.Infof(ctx, "Rotating Kubernetes cluster certificates")
 	currentCluster, err := kubeCluster.GetClusterState(ctx, rkeFullState)
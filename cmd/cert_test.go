@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/rancher/rke/pki"
+)
+
+func newTestCertificate(commonName string, notAfter time.Time) pki.CertificatePKI {
+	return pki.CertificatePKI{Certificate: &x509.Certificate{
+		Raw:      []byte(commonName + notAfter.String()),
+		Subject:  pkix.Name{CommonName: commonName},
+		NotAfter: notAfter,
+	}}
+}
+
+func TestCertificateChecksExpiry(t *testing.T) {
+	now := time.Now()
+	kubeletCertName := pki.GetKubeletCrtName("2.2.2.2")
+	stateCerts := map[string]pki.CertificatePKI{
+		pki.CACertName:        newTestCertificate("kube-ca", now.Add(10*365*24*time.Hour)),
+		pki.KubeProxyCertName: newTestCertificate("system:kube-proxy", now.Add(-24*time.Hour)),
+		kubeletCertName:       newTestCertificate("system:node:worker", now.Add(10*24*time.Hour)),
+		pki.KubeAPICertName:   newTestCertificate("kube-apiserver", now.Add(365*24*time.Hour)),
+	}
+	// the certificates deployed on the worker node, the kubelet one is older than the state file's
+	workerCerts := map[string]pki.CertificatePKI{
+		pki.CACertName:        stateCerts[pki.CACertName],
+		pki.KubeProxyCertName: stateCerts[pki.KubeProxyCertName],
+		kubeletCertName:       newTestCertificate("system:node:worker", now.Add(2*24*time.Hour)),
+	}
+	checks := append(getCertificateChecks(stateCertificatesSource, stateCerts, nil), getCertificateChecks("2.2.2.2", workerCerts, stateCerts)...)
+
+	tests := []struct {
+		threshold time.Duration
+		expected  []string
+	}{
+		{threshold: 0, expected: []string{"state/kube-proxy", "2.2.2.2/kube-proxy"}},
+		{threshold: 7 * 24 * time.Hour, expected: []string{"state/kube-proxy", "2.2.2.2/" + kubeletCertName, "2.2.2.2/kube-proxy"}},
+		{threshold: 30 * 24 * time.Hour, expected: []string{"state/" + kubeletCertName, "state/kube-proxy", "2.2.2.2/" + kubeletCertName, "2.2.2.2/kube-proxy"}},
+	}
+	for _, test := range tests {
+		expiring := []string{}
+		for _, check := range getExpiringChecks(checks, test.threshold) {
+			expiring = append(expiring, check.Source+"/"+check.Name)
+		}
+		if len(expiring) != len(test.expected) {
+			t.Fatalf("Expected %v to expire within [%s], got %v", test.expected, test.threshold, expiring)
+		}
+		for i := range expiring {
+			if expiring[i] != test.expected[i] {
+				t.Fatalf("Expected %v to expire within [%s], got %v", test.expected, test.threshold, expiring)
+			}
+		}
+	}
+
+	for _, check := range checks {
+		if check.Source == "2.2.2.2" && check.Mismatch != (check.Name == kubeletCertName) {
+			t.Fatalf("Expected only the kubelet certificate of the worker node to mismatch the state file, got %+v", check)
+		}
+		if check.Name == pki.KubeProxyCertName && check.DaysToExpiry >= 0 {
+			t.Fatalf("Expected an expired certificate to have negative days to expiry, got %+v", check)
+		}
+	}
+}
@@ -18,6 +18,11 @@ import (
 	"github.com/urfave/cli"
 )
 
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
 var commonFlags = []cli.Flag{
 	cli.BoolFlag{
 		Name:  "ssh-agent-auth",
@@ -29,6 +34,20 @@ var commonFlags = []cli.Flag{
 	},
 }
 
+// SSHKeyFlags are global flags, they apply to every command that connects to the nodes
+var SSHKeyFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "ssh-key-passphrase",
+		Usage:  "Passphrase used to decrypt the encrypted SSH private keys",
+		EnvVar: "RKE_SSH_KEY_PASSPHRASE",
+	},
+	cli.StringFlag{
+		Name:   "ssh-key-passphrase-file",
+		Usage:  "File containing the passphrase used to decrypt the encrypted SSH private keys, takes precedence over the passphrase",
+		EnvVar: "RKE_SSH_KEY_PASSPHRASE_FILE",
+	},
+}
+
 func resolveClusterFile(ctx *cli.Context) (string, string, error) {
 	clusterFile := ctx.String("config")
 	fp, err := filepath.Abs(clusterFile)
@@ -74,7 +93,14 @@ func ClusterInit(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfi
 	if len(flags.CertificateDir) == 0 {
 		flags.CertificateDir = cluster.GetCertificateDirPath(flags.ClusterFilePath, flags.ConfigDir)
 	}
-	rkeFullState, _ := cluster.ReadStateFile(ctx, stateFilePath)
+	rkeFullState, err := cluster.ReadStateFile(ctx, stateFilePath)
+	// a state file that can't be decrypted must not be replaced with a new one
+	if cluster.IsStateEncryptionError(err) {
+		return err
+	}
+	if err := cluster.SetAutoRotateCertificates(ctx, rkeConfig, rkeFullState); err != nil {
+		return err
+	}
 
 	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig, flags)
 	if err != nil {
@@ -210,3 +236,27 @@ func fetchAndUpdateStateFromLegacyCluster(ctx context.Context, kubeCluster *clus
 	fullState.DesiredState.CertificatesBundle = recoveredCerts
 	return fullState.WriteStateFile(ctx, kubeCluster.StateFilePath)
 }
+
+// newContext returns the context for a command, with the logger selected by the global output flag,
+// the state backend and the state key provider if a state key was set
+func newContext(ctx *cli.Context) context.Context {
+	newCtx := cluster.SetStateBackend(context.Background(), newStateBackend(ctx))
+	if ctx.GlobalString("output") == OutputJSON {
+		newCtx = log.SetLogger(newCtx, log.NewJSONLogger(os.Stdout))
+	}
+	if provider := newStateKeyProvider(ctx.GlobalString("state-passphrase"), ctx.GlobalString("state-key-file")); provider != nil {
+		newCtx = cluster.SetStateKeyProvider(newCtx, provider)
+	}
+	hosts.SetSSHKeyPassphrase(ctx.GlobalString("ssh-key-passphrase"), ctx.GlobalString("ssh-key-passphrase-file"))
+	return newCtx
+}
+
+func newStateKeyProvider(passphrase, keyFile string) cluster.StateKeyProvider {
+	if len(keyFile) > 0 {
+		return &cluster.KeyFileKeyProvider{Path: keyFile}
+	}
+	if len(passphrase) > 0 {
+		return &cluster.PassphraseKeyProvider{Passphrase: passphrase}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/log"
+	"github.com/rancher/rke/pki"
+	"github.com/urfave/cli"
+)
+
+func EncryptCommand() cli.Command {
+	return cli.Command{
+		Name:  "encrypt",
+		Usage: "Secrets encryption at rest management for RKE cluster",
+		Subcommands: cli.Commands{
+			cli.Command{
+				Name:   "rotate-key",
+				Usage:  "Rotate the secrets encryption key and re-encrypt all secrets with it",
+				Action: rotateEncryptionKeyFromCli,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "config",
+						Usage:  "Specify an alternate cluster YAML file",
+						Value:  pki.ClusterConfig,
+						EnvVar: "RKE_CONFIG",
+					},
+				},
+			},
+		},
+	}
+}
+
+func rotateEncryptionKeyFromCli(ctx *cli.Context) error {
+	clusterFile, filePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve cluster file: %v", err)
+	}
+
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	if err != nil {
+		return fmt.Errorf("Failed to parse cluster file: %v", err)
+	}
+	rkeConfig, err = setOptionsFromCLI(ctx, rkeConfig)
+	if err != nil {
+		return err
+	}
+	if !cluster.IsSecretsEncryptionEnabled(rkeConfig) {
+		return fmt.Errorf("Secrets encryption is not enabled in services.kube-api.secrets_encryption")
+	}
+	// setting up the flags
+	flags := cluster.GetExternalFlags(false, false, false, "", filePath)
+	unlock, err := lockClusterState(ctx, cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir), "encrypt rotate-key")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := ClusterInit(newContext(ctx), rkeConfig, hosts.DialersOptions{}, flags); err != nil {
+		return err
+	}
+	return RotateEncryptionKey(newContext(ctx), hosts.DialersOptions{}, flags)
+}
+
+// RotateEncryptionKey rotates the secrets encryption key of a cluster that was brought up with secrets encryption enabled
+func RotateEncryptionKey(ctx context.Context, dialersOptions hosts.DialersOptions, flags cluster.ExternalFlags) error {
+	log.Infof(ctx, "Rotating secrets encryption key")
+	clusterState, err := cluster.ReadStateFile(ctx, cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir))
+	if err != nil {
+		return err
+	}
+	if len(clusterState.CurrentState.SecretsEncryptionKeys) == 0 {
+		return fmt.Errorf("Secrets encryption is not deployed yet, run 'rke up' first")
+	}
+
+	kubeCluster, err := cluster.InitClusterObject(ctx, clusterState.DesiredState.RancherKubernetesEngineConfig.DeepCopy(), flags)
+	if err != nil {
+		return err
+	}
+	if err := kubeCluster.SetupDialers(ctx, dialersOptions); err != nil {
+		return err
+	}
+	if err := kubeCluster.TunnelHosts(ctx, flags); err != nil {
+		return err
+	}
+	if err := cluster.SetUpAuthentication(ctx, kubeCluster, nil, clusterState); err != nil {
+		return err
+	}
+	cluster.SetUpSecretsEncryption(kubeCluster, clusterState)
+
+	if err := cluster.RotateSecretsEncryptionKey(ctx, kubeCluster, clusterState); err != nil {
+		return err
+	}
+	log.Infof(ctx, "Finished rotating secrets encryption key successfully")
+	return nil
+}
@@ -3,13 +3,16 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/rancher/rke/cluster"
 	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/log"
 	"github.com/rancher/rke/pki"
+	"github.com/rancher/rke/services"
 	v3 "github.com/rancher/types/apis/management.cattle.io/v3"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -63,7 +66,7 @@ func EtcdCommand() cli.Command {
 
 	return cli.Command{
 		Name:  "etcd",
-		Usage: "etcd snapshot save/restore operations in k8s cluster",
+		Usage: "etcd snapshot save/restore/remove/list operations in k8s cluster",
 		Subcommands: []cli.Command{
 			{
 				Name:   "snapshot-save",
@@ -77,6 +80,25 @@ func EtcdCommand() cli.Command {
 				Flags:  snapshotFlags,
 				Action: RestoreEtcdSnapshotFromCli,
 			},
+			{
+				Name:   "snapshot-remove",
+				Usage:  "Remove snapshot from all etcd hosts and s3",
+				Flags:  snapshotFlags,
+				Action: SnapshotRemoveFromEtcdHostsFromCli,
+			},
+			{
+				Name:   "snapshot-list",
+				Usage:  "List snapshots on all etcd hosts and s3",
+				Flags:  snapshotFlags,
+				Action: SnapshotListFromCli,
+			},
+			{
+				Name:      "snapshot-inspect",
+				Usage:     "Show the etcd revision and key count of a snapshot",
+				ArgsUsage: "<name>",
+				Flags:     snapshotFlags,
+				Action:    SnapshotInspectFromCli,
+			},
 		},
 	}
 }
@@ -120,7 +142,10 @@ func RestoreEtcdSnapshot(
 		return APIURL, caCrt, clientCert, clientKey, nil, err
 	}
 	stateFilePath := cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir)
-	rkeFullState, _ := cluster.ReadStateFile(ctx, stateFilePath)
+	rkeFullState, err := cluster.ReadStateFile(ctx, stateFilePath)
+	if cluster.IsStateEncryptionError(err) {
+		return APIURL, caCrt, clientCert, clientKey, nil, err
+	}
 	if err := checkLegacyCluster(ctx, kubeCluster, rkeFullState, flags); err != nil {
 		return APIURL, caCrt, clientCert, clientKey, nil, err
 	}
@@ -196,7 +221,7 @@ func SnapshotSaveEtcdHostsFromCli(ctx *cli.Context) error {
 	// setting up the flags
 	flags := cluster.GetExternalFlags(false, false, false, "", filePath)
 
-	return SnapshotSaveEtcdHosts(context.Background(), rkeConfig, hosts.DialersOptions{}, flags, etcdSnapshotName)
+	return SnapshotSaveEtcdHosts(newContext(ctx), rkeConfig, hosts.DialersOptions{}, flags, etcdSnapshotName)
 }
 
 func RestoreEtcdSnapshotFromCli(ctx *cli.Context) error {
@@ -220,8 +245,13 @@ func RestoreEtcdSnapshotFromCli(ctx *cli.Context) error {
 	}
 	// setting up the flags
 	flags := cluster.GetExternalFlags(false, false, false, "", filePath)
+	unlock, err := lockClusterState(ctx, cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir), "etcd snapshot-restore")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, _, _, _, _, err = RestoreEtcdSnapshot(context.Background(), rkeConfig, hosts.DialersOptions{}, flags, etcdSnapshotName)
+	_, _, _, _, _, err = RestoreEtcdSnapshot(newContext(ctx), rkeConfig, hosts.DialersOptions{}, flags, etcdSnapshotName)
 	return err
 }
 
@@ -251,3 +281,123 @@ func SnapshotRemoveFromEtcdHosts(
 	log.Infof(ctx, "Finished removing snapshot [%s] from all etcd hosts", snapshotName)
 	return nil
 }
+
+func SnapshotList(
+	ctx context.Context,
+	rkeConfig *v3.RancherKubernetesEngineConfig,
+	dialersOptions hosts.DialersOptions,
+	flags cluster.ExternalFlags) ([]cluster.EtcdSnapshotInfo, int, error) {
+
+	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig, flags)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := kubeCluster.SetupDialers(ctx, dialersOptions); err != nil {
+		return nil, 0, err
+	}
+
+	if err := kubeCluster.TunnelHosts(ctx, flags); err != nil {
+		return nil, 0, err
+	}
+
+	snapshots, err := kubeCluster.ListEtcdSnapshots(ctx)
+	return snapshots, len(kubeCluster.EtcdHosts), err
+}
+
+func SnapshotInspect(
+	ctx context.Context,
+	rkeConfig *v3.RancherKubernetesEngineConfig,
+	dialersOptions hosts.DialersOptions,
+	flags cluster.ExternalFlags, snapshotName string) (services.EtcdSnapshotStatus, error) {
+
+	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig, flags)
+	if err != nil {
+		return services.EtcdSnapshotStatus{}, err
+	}
+	if err := kubeCluster.SetupDialers(ctx, dialersOptions); err != nil {
+		return services.EtcdSnapshotStatus{}, err
+	}
+
+	if err := kubeCluster.TunnelHosts(ctx, flags); err != nil {
+		return services.EtcdSnapshotStatus{}, err
+	}
+
+	return kubeCluster.InspectEtcdSnapshot(ctx, snapshotName)
+}
+
+func SnapshotRemoveFromEtcdHostsFromCli(ctx *cli.Context) error {
+	rkeConfig, flags, err := getEtcdCommandConfig(ctx)
+	if err != nil {
+		return err
+	}
+	etcdSnapshotName := ctx.String("name")
+	if etcdSnapshotName == "" {
+		return fmt.Errorf("you must specify the snapshot name to remove")
+	}
+
+	return SnapshotRemoveFromEtcdHosts(newContext(ctx), rkeConfig, hosts.DialersOptions{}, flags, etcdSnapshotName)
+}
+
+func SnapshotListFromCli(ctx *cli.Context) error {
+	rkeConfig, flags, err := getEtcdCommandConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	snapshots, etcdHostCount, err := SnapshotList(newContext(ctx), rkeConfig, hosts.DialersOptions{}, flags)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSIZE\tTIMESTAMP\tHOSTS\tS3\tCHECKSUM")
+	for _, snapshot := range snapshots {
+		checksumStatus := "mismatch"
+		if len(snapshot.Checksums) == 0 {
+			checksumStatus = "-"
+		} else if snapshot.ChecksumMatch(etcdHostCount) {
+			checksumStatus = "match"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d/%d\t%t\t%s\n", snapshot.Name, snapshot.Size, snapshot.ModTime.Format(time.RFC3339), len(snapshot.Checksums), etcdHostCount, snapshot.InS3, checksumStatus)
+	}
+	return w.Flush()
+}
+
+func SnapshotInspectFromCli(ctx *cli.Context) error {
+	rkeConfig, flags, err := getEtcdCommandConfig(ctx)
+	if err != nil {
+		return err
+	}
+	etcdSnapshotName := ctx.Args().First()
+	if etcdSnapshotName == "" {
+		etcdSnapshotName = ctx.String("name")
+	}
+	if etcdSnapshotName == "" {
+		return fmt.Errorf("you must specify the snapshot name to inspect")
+	}
+
+	snapshotStatus, err := SnapshotInspect(newContext(ctx), rkeConfig, hosts.DialersOptions{}, flags, etcdSnapshotName)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Snapshot: %s\nRevision: %d\nTotal keys: %d\nTotal size: %d\nHash: %x\n", etcdSnapshotName, snapshotStatus.Revision, snapshotStatus.TotalKey, snapshotStatus.TotalSize, snapshotStatus.Hash)
+	return nil
+}
+
+func getEtcdCommandConfig(ctx *cli.Context) (*v3.RancherKubernetesEngineConfig, cluster.ExternalFlags, error) {
+	clusterFile, filePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return nil, cluster.ExternalFlags{}, fmt.Errorf("failed to resolve cluster file: %v", err)
+	}
+
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	if err != nil {
+		return nil, cluster.ExternalFlags{}, fmt.Errorf("failed to parse cluster file: %v", err)
+	}
+
+	rkeConfig, err = setOptionsFromCLI(ctx, rkeConfig)
+	if err != nil {
+		return nil, cluster.ExternalFlags{}, err
+	}
+	// setting up the flags
+	return rkeConfig, cluster.GetExternalFlags(false, false, false, "", filePath), nil
+}
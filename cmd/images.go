@@ -0,0 +1,472 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/docker"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/log"
+	"github.com/rancher/rke/pki"
+	"github.com/rancher/rke/services"
+	"github.com/rancher/rke/simulate"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/urfave/cli"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	DefaultImageBundle = "rke-images.tar.gz"
+
+	imageBundleManifestName = "manifest.json"
+	imageBundlePlane        = "images"
+	localImageHost          = "local"
+)
+
+// etcdSystemImages and workerSystemImages are the system images only needed on the etcd and worker nodes,
+// the other system images run on all the nodes
+var (
+	etcdSystemImages   = []string{"Etcd"}
+	workerSystemImages = []string{"KubeDNS", "DNSmasq", "KubeDNSSidecar", "KubeDNSAutoscaler", "CoreDNS", "CoreDNSAutoscaler", "Ingress", "IngressBackend", "MetricsServer"}
+)
+
+// imageBundleManifest lists the image archives of a bundle, each archive holds the images of the nodes with its roles
+type imageBundleManifest struct {
+	KubernetesVersion string         `json:"kubernetesVersion,omitempty"`
+	Archives          []imageArchive `json:"archives"`
+}
+
+type imageArchive struct {
+	Name   string   `json:"name"`
+	Roles  []string `json:"roles"`
+	Images []string `json:"images"`
+}
+
+func ImagesCommand() cli.Command {
+	return cli.Command{
+		Name:  "images",
+		Usage: "Save and load the cluster images for air-gapped installs",
+		Subcommands: cli.Commands{
+			cli.Command{
+				Name:   "save",
+				Usage:  "Pull the cluster images and save them in a compressed bundle",
+				Action: imagesSaveFromCli,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "version",
+						Usage: "Save the default system images of a specific k8s version",
+					},
+					cli.StringFlag{
+						Name:  "config",
+						Usage: "Save the system images and user addon images of a cluster YAML file instead of the default system images",
+					},
+					cli.StringSliceFlag{
+						Name:  "image",
+						Usage: "Additional image to save in the bundle, can be repeated",
+					},
+					cli.StringFlag{
+						Name:  "bundle,o",
+						Usage: "Path of the image bundle",
+						Value: DefaultImageBundle,
+					},
+				},
+			},
+			cli.Command{
+				Name:      "load",
+				Usage:     "Load an image bundle on the cluster nodes",
+				ArgsUsage: "BUNDLE",
+				Action:    imagesLoadFromCli,
+				Flags: append([]cli.Flag{
+					cli.StringFlag{
+						Name:   "config",
+						Usage:  "Specify an alternate cluster YAML file",
+						Value:  pki.ClusterConfig,
+						EnvVar: "RKE_CONFIG",
+					},
+					cli.BoolFlag{
+						Name:  "by-role",
+						Usage: "Only load the images needed by the roles of each node",
+					},
+					simulateFlag,
+				}, commonFlags...),
+			},
+		},
+	}
+}
+
+func imagesSaveFromCli(ctx *cli.Context) error {
+	var manifest imageBundleManifest
+	prsMap := map[string]v3.PrivateRegistry{}
+	if len(ctx.String("config")) > 0 {
+		clusterFile, filePath, err := resolveClusterFile(ctx)
+		if err != nil {
+			return fmt.Errorf("Failed to resolve cluster file: %v", err)
+		}
+		rkeConfig, err := cluster.ParseConfig(clusterFile)
+		if err != nil {
+			return fmt.Errorf("Failed to parse cluster file: %v", err)
+		}
+		if len(ctx.String("version")) > 0 {
+			rkeConfig.Version = ctx.String("version")
+		}
+		flags := cluster.GetExternalFlags(false, false, false, "", filePath)
+		kubeCluster, err := cluster.InitClusterObject(newContext(ctx), rkeConfig, flags)
+		if err != nil {
+			return err
+		}
+		addonImages, err := kubeCluster.GetUserAddonImages()
+		if err != nil {
+			return err
+		}
+		manifest = newImageBundleManifest(kubeCluster.Version, kubeCluster.SystemImages, addonImages, ctx.StringSlice("image"))
+		prsMap = kubeCluster.PrivateRegistriesMap
+	} else {
+		version := ctx.String("version")
+		if len(version) == 0 {
+			version = v3.DefaultK8s
+		}
+		if _, ok := v3.K8sBadVersions[version]; ok {
+			return fmt.Errorf("k8s version [%s] is not recommended", version)
+		}
+		systemImages, ok := v3.AllK8sVersions[version]
+		if !ok {
+			return fmt.Errorf("k8s version [%s] is not supported", version)
+		}
+		manifest = newImageBundleManifest(version, systemImages, nil, ctx.StringSlice("image"))
+	}
+	return saveImageBundle(newContext(ctx), manifest, prsMap, ctx.String("bundle"))
+}
+
+// saveImageBundle pulls the images of the manifest with the local Docker daemon and writes them to the bundle
+func saveImageBundle(ctx context.Context, manifest imageBundleManifest, prsMap map[string]v3.PrivateRegistry, bundlePath string) error {
+	dClient, err := client.NewEnvClient()
+	if err != nil {
+		return fmt.Errorf("Can't initiate NewClient: %v", err)
+	}
+	for _, archive := range manifest.Archives {
+		for _, image := range archive.Images {
+			if err := docker.UseLocalOrPull(ctx, dClient, localImageHost, image, imageBundlePlane, prsMap); err != nil {
+				return err
+			}
+		}
+	}
+	bundle, err := os.Create(bundlePath)
+	if err != nil {
+		return fmt.Errorf("Failed to create image bundle: %v", err)
+	}
+	defer bundle.Close()
+	err = writeImageBundle(bundle, manifest, func(archive imageArchive) (io.ReadCloser, error) {
+		log.Infof(ctx, "[%s] Saving images of roles %v", imageBundlePlane, archive.Roles)
+		return dClient.ImageSave(ctx, archive.Images)
+	})
+	if err != nil {
+		os.Remove(bundlePath)
+		return err
+	}
+	log.Infof(ctx, "[%s] Saved image bundle [%s]", imageBundlePlane, bundlePath)
+	return bundle.Close()
+}
+
+// newImageBundleManifest groups the images by the roles of the nodes that need them, the user addons run on
+// the worker nodes and the additional images are loaded on all the nodes
+func newImageBundleManifest(version string, systemImages v3.RKESystemImages, addonImages, extraImages []string) imageBundleManifest {
+	allRoles := []string{services.ControlRole, services.ETCDRole, services.WorkerRole}
+	imageRoles := map[string]map[string]bool{}
+	addImage := func(image string, roles ...string) {
+		if len(image) == 0 {
+			return
+		}
+		if imageRoles[image] == nil {
+			imageRoles[image] = map[string]bool{}
+		}
+		for _, role := range roles {
+			imageRoles[image][role] = true
+		}
+	}
+	imagesReflect := reflect.ValueOf(systemImages)
+	for i := 0; i < imagesReflect.NumField(); i++ {
+		image := imagesReflect.Field(i).Interface().(string)
+		switch name := imagesReflect.Type().Field(i).Name; {
+		case containsString(etcdSystemImages, name):
+			addImage(image, services.ETCDRole)
+		case containsString(workerSystemImages, name):
+			addImage(image, services.WorkerRole)
+		default:
+			addImage(image, allRoles...)
+		}
+	}
+	for _, image := range addonImages {
+		addImage(image, services.WorkerRole)
+	}
+	for _, image := range extraImages {
+		addImage(image, allRoles...)
+	}
+
+	archives := map[string]*imageArchive{}
+	for image, roleSet := range imageRoles {
+		roles := []string{}
+		for role := range roleSet {
+			roles = append(roles, role)
+		}
+		sort.Strings(roles)
+		name := fmt.Sprintf("images-%s.tar", strings.Join(roles, "-"))
+		if archives[name] == nil {
+			archives[name] = &imageArchive{Name: name, Roles: roles}
+		}
+		archives[name].Images = append(archives[name].Images, image)
+	}
+	manifest := imageBundleManifest{KubernetesVersion: version}
+	for _, archive := range archives {
+		sort.Strings(archive.Images)
+		manifest.Archives = append(manifest.Archives, *archive)
+	}
+	sort.Slice(manifest.Archives, func(i, j int) bool {
+		return manifest.Archives[i].Name < manifest.Archives[j].Name
+	})
+	return manifest
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// writeImageBundle writes the manifest and the image archives to a gzipped tar, the archives are buffered
+// in temporary files since the tar headers need their size
+func writeImageBundle(w io.Writer, manifest imageBundleManifest, saveImages func(archive imageArchive) (io.ReadCloser, error)) error {
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to marshal image bundle manifest: %v", err)
+	}
+	if err := tarWriter.WriteHeader(&tar.Header{Name: imageBundleManifestName, Mode: 0644, Size: int64(len(manifestBytes))}); err != nil {
+		return fmt.Errorf("Failed to write image bundle manifest: %v", err)
+	}
+	if _, err := tarWriter.Write(manifestBytes); err != nil {
+		return fmt.Errorf("Failed to write image bundle manifest: %v", err)
+	}
+	for _, archive := range manifest.Archives {
+		if err := writeImageArchive(tarWriter, archive, saveImages); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return fmt.Errorf("Failed to write image bundle: %v", err)
+	}
+	return gzipWriter.Close()
+}
+
+func writeImageArchive(tarWriter *tar.Writer, archive imageArchive, saveImages func(archive imageArchive) (io.ReadCloser, error)) error {
+	saved, err := saveImages(archive)
+	if err != nil {
+		return fmt.Errorf("Failed to save images %v: %v", archive.Images, err)
+	}
+	defer saved.Close()
+	tmpFile, err := ioutil.TempFile("", "rke-images")
+	if err != nil {
+		return fmt.Errorf("Failed to create temporary image archive: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+	size, err := io.Copy(tmpFile, saved)
+	if err != nil {
+		return fmt.Errorf("Failed to save images %v: %v", archive.Images, err)
+	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := tarWriter.WriteHeader(&tar.Header{Name: archive.Name, Mode: 0644, Size: size}); err != nil {
+		return fmt.Errorf("Failed to write image archive [%s]: %v", archive.Name, err)
+	}
+	if _, err := io.Copy(tarWriter, tmpFile); err != nil {
+		return fmt.Errorf("Failed to write image archive [%s]: %v", archive.Name, err)
+	}
+	return nil
+}
+
+// readImageBundle reads the manifest of a bundle and streams each of its image archives to loadArchive, a bundle
+// missing some of the archives of its manifest is truncated and fails
+func readImageBundle(r io.Reader, loadArchive func(archive imageArchive, archiveReader io.Reader) error) (imageBundleManifest, error) {
+	manifest := imageBundleManifest{}
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return manifest, fmt.Errorf("Failed to read image bundle: %v", err)
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
+	header, err := tarReader.Next()
+	if err != nil || header.Name != imageBundleManifestName {
+		return manifest, fmt.Errorf("Failed to read image bundle: %s is missing", imageBundleManifestName)
+	}
+	if err := json.NewDecoder(tarReader).Decode(&manifest); err != nil {
+		return manifest, fmt.Errorf("Failed to read image bundle manifest: %v", err)
+	}
+	archives := map[string]imageArchive{}
+	for _, archive := range manifest.Archives {
+		archives[archive.Name] = archive
+	}
+	seen := map[string]bool{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return manifest, fmt.Errorf("Failed to read image bundle: %v", err)
+		}
+		archive, ok := archives[header.Name]
+		if !ok {
+			return manifest, fmt.Errorf("Image archive [%s] of the bundle is not in its manifest", header.Name)
+		}
+		if seen[header.Name] {
+			return manifest, fmt.Errorf("Image archive [%s] is in the bundle more than once", header.Name)
+		}
+		seen[header.Name] = true
+		if err := loadArchive(archive, tarReader); err != nil {
+			return manifest, err
+		}
+	}
+	for _, archive := range manifest.Archives {
+		if !seen[archive.Name] {
+			return manifest, fmt.Errorf("Failed to read image bundle: image archive [%s] is missing, the bundle is truncated", archive.Name)
+		}
+	}
+	return manifest, nil
+}
+
+func imagesLoadFromCli(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return fmt.Errorf("The image bundle to load is not set")
+	}
+	clusterFile, filePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve cluster file: %v", err)
+	}
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	if err != nil {
+		return fmt.Errorf("Failed to parse cluster file: %v", err)
+	}
+	rkeConfig, err = setOptionsFromCLI(ctx, rkeConfig)
+	if err != nil {
+		return err
+	}
+	flags := cluster.GetExternalFlags(false, false, false, "", filePath)
+	bundlePath, byRole := ctx.Args().First(), ctx.Bool("by-role")
+	if ctx.Bool("simulate") {
+		sim, err := simulate.NewSimulator()
+		if err != nil {
+			return err
+		}
+		defer sim.Close()
+		return runSimulation(newContext(ctx), sim, flags, func(simCtx context.Context, dialersOptions hosts.DialersOptions, simFlags cluster.ExternalFlags) error {
+			return LoadImageBundle(simCtx, rkeConfig, dialersOptions, simFlags, bundlePath, byRole)
+		})
+	}
+	return LoadImageBundle(newContext(ctx), rkeConfig, hosts.DialersOptions{}, flags, bundlePath, byRole)
+}
+
+// LoadImageBundle loads the image archives of the bundle on the nodes through their Docker clients, all the
+// archives are loaded on all the nodes unless byRole is set
+func LoadImageBundle(
+	ctx context.Context,
+	rkeConfig *v3.RancherKubernetesEngineConfig,
+	dialersOptions hosts.DialersOptions,
+	flags cluster.ExternalFlags,
+	bundlePath string,
+	byRole bool) error {
+
+	bundle, err := os.Open(bundlePath)
+	if err != nil {
+		return fmt.Errorf("Failed to open image bundle: %v", err)
+	}
+	defer bundle.Close()
+	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig, flags)
+	if err != nil {
+		return err
+	}
+	if err := kubeCluster.SetupDialers(ctx, dialersOptions); err != nil {
+		return err
+	}
+	if err := kubeCluster.TunnelHosts(ctx, flags); err != nil {
+		return err
+	}
+	uniqueHosts := hosts.GetUniqueHostList(kubeCluster.EtcdHosts, kubeCluster.ControlPlaneHosts, kubeCluster.WorkerHosts)
+	manifest, err := readImageBundle(bundle, func(archive imageArchive, archiveReader io.Reader) error {
+		targetHosts := uniqueHosts
+		if byRole {
+			targetHosts = getImageArchiveHosts(archive, uniqueHosts)
+		}
+		if len(targetHosts) == 0 {
+			log.Infof(ctx, "[%s] No nodes with roles %v, skipping image archive [%s]", imageBundlePlane, archive.Roles, archive.Name)
+			return nil
+		}
+		return loadImageArchive(ctx, archive, archiveReader, targetHosts)
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof(ctx, "[%s] Loaded image bundle [%s] of k8s version [%s]", imageBundlePlane, bundlePath, manifest.KubernetesVersion)
+	return nil
+}
+
+func getImageArchiveHosts(archive imageArchive, uniqueHosts []*hosts.Host) []*hosts.Host {
+	targetHosts := []*hosts.Host{}
+	for _, host := range uniqueHosts {
+		if (host.IsControl && containsString(archive.Roles, services.ControlRole)) ||
+			(host.IsEtcd && containsString(archive.Roles, services.ETCDRole)) ||
+			(host.IsWorker && containsString(archive.Roles, services.WorkerRole)) {
+			targetHosts = append(targetHosts, host)
+		}
+	}
+	return targetHosts
+}
+
+// loadImageArchive streams the archive to all the hosts at once, a failed host stops the load on the others
+func loadImageArchive(ctx context.Context, archive imageArchive, archiveReader io.Reader, targetHosts []*hosts.Host) error {
+	writers := []io.Writer{}
+	pipeWriters := []*io.PipeWriter{}
+	var errgrp errgroup.Group
+	for _, host := range targetHosts {
+		runHost := host
+		pipeReader, pipeWriter := io.Pipe()
+		writers = append(writers, pipeWriter)
+		pipeWriters = append(pipeWriters, pipeWriter)
+		errgrp.Go(func() error {
+			log.Infof(ctx, "[%s] Loading image archive [%s] on host [%s]", imageBundlePlane, archive.Name, runHost.Address)
+			err := docker.LoadImages(ctx, runHost.DClient, runHost.Address, pipeReader)
+			if err != nil {
+				pipeReader.CloseWithError(err)
+				return err
+			}
+			// keep reading until the end of the archive, so the other hosts aren't blocked
+			io.Copy(ioutil.Discard, pipeReader)
+			return nil
+		})
+	}
+	_, copyErr := io.Copy(io.MultiWriter(writers...), archiveReader)
+	for _, pipeWriter := range pipeWriters {
+		pipeWriter.CloseWithError(copyErr)
+	}
+	if err := errgrp.Wait(); err != nil {
+		return err
+	}
+	if copyErr != nil {
+		return fmt.Errorf("Failed to read image archive [%s]: %v", archive.Name, copyErr)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/services"
+	v3 "github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+func TestImageBundleManifestGroupsImagesByRole(t *testing.T) {
+	manifest := newImageBundleManifest("v1.13.5-rancher1-2", v3.RKESystemImages{
+		Etcd:       "rancher/coreos-etcd:v3.2.24",
+		Alpine:     "rancher/rke-tools:v0.1.27",
+		Kubernetes: "rancher/hyperkube:v1.13.5",
+		CoreDNS:    "coredns/coredns:1.2.6",
+		Ingress:    "rancher/nginx-ingress-controller:0.21.0",
+	}, []string{"example/addon:1.0"}, []string{"example/extra:1.0", "rancher/rke-tools:v0.1.27"})
+
+	expected := []imageArchive{
+		{
+			Name:   "images-controlplane-etcd-worker.tar",
+			Roles:  []string{services.ControlRole, services.ETCDRole, services.WorkerRole},
+			Images: []string{"example/extra:1.0", "rancher/hyperkube:v1.13.5", "rancher/rke-tools:v0.1.27"},
+		},
+		{
+			Name:   "images-etcd.tar",
+			Roles:  []string{services.ETCDRole},
+			Images: []string{"rancher/coreos-etcd:v3.2.24"},
+		},
+		{
+			Name:   "images-worker.tar",
+			Roles:  []string{services.WorkerRole},
+			Images: []string{"coredns/coredns:1.2.6", "example/addon:1.0", "rancher/nginx-ingress-controller:0.21.0"},
+		},
+	}
+	if !reflect.DeepEqual(manifest.Archives, expected) {
+		t.Fatalf("Expected image archives %v, got %v", expected, manifest.Archives)
+	}
+}
+
+func TestLoadImageBundleByRole(t *testing.T) {
+	ctx := context.Background()
+	configDir, err := ioutil.TempDir("", "rke-images-test")
+	if err != nil {
+		t.Fatalf("Failed to create config dir: %v", err)
+	}
+	defer os.RemoveAll(configDir)
+	flags := cluster.GetExternalFlags(false, false, false, "", filepath.Join(configDir, "cluster.yml"))
+
+	manifest := newImageBundleManifest("v1.13.5-rancher1-2", v3.RKESystemImages{
+		Etcd:       "rancher/coreos-etcd:v3.2.24",
+		Kubernetes: "rancher/hyperkube:v1.13.5",
+		CoreDNS:    "coredns/coredns:1.2.6",
+	}, nil, nil)
+	bundlePath := filepath.Join(configDir, DefaultImageBundle)
+	bundle, err := os.Create(bundlePath)
+	if err != nil {
+		t.Fatalf("Failed to create image bundle: %v", err)
+	}
+	if err := writeImageBundle(bundle, manifest, newTestImageArchive); err != nil {
+		t.Fatalf("Failed to write image bundle: %v", err)
+	}
+	bundle.Close()
+
+	sim := newTestSimulator(t)
+	defer sim.Close()
+	if err := LoadImageBundle(ctx, parseSimulatedConfig(t, simulatedClusterFile), sim.DialersOptions(), flags, bundlePath, true); err != nil {
+		t.Fatalf("Failed to load image bundle: %v", err)
+	}
+	assertActions(t, sim.Actions(), []string{
+		"[10.0.0.1] load image [rancher/hyperkube:v1.13.5]",
+		"[10.0.0.2] load image [rancher/hyperkube:v1.13.5]",
+		"[10.0.0.1] load image [rancher/coreos-etcd:v3.2.24]",
+		"[10.0.0.2] load image [coredns/coredns:1.2.6]",
+	}, []string{
+		"[10.0.0.2] load image [rancher/coreos-etcd:v3.2.24]",
+		"[10.0.0.1] load image [coredns/coredns:1.2.6]",
+	})
+}
+
+func TestLoadImageBundleSlowLoad(t *testing.T) {
+	ctx := context.Background()
+	configDir, err := ioutil.TempDir("", "rke-images-test")
+	if err != nil {
+		t.Fatalf("Failed to create config dir: %v", err)
+	}
+	defer os.RemoveAll(configDir)
+	flags := cluster.GetExternalFlags(false, false, false, "", filepath.Join(configDir, "cluster.yml"))
+	bundlePath := filepath.Join(configDir, DefaultImageBundle)
+	bundle, err := os.Create(bundlePath)
+	if err != nil {
+		t.Fatalf("Failed to create image bundle: %v", err)
+	}
+	manifest := newImageBundleManifest("v1.13.5-rancher1-2", v3.RKESystemImages{Kubernetes: "rancher/hyperkube:v1.13.5"}, nil, nil)
+	if err := writeImageBundle(bundle, manifest, newTestImageArchive); err != nil {
+		t.Fatalf("Failed to write image bundle: %v", err)
+	}
+	bundle.Close()
+
+	// the load takes longer than the response header timeout of the Docker clients
+	responseHeaderTimeout := hosts.DockerResponseHeaderTimeout
+	defer func() { hosts.DockerResponseHeaderTimeout = responseHeaderTimeout }()
+	hosts.DockerResponseHeaderTimeout = 200 * time.Millisecond
+	sim := newTestSimulator(t)
+	defer sim.Close()
+	sim.SetImageLoadDelay(time.Second)
+	if err := LoadImageBundle(ctx, parseSimulatedConfig(t, simulatedClusterFile), sim.DialersOptions(), flags, bundlePath, false); err != nil {
+		t.Fatalf("Failed to load image bundle slower than the response header timeout: %v", err)
+	}
+	assertActions(t, sim.Actions(), []string{
+		"[10.0.0.1] load image [rancher/hyperkube:v1.13.5]",
+		"[10.0.0.2] load image [rancher/hyperkube:v1.13.5]",
+	}, nil)
+}
+
+func TestReadTruncatedImageBundle(t *testing.T) {
+	manifest := newImageBundleManifest("v1.13.5-rancher1-2", v3.RKESystemImages{
+		Etcd:       "rancher/coreos-etcd:v3.2.24",
+		Kubernetes: "rancher/hyperkube:v1.13.5",
+	}, nil, nil)
+	buf := &bytes.Buffer{}
+	if err := writeImageBundle(buf, manifest, newTestImageArchive); err != nil {
+		t.Fatalf("Failed to write image bundle: %v", err)
+	}
+	noop := func(archive imageArchive, archiveReader io.Reader) error {
+		return nil
+	}
+	if _, err := readImageBundle(bytes.NewReader(buf.Bytes()), noop); err != nil {
+		t.Fatalf("Failed to read image bundle: %v", err)
+	}
+	if _, err := readImageBundle(bytes.NewReader(buf.Bytes()[:buf.Len()/2]), noop); err == nil {
+		t.Fatalf("Expected a bundle cut in the middle to fail")
+	}
+
+	// a bundle that ends cleanly after its first archive
+	truncated := manifest
+	truncated.Archives = manifest.Archives[:1]
+	buf.Reset()
+	if err := writeImageBundle(buf, truncated, newTestImageArchive); err != nil {
+		t.Fatalf("Failed to write image bundle: %v", err)
+	}
+	bundle := rewriteImageBundleManifest(t, buf.Bytes(), manifest)
+	_, err := readImageBundle(bytes.NewReader(bundle), noop)
+	if err == nil || !strings.Contains(err.Error(), manifest.Archives[1].Name) {
+		t.Fatalf("Expected the bundle missing archive [%s] to fail, got: %v", manifest.Archives[1].Name, err)
+	}
+}
+
+// rewriteImageBundleManifest replaces the manifest of a bundle and keeps its archives
+func rewriteImageBundleManifest(t *testing.T, bundle []byte, manifest imageBundleManifest) []byte {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		t.Fatalf("Failed to read image bundle: %v", err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+	buf := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Failed to read image bundle: %v", err)
+		}
+		content, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			t.Fatalf("Failed to read image bundle: %v", err)
+		}
+		if header.Name == imageBundleManifestName {
+			if content, err = json.Marshal(manifest); err != nil {
+				t.Fatalf("Failed to marshal image bundle manifest: %v", err)
+			}
+			header.Size = int64(len(content))
+		}
+		tarWriter.WriteHeader(header)
+		tarWriter.Write(content)
+	}
+	tarWriter.Close()
+	gzipWriter.Close()
+	return buf.Bytes()
+}
+
+// newTestImageArchive returns a docker save archive with only the manifest of the images
+func newTestImageArchive(archive imageArchive) (io.ReadCloser, error) {
+	manifest, err := json.Marshal([]map[string][]string{{"RepoTags": archive.Images}})
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(buf)
+	if err := tarWriter.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(manifest))}); err != nil {
+		return nil, err
+	}
+	tarWriter.Write(manifest)
+	tarWriter.Close()
+	return ioutil.NopCloser(buf), nil
+}
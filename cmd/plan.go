@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/docker"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/log"
+	"github.com/rancher/rke/pki"
+	"github.com/rancher/rke/services"
+	v3 "github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/urfave/cli"
+)
+
+func PlanCommand() cli.Command {
+	planFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "Specify an alternate cluster YAML file",
+			Value:  pki.ClusterConfig,
+			EnvVar: "RKE_CONFIG",
+		},
+		cli.BoolFlag{
+			Name:  "diff",
+			Usage: "Show the container changes rke up would make on each host",
+		},
+	}
+
+	planFlags = append(planFlags, commonFlags...)
+
+	return cli.Command{
+		Name:   "plan",
+		Usage:  "Show the cluster plan without making any changes",
+		Action: clusterPlanFromCli,
+		Flags:  planFlags,
+	}
+}
+
+func ClusterPlan(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfig, dialersOptions hosts.DialersOptions, flags cluster.ExternalFlags) (v3.RKEPlan, error) {
+	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig, flags)
+	if err != nil {
+		return v3.RKEPlan{}, err
+	}
+	if err := kubeCluster.SetupDialers(ctx, dialersOptions); err != nil {
+		return v3.RKEPlan{}, err
+	}
+	if err := kubeCluster.TunnelHosts(ctx, flags); err != nil {
+		return v3.RKEPlan{}, err
+	}
+	hostsInfoMap := make(map[string]types.Info)
+	for _, host := range hosts.GetUniqueHostList(kubeCluster.EtcdHosts, kubeCluster.ControlPlaneHosts, kubeCluster.WorkerHosts) {
+		hostsInfoMap[host.Address] = host.DockerInfo
+	}
+	return cluster.GeneratePlan(ctx, &kubeCluster.RancherKubernetesEngineConfig, hostsInfoMap)
+}
+
+func ClusterPlanDiff(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfig, dialersOptions hosts.DialersOptions, flags cluster.ExternalFlags) (cluster.PlanDiff, error) {
+	log.Infof(ctx, "Comparing cluster plan with running containers")
+	// the state file is optional here, without it every host is treated as new
+	clusterState, err := cluster.ReadStateFile(ctx, cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir))
+	if err != nil {
+		log.Warnf(ctx, "[state] %v, all hosts will be shown as added", err)
+	}
+
+	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig, flags)
+	if err != nil {
+		return cluster.PlanDiff{}, err
+	}
+	if err := kubeCluster.SetupDialers(ctx, dialersOptions); err != nil {
+		return cluster.PlanDiff{}, err
+	}
+	if err := kubeCluster.TunnelHosts(ctx, flags); err != nil {
+		return cluster.PlanDiff{}, err
+	}
+	currentCluster, err := kubeCluster.GetClusterState(ctx, clusterState)
+	if err != nil {
+		return cluster.PlanDiff{}, err
+	}
+	cluster.SetUpSecretsEncryption(kubeCluster, clusterState)
+	return kubeCluster.DiffPlan(ctx, currentCluster)
+}
+
+func clusterPlanFromCli(ctx *cli.Context) error {
+	clusterFile, filePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve cluster file: %v", err)
+	}
+
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	if err != nil {
+		return fmt.Errorf("Failed to parse cluster file: %v", err)
+	}
+
+	rkeConfig, err = setOptionsFromCLI(ctx, rkeConfig)
+	if err != nil {
+		return err
+	}
+	// setting up the flags
+	flags := cluster.GetExternalFlags(false, false, false, "", filePath)
+
+	if !ctx.Bool("diff") {
+		plan, err := ClusterPlan(newContext(ctx), rkeConfig, hosts.DialersOptions{}, flags)
+		if err != nil {
+			return err
+		}
+		planJSON, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return fmt.Errorf("Failed to marshal cluster plan: %v", err)
+		}
+		fmt.Printf("%s\n", planJSON)
+		return nil
+	}
+
+	planDiff, err := ClusterPlanDiff(newContext(ctx), rkeConfig, hosts.DialersOptions{}, flags)
+	if err != nil {
+		return err
+	}
+	printPlanDiff(planDiff)
+	return nil
+}
+
+func printPlanDiff(planDiff cluster.PlanDiff) {
+	for _, role := range []string{services.ETCDRole, services.ControlRole, services.WorkerRole} {
+		if len(planDiff.ToAddHosts[role]) > 0 {
+			fmt.Printf("Hosts to add as [%s]: %s\n", role, strings.Join(planDiff.ToAddHosts[role], ", "))
+		}
+		if len(planDiff.ToDeleteHosts[role]) > 0 {
+			fmt.Printf("Hosts to remove from [%s]: %s\n", role, strings.Join(planDiff.ToDeleteHosts[role], ", "))
+		}
+	}
+	if len(planDiff.InactiveHosts) > 0 {
+		fmt.Printf("Unreachable hosts, skipped: %s\n", strings.Join(planDiff.InactiveHosts, ", "))
+	}
+	for _, node := range planDiff.Nodes {
+		fmt.Printf("\nHost [%s]\n", node.Address)
+		for _, container := range node.Containers {
+			printContainerDiff(container)
+		}
+	}
+}
+
+func printContainerDiff(diff docker.ContainerDiff) {
+	if !diff.Changed {
+		fmt.Printf("  [%s] unchanged\n", diff.Name)
+		return
+	}
+	if !diff.Exists {
+		fmt.Printf("  [%s] will be created\n", diff.Name)
+		fmt.Printf("      image: %s\n", diff.PlannedImage)
+		return
+	}
+	fmt.Printf("  [%s] will be recreated\n", diff.Name)
+	if diff.CurrentImage != diff.PlannedImage {
+		fmt.Printf("      image: %s -> %s\n", diff.CurrentImage, diff.PlannedImage)
+	}
+	printDiffLines("arg", diff.AddedArgs, diff.RemovedArgs)
+	printDiffLines("env", diff.AddedEnv, diff.RemovedEnv)
+	printDiffLines("bind", diff.AddedBinds, diff.RemovedBinds)
+}
+
+func printDiffLines(kind string, added, removed []string) {
+	for _, item := range removed {
+		fmt.Printf("    - %s: %s\n", kind, item)
+	}
+	for _, item := range added {
+		fmt.Printf("    + %s: %s\n", kind, item)
+	}
+}
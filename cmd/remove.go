@@ -12,6 +12,7 @@ import (
 	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/log"
 	"github.com/rancher/rke/pki"
+	"github.com/rancher/rke/simulate"
 	"github.com/rancher/types/apis/management.cattle.io/v3"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -37,6 +38,7 @@ func RemoveCommand() cli.Command {
 			Name:  "dind",
 			Usage: "Remove Kubernetes cluster deployed in dind mode",
 		},
+		simulateFlag,
 	}
 
 	removeFlags = append(removeFlags, commonFlags...)
@@ -87,7 +89,8 @@ func clusterRemoveFromCli(ctx *cli.Context) error {
 	if err != nil {
 		return fmt.Errorf("Failed to resolve cluster file: %v", err)
 	}
-	force := ctx.Bool("force")
+	// nothing is removed in a simulation
+	force := ctx.Bool("force") || ctx.Bool("simulate")
 	if !force {
 		reader := bufio.NewReader(os.Stdin)
 		fmt.Printf("Are you sure you want to remove Kubernetes cluster [y/n]: ")
@@ -115,15 +118,30 @@ func clusterRemoveFromCli(ctx *cli.Context) error {
 
 	// setting up the flags
 	flags := cluster.GetExternalFlags(false, false, false, "", filePath)
+	unlock, err := lockClusterState(ctx, cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir), "remove")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	return ClusterRemove(context.Background(), rkeConfig, hosts.DialersOptions{}, flags)
+	if ctx.Bool("simulate") {
+		sim, err := simulate.NewSimulator()
+		if err != nil {
+			return err
+		}
+		defer sim.Close()
+		return runSimulation(newContext(ctx), sim, flags, func(simCtx context.Context, dialersOptions hosts.DialersOptions, simFlags cluster.ExternalFlags) error {
+			return ClusterRemove(simCtx, rkeConfig, dialersOptions, simFlags)
+		})
+	}
+	return ClusterRemove(newContext(ctx), rkeConfig, hosts.DialersOptions{}, flags)
 }
 
 func clusterRemoveLocal(ctx *cli.Context) error {
 	var rkeConfig *v3.RancherKubernetesEngineConfig
 	clusterFile, filePath, err := resolveClusterFile(ctx)
 	if err != nil {
-		log.Warnf(context.Background(), "Failed to resolve cluster file, using default cluster instead")
+		log.Warnf(newContext(ctx), "Failed to resolve cluster file, using default cluster instead")
 		rkeConfig = cluster.GetLocalRKEConfig()
 	} else {
 		rkeConfig, err = cluster.ParseConfig(clusterFile)
@@ -140,7 +158,7 @@ func clusterRemoveLocal(ctx *cli.Context) error {
 	// setting up the flags
 	flags := cluster.GetExternalFlags(true, false, false, "", filePath)
 
-	return ClusterRemove(context.Background(), rkeConfig, hosts.DialersOptions{}, flags)
+	return ClusterRemove(newContext(ctx), rkeConfig, hosts.DialersOptions{}, flags)
 }
 
 func clusterRemoveDind(ctx *cli.Context) error {
@@ -160,12 +178,12 @@ func clusterRemoveDind(ctx *cli.Context) error {
 	}
 
 	for _, node := range rkeConfig.Nodes {
-		if err = dind.RmoveDindContainer(context.Background(), node.Address); err != nil {
+		if err = dind.RmoveDindContainer(newContext(ctx), node.Address); err != nil {
 			return err
 		}
 	}
 	localKubeConfigPath := pki.GetLocalKubeConfig(filePath, "")
 	// remove the kube config file
-	pki.RemoveAdminConfig(context.Background(), localKubeConfigPath)
+	pki.RemoveAdminConfig(newContext(ctx), localKubeConfigPath)
 	return err
 }
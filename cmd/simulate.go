@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/log"
+	"github.com/rancher/rke/simulate"
+	"github.com/urfave/cli"
+)
+
+var simulateFlag = cli.BoolFlag{
+	Name:  "simulate",
+	Usage: "Run against in-memory simulated hosts and print the resulting actions, no host is contacted",
+}
+
+type simulatedRun func(ctx context.Context, dialersOptions hosts.DialersOptions, flags cluster.ExternalFlags) error
+
+// runSimulation runs a cluster operation against a simulator. The state is copied to a temporary config dir so
+// the real state, kube config and certificates are left untouched. The current cluster state is replayed on the
+// simulated hosts first, so the action log only shows what the operation changes on the existing cluster.
+func runSimulation(ctx context.Context, sim *simulate.Simulator, flags cluster.ExternalFlags, run simulatedRun) error {
+	configDir, err := ioutil.TempDir("", "rke-simulate")
+	if err != nil {
+		return fmt.Errorf("Failed to create simulation config dir: %v", err)
+	}
+	defer os.RemoveAll(configDir)
+	simFlags := flags
+	simFlags.ConfigDir = configDir + "/"
+	if !simFlags.CustomCerts {
+		simFlags.CertificateDir = ""
+	}
+	simCtx := cluster.SetStateBackend(ctx, &cluster.LocalStateBackend{})
+
+	clusterState, err := cluster.ReadStateFile(ctx, cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir))
+	if err == nil && clusterState.CurrentState.RancherKubernetesEngineConfig != nil {
+		log.Infof(ctx, "[simulate] Replaying current cluster state on the simulated hosts")
+		if err := clusterState.WriteStateFile(simCtx, cluster.GetStateFilePath(simFlags.ClusterFilePath, simFlags.ConfigDir)); err != nil {
+			return err
+		}
+		if err := ClusterInit(simCtx, clusterState.CurrentState.RancherKubernetesEngineConfig.DeepCopy(), sim.DialersOptions(), simFlags); err != nil {
+			return fmt.Errorf("Failed to replay current cluster state: %v", err)
+		}
+		if _, _, _, _, _, err := ClusterUp(simCtx, sim.DialersOptions(), simFlags); err != nil {
+			return fmt.Errorf("Failed to replay current cluster state: %v", err)
+		}
+		sim.ResetActions()
+	}
+
+	log.Infof(ctx, "[simulate] Running on the simulated hosts")
+	runErr := run(simCtx, sim.DialersOptions(), simFlags)
+	for _, action := range sim.Actions() {
+		log.Infof(ctx, "[simulate] %s", action)
+	}
+	return runErr
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/simulate"
+	v3 "github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+const simulatedClusterFile = `
+nodes:
+- address: 10.0.0.1
+  user: rancher
+  role: [controlplane, etcd]
+- address: 10.0.0.2
+  user: rancher
+  role: [worker]
+`
+
+func TestSimulatedClusterUpAndRemove(t *testing.T) {
+	ctx := context.Background()
+	configDir, err := ioutil.TempDir("", "rke-simulate-test")
+	if err != nil {
+		t.Fatalf("Failed to create config dir: %v", err)
+	}
+	defer os.RemoveAll(configDir)
+	flags := cluster.GetExternalFlags(false, false, false, "", filepath.Join(configDir, "cluster.yml"))
+
+	// deploy the existing cluster on a first simulator, it writes the state the simulations start from
+	sim := newTestSimulator(t)
+	defer sim.Close()
+	if err := ClusterInit(ctx, parseSimulatedConfig(t, simulatedClusterFile), sim.DialersOptions(), flags); err != nil {
+		t.Fatalf("Failed to init cluster: %v", err)
+	}
+	if _, _, _, _, _, err := ClusterUp(ctx, sim.DialersOptions(), flags); err != nil {
+		t.Fatalf("Failed to bring up simulated cluster: %v", err)
+	}
+	assertActions(t, sim.Actions(), []string{
+		"[10.0.0.1] create container [etcd]",
+		"[10.0.0.1] create container [kube-apiserver]",
+		"[10.0.0.2] create container [kubelet]",
+		"[k8s] register node [10.0.0.2]",
+		"[k8s] apply DaemonSet [kube-system/canal] from addon [rke-network-plugin]",
+	}, nil)
+	stateFile, err := ioutil.ReadFile(cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir))
+	if err != nil {
+		t.Fatalf("Failed to read state file: %v", err)
+	}
+
+	// adding a worker only touches the new node
+	upSim := newTestSimulator(t)
+	defer upSim.Close()
+	err = runSimulation(ctx, upSim, flags, func(simCtx context.Context, dialersOptions hosts.DialersOptions, simFlags cluster.ExternalFlags) error {
+		rkeConfig := parseSimulatedConfig(t, simulatedClusterFile+"- address: 10.0.0.3\n  user: rancher\n  role: [worker]\n")
+		if err := ClusterInit(simCtx, rkeConfig, dialersOptions, simFlags); err != nil {
+			return err
+		}
+		_, _, _, _, _, err := ClusterUp(simCtx, dialersOptions, simFlags)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to simulate adding a worker: %v", err)
+	}
+	assertActions(t, upSim.Actions(), []string{
+		"[10.0.0.3] create container [kubelet]",
+		"[k8s] register node [10.0.0.3]",
+	}, []string{
+		"[10.0.0.1] create container [etcd]",
+		"[10.0.0.1] create container [kube-apiserver]",
+		"[10.0.0.2] create container [kubelet]",
+	})
+
+	removeSim := newTestSimulator(t)
+	defer removeSim.Close()
+	err = runSimulation(ctx, removeSim, flags, func(simCtx context.Context, dialersOptions hosts.DialersOptions, simFlags cluster.ExternalFlags) error {
+		return ClusterRemove(simCtx, parseSimulatedConfig(t, simulatedClusterFile), dialersOptions, simFlags)
+	})
+	if err != nil {
+		t.Fatalf("Failed to simulate cluster removal: %v", err)
+	}
+	assertActions(t, removeSim.Actions(), []string{
+		"[10.0.0.1] remove container [etcd]",
+		"[10.0.0.1] remove container [kube-apiserver]",
+		"[10.0.0.2] remove container [kubelet]",
+	}, nil)
+
+	// the simulations don't change the real state
+	newStateFile, err := ioutil.ReadFile(cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir))
+	if err != nil || string(newStateFile) != string(stateFile) {
+		t.Fatalf("Expected the state file to be left untouched by the simulations")
+	}
+}
+
+func parseSimulatedConfig(t *testing.T, clusterFile string) *v3.RancherKubernetesEngineConfig {
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	if err != nil {
+		t.Fatalf("Failed to parse cluster file: %v", err)
+	}
+	return rkeConfig
+}
+
+func newTestSimulator(t *testing.T) *simulate.Simulator {
+	sim, err := simulate.NewSimulator()
+	if err != nil {
+		t.Fatalf("Failed to create simulator: %v", err)
+	}
+	return sim
+}
+
+// assertActions checks the actions include the expected ones, matched by prefix, and none of the unexpected ones
+func assertActions(t *testing.T, actions, expected, unexpected []string) {
+	hasAction := func(prefix string) bool {
+		for _, action := range actions {
+			if strings.HasPrefix(action, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, prefix := range expected {
+		if !hasAction(prefix) {
+			t.Fatalf("Expected action [%s] in %v", prefix, actions)
+		}
+	}
+	for _, prefix := range unexpected {
+		if hasAction(prefix) {
+			t.Fatalf("Unexpected action [%s] in %v", prefix, actions)
+		}
+	}
+}
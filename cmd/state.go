@@ -0,0 +1,265 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/log"
+	"github.com/rancher/rke/pki"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// StateFlags are global flags, they apply to every command that reads or writes the cluster state
+var StateFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "state-passphrase",
+		Usage:  "Passphrase used to encrypt and decrypt the cluster state file",
+		EnvVar: "RKE_STATE_PASSPHRASE",
+	},
+	cli.StringFlag{
+		Name:   "state-key-file",
+		Usage:  "Key file used to encrypt and decrypt the cluster state file, takes precedence over the passphrase",
+		EnvVar: "RKE_STATE_KEY_FILE",
+	},
+	cli.StringFlag{
+		Name:   "state-backend",
+		Usage:  fmt.Sprintf("Where to store the cluster state, one of %s, %s or %s", cluster.LocalStateBackendName, cluster.S3StateBackendName, cluster.KubernetesStateBackendName),
+		Value:  cluster.LocalStateBackendName,
+		EnvVar: "RKE_STATE_BACKEND",
+	},
+	cli.DurationFlag{
+		Name:   "state-lock-timeout",
+		Usage:  "How long to wait for the cluster state lock held by another run",
+		EnvVar: "RKE_STATE_LOCK_TIMEOUT",
+	},
+	cli.StringFlag{
+		Name:   "state-s3-endpoint",
+		Usage:  "Specify the s3 endpoint url of the state backend",
+		Value:  s3Endpoint,
+		EnvVar: "RKE_STATE_S3_ENDPOINT",
+	},
+	cli.StringFlag{
+		Name:   "state-s3-endpoint-ca",
+		Usage:  "Specify a custom CA cert to connect to the s3 state backend",
+		EnvVar: "RKE_STATE_S3_ENDPOINT_CA",
+	},
+	cli.StringFlag{
+		Name:   "state-s3-bucket-name",
+		Usage:  "Specify the s3 bucket name of the state backend",
+		EnvVar: "RKE_STATE_S3_BUCKET_NAME",
+	},
+	cli.StringFlag{
+		Name:   "state-s3-folder",
+		Usage:  "Specify the folder of the cluster in the s3 bucket of the state backend, required and unique to each cluster",
+		EnvVar: "RKE_STATE_S3_FOLDER",
+	},
+	cli.StringFlag{
+		Name:   "state-s3-region",
+		Usage:  "Specify the s3 bucket location of the state backend (optional)",
+		EnvVar: "RKE_STATE_S3_REGION",
+	},
+	cli.StringFlag{
+		Name:   "state-s3-access-key",
+		Usage:  "Specify s3 accessKey of the state backend",
+		EnvVar: "RKE_STATE_S3_ACCESS_KEY",
+	},
+	cli.StringFlag{
+		Name:   "state-s3-secret-key",
+		Usage:  "Specify s3 secretKey of the state backend",
+		EnvVar: "RKE_STATE_S3_SECRET_KEY",
+	},
+	cli.StringFlag{
+		Name:   "state-kubeconfig",
+		Usage:  "Kube config used by the kubernetes state backend, defaults to the one written next to the cluster file",
+		EnvVar: "RKE_STATE_KUBECONFIG",
+	},
+}
+
+func StateCommand() cli.Command {
+	stateFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "Specify an alternate cluster YAML file",
+			Value:  pki.ClusterConfig,
+			EnvVar: "RKE_CONFIG",
+		},
+	}
+	rekeyFlags := append(stateFlags,
+		cli.StringFlag{
+			Name:   "new-passphrase",
+			Usage:  "New passphrase for the state file",
+			EnvVar: "RKE_STATE_NEW_PASSPHRASE",
+		},
+		cli.StringFlag{
+			Name:   "new-key-file",
+			Usage:  "New key file for the state file, takes precedence over the new passphrase",
+			EnvVar: "RKE_STATE_NEW_KEY_FILE",
+		},
+	)
+	return cli.Command{
+		Name:  "state",
+		Usage: "Cluster state file encryption and locking",
+		Subcommands: cli.Commands{
+			cli.Command{
+				Name:   "encrypt",
+				Usage:  "Encrypt the state file with the key set by --state-passphrase or --state-key-file",
+				Action: stateEncryptFromCli,
+				Flags:  stateFlags,
+			},
+			cli.Command{
+				Name:   "decrypt",
+				Usage:  "Decrypt the state file with the key set by --state-passphrase or --state-key-file",
+				Action: stateDecryptFromCli,
+				Flags:  stateFlags,
+			},
+			cli.Command{
+				Name:   "rekey",
+				Usage:  "Encrypt the state file with a new key",
+				Action: stateRekeyFromCli,
+				Flags:  rekeyFlags,
+			},
+			cli.Command{
+				Name:   "force-unlock",
+				Usage:  "Remove the cluster state lock left behind by an interrupted run",
+				Action: stateForceUnlockFromCli,
+				Flags:  stateFlags,
+			},
+		},
+	}
+}
+
+func StateEncrypt(ctx context.Context, statePath string) error {
+	buf, err := cluster.GetStateBackend(ctx).ReadState(ctx, statePath)
+	if err != nil {
+		return err
+	}
+	if cluster.IsStateEncrypted(buf) {
+		return fmt.Errorf("State file [%s] is already encrypted, use rekey to change its key", statePath)
+	}
+	return StateRekey(cluster.SetStateKeyProvider(ctx, nil), statePath, cluster.GetStateKeyProvider(ctx))
+}
+
+func StateDecrypt(ctx context.Context, statePath string) error {
+	return StateRekey(ctx, statePath, nil)
+}
+
+// StateRekey reads the state file with the key provider of the context and writes it back with newProvider,
+// a nil newProvider writes the state file unencrypted
+func StateRekey(ctx context.Context, statePath string, newProvider cluster.StateKeyProvider) error {
+	fullState, err := cluster.ReadStateFile(ctx, statePath)
+	if err != nil {
+		return err
+	}
+	if newProvider == nil {
+		log.Infof(ctx, "Writing decrypted state file [%s]", statePath)
+	} else {
+		log.Infof(ctx, "Encrypting state file [%s] with [%s] key provider", statePath, newProvider.Name())
+	}
+	return fullState.WriteStateFile(cluster.SetStateKeyProvider(ctx, newProvider), statePath)
+}
+
+func stateEncryptFromCli(ctx *cli.Context) error {
+	statePath, err := getStateFilePathFromCli(ctx)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockClusterState(ctx, statePath, "state encrypt")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	newCtx := newContext(ctx)
+	if cluster.GetStateKeyProvider(newCtx) == nil {
+		return fmt.Errorf("A state key is required, set --state-passphrase or --state-key-file")
+	}
+	return StateEncrypt(newCtx, statePath)
+}
+
+func stateDecryptFromCli(ctx *cli.Context) error {
+	statePath, err := getStateFilePathFromCli(ctx)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockClusterState(ctx, statePath, "state decrypt")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return StateDecrypt(newContext(ctx), statePath)
+}
+
+func stateRekeyFromCli(ctx *cli.Context) error {
+	statePath, err := getStateFilePathFromCli(ctx)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockClusterState(ctx, statePath, "state rekey")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	newProvider := newStateKeyProvider(ctx.String("new-passphrase"), ctx.String("new-key-file"))
+	if newProvider == nil {
+		return fmt.Errorf("A new state key is required, set --new-passphrase or --new-key-file")
+	}
+	return StateRekey(newContext(ctx), statePath, newProvider)
+}
+
+func StateForceUnlock(ctx context.Context, statePath string) error {
+	backend := cluster.GetStateBackend(ctx)
+	if err := backend.ForceUnlock(ctx, statePath); err != nil {
+		return fmt.Errorf("Failed to remove cluster state lock at [%s]: %v", backend.Location(statePath), err)
+	}
+	log.Infof(ctx, "Removed cluster state lock at [%s]", backend.Location(statePath))
+	return nil
+}
+
+func stateForceUnlockFromCli(ctx *cli.Context) error {
+	statePath, err := getStateFilePathFromCli(ctx)
+	if err != nil {
+		return err
+	}
+	return StateForceUnlock(newContext(ctx), statePath)
+}
+
+// lockClusterState refuses to start an operation while another run holds the state lock, the returned func releases it
+func lockClusterState(ctx *cli.Context, statePath, operation string) (func(), error) {
+	return cluster.LockState(newContext(ctx), statePath, operation, ctx.GlobalDuration("state-lock-timeout"))
+}
+
+func newStateBackend(ctx *cli.Context) cluster.StateBackend {
+	switch ctx.GlobalString("state-backend") {
+	case cluster.S3StateBackendName:
+		s3Config := &v3.S3BackupConfig{
+			Endpoint:   ctx.GlobalString("state-s3-endpoint"),
+			BucketName: ctx.GlobalString("state-s3-bucket-name"),
+			Region:     ctx.GlobalString("state-s3-region"),
+			AccessKey:  ctx.GlobalString("state-s3-access-key"),
+			SecretKey:  ctx.GlobalString("state-s3-secret-key"),
+		}
+		if endpointCA := ctx.GlobalString("state-s3-endpoint-ca"); len(endpointCA) != 0 {
+			caStr, err := pki.ReadCertToStr(endpointCA)
+			if err != nil {
+				logrus.Warnf("Failed to read state-s3-endpoint-ca [%s]: %v", endpointCA, err)
+			} else {
+				s3Config.EndpointCA = caStr
+			}
+		}
+		return &cluster.S3StateBackend{Config: s3Config, Folder: ctx.GlobalString("state-s3-folder")}
+	case cluster.KubernetesStateBackendName:
+		return &cluster.KubernetesStateBackend{KubeConfigPath: ctx.GlobalString("state-kubeconfig")}
+	default:
+		return &cluster.LocalStateBackend{}
+	}
+}
+
+func getStateFilePathFromCli(ctx *cli.Context) (string, error) {
+	_, filePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve cluster file: %v", err)
+	}
+	return cluster.GetStateFilePath(filePath, ""), nil
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+func TestStateRekey(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "rke-state")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(stateDir)
+	statePath := filepath.Join(stateDir, "cluster.rkestate")
+	oldProvider := &cluster.PassphraseKeyProvider{Passphrase: "old passphrase"}
+	newProvider := &cluster.PassphraseKeyProvider{Passphrase: "new passphrase"}
+	fullState := &cluster.FullState{}
+	fullState.DesiredState.RancherKubernetesEngineConfig = &v3.RancherKubernetesEngineConfig{Version: "v1.11.1-rancher1-1"}
+
+	ctx := context.Background()
+	if err := fullState.WriteStateFile(cluster.SetStateKeyProvider(ctx, oldProvider), statePath); err != nil {
+		t.Fatalf("Failed to write state file: %v", err)
+	}
+	if err := StateRekey(cluster.SetStateKeyProvider(ctx, oldProvider), statePath, newProvider); err != nil {
+		t.Fatalf("Failed to rekey state file: %v", err)
+	}
+	if _, err := cluster.ReadStateFile(cluster.SetStateKeyProvider(ctx, oldProvider), statePath); !cluster.IsStateEncryptionError(err) {
+		t.Fatalf("Expected the old passphrase to be rejected after rekey, got [%v]", err)
+	}
+	rekeyedState, err := cluster.ReadStateFile(cluster.SetStateKeyProvider(ctx, newProvider), statePath)
+	if err != nil {
+		t.Fatalf("Failed to read rekeyed state file: %v", err)
+	}
+	if rekeyedState.DesiredState.RancherKubernetesEngineConfig.Version != fullState.DesiredState.RancherKubernetesEngineConfig.Version {
+		t.Fatalf("Expected the rekeyed state to keep its content, got %+v", rekeyedState.DesiredState)
+	}
+
+	if err := StateDecrypt(cluster.SetStateKeyProvider(ctx, newProvider), statePath); err != nil {
+		t.Fatalf("Failed to decrypt state file: %v", err)
+	}
+	buf, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("Failed to read state file: %v", err)
+	}
+	if cluster.IsStateEncrypted(buf) {
+		t.Fatalf("Expected the state file to be decrypted")
+	}
+	if _, err := cluster.ReadStateFile(ctx, statePath); err != nil {
+		t.Fatalf("Failed to read decrypted state file: %v", err)
+	}
+}
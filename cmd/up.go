@@ -11,6 +11,7 @@ import (
 	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/log"
 	"github.com/rancher/rke/pki"
+	"github.com/rancher/rke/simulate"
 	v3 "github.com/rancher/types/apis/management.cattle.io/v3"
 	"github.com/urfave/cli"
 	"k8s.io/client-go/util/cert"
@@ -63,6 +64,7 @@ func UpCommand() cli.Command {
 			Name:  "custom-certs",
 			Usage: "Use custom certificates from a cert dir",
 		},
+		simulateFlag,
 	}
 
 	upFlags = append(upFlags, commonFlags...)
@@ -117,12 +119,13 @@ func ClusterUp(ctx context.Context, dialersOptions hosts.DialersOptions, flags c
 	if err != nil {
 		return APIURL, caCrt, clientCert, clientKey, nil, err
 	}
+	cluster.SetUpSecretsEncryption(kubeCluster, clusterState)
 	if len(kubeCluster.ControlPlaneHosts) > 0 {
 		APIURL = fmt.Sprintf("https://" + kubeCluster.ControlPlaneHosts[0].Address + ":6443")
 	}
 	clientCert = string(cert.EncodeCertPEM(kubeCluster.Certificates[pki.KubeAdminCertName].Certificate))
-	clientKey = string(cert.EncodePrivateKeyPEM(kubeCluster.Certificates[pki.KubeAdminCertName].Key))
-	caCrt = string(cert.EncodeCertPEM(kubeCluster.Certificates[pki.CACertName].Certificate))
+	clientKey = string(pki.EncodePrivateKeyPEM(kubeCluster.Certificates[pki.KubeAdminCertName].Key))
+	caCrt = kubeCluster.Certificates[pki.CACertName].CertificatePEM
 
 	// moved deploying certs before reconcile to remove all unneeded certs generation from reconcile
 	err = kubeCluster.SetUpHosts(ctx, flags)
@@ -232,14 +235,33 @@ func clusterUpFromCli(ctx *cli.Context) error {
 	// Custom certificates and certificate dir flags
 	flags.CertificateDir = ctx.String("cert-dir")
 	flags.CustomCerts = ctx.Bool("custom-certs")
+	unlock, err := lockClusterState(ctx, cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir), "up")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if ctx.Bool("simulate") {
+		sim, err := simulate.NewSimulator()
+		if err != nil {
+			return err
+		}
+		defer sim.Close()
+		return runSimulation(newContext(ctx), sim, flags, func(simCtx context.Context, dialersOptions hosts.DialersOptions, simFlags cluster.ExternalFlags) error {
+			if err := ClusterInit(simCtx, rkeConfig, dialersOptions, simFlags); err != nil {
+				return err
+			}
+			_, _, _, _, _, err := ClusterUp(simCtx, dialersOptions, simFlags)
+			return err
+		})
+	}
 	if ctx.Bool("init") {
-		return ClusterInit(context.Background(), rkeConfig, hosts.DialersOptions{}, flags)
+		return ClusterInit(newContext(ctx), rkeConfig, hosts.DialersOptions{}, flags)
 	}
-	if err := ClusterInit(context.Background(), rkeConfig, hosts.DialersOptions{}, flags); err != nil {
+	if err := ClusterInit(newContext(ctx), rkeConfig, hosts.DialersOptions{}, flags); err != nil {
 		return err
 	}
 
-	_, _, _, _, _, err = ClusterUp(context.Background(), hosts.DialersOptions{}, flags)
+	_, _, _, _, _, err = ClusterUp(newContext(ctx), hosts.DialersOptions{}, flags)
 	return err
 }
 
@@ -247,7 +269,7 @@ func clusterUpLocal(ctx *cli.Context) error {
 	var rkeConfig *v3.RancherKubernetesEngineConfig
 	clusterFile, filePath, err := resolveClusterFile(ctx)
 	if err != nil {
-		log.Infof(context.Background(), "Failed to resolve cluster file, using default cluster instead")
+		log.Infof(newContext(ctx), "Failed to resolve cluster file, using default cluster instead")
 		rkeConfig = cluster.GetLocalRKEConfig()
 	} else {
 		rkeConfig, err = cluster.ParseConfig(clusterFile)
@@ -265,12 +287,12 @@ func clusterUpLocal(ctx *cli.Context) error {
 	flags := cluster.GetExternalFlags(true, false, false, "", filePath)
 
 	if ctx.Bool("init") {
-		return ClusterInit(context.Background(), rkeConfig, dialers, flags)
+		return ClusterInit(newContext(ctx), rkeConfig, dialers, flags)
 	}
-	if err := ClusterInit(context.Background(), rkeConfig, dialers, flags); err != nil {
+	if err := ClusterInit(newContext(ctx), rkeConfig, dialers, flags); err != nil {
 		return err
 	}
-	_, _, _, _, _, err = ClusterUp(context.Background(), dialers, flags)
+	_, _, _, _, _, err = ClusterUp(newContext(ctx), dialers, flags)
 	return err
 }
 
@@ -281,7 +303,7 @@ func clusterUpDind(ctx *cli.Context) error {
 		return err
 	}
 	// setup dind environment
-	if err = createDINDEnv(context.Background(), rkeConfig, dindStorageDriver, dindDNS); err != nil {
+	if err = createDINDEnv(newContext(ctx), rkeConfig, dindStorageDriver, dindDNS); err != nil {
 		return err
 	}
 
@@ -292,13 +314,13 @@ func clusterUpDind(ctx *cli.Context) error {
 	flags.DinD = true
 
 	if ctx.Bool("init") {
-		return ClusterInit(context.Background(), rkeConfig, dialers, flags)
+		return ClusterInit(newContext(ctx), rkeConfig, dialers, flags)
 	}
-	if err := ClusterInit(context.Background(), rkeConfig, dialers, flags); err != nil {
+	if err := ClusterInit(newContext(ctx), rkeConfig, dialers, flags); err != nil {
 		return err
 	}
 	// start cluster
-	_, _, _, _, _, err = ClusterUp(context.Background(), dialers, flags)
+	_, _, _, _, _, err = ClusterUp(newContext(ctx), dialers, flags)
 	return err
 }
 
@@ -31,6 +31,8 @@ const (
 	RestartTimeout = 5
 	// StopTimeout in seconds
 	StopTimeout = 5
+	// OldContainerPrefix is prepended to the name of a container while it's being replaced
+	OldContainerPrefix = "old-"
 )
 
 var K8sDockerVersions = map[string][]string{
@@ -50,27 +52,53 @@ type dockerConfig struct {
 
 type authConfig types.AuthConfig
 
+// ContainerDiff describes what would change if a container was recreated from its planned configuration
+type ContainerDiff struct {
+	Name         string
+	Exists       bool
+	Changed      bool
+	CurrentImage string
+	PlannedImage string
+	AddedArgs    []string
+	RemovedArgs  []string
+	AddedEnv     []string
+	RemovedEnv   []string
+	AddedBinds   []string
+	RemovedBinds []string
+}
+
 func DoRunContainer(ctx context.Context, dClient *client.Client, imageCfg *container.Config, hostCfg *container.HostConfig, containerName string, hostname string, plane string, prsMap map[string]v3.PrivateRegistry) error {
+	_, err := doRunContainer(ctx, dClient, imageCfg, hostCfg, containerName, hostname, plane, prsMap, false)
+	return err
+}
+
+// DoRunContainerKeepOld works like DoRunContainer, but an upgraded container is kept as old-<name> until it's removed
+// with RemoveOldContainer or brought back with RestoreOldContainer. It returns true if the old container was kept.
+func DoRunContainerKeepOld(ctx context.Context, dClient *client.Client, imageCfg *container.Config, hostCfg *container.HostConfig, containerName string, hostname string, plane string, prsMap map[string]v3.PrivateRegistry) (bool, error) {
+	return doRunContainer(ctx, dClient, imageCfg, hostCfg, containerName, hostname, plane, prsMap, true)
+}
+
+func doRunContainer(ctx context.Context, dClient *client.Client, imageCfg *container.Config, hostCfg *container.HostConfig, containerName string, hostname string, plane string, prsMap map[string]v3.PrivateRegistry, keepOld bool) (bool, error) {
 	if dClient == nil {
-		return fmt.Errorf("[%s] Failed to run container: docker client is nil for container [%s] on host [%s]", plane, containerName, hostname)
+		return false, fmt.Errorf("[%s] Failed to run container: docker client is nil for container [%s] on host [%s]", plane, containerName, hostname)
 	}
 	container, err := dClient.ContainerInspect(ctx, containerName)
 	if err != nil {
 		if !client.IsErrNotFound(err) {
-			return err
+			return false, err
 		}
 		if err := UseLocalOrPull(ctx, dClient, hostname, imageCfg.Image, plane, prsMap); err != nil {
-			return err
+			return false, err
 		}
 		resp, err := dClient.ContainerCreate(ctx, imageCfg, hostCfg, nil, containerName)
 		if err != nil {
-			return fmt.Errorf("Failed to create [%s] container on host [%s]: %v", containerName, hostname, err)
+			return false, fmt.Errorf("Failed to create [%s] container on host [%s]: %v", containerName, hostname, err)
 		}
 		if err := dClient.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
-			return fmt.Errorf("Failed to start [%s] container on host [%s]: %v", containerName, hostname, err)
+			return false, fmt.Errorf("Failed to start [%s] container on host [%s]: %v", containerName, hostname, err)
 		}
 		log.Infof(ctx, "[%s] Successfully started [%s] container on host [%s]", plane, containerName, hostname)
-		return nil
+		return false, nil
 	}
 	// Check for upgrades
 	if container.State.Running {
@@ -79,27 +107,27 @@ func DoRunContainer(ctx context.Context, dClient *client.Client, imageCfg *conta
 			logrus.Debugf("[%s] Container [%s] is in a restarting loop [%s]", plane, containerName, hostname)
 			restartTimeoutDuration := RestartTimeout * time.Second
 			if err := dClient.ContainerRestart(ctx, container.ID, &restartTimeoutDuration); err != nil {
-				return fmt.Errorf("Failed to start [%s] container on host [%s]: %v", containerName, hostname, err)
+				return false, fmt.Errorf("Failed to start [%s] container on host [%s]: %v", containerName, hostname, err)
 			}
 		}
 		logrus.Debugf("[%s] Container [%s] is already running on host [%s]", plane, containerName, hostname)
 		isUpgradable, err := IsContainerUpgradable(ctx, dClient, imageCfg, hostCfg, containerName, hostname, plane)
 		if err != nil {
-			return err
+			return false, err
 		}
 		if isUpgradable {
-			return DoRollingUpdateContainer(ctx, dClient, imageCfg, hostCfg, containerName, hostname, plane, prsMap)
+			return doRollingUpdateContainer(ctx, dClient, imageCfg, hostCfg, containerName, hostname, plane, prsMap, keepOld)
 		}
-		return nil
+		return false, nil
 	}
 
 	// start if not running
 	logrus.Debugf("[%s] Starting stopped container [%s] on host [%s]", plane, containerName, hostname)
 	if err := dClient.ContainerStart(ctx, container.ID, types.ContainerStartOptions{}); err != nil {
-		return fmt.Errorf("Failed to start [%s] container on host [%s]: %v", containerName, hostname, err)
+		return false, fmt.Errorf("Failed to start [%s] container on host [%s]: %v", containerName, hostname, err)
 	}
 	log.Infof(ctx, "[%s] Successfully started [%s] container on host [%s]", plane, containerName, hostname)
-	return nil
+	return false, nil
 }
 
 func DoRunOnetimeContainer(ctx context.Context, dClient *client.Client, imageCfg *container.Config, hostCfg *container.HostConfig, containerName string, hostname string, plane string, prsMap map[string]v3.PrivateRegistry) error {
@@ -142,39 +170,75 @@ func DoRunOnetimeContainer(ctx context.Context, dClient *client.Client, imageCfg
 }
 
 func DoRollingUpdateContainer(ctx context.Context, dClient *client.Client, imageCfg *container.Config, hostCfg *container.HostConfig, containerName, hostname, plane string, prsMap map[string]v3.PrivateRegistry) error {
+	_, err := doRollingUpdateContainer(ctx, dClient, imageCfg, hostCfg, containerName, hostname, plane, prsMap, false)
+	return err
+}
+
+func doRollingUpdateContainer(ctx context.Context, dClient *client.Client, imageCfg *container.Config, hostCfg *container.HostConfig, containerName, hostname, plane string, prsMap map[string]v3.PrivateRegistry, keepOld bool) (bool, error) {
 	if dClient == nil {
-		return fmt.Errorf("[%s] Failed rolling update of container: docker client is nil for container [%s] on host [%s]", plane, containerName, hostname)
+		return false, fmt.Errorf("[%s] Failed rolling update of container: docker client is nil for container [%s] on host [%s]", plane, containerName, hostname)
 	}
 	logrus.Debugf("[%s] Checking for deployed [%s]", plane, containerName)
 	isRunning, err := IsContainerRunning(ctx, dClient, hostname, containerName, false)
 	if err != nil {
-		return err
+		return false, err
 	}
 	if !isRunning {
 		logrus.Debugf("[%s] Container %s is not running on host [%s]", plane, containerName, hostname)
-		return nil
+		return false, nil
 	}
 	err = UseLocalOrPull(ctx, dClient, hostname, imageCfg.Image, plane, prsMap)
 	if err != nil {
-		return err
+		return false, err
 	}
 	logrus.Debugf("[%s] Stopping old container", plane)
-	oldContainerName := "old-" + containerName
+	oldContainerName := OldContainerPrefix + containerName
 	if err := StopRenameContainer(ctx, dClient, hostname, containerName, oldContainerName); err != nil {
-		return err
+		return false, err
 	}
 	logrus.Debugf("[%s] Successfully stopped old container %s on host [%s]", plane, containerName, hostname)
 	_, err = CreateContainer(ctx, dClient, hostname, containerName, imageCfg, hostCfg)
 	if err != nil {
-		return fmt.Errorf("Failed to create [%s] container on host [%s]: %v", containerName, hostname, err)
+		return keepOld, fmt.Errorf("Failed to create [%s] container on host [%s]: %v", containerName, hostname, err)
 	}
 	if err := StartContainer(ctx, dClient, hostname, containerName); err != nil {
-		return fmt.Errorf("Failed to start [%s] container on host [%s]: %v", containerName, hostname, err)
+		return keepOld, fmt.Errorf("Failed to start [%s] container on host [%s]: %v", containerName, hostname, err)
 	}
 	log.Infof(ctx, "[%s] Successfully updated [%s] container on host [%s]", plane, containerName, hostname)
+	if keepOld {
+		return true, nil
+	}
 	logrus.Debugf("[%s] Removing old container", plane)
 	err = RemoveContainer(ctx, dClient, hostname, oldContainerName)
-	return err
+	return false, err
+}
+
+// RemoveOldContainer removes the old-<name> container kept by DoRunContainerKeepOld
+func RemoveOldContainer(ctx context.Context, dClient *client.Client, containerName, hostname string) error {
+	return DoRemoveContainer(ctx, dClient, OldContainerPrefix+containerName, hostname)
+}
+
+// RestoreOldContainer replaces a container with the old-<name> container kept by DoRunContainerKeepOld
+func RestoreOldContainer(ctx context.Context, dClient *client.Client, containerName, hostname string) error {
+	oldContainerName := OldContainerPrefix + containerName
+	exists, err := IsContainerRunning(ctx, dClient, hostname, oldContainerName, true)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("Can't restore container [%s] on host [%s], [%s] doesn't exist", containerName, hostname, oldContainerName)
+	}
+	if err := DoRemoveContainer(ctx, dClient, containerName, hostname); err != nil {
+		return err
+	}
+	if err := RenameContainer(ctx, dClient, hostname, oldContainerName, containerName); err != nil {
+		return err
+	}
+	if err := StartContainer(ctx, dClient, hostname, containerName); err != nil {
+		return err
+	}
+	log.Infof(ctx, "Successfully restored previous [%s] container on host [%s]", containerName, hostname)
+	return nil
 }
 
 func DoRemoveContainer(ctx context.Context, dClient *client.Client, containerName, hostname string) error {
@@ -258,6 +322,38 @@ func pullImage(ctx context.Context, dClient *client.Client, hostname string, con
 	return nil
 }
 
+// LoadImages loads a docker save archive on the host, the errors of the load are in the response stream.
+// The load isn't quiet: a quiet load only answers once all the layers are loaded, which can take longer than
+// the response header timeout of the Docker clients, while the progress is streamed as soon as the first layer loads.
+func LoadImages(ctx context.Context, dClient *client.Client, hostname string, input io.Reader) error {
+	if dClient == nil {
+		return fmt.Errorf("Failed to load images: docker client is nil for host [%s]", hostname)
+	}
+	resp, err := dClient.ImageLoad(ctx, input, false)
+	if err != nil {
+		return fmt.Errorf("Can't load Docker images on host [%s]: %v", hostname, err)
+	}
+	defer resp.Body.Close()
+	if !resp.JSON {
+		_, err := io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		message := struct {
+			Error string `json:"error"`
+		}{}
+		if err := decoder.Decode(&message); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Failed to read the image load output of host [%s]: %v", hostname, err)
+		}
+		if len(message.Error) > 0 {
+			return fmt.Errorf("Failed to load Docker images on host [%s]: %s", hostname, message.Error)
+		}
+	}
+}
+
 func UseLocalOrPull(ctx context.Context, dClient *client.Client, hostname string, containerImage string, plane string, prsMap map[string]v3.PrivateRegistry) error {
 	if dClient == nil {
 		return fmt.Errorf("[%s] Failed to use local image or pull: docker client is nil for container [%s] on host [%s]", plane, containerImage, hostname)
@@ -425,11 +521,7 @@ func IsContainerUpgradable(ctx context.Context, dClient *client.Client, imageCfg
 		logrus.Debugf("[%s] Container [%s] is eligible for upgrade on host [%s]", plane, containerName, hostname)
 		return true, nil
 	}
-	if containerInspect.Config.Image != imageCfg.Image ||
-		!sliceEqualsIgnoreOrder(containerInspect.Config.Entrypoint, imageCfg.Entrypoint) ||
-		!sliceEqualsIgnoreOrder(containerInspect.Config.Cmd, imageCfg.Cmd) ||
-		!isContainerEnvChanged(containerInspect.Config.Env, imageCfg.Env, imageInspect.Config.Env) ||
-		!sliceEqualsIgnoreOrder(containerInspect.HostConfig.Binds, hostCfg.Binds) {
+	if isContainerConfigChanged(containerInspect, imageCfg, hostCfg, imageInspect.Config.Env) {
 		logrus.Debugf("[%s] Container [%s] is eligible for upgrade on host [%s]", plane, containerName, hostname)
 		return true, nil
 	}
@@ -437,10 +529,77 @@ func IsContainerUpgradable(ctx context.Context, dClient *client.Client, imageCfg
 	return false, nil
 }
 
+// GetContainerDiff compares a container with its planned configuration using the same checks as IsContainerUpgradable
+func GetContainerDiff(ctx context.Context, dClient *client.Client, imageCfg *container.Config, hostCfg *container.HostConfig, containerName string, hostname string, plane string) (ContainerDiff, error) {
+	diff := ContainerDiff{
+		Name:         containerName,
+		PlannedImage: imageCfg.Image,
+	}
+	if dClient == nil {
+		return diff, fmt.Errorf("[%s] Failed to diff container: docker client is nil for container [%s] on host [%s]", plane, containerName, hostname)
+	}
+	containerInspect, err := dClient.ContainerInspect(ctx, containerName)
+	if err != nil {
+		if !client.IsErrNotFound(err) {
+			return diff, fmt.Errorf("Failed to inspect [%s] container on host [%s]: %v", containerName, hostname, err)
+		}
+		diff.Changed = true
+		diff.AddedArgs = sets.NewString(append(append([]string{}, imageCfg.Entrypoint...), imageCfg.Cmd...)...).List()
+		diff.AddedEnv = sets.NewString(imageCfg.Env...).List()
+		diff.AddedBinds = sets.NewString(hostCfg.Binds...).List()
+		return diff, nil
+	}
+	diff.Exists = true
+	diff.CurrentImage = containerInspect.Config.Image
+
+	var dockerfileEnv []string
+	imageInspect, _, err := dClient.ImageInspectWithRaw(ctx, imageCfg.Image)
+	if err != nil {
+		if !client.IsErrNotFound(err) {
+			return diff, err
+		}
+		// the planned image is not on the host yet, IsContainerUpgradable treats this as an upgrade.
+		// the env of the running image is used so the env diff only shows what the plan changes
+		diff.Changed = true
+		if currentImageInspect, _, err := dClient.ImageInspectWithRaw(ctx, containerInspect.Image); err == nil {
+			dockerfileEnv = currentImageInspect.Config.Env
+		}
+	} else {
+		dockerfileEnv = imageInspect.Config.Env
+	}
+	currentArgs := append(append([]string{}, containerInspect.Config.Entrypoint...), containerInspect.Config.Cmd...)
+	plannedArgs := append(append([]string{}, imageCfg.Entrypoint...), imageCfg.Cmd...)
+	diff.AddedArgs, diff.RemovedArgs = sliceDiff(currentArgs, plannedArgs)
+	diff.AddedEnv, diff.RemovedEnv = sliceDiff(containerInspect.Config.Env, append(append([]string{}, imageCfg.Env...), dockerfileEnv...))
+	diff.AddedBinds, diff.RemovedBinds = sliceDiff(containerInspect.HostConfig.Binds, hostCfg.Binds)
+
+	if isContainerConfigChanged(containerInspect, imageCfg, hostCfg, dockerfileEnv) {
+		diff.Changed = true
+	}
+	return diff, nil
+}
+
+// isContainerConfigChanged is the upgrade check of IsContainerUpgradable and GetContainerDiff, so rke up
+// and the plan diff agree on the containers to replace
+func isContainerConfigChanged(containerInspect types.ContainerJSON, imageCfg *container.Config, hostCfg *container.HostConfig, dockerfileEnv []string) bool {
+	return containerInspect.Config.Image != imageCfg.Image ||
+		!sliceEqualsIgnoreOrder(containerInspect.Config.Entrypoint, imageCfg.Entrypoint) ||
+		!sliceEqualsIgnoreOrder(containerInspect.Config.Cmd, imageCfg.Cmd) ||
+		!isContainerEnvChanged(containerInspect.Config.Env, imageCfg.Env, dockerfileEnv) ||
+		!sliceEqualsIgnoreOrder(containerInspect.HostConfig.Binds, hostCfg.Binds)
+}
+
 func sliceEqualsIgnoreOrder(left, right []string) bool {
 	return sets.NewString(left...).Equal(sets.NewString(right...))
 }
 
+// sliceDiff returns the sorted items added to and removed from current to get planned
+func sliceDiff(current, planned []string) ([]string, []string) {
+	currentSet := sets.NewString(current...)
+	plannedSet := sets.NewString(planned...)
+	return plannedSet.Difference(currentSet).List(), currentSet.Difference(plannedSet).List()
+}
+
 func IsSupportedDockerVersion(info types.Info, K8sVersion string) (bool, error) {
 	dockerVersion, err := semver.NewVersion(info.ServerVersion)
 	if err != nil {
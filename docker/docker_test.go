@@ -0,0 +1,224 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+const testContainerName = "kubelet"
+
+func TestSliceDiff(t *testing.T) {
+	tests := []struct {
+		name            string
+		current         []string
+		planned         []string
+		expectedAdded   []string
+		expectedRemoved []string
+	}{
+		{
+			name:            "unchanged",
+			current:         []string{"--a=1", "--b=2"},
+			planned:         []string{"--a=1", "--b=2"},
+			expectedAdded:   []string{},
+			expectedRemoved: []string{},
+		},
+		{
+			name:            "reordered",
+			current:         []string{"--a=1", "--b=2", "--c=3"},
+			planned:         []string{"--c=3", "--a=1", "--b=2"},
+			expectedAdded:   []string{},
+			expectedRemoved: []string{},
+		},
+		{
+			name:            "added",
+			current:         []string{"--a=1"},
+			planned:         []string{"--b=2", "--a=1"},
+			expectedAdded:   []string{"--b=2"},
+			expectedRemoved: []string{},
+		},
+		{
+			name:            "removed",
+			current:         []string{"--a=1", "--b=2"},
+			planned:         []string{"--a=1"},
+			expectedAdded:   []string{},
+			expectedRemoved: []string{"--b=2"},
+		},
+		{
+			name:            "changed value",
+			current:         []string{"--a=1", "--b=2"},
+			planned:         []string{"--b=3", "--a=1"},
+			expectedAdded:   []string{"--b=3"},
+			expectedRemoved: []string{"--b=2"},
+		},
+		{
+			name:            "sorted output",
+			current:         nil,
+			planned:         []string{"--z", "--a", "--m"},
+			expectedAdded:   []string{"--a", "--m", "--z"},
+			expectedRemoved: []string{},
+		},
+	}
+	for _, test := range tests {
+		added, removed := sliceDiff(test.current, test.planned)
+		if !reflect.DeepEqual(added, test.expectedAdded) {
+			t.Errorf("[%s] expected added %v, got %v", test.name, test.expectedAdded, added)
+		}
+		if !reflect.DeepEqual(removed, test.expectedRemoved) {
+			t.Errorf("[%s] expected removed %v, got %v", test.name, test.expectedRemoved, removed)
+		}
+	}
+}
+
+func TestGetContainerDiff(t *testing.T) {
+	current := &types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			Image:      "sha256:current",
+			HostConfig: &container.HostConfig{Binds: []string{"/etc/kubernetes:/etc/kubernetes:z", "/var/lib/kubelet:/var/lib/kubelet:shared,z"}},
+		},
+		Config: &container.Config{
+			Image:      "rancher/hyperkube:v1.11.1",
+			Entrypoint: []string{"/opt/rke-tools/entrypoint.sh"},
+			Cmd:        []string{"kubelet", "--v=2", "--address=0.0.0.0"},
+			Env:        []string{"RKE_KUBELET=true", "PATH=/usr/bin"},
+		},
+	}
+	imageEnv := []string{"PATH=/usr/bin"}
+
+	tests := []struct {
+		name     string
+		imageCfg *container.Config
+		hostCfg  *container.HostConfig
+		expected ContainerDiff
+	}{
+		{
+			name: "reordered args, env and binds",
+			imageCfg: &container.Config{
+				Image:      "rancher/hyperkube:v1.11.1",
+				Entrypoint: []string{"/opt/rke-tools/entrypoint.sh"},
+				Cmd:        []string{"kubelet", "--address=0.0.0.0", "--v=2"},
+				Env:        []string{"RKE_KUBELET=true"},
+			},
+			hostCfg: &container.HostConfig{Binds: []string{"/var/lib/kubelet:/var/lib/kubelet:shared,z", "/etc/kubernetes:/etc/kubernetes:z"}},
+			expected: ContainerDiff{
+				Exists:       true,
+				Changed:      false,
+				AddedArgs:    []string{},
+				RemovedArgs:  []string{},
+				AddedEnv:     []string{},
+				RemovedEnv:   []string{},
+				AddedBinds:   []string{},
+				RemovedBinds: []string{},
+			},
+		},
+		{
+			name: "added args, env and binds",
+			imageCfg: &container.Config{
+				Image:      "rancher/hyperkube:v1.11.1",
+				Entrypoint: []string{"/opt/rke-tools/entrypoint.sh"},
+				Cmd:        []string{"kubelet", "--v=2", "--address=0.0.0.0", "--fail-swap-on=false"},
+				Env:        []string{"RKE_KUBELET=true", "HTTP_PROXY=http://proxy:3128"},
+			},
+			hostCfg: &container.HostConfig{Binds: []string{"/etc/kubernetes:/etc/kubernetes:z", "/var/lib/kubelet:/var/lib/kubelet:shared,z", "/opt/cni:/opt/cni:z"}},
+			expected: ContainerDiff{
+				Exists:       true,
+				Changed:      true,
+				AddedArgs:    []string{"--fail-swap-on=false"},
+				RemovedArgs:  []string{},
+				AddedEnv:     []string{"HTTP_PROXY=http://proxy:3128"},
+				RemovedEnv:   []string{},
+				AddedBinds:   []string{"/opt/cni:/opt/cni:z"},
+				RemovedBinds: []string{},
+			},
+		},
+		{
+			name: "removed args, env and binds",
+			imageCfg: &container.Config{
+				Image:      "rancher/hyperkube:v1.11.1",
+				Entrypoint: []string{"/opt/rke-tools/entrypoint.sh"},
+				Cmd:        []string{"kubelet", "--v=2"},
+			},
+			hostCfg: &container.HostConfig{Binds: []string{"/etc/kubernetes:/etc/kubernetes:z"}},
+			expected: ContainerDiff{
+				Exists:       true,
+				Changed:      true,
+				AddedArgs:    []string{},
+				RemovedArgs:  []string{"--address=0.0.0.0"},
+				AddedEnv:     []string{},
+				RemovedEnv:   []string{"RKE_KUBELET=true"},
+				AddedBinds:   []string{},
+				RemovedBinds: []string{"/var/lib/kubelet:/var/lib/kubelet:shared,z"},
+			},
+		},
+	}
+	dClient, server := newFakeDockerClient(t, current, imageEnv)
+	defer server.Close()
+	for _, test := range tests {
+		diff, err := GetContainerDiff(context.Background(), dClient, test.imageCfg, test.hostCfg, testContainerName, "host", "test")
+		if err != nil {
+			t.Fatalf("[%s] Failed to diff container: %v", test.name, err)
+		}
+		test.expected.Name = testContainerName
+		test.expected.CurrentImage = current.Config.Image
+		test.expected.PlannedImage = test.imageCfg.Image
+		if !reflect.DeepEqual(diff, test.expected) {
+			t.Errorf("[%s] expected diff %+v, got %+v", test.name, test.expected, diff)
+		}
+	}
+}
+
+func TestGetContainerDiffMissingContainer(t *testing.T) {
+	dClient, server := newFakeDockerClient(t, nil, nil)
+	defer server.Close()
+	imageCfg := &container.Config{
+		Image: "rancher/hyperkube:v1.11.1",
+		Cmd:   []string{"kubelet", "--v=2"},
+		Env:   []string{"RKE_KUBELET=true"},
+	}
+	hostCfg := &container.HostConfig{Binds: []string{"/etc/kubernetes:/etc/kubernetes:z"}}
+	diff, err := GetContainerDiff(context.Background(), dClient, imageCfg, hostCfg, testContainerName, "host", "test")
+	if err != nil {
+		t.Fatalf("Failed to diff container: %v", err)
+	}
+	if diff.Exists || !diff.Changed {
+		t.Fatalf("Expected a missing container to be a change, got %+v", diff)
+	}
+	if !reflect.DeepEqual(diff.AddedArgs, []string{"--v=2", "kubelet"}) ||
+		!reflect.DeepEqual(diff.AddedEnv, imageCfg.Env) ||
+		!reflect.DeepEqual(diff.AddedBinds, hostCfg.Binds) {
+		t.Fatalf("Expected everything to be added, got %+v", diff)
+	}
+}
+
+// newFakeDockerClient serves the container and image inspect calls of GetContainerDiff, a nil container is not found
+func newFakeDockerClient(t *testing.T, current *types.ContainerJSON, imageEnv []string) (*client.Client, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var obj interface{}
+		switch {
+		case strings.Contains(req.URL.Path, "/containers/"+testContainerName+"/json") && current != nil:
+			obj = current
+		case strings.Contains(req.URL.Path, "/images/"):
+			obj = types.ImageInspect{Config: &container.Config{Env: imageEnv}}
+		default:
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(rw).Encode(map[string]string{"message": "No such container: " + testContainerName})
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(obj)
+	}))
+	dClient, err := client.NewClient("tcp://"+server.Listener.Addr().String(), "1.24", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create docker client: %v", err)
+	}
+	return dClient, server
+}
@@ -1,14 +1,18 @@
 package hosts
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/rancher/rke/k8s"
-	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -16,11 +20,17 @@ const (
 	DockerDialerTimeout = 50
 )
 
+// DockerResponseHeaderTimeout is how long the Docker API calls wait for the response headers once the request is sent,
+// the long running calls have to stream their progress to keep under it
+var DockerResponseHeaderTimeout = time.Second * DockerDialerTimeout
+
 type DialerFactory func(h *Host) (func(network, address string) (net.Conn, error), error)
 
 type dialer struct {
 	signer          ssh.Signer
 	sshKeyString    string
+	sshKeyPath      string
+	sshKeyPhrase    string
 	sshCertString   string
 	sshAddress      string
 	username        string
@@ -28,6 +38,11 @@ type dialer struct {
 	dockerSocket    string
 	useSSHAgentAuth bool
 	bastionDialer   *dialer
+	// host key checks, the host keys aren't checked without a verifier
+	hostKeyVerifier       *HostKeyVerifier
+	sshHostKeyFingerprint string
+	// limit of the concurrent channels on the pooled SSH connection, no limit if it's not set
+	maxChannels int
 }
 
 type DialersOptions struct {
@@ -45,68 +60,118 @@ func GetDialerOptions(d, l DialerFactory, w k8s.WrapTransport) DialersOptions {
 }
 
 func newDialer(h *Host, kind string) (*dialer, error) {
-	// Check for Bastion host connection
-	var bastionDialer *dialer
-	if len(h.BastionHost.Address) > 0 {
-		bastionDialer = &dialer{
-			sshAddress:      fmt.Sprintf("%s:%s", h.BastionHost.Address, h.BastionHost.Port),
-			username:        h.BastionHost.User,
-			sshKeyString:    h.BastionHost.SSHKey,
-			sshCertString:   h.BastionHost.SSHCert,
-			netConn:         "tcp",
-			useSSHAgentAuth: h.SSHAgentAuth,
-		}
-		if bastionDialer.sshKeyString == "" && !bastionDialer.useSSHAgentAuth {
-			var err error
-			bastionDialer.sshKeyString, err = privateKeyPath(h.BastionHost.SSHKeyPath)
-			if err != nil {
-				return nil, err
-			}
+	target := sshTarget{
+		address:     h.Address,
+		port:        h.Port,
+		user:        h.User,
+		sshKey:      h.SSHKey,
+		sshKeyPath:  h.SSHKeyPath,
+		sshCert:     h.SSHCert,
+		sshCertPath: h.SSHCertPath,
+		fingerprint: h.SSHHostKeyFingerprint,
+		agentAuth:   h.SSHAgentAuth,
+		keyPhrase:   h.SavedKeyPhrase,
+	}
+	dialer, err := h.newSSHDialer(target, h.getBastionTargets(), 0)
+	if err != nil {
+		return nil, err
+	}
+	dialer.dockerSocket = h.DockerSocket
+	dialer.netConn = "unix"
 
-			if bastionDialer.sshCertString == "" && len(h.BastionHost.SSHCertPath) > 0 {
-				bastionDialer.sshCertString, err = certificatePath(h.BastionHost.SSHCertPath)
-				if err != nil {
-					return nil, err
-				}
-			}
-		}
+	switch kind {
+	case "network", "health":
+		dialer.netConn = "tcp"
+	}
+
+	if len(dialer.dockerSocket) == 0 {
+		dialer.dockerSocket = "/var/run/docker.sock"
+	}
+
+	return dialer, nil
+}
+
+func (h *Host) getBastionTargets() []sshTarget {
+	targets := []sshTarget{}
+	for _, bastionHost := range h.BastionHosts {
+		targets = append(targets, sshTarget{
+			address:     bastionHost.Address,
+			port:        bastionHost.Port,
+			user:        bastionHost.User,
+			sshKey:      bastionHost.SSHKey,
+			sshKeyPath:  bastionHost.SSHKeyPath,
+			sshCert:     bastionHost.SSHCert,
+			sshCertPath: bastionHost.SSHCertPath,
+			fingerprint: bastionHost.SSHHostKeyFingerprint,
+			agentAuth:   h.SSHAgentAuth,
+		})
 	}
+	return targets
+}
 
+// newSSHDialer builds the dialer of a target behind a chain of jump hosts, the last jump host is reached through the
+// ones before it. The ssh config fills in what the cluster file leaves empty, its ProxyJump is only used without jump hosts.
+func (h *Host) newSSHDialer(target sshTarget, jumps []sshTarget, depth int) (*dialer, error) {
+	if depth > maxProxyJumpDepth {
+		return nil, fmt.Errorf("Too many jump hosts to reach host [%s], check the ProxyJump loops in SSH config file", target.address)
+	}
+	if h.SSHConfig != nil {
+		if proxyJump := h.SSHConfig.resolve(&target); len(jumps) == 0 && len(proxyJump) > 0 {
+			jumps = parseProxyJump(proxyJump)
+		}
+	}
+	if len(target.user) == 0 {
+		return nil, fmt.Errorf("SSH user for host [%s] is not set in the cluster file or the SSH config file", target.address)
+	}
+	if len(target.port) == 0 {
+		target.port = defaultSSHPort
+	}
+	if len(target.sshKey) == 0 && len(target.sshKeyPath) == 0 {
+		target.sshKeyPath = DefaultSSHKeyPath
+	}
 	dialer := &dialer{
-		sshAddress:      fmt.Sprintf("%s:%s", h.Address, h.Port),
-		username:        h.User,
-		dockerSocket:    h.DockerSocket,
-		sshKeyString:    h.SSHKey,
-		sshCertString:   h.SSHCert,
-		netConn:         "unix",
-		useSSHAgentAuth: h.SSHAgentAuth,
-		bastionDialer:   bastionDialer,
+		sshAddress:      fmt.Sprintf("%s:%s", target.address, target.port),
+		username:        target.user,
+		sshKeyString:    target.sshKey,
+		sshKeyPhrase:    target.keyPhrase,
+		sshCertString:   target.sshCert,
+		netConn:         "tcp",
+		useSSHAgentAuth: target.agentAuth,
+
+		hostKeyVerifier:       h.HostKeyVerifier,
+		sshHostKeyFingerprint: target.fingerprint,
+		maxChannels:           h.SSHMaxChannels,
 	}
 
 	if dialer.sshKeyString == "" && !dialer.useSSHAgentAuth {
 		var err error
-		dialer.sshKeyString, err = privateKeyPath(h.SSHKeyPath)
+		dialer.sshKeyString, err = privateKeyPath(target.sshKeyPath)
 		if err != nil {
 			return nil, err
 		}
+		dialer.sshKeyPath = target.sshKeyPath
 
-		if dialer.sshCertString == "" && len(h.SSHCertPath) > 0 {
-			dialer.sshCertString, err = certificatePath(h.SSHCertPath)
+		if dialer.sshCertString == "" && len(target.sshCertPath) > 0 {
+			dialer.sshCertString, err = certificatePath(target.sshCertPath)
 			if err != nil {
 				return nil, err
 			}
 		}
 	}
 
-	switch kind {
-	case "network", "health":
-		dialer.netConn = "tcp"
-	}
-
-	if len(dialer.dockerSocket) == 0 {
-		dialer.dockerSocket = "/var/run/docker.sock"
+	if len(jumps) > 0 {
+		lastJump := jumps[len(jumps)-1]
+		// the jump hosts of the ssh config use the key of the host they lead to if they don't have one
+		if len(lastJump.sshKey) == 0 && len(lastJump.sshKeyPath) == 0 {
+			lastJump.sshKey, lastJump.sshKeyPath, lastJump.keyPhrase = target.sshKey, target.sshKeyPath, target.keyPhrase
+			lastJump.agentAuth = target.agentAuth
+		}
+		var err error
+		dialer.bastionDialer, err = h.newSSHDialer(lastJump, jumps[:len(jumps)-1], depth+1)
+		if err != nil {
+			return nil, err
+		}
 	}
-
 	return dialer, nil
 }
 
@@ -116,6 +181,10 @@ func SSHFactory(h *Host) (func(network, address string) (net.Conn, error), error
 }
 
 func LocalConnFactory(h *Host) (func(network, address string) (net.Conn, error), error) {
+	// there is no SSH to the hosts with a Docker endpoint, their ports are reached through a helper container
+	if h.UsesDockerEndpoint() {
+		return h.newPortForwardDialer()
+	}
 	dialer, err := newDialer(h, "network")
 	return dialer.Dial, err
 }
@@ -128,35 +197,35 @@ func (d *dialer) DialLocalConn(network, addr string) (net.Conn, error) {
 	return d.Dial(network, addr)
 }
 
+// Dial opens a channel to addr on the pooled SSH connection of the host, a broken connection is reconnected once
 func (d *dialer) Dial(network, addr string) (net.Conn, error) {
-	var conn *ssh.Client
-	var err error
-	if d.bastionDialer != nil {
-		conn, err = d.getBastionHostTunnelConn()
-	} else {
-		conn, err = d.getSSHTunnelConnection()
-	}
-	if err != nil {
-		if strings.Contains(err.Error(), "no key found") {
-			return nil, fmt.Errorf("Unable to access node with address [%s] using SSH. Please check if the configured key or specified key file is a valid SSH Private Key. Error: %v", d.sshAddress, err)
-		} else if strings.Contains(err.Error(), "no supported methods remain") {
-			return nil, fmt.Errorf("Unable to access node with address [%s] using SSH. Please check if you are able to SSH to the node using the specified SSH Private Key and if you have configured the correct SSH username. Error: %v", d.sshAddress, err)
-		} else if strings.Contains(err.Error(), "cannot decode encrypted private keys") {
-			return nil, fmt.Errorf("Unable to access node with address [%s] using SSH. Using encrypted private keys is only supported using ssh-agent. Please configure the option `ssh_agent_auth: true` in the configuration file or use --ssh-agent-auth as a parameter when running RKE. This will use the `SSH_AUTH_SOCK` environment variable. Error: %v", d.sshAddress, err)
-		} else if strings.Contains(err.Error(), "operation timed out") {
-			return nil, fmt.Errorf("Unable to access node with address [%s] using SSH. Please check if the node is up and is accepting SSH connections or check network policies and firewall rules. Error: %v", d.sshAddress, err)
-		}
-		return nil, fmt.Errorf("Failed to dial ssh using address [%s]: %v", d.sshAddress, err)
-	}
-
 	// Docker Socket....
 	if d.netConn == "unix" {
 		addr = d.dockerSocket
 		network = d.netConn
 	}
 
-	remote, err := conn.Dial(network, addr)
+	pooled := sshClients.get(d.poolKey(), d.sshAddress, d.maxChannels)
+	release, err := pooled.openChannel(true)
 	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		conn, err := pooled.getClient(d.connect)
+		if err != nil {
+			release()
+			return nil, d.sshConnectionError(err)
+		}
+		remote, err := conn.Dial(network, addr)
+		if err == nil {
+			return &pooledConn{Conn: remote, release: release}, nil
+		}
+		if attempt == 0 && !isChannelRejected(err) {
+			logrus.Debugf("[dialer] Reconnecting SSH to [%s] after dial failure: %v", d.sshAddress, err)
+			pooled.reset(conn)
+			continue
+		}
+		release()
 		if strings.Contains(err.Error(), "connect failed") {
 			return nil, fmt.Errorf("Unable to access the service on %s. The service might be still starting up. Error: %v", addr, err)
 		} else if strings.Contains(err.Error(), "administratively prohibited") {
@@ -164,14 +233,63 @@ func (d *dialer) Dial(network, addr string) (net.Conn, error) {
 		}
 		return nil, fmt.Errorf("Failed to dial to %s: %v", addr, err)
 	}
-	return remote, err
+}
+
+func (d *dialer) sshConnectionError(err error) error {
+	if strings.Contains(err.Error(), "no key found") {
+		return fmt.Errorf("Unable to access node with address [%s] using SSH. Please check if the configured key or specified key file is a valid SSH Private Key. Error: %v", d.sshAddress, err)
+	} else if strings.Contains(err.Error(), "no supported methods remain") {
+		return fmt.Errorf("Unable to access node with address [%s] using SSH. Please check if you are able to SSH to the node using the specified SSH Private Key and if you have configured the correct SSH username. Error: %v", d.sshAddress, err)
+	} else if strings.Contains(err.Error(), "cannot decode encrypted private keys") || strings.Contains(err.Error(), "passphrase protected") {
+		return fmt.Errorf("Unable to access node with address [%s] using SSH. The encrypted private key can't be decoded, set its passphrase with --ssh-key-passphrase or --ssh-key-passphrase-file, or configure the option `ssh_agent_auth: true` in the configuration file or use --ssh-agent-auth as a parameter when running RKE. This will use the `SSH_AUTH_SOCK` environment variable. Error: %v", d.sshAddress, err)
+	} else if strings.Contains(err.Error(), "operation timed out") {
+		return fmt.Errorf("Unable to access node with address [%s] using SSH. Please check if the node is up and is accepting SSH connections or check network policies and firewall rules. Error: %v", d.sshAddress, err)
+	}
+	return fmt.Errorf("Failed to dial ssh using address [%s]: %v", d.sshAddress, err)
+}
+
+// poolKey identifies the SSH connection of the dialer, the hosts behind a bastion host are keyed by the bastion host too.
+// The key includes the credentials and the host key checks of the dialer so a connection is never reused by a dialer
+// that would have authenticated or verified the host differently.
+func (d *dialer) poolKey() string {
+	key := fmt.Sprintf("%s@%s#%s", d.username, d.sshAddress, d.credentialsFingerprint())
+	if d.bastionDialer != nil {
+		key = d.bastionDialer.poolKey() + "/" + key
+	}
+	return key
+}
+
+// credentialsFingerprint hashes what the dialer authenticates with and how it checks the host key
+func (d *dialer) credentialsFingerprint() string {
+	var verifierID uint64
+	if d.hostKeyVerifier != nil {
+		verifierID = d.hostKeyVerifier.id
+	}
+	agentSocket := ""
+	if d.useSSHAgentAuth {
+		agentSocket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	hash := sha256.New()
+	for _, field := range []string{d.sshKeyString, d.sshKeyPhrase, d.sshCertString, agentSocket, d.sshHostKeyFingerprint, strconv.FormatUint(verifierID, 10)} {
+		// the length prefix keeps the fields from running into each other
+		fmt.Fprintf(hash, "%d:%s", len(field), field)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (d *dialer) connect() (*ssh.Client, error) {
+	if d.bastionDialer != nil {
+		return d.getBastionHostTunnelConn()
+	}
+	return d.getSSHTunnelConnection()
 }
 
 func (d *dialer) getSSHTunnelConnection() (*ssh.Client, error) {
-	cfg, err := getSSHConfig(d.username, d.sshKeyString, d.sshCertString, d.useSSHAgentAuth)
+	cfg, err := d.getSSHConfig()
 	if err != nil {
 		return nil, fmt.Errorf("Error configuring SSH: %v", err)
 	}
+	d.setHostKeyCallback(cfg)
 	// Establish connection with SSH server
 	return ssh.Dial("tcp", d.sshAddress, cfg)
 }
@@ -192,63 +310,75 @@ func (h *Host) newHTTPClient(dialerFactory DialerFactory) (*http.Client, error)
 			Dial:                  dialer,
 			TLSHandshakeTimeout:   dockerDialerTimeout,
 			IdleConnTimeout:       dockerDialerTimeout,
-			ResponseHeaderTimeout: dockerDialerTimeout,
+			ResponseHeaderTimeout: DockerResponseHeaderTimeout,
 		},
 	}, nil
 }
 
+// getBastionHostTunnelConn connects to the host through the pooled SSH connection of the last bastion host of its chain
 func (d *dialer) getBastionHostTunnelConn() (*ssh.Client, error) {
-	bastionCfg, err := getSSHConfig(d.bastionDialer.username, d.bastionDialer.sshKeyString, d.bastionDialer.sshCertString, d.bastionDialer.useSSHAgentAuth)
-	if err != nil {
-		return nil, fmt.Errorf("Error configuring SSH for bastion host [%s]: %v", d.bastionDialer.sshAddress, err)
-	}
-	bastionClient, err := ssh.Dial("tcp", d.bastionDialer.sshAddress, bastionCfg)
+	bastion := sshClients.get(d.bastionDialer.poolKey(), d.bastionDialer.sshAddress, d.bastionDialer.maxChannels)
+	// the tunnels to the hosts aren't limited, each host holds one for as long as its connection is open
+	release, err := bastion.openChannel(false)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to connect to the bastion host [%s]: %v", d.bastionDialer.sshAddress, err)
+		return nil, err
 	}
-	conn, err := bastionClient.Dial(d.bastionDialer.netConn, d.sshAddress)
-	if err != nil {
+	var conn net.Conn
+	for attempt := 0; ; attempt++ {
+		bastionClient, err := bastion.getClient(d.bastionDialer.connect)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("Failed to connect to the bastion host [%s]: %v", d.bastionDialer.sshAddress, err)
+		}
+		conn, err = bastionClient.Dial(d.bastionDialer.netConn, d.sshAddress)
+		if err == nil {
+			break
+		}
+		if attempt == 0 && !isChannelRejected(err) {
+			logrus.Debugf("[dialer] Reconnecting SSH to bastion host [%s] after dial failure: %v", d.bastionDialer.sshAddress, err)
+			bastion.reset(bastionClient)
+			continue
+		}
+		release()
 		return nil, fmt.Errorf("Failed to connect to the host [%s]: %v", d.sshAddress, err)
 	}
-	cfg, err := getSSHConfig(d.username, d.sshKeyString, d.sshCertString, d.useSSHAgentAuth)
+	conn = &pooledConn{Conn: conn, release: release}
+	cfg, err := d.getSSHConfig()
 	if err != nil {
+		conn.Close()
 		return nil, fmt.Errorf("Error configuring SSH for host [%s]: %v", d.sshAddress, err)
 	}
+	d.setHostKeyCallback(cfg)
 	newClientConn, channels, sshRequest, err := ssh.NewClientConn(conn, d.sshAddress, cfg)
 	if err != nil {
+		conn.Close()
 		return nil, fmt.Errorf("Failed to establish new ssh client conn [%s]: %v", d.sshAddress, err)
 	}
 	return ssh.NewClient(newClientConn, channels, sshRequest), nil
 }
 
-func BastionHostWrapTransport(bastionHost v3.BastionHost) (k8s.WrapTransport, error) {
-
-	bastionDialer := &dialer{
-		sshAddress:      fmt.Sprintf("%s:%s", bastionHost.Address, bastionHost.Port),
-		username:        bastionHost.User,
-		sshKeyString:    bastionHost.SSHKey,
-		sshCertString:   bastionHost.SSHCert,
-		netConn:         "tcp",
-		useSSHAgentAuth: bastionHost.SSHAgentAuth,
+// setHostKeyCallback checks the host key of the dialed host, the host key is ignored if host key checking isn't configured
+func (d *dialer) setHostKeyCallback(cfg *ssh.ClientConfig) {
+	if d.hostKeyVerifier == nil {
+		return
 	}
+	cfg.HostKeyCallback, cfg.HostKeyAlgorithms = d.hostKeyVerifier.HostKeyCallback(d.sshAddress, d.sshHostKeyFingerprint)
+}
 
-	if bastionDialer.sshKeyString == "" {
-		var err error
-		bastionDialer.sshKeyString, err = privateKeyPath(bastionHost.SSHKeyPath)
-		if err != nil {
-			return nil, err
-		}
-
+// BastionHostWrapTransport dials the Kubernetes API of a host through its bastion hosts, there is nothing to wrap if the host
+// is reached directly
+func BastionHostWrapTransport(h *Host) (k8s.WrapTransport, error) {
+	if h.UsesDockerEndpoint() {
+		return nil, nil
 	}
-
-	if bastionDialer.sshCertString == "" && len(bastionHost.SSHCertPath) > 0 {
-		var err error
-		bastionDialer.sshCertString, err = certificatePath(bastionHost.SSHCertPath)
-		if err != nil {
-			return nil, err
-		}
-
+	dialer, err := newDialer(h, "network")
+	if err != nil {
+		return nil, err
+	}
+	if dialer.bastionDialer == nil {
+		return nil, nil
 	}
+	bastionDialer := dialer.bastionDialer
 	return func(rt http.RoundTripper) http.RoundTripper {
 		if ht, ok := rt.(*http.Transport); ok {
 			ht.DialContext = nil
@@ -0,0 +1,269 @@
+package hosts
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/rancher/rke/docker"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DockerEndpointScheme     = "tcp"
+	PortForwardContainerName = "rke-port-forward"
+)
+
+// portForwardContainers numbers the helper containers of the run, so the concurrent connections don't share a container name
+var portForwardContainers int64
+
+// UsesDockerEndpoint is true if the host is reached through its Docker endpoint over TLS instead of SSH
+func (h *Host) UsesDockerEndpoint() bool {
+	return len(h.DockerEndpoint) > 0
+}
+
+// ValidateDockerEndpoint checks the endpoint is a tcp://host:port address
+func ValidateDockerEndpoint(endpoint string) error {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if endpointURL.Scheme != DockerEndpointScheme {
+		return fmt.Errorf("scheme [%s] is not supported, must be %s", endpointURL.Scheme, DockerEndpointScheme)
+	}
+	if _, _, err := net.SplitHostPort(endpointURL.Host); err != nil {
+		return err
+	}
+	return nil
+}
+
+// newDockerEndpointClient connects to the Docker endpoint of the host with the client certificate of the host
+func (h *Host) newDockerEndpointClient() (*client.Client, error) {
+	tlsConfig, err := h.getDockerTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	dockerDialerTimeout := time.Second * DockerDialerTimeout
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout:   dockerDialerTimeout,
+				KeepAlive: SSHKeepAliveInterval,
+			}).DialContext,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   dockerDialerTimeout,
+			IdleConnTimeout:       dockerDialerTimeout,
+			ResponseHeaderTimeout: DockerResponseHeaderTimeout,
+		},
+	}
+	return client.NewClient(h.DockerEndpoint, DockerAPIVersion, httpClient, nil)
+}
+
+func (h *Host) getDockerTLSConfig() (*tls.Config, error) {
+	caCert, err := readDockerTLSFile(h.DockerCACert, h.DockerCACertPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read Docker CA certificate of host [%s]: %v", h.Address, err)
+	}
+	cert, err := readDockerTLSFile(h.DockerCert, h.DockerCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read Docker client certificate of host [%s]: %v", h.Address, err)
+	}
+	key, err := readDockerTLSFile(h.DockerKey, h.DockerKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read Docker client key of host [%s]: %v", h.Address, err)
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("Failed to parse Docker CA certificate of host [%s]", h.Address)
+	}
+	keyPair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse Docker client certificate of host [%s]: %v", h.Address, err)
+	}
+	return &tls.Config{
+		RootCAs:      rootCAs,
+		Certificates: []tls.Certificate{keyPair},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// readDockerTLSFile returns the PEM content, or reads it from the path if it's not set
+func readDockerTLSFile(content, path string) ([]byte, error) {
+	if len(content) > 0 {
+		return []byte(content), nil
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("neither the content nor the path is set")
+	}
+	if strings.HasPrefix(path, "~/") {
+		path = filepath.Join(userHome(), path[2:])
+	}
+	return ioutil.ReadFile(path)
+}
+
+// portForwardDialer reaches the ports of a host without SSH, each connection runs a short lived helper
+// container on the host network that relays its stdin and stdout to the dialed address
+type portForwardDialer struct {
+	host      *Host
+	dClient   *client.Client
+	pullImage sync.Once
+	pullErr   error
+}
+
+func (h *Host) newPortForwardDialer() (func(network, address string) (net.Conn, error), error) {
+	if len(h.PortForwardImage) == 0 {
+		return nil, fmt.Errorf("No port forward image is set for host [%s]", h.Address)
+	}
+	dClient := h.DClient
+	if dClient == nil {
+		var err error
+		if dClient, err = h.newDockerEndpointClient(); err != nil {
+			return nil, fmt.Errorf("Failed to connect to the Docker endpoint of host [%s]: %v", h.Address, err)
+		}
+	}
+	dialer := &portForwardDialer{host: h, dClient: dClient}
+	return dialer.Dial, nil
+}
+
+func (d *portForwardDialer) Dial(network, addr string) (net.Conn, error) {
+	d.pullImage.Do(func() {
+		d.pullErr = docker.UseLocalOrPull(context.Background(), d.dClient, d.host.Address, d.host.PortForwardImage, PortForwardContainerName, d.host.PrivateRegistriesMap)
+	})
+	if d.pullErr != nil {
+		return nil, d.pullErr
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*DockerDialerTimeout)
+	defer cancel()
+	address, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to dial to %s: %v", addr, err)
+	}
+	containerName := fmt.Sprintf("%s-%s-%d", PortForwardContainerName, strconv.FormatInt(time.Now().Unix(), 36), atomic.AddInt64(&portForwardContainers, 1))
+	imageCfg := &container.Config{
+		Image:        d.host.PortForwardImage,
+		Entrypoint:   []string{"nc"},
+		Cmd:          []string{address, port},
+		OpenStdin:    true,
+		StdinOnce:    true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+	hostCfg := &container.HostConfig{
+		NetworkMode: "host",
+	}
+	created, err := docker.CreateContainer(ctx, d.dClient, d.host.Address, containerName, imageCfg, hostCfg)
+	if err != nil {
+		return nil, err
+	}
+	conn := &portForwardConn{dClient: d.dClient, containerID: created.ID, containerName: containerName, hostname: d.host.Address}
+	// the container is attached before it's started, so none of its output is lost
+	conn.stream, err = d.dClient.ContainerAttach(ctx, created.ID, types.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		conn.remove()
+		return nil, fmt.Errorf("Failed to attach to port forward container [%s] on host [%s]: %v", containerName, d.host.Address, err)
+	}
+	if err := d.dClient.ContainerStart(ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Failed to start port forward container [%s] on host [%s]: %v", containerName, d.host.Address, err)
+	}
+	logrus.Debugf("[dialer] Forwarding to [%s] through container [%s] on host [%s]", addr, containerName, d.host.Address)
+	var stdoutWriter *io.PipeWriter
+	conn.stdout, stdoutWriter = io.Pipe()
+	go func() {
+		stderr := &logWriter{prefix: fmt.Sprintf("[dialer] Port forward container [%s] on host [%s]: ", containerName, d.host.Address)}
+		_, err := stdcopy.StdCopy(stdoutWriter, stderr, conn.stream.Reader)
+		stdoutWriter.CloseWithError(err)
+	}()
+	return conn, nil
+}
+
+// portForwardConn writes to the stdin of the helper container and reads its demultiplexed stdout,
+// the container is removed when the connection is closed
+type portForwardConn struct {
+	stream        types.HijackedResponse
+	stdout        *io.PipeReader
+	dClient       *client.Client
+	containerID   string
+	containerName string
+	hostname      string
+	once          sync.Once
+}
+
+func (c *portForwardConn) Read(b []byte) (int, error) {
+	return c.stdout.Read(b)
+}
+
+func (c *portForwardConn) Write(b []byte) (int, error) {
+	return c.stream.Conn.Write(b)
+}
+
+func (c *portForwardConn) Close() error {
+	c.once.Do(func() {
+		c.stream.Close()
+		if c.stdout != nil {
+			c.stdout.Close()
+		}
+		c.remove()
+	})
+	return nil
+}
+
+func (c *portForwardConn) remove() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*DockerDialerTimeout)
+	defer cancel()
+	if err := c.dClient.ContainerRemove(ctx, c.containerID, types.ContainerRemoveOptions{Force: true}); err != nil {
+		logrus.Debugf("[dialer] Failed to remove port forward container [%s] on host [%s]: %v", c.containerName, c.hostname, err)
+	}
+}
+
+func (c *portForwardConn) LocalAddr() net.Addr {
+	return c.stream.Conn.LocalAddr()
+}
+
+func (c *portForwardConn) RemoteAddr() net.Addr {
+	return c.stream.Conn.RemoteAddr()
+}
+
+func (c *portForwardConn) SetDeadline(t time.Time) error {
+	return c.stream.Conn.SetDeadline(t)
+}
+
+func (c *portForwardConn) SetReadDeadline(t time.Time) error {
+	return c.stream.Conn.SetReadDeadline(t)
+}
+
+func (c *portForwardConn) SetWriteDeadline(t time.Time) error {
+	return c.stream.Conn.SetWriteDeadline(t)
+}
+
+// logWriter logs the stderr of the helper containers at debug level
+type logWriter struct {
+	prefix string
+}
+
+func (w *logWriter) Write(b []byte) (int, error) {
+	logrus.Debugf("%s%s", w.prefix, b)
+	return len(b), nil
+}
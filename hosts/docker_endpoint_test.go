@@ -0,0 +1,133 @@
+package hosts
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+func TestValidateDockerEndpoint(t *testing.T) {
+	for endpoint, valid := range map[string]bool{
+		"tcp://10.0.0.1:2376":     true,
+		"tcp://node.example:2376": true,
+		"tcp://10.0.0.1":          false,
+		"https://10.0.0.1:2376":   false,
+		"unix:///var/run/docker":  false,
+	} {
+		if err := ValidateDockerEndpoint(endpoint); (err == nil) != valid {
+			t.Errorf("Expected Docker endpoint [%s] to be valid: %v, got: %v", endpoint, valid, err)
+		}
+	}
+}
+
+func TestDockerEndpointClientUsesMutualTLS(t *testing.T) {
+	caCert, caKey := newTestCertificate(t, nil, nil, "docker-ca")
+	serverCert, serverKey := newTestCertificate(t, caCert, caKey, "docker-server")
+	clientCert, clientKey := newTestCertificate(t, caCert, caKey, "rke")
+
+	caPool := x509.NewCertPool()
+	caPool.AddCert(caCert)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "rke" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(types.Info{ServerVersion: "18.09.2"})
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey}},
+		ClientCAs:    caPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	host := &Host{
+		RKEConfigNode: v3.RKEConfigNode{
+			Address:        "127.0.0.1",
+			DockerEndpoint: "tcp://" + server.Listener.Addr().String(),
+			DockerCACert:   encodeTestCertificate(caCert),
+			DockerCert:     encodeTestCertificate(clientCert),
+			DockerKey:      encodeTestKey(t, clientKey),
+		},
+	}
+	dClient, err := host.newDockerEndpointClient()
+	if err != nil {
+		t.Fatalf("Failed to create Docker endpoint client: %v", err)
+	}
+	info, err := dClient.Info(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get Docker info over TLS: %v", err)
+	}
+	if info.ServerVersion != "18.09.2" {
+		t.Fatalf("Expected Docker version [18.09.2], got [%s]", info.ServerVersion)
+	}
+
+	host.DockerCACert = encodeTestCertificate(clientCert)
+	dClient, err = host.newDockerEndpointClient()
+	if err != nil {
+		t.Fatalf("Failed to create Docker endpoint client: %v", err)
+	}
+	if _, err := dClient.Info(context.Background()); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("Expected the Docker endpoint certificate check to fail with the wrong CA, got: %v", err)
+	}
+}
+
+func newTestCertificate(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, commonName string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		ca, caKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return cert, key
+}
+
+func encodeTestCertificate(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+func encodeTestKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}))
+}
@@ -37,7 +37,13 @@ type Host struct {
 	DockerInfo          types.Info
 	UpdateWorker        bool
 	PrefixPath          string
-	BastionHost         v3.BastionHost
+	BastionHosts        []v3.BastionHost
+	HostKeyVerifier     *HostKeyVerifier
+	SSHConfig           *SSHConfig
+	SSHMaxChannels      int
+	// the helper image and registries used to reach the ports of the hosts with a Docker endpoint
+	PortForwardImage     string
+	PrivateRegistriesMap map[string]v3.PrivateRegistry
 }
 
 const (
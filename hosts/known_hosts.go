@@ -0,0 +1,313 @@
+package hosts
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	HostKeyCheckingNone            = "none"
+	HostKeyCheckingKnownHosts      = "known_hosts"
+	HostKeyCheckingTrustOnFirstUse = "trust_on_first_use"
+	DefaultKnownHostsPath          = "~/.ssh/known_hosts"
+	SSHFingerprintPrefix           = "SHA256:"
+	// HostKeyVerificationError starts the errors of the host key checks, they aren't connectivity problems
+	HostKeyVerificationError = "SSH host key verification failed"
+
+	knownHostsRevokedMarker = "@revoked"
+	knownHostsHashPrefix    = "|1|"
+	defaultSSHPort          = "22"
+)
+
+// HostKeyVerifier checks the SSH host keys of the nodes and the bastion host. A pinned fingerprint is checked
+// whatever the mode, otherwise the key is checked against a known_hosts file or against the keys trusted on first use.
+type HostKeyVerifier struct {
+	// id tells the verifiers apart in the SSH connection pool, a connection is only shared by the dialers of one verifier
+	id             uint64
+	mode           string
+	knownHostsPath string
+	lock           sync.Mutex
+	knownHosts     []knownHostsLine
+	knownHostsErr  error
+	loaded         bool
+	// trusted keys in authorized_keys format by SSH address, they're kept in the cluster state
+	trustedKeys map[string]string
+}
+
+var hostKeyVerifierIDs uint64
+
+type knownHostsLine struct {
+	marker   string
+	patterns []string
+	key      ssh.PublicKey
+}
+
+func NewHostKeyVerifier(mode, knownHostsPath string, trustedKeys map[string]string) *HostKeyVerifier {
+	v := &HostKeyVerifier{
+		id:             atomic.AddUint64(&hostKeyVerifierIDs, 1),
+		mode:           mode,
+		knownHostsPath: knownHostsPath,
+		trustedKeys:    map[string]string{},
+	}
+	if len(v.knownHostsPath) == 0 {
+		v.knownHostsPath = DefaultKnownHostsPath
+	}
+	for address, key := range trustedKeys {
+		v.trustedKeys[address] = key
+	}
+	return v
+}
+
+// TrustedKeys returns the host keys trusted on first use so far, including the ones it was created with
+func (v *HostKeyVerifier) TrustedKeys() map[string]string {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	trustedKeys := map[string]string{}
+	for address, key := range v.trustedKeys {
+		trustedKeys[address] = key
+	}
+	return trustedKeys
+}
+
+// HostKeyCallback returns the host key check of an SSH address and the host key algorithms to negotiate,
+// the algorithms are limited to the types of the expected keys so the server offers a key that can be checked
+func (v *HostKeyVerifier) HostKeyCallback(sshAddress, fingerprint string) (ssh.HostKeyCallback, []string) {
+	if len(fingerprint) > 0 {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if keyFingerprint := ssh.FingerprintSHA256(key); keyFingerprint != fingerprint {
+				return fmt.Errorf("%s for host [%s]: host key fingerprint [%s] doesn't match the configured fingerprint [%s]", HostKeyVerificationError, sshAddress, keyFingerprint, fingerprint)
+			}
+			return nil
+		}, nil
+	}
+	switch v.mode {
+	case HostKeyCheckingKnownHosts:
+		return v.checkKnownHosts, v.getKnownHostsKeyTypes(sshAddress)
+	case HostKeyCheckingTrustOnFirstUse:
+		var keyTypes []string
+		v.lock.Lock()
+		if trustedKey, ok := v.trustedKeys[sshAddress]; ok {
+			if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(trustedKey)); err == nil {
+				keyTypes = []string{key.Type()}
+			}
+		}
+		v.lock.Unlock()
+		return v.checkTrustedKey, keyTypes
+	}
+	return ssh.InsecureIgnoreHostKey(), nil
+}
+
+func (v *HostKeyVerifier) checkTrustedKey(sshAddress string, remote net.Addr, key ssh.PublicKey) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	trustedKey, ok := v.trustedKeys[sshAddress]
+	if !ok {
+		logrus.Infof("Trusting SSH host key [%s] of host [%s] on first use", ssh.FingerprintSHA256(key), sshAddress)
+		v.trustedKeys[sshAddress] = authorizedKey
+		return nil
+	}
+	if trustedKey != authorizedKey {
+		return fmt.Errorf("%s for host [%s]: host key [%s] changed since it was trusted on first use, remove the host key from the cluster state if the change is expected", HostKeyVerificationError, sshAddress, ssh.FingerprintSHA256(key))
+	}
+	return nil
+}
+
+func (v *HostKeyVerifier) checkKnownHosts(sshAddress string, remote net.Addr, key ssh.PublicKey) error {
+	lines, err := v.loadKnownHosts()
+	if err != nil {
+		return fmt.Errorf("%s for host [%s]: %v", HostKeyVerificationError, sshAddress, err)
+	}
+	hostName := getKnownHostsName(sshAddress)
+	found := false
+	for _, line := range lines {
+		if !line.match(hostName) {
+			continue
+		}
+		keyMatch := bytes.Equal(line.key.Marshal(), key.Marshal())
+		if line.marker == knownHostsRevokedMarker && keyMatch {
+			return fmt.Errorf("%s for host [%s]: host key [%s] is revoked in [%s]", HostKeyVerificationError, sshAddress, ssh.FingerprintSHA256(key), v.knownHostsPath)
+		}
+		if len(line.marker) > 0 {
+			continue
+		}
+		found = true
+		if keyMatch {
+			return nil
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s for host [%s]: host [%s] is not found in [%s]", HostKeyVerificationError, sshAddress, hostName, v.knownHostsPath)
+	}
+	return fmt.Errorf("%s for host [%s]: host key [%s] doesn't match the keys in [%s]", HostKeyVerificationError, sshAddress, ssh.FingerprintSHA256(key), v.knownHostsPath)
+}
+
+func (v *HostKeyVerifier) getKnownHostsKeyTypes(sshAddress string) []string {
+	lines, err := v.loadKnownHosts()
+	if err != nil {
+		return nil
+	}
+	hostName := getKnownHostsName(sshAddress)
+	keyTypes := []string{}
+	for _, line := range lines {
+		if len(line.marker) == 0 && line.match(hostName) {
+			keyTypes = append(keyTypes, line.key.Type())
+		}
+	}
+	if len(keyTypes) == 0 {
+		return nil
+	}
+	return keyTypes
+}
+
+// loadKnownHosts reads the known_hosts file once, the lines that can't be parsed are skipped like ssh does
+func (v *HostKeyVerifier) loadKnownHosts() ([]knownHostsLine, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.loaded {
+		return v.knownHosts, v.knownHostsErr
+	}
+	v.loaded = true
+	knownHostsPath := v.knownHostsPath
+	if strings.HasPrefix(knownHostsPath, "~/") {
+		knownHostsPath = filepath.Join(userHome(), knownHostsPath[2:])
+	}
+	file, err := os.Open(knownHostsPath)
+	if err != nil {
+		v.knownHostsErr = fmt.Errorf("Error while reading known hosts file: %v", err)
+		return nil, v.knownHostsErr
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line, err := parseKnownHostsLine(scanner.Text())
+		if err != nil {
+			logrus.Debugf("Skipping line %d of known hosts file [%s]: %v", lineNumber, v.knownHostsPath, err)
+			continue
+		}
+		if line != nil {
+			v.knownHosts = append(v.knownHosts, *line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		v.knownHostsErr = fmt.Errorf("Error while reading known hosts file: %v", err)
+	}
+	return v.knownHosts, v.knownHostsErr
+}
+
+func parseKnownHostsLine(text string) (*knownHostsLine, error) {
+	text = strings.TrimSpace(text)
+	if len(text) == 0 || strings.HasPrefix(text, "#") {
+		return nil, nil
+	}
+	line := &knownHostsLine{}
+	fields := strings.Fields(text)
+	if strings.HasPrefix(fields[0], "@") {
+		line.marker = fields[0]
+		fields = fields[1:]
+	}
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("missing fields")
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(fields[1] + " " + fields[2]))
+	if err != nil {
+		return nil, err
+	}
+	line.patterns = strings.Split(fields[0], ",")
+	line.key = key
+	return line, nil
+}
+
+func (l knownHostsLine) match(hostName string) bool {
+	return matchPatternList(l.patterns, func(pattern string) bool {
+		return matchKnownHostsPattern(pattern, hostName)
+	})
+}
+
+// matchPatternList checks a list of host patterns, a matching negated pattern excludes the host
+func matchPatternList(patterns []string, match func(pattern string) bool) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if !match(strings.TrimPrefix(pattern, "!")) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+func matchKnownHostsPattern(pattern, hostName string) bool {
+	if strings.HasPrefix(pattern, knownHostsHashPrefix) {
+		// hashed host names are |1|base64(salt)|base64(hmac-sha1(salt, host name))
+		parts := strings.Split(strings.TrimPrefix(pattern, knownHostsHashPrefix), "|")
+		if len(parts) != 2 {
+			return false
+		}
+		salt, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return false
+		}
+		hash, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(hostName))
+		return hmac.Equal(mac.Sum(nil), hash)
+	}
+	return matchWildcard(strings.ToLower(pattern), strings.ToLower(hostName))
+}
+
+// matchWildcard matches the * and ? wildcards of the known_hosts and ssh config host patterns
+func matchWildcard(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := 0; i <= len(name); i++ {
+				if matchWildcard(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(name) == 0 {
+				return false
+			}
+		default:
+			if len(name) == 0 || pattern[0] != name[0] {
+				return false
+			}
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// getKnownHostsName returns the name of an SSH address in the known_hosts format, the port is only added if it's not 22
+func getKnownHostsName(sshAddress string) string {
+	host, port, err := net.SplitHostPort(sshAddress)
+	if err != nil {
+		return sshAddress
+	}
+	if port == defaultSSHPort {
+		return host
+	}
+	return fmt.Sprintf("[%s]:%s", host, port)
+}
@@ -0,0 +1,132 @@
+package hosts
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKnownHostsHostKeyCallback(t *testing.T) {
+	nodeKey, otherKey, revokedKey := newTestHostKey(t), newTestHostKey(t), newTestHostKey(t)
+	knownHosts := strings.Join([]string{
+		"# cluster nodes",
+		"10.0.0.1,node1 " + authorizedKey(nodeKey),
+		hashKnownHostsName("10.0.0.2") + " " + authorizedKey(nodeKey),
+		"[10.0.0.3]:2222 " + authorizedKey(nodeKey),
+		"10.0.1.* " + authorizedKey(nodeKey),
+		"@revoked 10.0.0.4 " + authorizedKey(revokedKey),
+		"10.0.0.4 " + authorizedKey(revokedKey),
+	}, "\n")
+	dir, err := ioutil.TempDir("", "rke-known-hosts")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	knownHostsPath := filepath.Join(dir, "known_hosts")
+	if err := ioutil.WriteFile(knownHostsPath, []byte(knownHosts), 0600); err != nil {
+		t.Fatalf("Failed to write known hosts file: %v", err)
+	}
+	verifier := NewHostKeyVerifier(HostKeyCheckingKnownHosts, knownHostsPath, nil)
+
+	tests := []struct {
+		address string
+		key     ssh.PublicKey
+		err     string
+	}{
+		{"10.0.0.1:22", nodeKey, ""},
+		{"10.0.0.2:22", nodeKey, ""},
+		{"10.0.0.3:2222", nodeKey, ""},
+		{"10.0.1.5:22", nodeKey, ""},
+		{"10.0.0.1:22", otherKey, "doesn't match"},
+		{"10.0.0.3:22", nodeKey, "not found"},
+		{"10.0.0.4:22", revokedKey, "revoked"},
+		{"10.0.0.5:22", nodeKey, "not found"},
+	}
+	for _, tt := range tests {
+		callback, algorithms := verifier.HostKeyCallback(tt.address, "")
+		err := callback(tt.address, nil, tt.key)
+		assertHostKeyError(t, tt.address, err, tt.err)
+		if len(tt.err) == 0 && (len(algorithms) != 1 || algorithms[0] != nodeKey.Type()) {
+			t.Fatalf("Expected host key algorithms [%s] for [%s], got %v", nodeKey.Type(), tt.address, algorithms)
+		}
+	}
+}
+
+func TestTrustOnFirstUseHostKeyCallback(t *testing.T) {
+	nodeKey, otherKey := newTestHostKey(t), newTestHostKey(t)
+	verifier := NewHostKeyVerifier(HostKeyCheckingTrustOnFirstUse, "", map[string]string{"10.0.0.2:22": authorizedKey(nodeKey)})
+
+	callback, _ := verifier.HostKeyCallback("10.0.0.1:22", "")
+	assertHostKeyError(t, "10.0.0.1:22", callback("10.0.0.1:22", nil, nodeKey), "")
+	assertHostKeyError(t, "10.0.0.1:22", callback("10.0.0.1:22", nil, nodeKey), "")
+	assertHostKeyError(t, "10.0.0.1:22", callback("10.0.0.1:22", nil, otherKey), "changed since it was trusted")
+
+	callback, algorithms := verifier.HostKeyCallback("10.0.0.2:22", "")
+	if len(algorithms) != 1 || algorithms[0] != nodeKey.Type() {
+		t.Fatalf("Expected host key algorithms [%s] for trusted key, got %v", nodeKey.Type(), algorithms)
+	}
+	assertHostKeyError(t, "10.0.0.2:22", callback("10.0.0.2:22", nil, otherKey), "changed since it was trusted")
+
+	trustedKeys := verifier.TrustedKeys()
+	if len(trustedKeys) != 2 || trustedKeys["10.0.0.1:22"] != authorizedKey(nodeKey) {
+		t.Fatalf("Expected the first used host key to be trusted, got %v", trustedKeys)
+	}
+}
+
+func TestPinnedHostKeyFingerprint(t *testing.T) {
+	nodeKey, otherKey := newTestHostKey(t), newTestHostKey(t)
+	verifier := NewHostKeyVerifier(HostKeyCheckingNone, "", nil)
+
+	callback, _ := verifier.HostKeyCallback("10.0.0.1:22", ssh.FingerprintSHA256(nodeKey))
+	assertHostKeyError(t, "10.0.0.1:22", callback("10.0.0.1:22", nil, nodeKey), "")
+	assertHostKeyError(t, "10.0.0.1:22", callback("10.0.0.1:22", nil, otherKey), "doesn't match the configured fingerprint")
+
+	callback, _ = verifier.HostKeyCallback("10.0.0.1:22", "")
+	assertHostKeyError(t, "10.0.0.1:22", callback("10.0.0.1:22", nil, otherKey), "")
+}
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to convert host key: %v", err)
+	}
+	return key
+}
+
+func authorizedKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func hashKnownHostsName(hostName string) string {
+	salt := []byte("0123456789abcdefghij")
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(hostName))
+	return fmt.Sprintf("|1|%s|%s", base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+func assertHostKeyError(t *testing.T, address string, err error, expected string) {
+	if len(expected) == 0 {
+		if err != nil {
+			t.Fatalf("Expected host key of [%s] to be accepted, got: %v", address, err)
+		}
+		return
+	}
+	if err == nil || !strings.Contains(err.Error(), expected) || !strings.HasPrefix(err.Error(), HostKeyVerificationError) {
+		t.Fatalf("Expected host key verification error [%s] for [%s], got: %v", expected, address, err)
+	}
+}
@@ -0,0 +1,191 @@
+package hosts
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultSSHKeyPath = "~/.ssh/id_rsa"
+
+	proxyJumpNone = "none"
+	// maxProxyJumpDepth stops ProxyJump loops in the ssh config
+	maxProxyJumpDepth = 10
+)
+
+// SSHConfig is the part of an OpenSSH client config used to build the dialers,
+// the HostName, User, Port, IdentityFile and ProxyJump options of the Host blocks
+type SSHConfig struct {
+	path   string
+	blocks []sshConfigBlock
+}
+
+type sshConfigBlock struct {
+	patterns []string
+	// options by lower case keyword, the first value of a keyword is used like ssh does
+	options map[string]string
+}
+
+// sshTarget is a host the dialers connect to and the credentials used to connect to it
+type sshTarget struct {
+	address     string
+	port        string
+	user        string
+	sshKey      string
+	sshKeyPath  string
+	sshCert     string
+	sshCertPath string
+	fingerprint string
+	agentAuth   bool
+	// passphrase of an encrypted key, the passphrase options are used if it's not set
+	keyPhrase string
+}
+
+func LoadSSHConfig(sshConfigPath string) (*SSHConfig, error) {
+	configPath := sshConfigPath
+	if strings.HasPrefix(configPath, "~/") {
+		configPath = filepath.Join(userHome(), configPath[2:])
+	}
+	file, err := os.Open(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading SSH config file: %v", err)
+	}
+	defer file.Close()
+	config := &SSHConfig{path: sshConfigPath}
+	// options before the first Host block apply to all hosts
+	block := &sshConfigBlock{patterns: []string{"*"}, options: map[string]string{}}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		keyword, args := parseSSHConfigLine(scanner.Text())
+		switch keyword {
+		case "":
+			continue
+		case "host":
+			config.blocks = append(config.blocks, *block)
+			block = &sshConfigBlock{patterns: args, options: map[string]string{}}
+		case "match":
+			// Match blocks aren't supported, their options are skipped
+			logrus.Debugf("Skipping unsupported Match block on line %d of SSH config file [%s]", lineNumber, sshConfigPath)
+			config.blocks = append(config.blocks, *block)
+			block = &sshConfigBlock{options: map[string]string{}}
+		case "include":
+			logrus.Debugf("Skipping unsupported Include on line %d of SSH config file [%s]", lineNumber, sshConfigPath)
+		default:
+			if _, ok := block.options[keyword]; !ok && len(args) > 0 {
+				block.options[keyword] = strings.Join(args, " ")
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error while reading SSH config file: %v", err)
+	}
+	config.blocks = append(config.blocks, *block)
+	return config, nil
+}
+
+// parseSSHConfigLine splits a line into its lower case keyword and arguments, the keyword can be followed by = and the arguments can be quoted
+func parseSSHConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+	args := []string{}
+	for len(rest) > 0 {
+		if rest[0] == '"' {
+			end = strings.Index(rest[1:], "\"")
+			if end < 0 {
+				args = append(args, rest[1:])
+				break
+			}
+			args = append(args, rest[1:end+1])
+			rest = strings.TrimLeft(rest[end+2:], " \t")
+			continue
+		}
+		end = strings.IndexAny(rest, " \t")
+		if end < 0 {
+			args = append(args, rest)
+			break
+		}
+		args = append(args, rest[:end])
+		rest = strings.TrimLeft(rest[end:], " \t")
+	}
+	return keyword, args
+}
+
+// get returns the first value of an option for a host alias
+func (c *SSHConfig) get(alias, keyword string) string {
+	for _, block := range c.blocks {
+		value, ok := block.options[keyword]
+		if !ok {
+			continue
+		}
+		if matchPatternList(block.patterns, func(pattern string) bool {
+			return matchWildcard(strings.ToLower(pattern), strings.ToLower(alias))
+		}) {
+			return value
+		}
+	}
+	return ""
+}
+
+// resolve fills in the empty user, port and key path of a target from the ssh config of its address, and replaces
+// the address with the configured HostName. The ProxyJump of the target is returned.
+func (c *SSHConfig) resolve(target *sshTarget) string {
+	alias := target.address
+	if hostName := c.get(alias, "hostname"); len(hostName) > 0 {
+		target.address = strings.Replace(hostName, "%h", alias, -1)
+	}
+	if len(target.user) == 0 {
+		target.user = c.get(alias, "user")
+	}
+	if len(target.port) == 0 {
+		target.port = c.get(alias, "port")
+	}
+	if len(target.sshKey) == 0 && len(target.sshKeyPath) == 0 {
+		if identityFile := c.get(alias, "identityfile"); len(identityFile) > 0 {
+			target.sshKeyPath = expandSSHConfigTokens(identityFile, alias, target.user)
+		}
+	}
+	return c.get(alias, "proxyjump")
+}
+
+func expandSSHConfigTokens(value, alias, user string) string {
+	if strings.HasPrefix(value, "~/") {
+		value = filepath.Join(userHome(), value[2:])
+	}
+	return strings.NewReplacer("%%", "%", "%d", userHome(), "%h", alias, "%r", user).Replace(value)
+}
+
+// parseProxyJump parses the [user@]host[:port] jump hosts of a ProxyJump, in the order they're connected to
+func parseProxyJump(proxyJump string) []sshTarget {
+	if strings.ToLower(proxyJump) == proxyJumpNone {
+		return nil
+	}
+	jumps := []sshTarget{}
+	for _, jump := range strings.Split(proxyJump, ",") {
+		target := sshTarget{}
+		if i := strings.LastIndex(jump, "@"); i >= 0 {
+			target.user, jump = jump[:i], jump[i+1:]
+		}
+		if host, port, err := net.SplitHostPort(jump); err == nil {
+			target.address, target.port = host, port
+		} else {
+			target.address = jump
+		}
+		jumps = append(jumps, target)
+	}
+	return jumps
+}
@@ -0,0 +1,123 @@
+package hosts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+const testSSHConfig = `
+# corporate jump host, then the DC bastion
+Host jump
+  HostName jump.example.com
+  User jumper
+  Port 2200
+
+Host bastion
+  HostName=10.1.0.1
+  ProxyJump jump
+
+Match user root
+  User ignored
+
+Host node-* !node-direct
+  HostName %h.dc.example.com
+  ProxyJump admin@bastion:2222
+  IdentityFile "~/.ssh/node key"
+
+Host *
+  User rancher
+  User ignored
+`
+
+func TestSSHConfigResolvesProxyJumpChain(t *testing.T) {
+	sshConfig := loadTestSSHConfig(t)
+	host := &Host{
+		RKEConfigNode: v3.RKEConfigNode{Address: "node-1", SSHAgentAuth: true},
+		SSHConfig:     sshConfig,
+	}
+	dialer, err := newDialer(host, "docker")
+	if err != nil {
+		t.Fatalf("Failed to create dialer: %v", err)
+	}
+	assertDialerChain(t, dialer, []string{
+		"rancher@node-1.dc.example.com:22",
+		"admin@10.1.0.1:2222",
+		"jumper@jump.example.com:2200",
+	})
+
+	target := sshTarget{address: "node-1"}
+	sshConfig.resolve(&target)
+	if expected := filepath.Join(userHome(), ".ssh/node key"); target.sshKeyPath != expected {
+		t.Fatalf("Expected identity file [%s], got [%s]", expected, target.sshKeyPath)
+	}
+
+	direct, err := newDialer(&Host{RKEConfigNode: v3.RKEConfigNode{Address: "node-direct", SSHAgentAuth: true}, SSHConfig: sshConfig}, "docker")
+	if err != nil {
+		t.Fatalf("Failed to create dialer: %v", err)
+	}
+	assertDialerChain(t, direct, []string{"rancher@node-direct:22"})
+}
+
+func TestBastionHostsOverrideProxyJump(t *testing.T) {
+	host := &Host{
+		RKEConfigNode: v3.RKEConfigNode{Address: "node-1", User: "docker", Port: "2022", SSHAgentAuth: true},
+		BastionHosts: []v3.BastionHost{
+			{Address: "jump", User: "corp"},
+			{Address: "10.2.0.1", Port: "22", User: "dc"},
+		},
+		SSHConfig: loadTestSSHConfig(t),
+	}
+	dialer, err := newDialer(host, "docker")
+	if err != nil {
+		t.Fatalf("Failed to create dialer: %v", err)
+	}
+	assertDialerChain(t, dialer, []string{
+		"docker@node-1.dc.example.com:2022",
+		"dc@10.2.0.1:22",
+		"corp@jump.example.com:2200",
+	})
+
+	host.SSHConfig = nil
+	dialer, err = newDialer(host, "docker")
+	if err != nil {
+		t.Fatalf("Failed to create dialer: %v", err)
+	}
+	assertDialerChain(t, dialer, []string{"docker@node-1:2022", "dc@10.2.0.1:22", "corp@jump:22"})
+}
+
+func loadTestSSHConfig(t *testing.T) *SSHConfig {
+	dir, err := ioutil.TempDir("", "rke-ssh-config")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(configPath, []byte(testSSHConfig), 0600); err != nil {
+		t.Fatalf("Failed to write SSH config: %v", err)
+	}
+	sshConfig, err := LoadSSHConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load SSH config: %v", err)
+	}
+	return sshConfig
+}
+
+// assertDialerChain checks the user@address of a dialer and of its bastion dialers, from the host to the first jump host
+func assertDialerChain(t *testing.T, d *dialer, expected []string) {
+	chain := []string{}
+	for ; d != nil; d = d.bastionDialer {
+		chain = append(chain, d.username+"@"+d.sshAddress)
+	}
+	if len(chain) != len(expected) {
+		t.Fatalf("Expected dialer chain %v, got %v", expected, chain)
+	}
+	for i := range chain {
+		if chain[i] != expected[i] {
+			t.Fatalf("Expected dialer chain %v, got %v", expected, chain)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package hosts
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	// SSHKeyPassphraseAttempts is how many times the passphrase of a key is prompted for before giving up
+	SSHKeyPassphraseAttempts = 3
+
+	openSSHKeyMagic    = "openssh-key-v1\x00"
+	openSSHKeyPEMType  = "OPENSSH PRIVATE KEY"
+	openSSHCipherNone  = "none"
+	pemEncryptedHeader = "Proc-Type"
+)
+
+// sshKeyPassphrases holds the passphrase options and the passphrases of the decrypted keys by key path,
+// so every key is only prompted for once per run
+var sshKeyPassphrases = &sshKeyPassphraseCache{passphrases: map[string]string{}}
+
+type sshKeyPassphraseCache struct {
+	lock           sync.Mutex
+	passphrase     string
+	passphraseFile string
+	passphrases    map[string]string
+}
+
+// SetSSHKeyPassphrase sets the passphrase used to decrypt the encrypted SSH keys, the passphrase file takes
+// precedence over the passphrase
+func SetSSHKeyPassphrase(passphrase, passphraseFile string) {
+	sshKeyPassphrases.lock.Lock()
+	defer sshKeyPassphrases.lock.Unlock()
+	sshKeyPassphrases.passphrase = passphrase
+	sshKeyPassphrases.passphraseFile = passphraseFile
+	sshKeyPassphrases.passphrases = map[string]string{}
+}
+
+// parsePrivateKey parses an SSH private key, encrypted keys are decrypted with the passphrase of the host,
+// the passphrase options or a passphrase prompted for on the terminal
+func parsePrivateKey(keyBuff, keyName, keyPhrase string) (ssh.Signer, error) {
+	if !isEncryptedPrivateKey([]byte(keyBuff)) {
+		return ssh.ParsePrivateKey([]byte(keyBuff))
+	}
+	if len(keyPhrase) > 0 {
+		return parseEncryptedPrivateKey(keyBuff, keyName, keyPhrase)
+	}
+	return sshKeyPassphrases.parsePrivateKey(keyBuff, keyName)
+}
+
+func (c *sshKeyPassphraseCache) parsePrivateKey(keyBuff, keyName string) (ssh.Signer, error) {
+	// the lock is held while prompting, so the dialers of the same key don't all prompt for it
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if passphrase, ok := c.passphrases[keyName]; ok {
+		return parseEncryptedPrivateKey(keyBuff, keyName, passphrase)
+	}
+	passphrase, err := c.getPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) > 0 {
+		signer, err := parseEncryptedPrivateKey(keyBuff, keyName, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		c.passphrases[keyName] = passphrase
+		return signer, nil
+	}
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("SSH private key [%s] is encrypted, set its passphrase with --ssh-key-passphrase, --ssh-key-passphrase-file or the RKE_SSH_KEY_PASSPHRASE environment variable, or use ssh-agent with --ssh-agent-auth", keyName)
+	}
+	for attempt := 1; ; attempt++ {
+		fmt.Fprintf(os.Stderr, "Enter passphrase for SSH private key [%s]: ", keyName)
+		input, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read passphrase for SSH private key [%s]: %v", keyName, err)
+		}
+		signer, err := ssh.ParsePrivateKeyWithPassphrase([]byte(keyBuff), input)
+		if err == nil {
+			c.passphrases[keyName] = string(input)
+			return signer, nil
+		}
+		if err != x509.IncorrectPasswordError || attempt == SSHKeyPassphraseAttempts {
+			return nil, fmt.Errorf("Failed to decrypt SSH private key [%s]: %v", keyName, err)
+		}
+		fmt.Fprintln(os.Stderr, "Incorrect passphrase, try again.")
+	}
+}
+
+func (c *sshKeyPassphraseCache) getPassphrase() (string, error) {
+	if len(c.passphraseFile) > 0 {
+		buff, err := ioutil.ReadFile(c.passphraseFile)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read SSH key passphrase file: %v", err)
+		}
+		return strings.TrimRight(string(buff), "\r\n"), nil
+	}
+	return c.passphrase, nil
+}
+
+func parseEncryptedPrivateKey(keyBuff, keyName, passphrase string) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKeyWithPassphrase([]byte(keyBuff), []byte(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt SSH private key [%s]: %v", keyName, err)
+	}
+	return signer, nil
+}
+
+// isEncryptedPrivateKey checks the PEM headers of legacy keys and the cipher of OpenSSH keys
+func isEncryptedPrivateKey(keyBuff []byte) bool {
+	block, _ := pem.Decode(keyBuff)
+	if block == nil {
+		// not a PEM key, ssh.ParsePrivateKey returns the error
+		return false
+	}
+	if block.Type != openSSHKeyPEMType {
+		return strings.Contains(block.Headers[pemEncryptedHeader], "ENCRYPTED")
+	}
+	if !bytes.HasPrefix(block.Bytes, []byte(openSSHKeyMagic)) {
+		return false
+	}
+	header := struct {
+		CipherName string
+		KdfName    string
+		Rest       []byte `ssh:"rest"`
+	}{}
+	if err := ssh.Unmarshal(block.Bytes[len(openSSHKeyMagic):], &header); err != nil {
+		return false
+	}
+	return header.CipherName != openSSHCipherNone
+}
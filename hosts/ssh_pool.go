@@ -0,0 +1,184 @@
+package hosts
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	SSHKeepAliveInterval = 30 * time.Second
+	SSHKeepAliveTimeout  = 15 * time.Second
+	// SSHIdleTimeout closes the pooled connections without open channels, so long running processes don't keep them forever
+	SSHIdleTimeout = 5 * time.Minute
+
+	sshKeepAliveRequest = "keepalive@openssh.com"
+)
+
+// sshClients shares one SSH connection per host and bastion host between the dialers with the same credentials
+// and host key checks. The connections are closed by CloseSSHClients once a command is done, or when they're idle.
+var sshClients = &sshClientPool{clients: map[string]*pooledSSHClient{}}
+
+type sshClientPool struct {
+	lock    sync.Mutex
+	clients map[string]*pooledSSHClient
+}
+
+// pooledSSHClient is the connection to a host, it's reconnected on the next use once it's closed
+type pooledSSHClient struct {
+	lock     sync.Mutex
+	address  string
+	client   *ssh.Client
+	channels chan struct{}
+	inUse    int
+	lastUsed time.Time
+}
+
+type pooledConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *pooledConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// get returns the pooled connection of a key, the channels of the connection are limited to maxChannels if it's set
+func (p *sshClientPool) get(key, address string, maxChannels int) *pooledSSHClient {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pooled, ok := p.clients[key]
+	if !ok {
+		pooled = &pooledSSHClient{address: address}
+		if maxChannels > 0 {
+			pooled.channels = make(chan struct{}, maxChannels)
+		}
+		p.clients[key] = pooled
+	}
+	return pooled
+}
+
+// CloseSSHClients closes all the pooled SSH connections, it's called when a command is done
+func CloseSSHClients() {
+	sshClients.lock.Lock()
+	defer sshClients.lock.Unlock()
+	for key, pooled := range sshClients.clients {
+		pooled.lock.Lock()
+		if pooled.client != nil {
+			pooled.client.Close()
+			pooled.client = nil
+		}
+		pooled.lock.Unlock()
+		delete(sshClients.clients, key)
+	}
+}
+
+// getClient returns the connection of the host, connecting if there is none yet or the last one was closed
+func (p *pooledSSHClient) getClient(connect func() (*ssh.Client, error)) (*ssh.Client, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.client != nil {
+		return p.client, nil
+	}
+	client, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("[dialer] Opened pooled SSH connection to [%s]", p.address)
+	p.client = client
+	p.lastUsed = time.Now()
+	go p.keepAlive(client)
+	return client, nil
+}
+
+// reset drops a broken connection from the pool, the next dial reconnects
+func (p *pooledSSHClient) reset(client *ssh.Client) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.client == client {
+		logrus.Debugf("[dialer] Closing pooled SSH connection to [%s]", p.address)
+		p.client = nil
+	}
+	client.Close()
+}
+
+// openChannel reserves a channel on the connection, limited channels wait for a free slot if the cap is reached
+func (p *pooledSSHClient) openChannel(limited bool) (func(), error) {
+	if limited && p.channels != nil {
+		select {
+		case p.channels <- struct{}{}:
+		case <-time.After(time.Second * DockerDialerTimeout):
+			return nil, fmt.Errorf("Timed out waiting for a free SSH channel to [%s], all %d channels are in use", p.address, cap(p.channels))
+		}
+	}
+	p.lock.Lock()
+	p.inUse++
+	p.lock.Unlock()
+	return func() {
+		p.lock.Lock()
+		p.inUse--
+		p.lastUsed = time.Now()
+		p.lock.Unlock()
+		if limited && p.channels != nil {
+			<-p.channels
+		}
+	}, nil
+}
+
+// keepAlive checks the connection is alive until it's closed, the connection is closed if the host
+// doesn't answer or if it's been idle for too long
+func (p *pooledSSHClient) keepAlive(client *ssh.Client) {
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+		p.reset(client)
+	}()
+	ticker := time.NewTicker(SSHKeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+		p.lock.Lock()
+		idle := p.inUse == 0 && time.Since(p.lastUsed) > SSHIdleTimeout
+		p.lock.Unlock()
+		if idle {
+			p.reset(client)
+			return
+		}
+		reply := make(chan error, 1)
+		go func() {
+			// the reply doesn't matter, OpenSSH rejects the request but still answers it
+			_, _, err := client.SendRequest(sshKeepAliveRequest, true, nil)
+			reply <- err
+		}()
+		select {
+		case err := <-reply:
+			if err == nil {
+				continue
+			}
+			logrus.Debugf("[dialer] SSH keepalive to [%s] failed: %v", p.address, err)
+		case <-time.After(SSHKeepAliveTimeout):
+			logrus.Debugf("[dialer] SSH keepalive to [%s] timed out", p.address)
+		case <-closed:
+			return
+		}
+		p.reset(client)
+		return
+	}
+}
+
+// isChannelRejected is true if the host refused to open the channel, the connection itself still works
+func isChannelRejected(err error) bool {
+	_, ok := err.(*ssh.OpenChannelError)
+	return ok
+}
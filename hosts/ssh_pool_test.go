@@ -0,0 +1,267 @@
+package hosts
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer accepts any client key and echoes the data of the forwarded TCP and unix socket channels
+type testSSHServer struct {
+	listener   net.Listener
+	config     *ssh.ServerConfig
+	lock       sync.Mutex
+	handshakes int
+	conns      []*ssh.ServerConn
+}
+
+func TestPooledSSHConnectionIsShared(t *testing.T) {
+	server := newTestSSHServer(t)
+	defer server.close()
+	defer CloseSSHClients()
+	host := server.host(t, 0)
+
+	for _, factory := range []DialerFactory{SSHFactory, LocalConnFactory, LocalConnFactory} {
+		dial, err := factory(host)
+		if err != nil {
+			t.Fatalf("Failed to create dialer: %v", err)
+		}
+		conn, err := dial("tcp", "127.0.0.1:2379")
+		if err != nil {
+			t.Fatalf("Failed to dial through SSH: %v", err)
+		}
+		assertEcho(t, conn)
+		conn.Close()
+	}
+	if handshakes := server.getHandshakes(); handshakes != 1 {
+		t.Fatalf("Expected the dials to share 1 SSH connection, got %d handshakes", handshakes)
+	}
+}
+
+func TestPooledSSHConnectionIsScopedToCredentials(t *testing.T) {
+	server := newTestSSHServer(t)
+	defer server.close()
+	defer CloseSSHClients()
+	host := server.host(t, 0)
+	host.HostKeyVerifier = NewHostKeyVerifier(HostKeyCheckingNone, "", nil)
+	otherKeyHost := server.host(t, 0)
+	otherKeyHost.HostKeyVerifier = host.HostKeyVerifier
+	otherVerifierHost := *host
+	otherVerifierHost.HostKeyVerifier = NewHostKeyVerifier(HostKeyCheckingNone, "", nil)
+	for _, h := range []*Host{host, otherKeyHost, &otherVerifierHost} {
+		dial, err := LocalConnFactory(h)
+		if err != nil {
+			t.Fatalf("Failed to create dialer: %v", err)
+		}
+		conn, err := dial("tcp", "127.0.0.1:2379")
+		if err != nil {
+			t.Fatalf("Failed to dial through SSH: %v", err)
+		}
+		conn.Close()
+	}
+	if handshakes := server.getHandshakes(); handshakes != 3 {
+		t.Fatalf("Expected each key and host key verifier to get its own SSH connection, got %d handshakes", handshakes)
+	}
+
+	// the host key is checked again by a dialer with a pinned fingerprint even if a connection is open
+	pinnedHost := *host
+	pinnedHost.SSHHostKeyFingerprint = SSHFingerprintPrefix + "doesnotmatch"
+	dial, err := LocalConnFactory(&pinnedHost)
+	if err != nil {
+		t.Fatalf("Failed to create dialer: %v", err)
+	}
+	if conn, err := dial("tcp", "127.0.0.1:2379"); err == nil {
+		conn.Close()
+		t.Fatalf("Expected the dial to fail the host key check instead of reusing the open SSH connection")
+	}
+}
+
+func TestPooledSSHConnectionReconnects(t *testing.T) {
+	server := newTestSSHServer(t)
+	defer server.close()
+	defer CloseSSHClients()
+	dial, err := LocalConnFactory(server.host(t, 0))
+	if err != nil {
+		t.Fatalf("Failed to create dialer: %v", err)
+	}
+	conn, err := dial("tcp", "127.0.0.1:2379")
+	if err != nil {
+		t.Fatalf("Failed to dial through SSH: %v", err)
+	}
+	conn.Close()
+
+	server.closeConns()
+	conn, err = dial("tcp", "127.0.0.1:2379")
+	if err != nil {
+		t.Fatalf("Expected the dial to reconnect the closed SSH connection, got: %v", err)
+	}
+	assertEcho(t, conn)
+	conn.Close()
+	if handshakes := server.getHandshakes(); handshakes != 2 {
+		t.Fatalf("Expected 2 SSH handshakes after reconnecting, got %d", handshakes)
+	}
+}
+
+func TestPooledSSHConnectionLimitsChannels(t *testing.T) {
+	server := newTestSSHServer(t)
+	defer server.close()
+	defer CloseSSHClients()
+	dial, err := LocalConnFactory(server.host(t, 1))
+	if err != nil {
+		t.Fatalf("Failed to create dialer: %v", err)
+	}
+	first, err := dial("tcp", "127.0.0.1:2379")
+	if err != nil {
+		t.Fatalf("Failed to dial through SSH: %v", err)
+	}
+
+	dialed := make(chan error, 1)
+	go func() {
+		second, err := dial("tcp", "127.0.0.1:2379")
+		if err == nil {
+			second.Close()
+		}
+		dialed <- err
+	}()
+	select {
+	case <-dialed:
+		t.Fatalf("Expected the second dial to wait for a free channel")
+	case <-time.After(200 * time.Millisecond):
+	}
+	first.Close()
+	select {
+	case err := <-dialed:
+		if err != nil {
+			t.Fatalf("Failed to dial through SSH after a channel was closed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the second dial to get the closed channel")
+	}
+}
+
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to create host key signer: %v", err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &testSSHServer{listener: listener, config: config}
+	go server.serve()
+	return server
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		netConn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			conn, channels, requests, err := ssh.NewServerConn(netConn, s.config)
+			if err != nil {
+				return
+			}
+			s.lock.Lock()
+			s.handshakes++
+			s.conns = append(s.conns, conn)
+			s.lock.Unlock()
+			go ssh.DiscardRequests(requests)
+			for newChannel := range channels {
+				if newChannel.ChannelType() != "direct-tcpip" && newChannel.ChannelType() != "direct-streamlocal@openssh.com" {
+					newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+					continue
+				}
+				channel, channelRequests, err := newChannel.Accept()
+				if err != nil {
+					continue
+				}
+				go ssh.DiscardRequests(channelRequests)
+				go func() {
+					defer channel.Close()
+					buf := make([]byte, 1024)
+					for {
+						n, err := channel.Read(buf)
+						if err != nil {
+							return
+						}
+						channel.Write(buf[:n])
+					}
+				}()
+			}
+		}()
+	}
+}
+
+func (s *testSSHServer) host(t *testing.T, maxChannels int) *Host {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %v", err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal client key: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return &Host{
+		RKEConfigNode: v3.RKEConfigNode{
+			Address: "127.0.0.1",
+			Port:    port,
+			User:    "rancher",
+			SSHKey:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})),
+		},
+		SSHMaxChannels: maxChannels,
+	}
+}
+
+func (s *testSSHServer) getHandshakes() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.handshakes
+}
+
+func (s *testSSHServer) closeConns() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testSSHServer) close() {
+	s.listener.Close()
+	s.closeConns()
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Failed to write to forwarded connection: %v", err)
+	}
+	buf := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Expected the forwarded connection to echo [ping], got [%s]: %v", buf, err)
+	}
+}
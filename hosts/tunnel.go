@@ -26,6 +26,10 @@ func (h *Host) TunnelUp(ctx context.Context, dialerFactory DialerFactory, cluste
 	if h.DClient != nil {
 		return nil
 	}
+	// the Docker endpoint is only used with the default dialer, a custom dialer factory still reaches the host its own way
+	if h.UsesDockerEndpoint() && dialerFactory == nil {
+		return h.tunnelUpDockerEndpoint(ctx, clusterPrefixPath, clusterVersion)
+	}
 	log.Infof(ctx, "[dialer] Setup tunnel for host [%s]", h.Address)
 	httpClient, err := h.newHTTPClient(dialerFactory)
 	if err != nil {
@@ -44,6 +48,20 @@ func (h *Host) TunnelUp(ctx context.Context, dialerFactory DialerFactory, cluste
 	return nil
 }
 
+func (h *Host) tunnelUpDockerEndpoint(ctx context.Context, clusterPrefixPath string, clusterVersion string) error {
+	var err error
+	log.Infof(ctx, "[dialer] Connecting to Docker endpoint [%s] of host [%s]", h.DockerEndpoint, h.Address)
+	h.DClient, err = h.newDockerEndpointClient()
+	if err != nil {
+		return fmt.Errorf("Can't initiate NewClient: %v", err)
+	}
+	if err := checkDockerVersion(ctx, h, clusterVersion); err != nil {
+		return err
+	}
+	h.PrefixPath = GetPrefixPath(h.DockerInfo.OperatingSystem, clusterPrefixPath)
+	return nil
+}
+
 func (h *Host) TunnelUpLocal(ctx context.Context, clusterVersion string) error {
 	var err error
 	if h.DClient != nil {
@@ -83,18 +101,14 @@ func checkDockerVersion(ctx context.Context, h *Host, clusterVersion string) err
 	return nil
 }
 
-func parsePrivateKey(keyBuff string) (ssh.Signer, error) {
-	return ssh.ParsePrivateKey([]byte(keyBuff))
-}
-
-func getSSHConfig(username, sshPrivateKeyString string, sshCertificateString string, useAgentAuth bool) (*ssh.ClientConfig, error) {
+func (d *dialer) getSSHConfig() (*ssh.ClientConfig, error) {
 	config := &ssh.ClientConfig{
-		User:            username,
+		User:            d.username,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
 
 	// Kind of a double check now.
-	if useAgentAuth {
+	if d.useSSHAgentAuth {
 		if sshAgentSock := os.Getenv("SSH_AUTH_SOCK"); sshAgentSock != "" {
 			sshAgent, err := net.Dial("unix", sshAgentSock)
 			if err != nil {
@@ -108,13 +122,17 @@ func getSSHConfig(username, sshPrivateKeyString string, sshCertificateString str
 		}
 	}
 
-	signer, err := parsePrivateKey(sshPrivateKeyString)
+	keyName := d.sshKeyPath
+	if len(keyName) == 0 {
+		keyName = fmt.Sprintf("ssh_key of host [%s]", d.sshAddress)
+	}
+	signer, err := parsePrivateKey(d.sshKeyString, keyName, d.sshKeyPhrase)
 	if err != nil {
 		return config, err
 	}
 
-	if len(sshCertificateString) > 0 {
-		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(sshCertificateString))
+	if len(d.sshCertString) > 0 {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(d.sshCertString))
 		if err != nil {
 			return config, fmt.Errorf("Unable to parse SSH certificate: %v", err)
 		}
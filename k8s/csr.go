@@ -0,0 +1,34 @@
+package k8s
+
+import (
+	certificatesv1beta1 "k8s.io/api/certificates/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ListPendingCSRs lists the certificate signing requests that are neither approved nor denied
+func ListPendingCSRs(k8sClient *kubernetes.Clientset) ([]certificatesv1beta1.CertificateSigningRequest, error) {
+	csrList, err := k8sClient.CertificatesV1beta1().CertificateSigningRequests().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pending := []certificatesv1beta1.CertificateSigningRequest{}
+	for _, csr := range csrList.Items {
+		if len(csr.Status.Conditions) == 0 {
+			pending = append(pending, csr)
+		}
+	}
+	return pending, nil
+}
+
+// ApproveCSR marks the certificate signing request as approved so the controller manager signs it
+func ApproveCSR(k8sClient *kubernetes.Clientset, csr *certificatesv1beta1.CertificateSigningRequest, reason, message string) error {
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1beta1.CertificateSigningRequestCondition{
+		Type:           certificatesv1beta1.CertificateApproved,
+		Reason:         reason,
+		Message:        message,
+		LastUpdateTime: metav1.Now(),
+	})
+	_, err := k8sClient.CertificatesV1beta1().CertificateSigningRequests().UpdateApproval(csr)
+	return err
+}
@@ -5,10 +5,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/rancher/types/apis/management.cattle.io/v3"
 	"github.com/sirupsen/logrus"
 	"k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes"
 )
@@ -204,3 +206,77 @@ func delTaintFromList(l []v1.Taint, t v1.Taint) []v1.Taint {
 	}
 	return r
 }
+
+func WaitForNodeReady(k8sClient *kubernetes.Clientset, nodeName string, timeout int) error {
+	for timePassed := 0; timePassed < timeout; timePassed += DefaultSleepSeconds {
+		node, err := GetNode(k8sClient, nodeName)
+		if err != nil {
+			logrus.Debugf("Error getting node %s: %v", nodeName, err)
+		} else if IsNodeReady(*node) {
+			return nil
+		}
+		time.Sleep(time.Second * time.Duration(DefaultSleepSeconds))
+	}
+	return fmt.Errorf("Timeout waiting for node [%s] to be ready", nodeName)
+}
+
+// DrainNode evicts the pods running on a node the same way kubectl drain does, the node must already be cordoned
+func DrainNode(k8sClient *kubernetes.Clientset, nodeName string, drainInput *v3.NodeDrainInput) error {
+	node, err := GetNode(k8sClient, nodeName)
+	if err != nil {
+		return err
+	}
+	pods, err := k8sClient.CoreV1().Pods("").List(metav1.ListOptions{
+		FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": node.Name}).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to list pods on node [%s]: %v", nodeName, err)
+	}
+	toEvict := []v1.Pod{}
+	for _, pod := range pods.Items {
+		evict, err := isPodEvictable(pod, drainInput)
+		if err != nil {
+			return fmt.Errorf("Can't drain node [%s]: %v", nodeName, err)
+		}
+		if evict {
+			toEvict = append(toEvict, pod)
+		}
+	}
+	var gracePeriod *int64
+	if drainInput.GracePeriod >= 0 {
+		seconds := int64(drainInput.GracePeriod)
+		gracePeriod = &seconds
+	}
+	for _, pod := range toEvict {
+		if err := EvictPod(k8sClient, pod, gracePeriod, drainInput.Timeout); err != nil {
+			return fmt.Errorf("Failed to drain node [%s]: %v", nodeName, err)
+		}
+	}
+	return WaitForPodsDeleted(k8sClient, toEvict, drainInput.Timeout)
+}
+
+func isPodEvictable(pod v1.Pod, drainInput *v3.NodeDrainInput) (bool, error) {
+	// mirror pods are managed by the kubelet and can't be evicted
+	if _, ok := pod.Annotations[v1.MirrorPodAnnotationKey]; ok {
+		return false, nil
+	}
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return true, nil
+	}
+	controllerRef := metav1.GetControllerOf(&pod)
+	if controllerRef != nil && controllerRef.Kind == "DaemonSet" {
+		if !drainInput.IgnoreDaemonSets {
+			return false, fmt.Errorf("pod [%s/%s] is managed by a DaemonSet, enable ignore_daemonsets to continue", pod.Namespace, pod.Name)
+		}
+		return false, nil
+	}
+	if controllerRef == nil && !drainInput.Force {
+		return false, fmt.Errorf("pod [%s/%s] is not managed by a controller, enable force to continue", pod.Namespace, pod.Name)
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir != nil && !drainInput.DeleteLocalData {
+			return false, fmt.Errorf("pod [%s/%s] uses local storage, enable delete_local_data to continue", pod.Namespace, pod.Name)
+		}
+	}
+	return true, nil
+}
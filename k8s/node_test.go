@@ -0,0 +1,98 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsPodEvictable(t *testing.T) {
+	controller := true
+	replicaSetRef := []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web", Controller: &controller}}
+	daemonSetRef := []metav1.OwnerReference{{Kind: "DaemonSet", Name: "agent", Controller: &controller}}
+	emptyDir := []v1.Volume{{Name: "cache", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}}}
+
+	tests := []struct {
+		name       string
+		pod        v1.Pod
+		drainInput v3.NodeDrainInput
+		expected   bool
+		expectErr  bool
+	}{
+		{
+			name:     "managed pod",
+			pod:      v1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: replicaSetRef}},
+			expected: true,
+		},
+		{
+			name: "mirror pod",
+			pod: v1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{v1.MirrorPodAnnotationKey: "hash"},
+			}},
+			expected: false,
+		},
+		{
+			name:     "completed unmanaged pod",
+			pod:      v1.Pod{Status: v1.PodStatus{Phase: v1.PodSucceeded}},
+			expected: true,
+		},
+		{
+			name:       "daemonset pod ignored",
+			pod:        v1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: daemonSetRef}},
+			drainInput: v3.NodeDrainInput{IgnoreDaemonSets: true},
+			expected:   false,
+		},
+		{
+			name:      "daemonset pod not ignored",
+			pod:       v1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: daemonSetRef}},
+			expectErr: true,
+		},
+		{
+			name:      "unmanaged pod",
+			pod:       v1.Pod{},
+			expectErr: true,
+		},
+		{
+			name:       "unmanaged pod forced",
+			pod:        v1.Pod{},
+			drainInput: v3.NodeDrainInput{Force: true},
+			expected:   true,
+		},
+		{
+			name: "local storage",
+			pod: v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{OwnerReferences: replicaSetRef},
+				Spec:       v1.PodSpec{Volumes: emptyDir},
+			},
+			expectErr: true,
+		},
+		{
+			name: "local storage deleted",
+			pod: v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{OwnerReferences: replicaSetRef},
+				Spec:       v1.PodSpec{Volumes: emptyDir},
+			},
+			drainInput: v3.NodeDrainInput{DeleteLocalData: true},
+			expected:   true,
+		},
+	}
+	for _, test := range tests {
+		test.pod.Namespace, test.pod.Name = "default", test.name
+		evictable, err := isPodEvictable(test.pod, &test.drainInput)
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("[%s] expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %v", test.name, err)
+			continue
+		}
+		if evictable != test.expected {
+			t.Errorf("[%s] expected evictable to be %v, got %v", test.name, test.expected, evictable)
+		}
+	}
+}
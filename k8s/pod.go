@@ -1,7 +1,12 @@
 package k8s
 
 import (
+	"fmt"
+	"time"
+
 	"k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -22,3 +27,46 @@ func ListPodsByLabel(k8sClient *kubernetes.Clientset, label string) (*v1.PodList
 	}
 	return pods, nil
 }
+
+// EvictPod evicts a pod through the eviction API so that pod disruption budgets are respected
+func EvictPod(k8sClient *kubernetes.Clientset, pod v1.Pod, gracePeriod *int64, timeout int) error {
+	eviction := &policyv1beta1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+		DeleteOptions: &metav1.DeleteOptions{GracePeriodSeconds: gracePeriod},
+	}
+	var err error
+	for timePassed := 0; timePassed < timeout; timePassed += DefaultSleepSeconds {
+		err = k8sClient.PolicyV1beta1().Evictions(pod.Namespace).Evict(eviction)
+		if err == nil || apierrors.IsNotFound(err) {
+			return nil
+		}
+		// too many requests means the eviction would violate a pod disruption budget, retry until it doesn't
+		if !apierrors.IsTooManyRequests(err) {
+			return fmt.Errorf("Failed to evict pod [%s/%s]: %v", pod.Namespace, pod.Name, err)
+		}
+		time.Sleep(time.Second * time.Duration(DefaultSleepSeconds))
+	}
+	return fmt.Errorf("Timeout evicting pod [%s/%s]: %v", pod.Namespace, pod.Name, err)
+}
+
+func WaitForPodsDeleted(k8sClient *kubernetes.Clientset, pods []v1.Pod, timeout int) error {
+	for timePassed := 0; timePassed < timeout; timePassed += DefaultSleepSeconds {
+		remaining := []v1.Pod{}
+		for _, pod := range pods {
+			current, err := k8sClient.CoreV1().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) || (err == nil && current.UID != pod.UID) {
+				continue
+			}
+			remaining = append(remaining, pod)
+		}
+		if len(remaining) == 0 {
+			return nil
+		}
+		pods = remaining
+		time.Sleep(time.Second * time.Duration(DefaultSleepSeconds))
+	}
+	return fmt.Errorf("Timeout waiting for %d pods to be deleted", len(pods))
+}
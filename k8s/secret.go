@@ -12,12 +12,18 @@ func GetSecret(k8sClient *kubernetes.Clientset, secretName string) (*v1.Secret,
 }
 
 func UpdateSecret(k8sClient *kubernetes.Clientset, secretDataMap map[string][]byte, secretName string) error {
+	return UpdateSecretWithType(k8sClient, secretDataMap, secretName, v1.SecretTypeOpaque)
+}
+
+// UpdateSecretWithType is UpdateSecret for secrets of a specific type, like bootstrap tokens
+func UpdateSecretWithType(k8sClient *kubernetes.Clientset, secretDataMap map[string][]byte, secretName string, secretType v1.SecretType) error {
 	secret := &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      secretName,
 			Namespace: metav1.NamespaceSystem,
 		},
 		Data: secretDataMap,
+		Type: secretType,
 	}
 	if _, err := k8sClient.CoreV1().Secrets(metav1.NamespaceSystem).Create(secret); err != nil {
 		if !apierrors.IsAlreadyExists(err) {
@@ -30,3 +36,61 @@ func UpdateSecret(k8sClient *kubernetes.Clientset, secretDataMap map[string][]by
 	}
 	return nil
 }
+
+// CreateSecret fails with an AlreadyExists error if the secret exists
+func CreateSecret(k8sClient *kubernetes.Clientset, secretDataMap map[string][]byte, secretName string) error {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: metav1.NamespaceSystem,
+		},
+		Data: secretDataMap,
+	}
+	_, err := k8sClient.CoreV1().Secrets(metav1.NamespaceSystem).Create(secret)
+	return err
+}
+
+func DeleteSecret(k8sClient *kubernetes.Clientset, secretName string) error {
+	return k8sClient.CoreV1().Secrets(metav1.NamespaceSystem).Delete(secretName, &metav1.DeleteOptions{})
+}
+
+// ListSecretsWithType lists the kube-system secrets of a type, like bootstrap tokens
+func ListSecretsWithType(k8sClient *kubernetes.Clientset, secretType v1.SecretType) ([]v1.Secret, error) {
+	secretList, err := k8sClient.CoreV1().Secrets(metav1.NamespaceSystem).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	secrets := []v1.Secret{}
+	for _, secret := range secretList.Items {
+		if secret.Type == secretType {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets, nil
+}
+
+// ListAllSecrets lists the secrets of all namespaces
+func ListAllSecrets(k8sClient *kubernetes.Clientset) (*v1.SecretList, error) {
+	return k8sClient.CoreV1().Secrets(metav1.NamespaceAll).List(metav1.ListOptions{})
+}
+
+// RewriteSecret updates the secret unchanged so it's stored again with the current encryption provider,
+// the secret is fetched again on conflicts with other writers and skipped if it was deleted in the meantime
+func RewriteSecret(k8sClient *kubernetes.Clientset, secret *v1.Secret) error {
+	var err error
+	for i := 0; i < DefaultRetries; i++ {
+		if _, err = k8sClient.CoreV1().Secrets(secret.Namespace).Update(secret); err == nil || apierrors.IsNotFound(err) {
+			return nil
+		}
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+		if secret, err = k8sClient.CoreV1().Secrets(secret.Namespace).Get(secret.Name, metav1.GetOptions{}); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+	}
+	return err
+}
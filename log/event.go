@@ -0,0 +1,64 @@
+package log
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	PhaseEtcd         = "etcd"
+	PhaseControlPlane = "controlPlane"
+	PhaseWorkerPlane  = "workerPlane"
+	PhaseAddons       = "addons"
+
+	ActionDeploy      = "deploy"
+	ActionUpgrade     = "upgrade"
+	ActionHealthcheck = "healthcheck"
+
+	StatusStarted   = "started"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Event is a structured record of a provisioning step
+type Event struct {
+	Phase     string
+	Host      string
+	Component string
+	Action    string
+	Status    string
+	Duration  time.Duration
+	Error     string
+}
+
+// eventLogger is implemented by loggers that can handle structured events,
+// events sent to other loggers are only shown in debug mode
+type eventLogger interface {
+	Event(event Event)
+}
+
+func Emit(ctx context.Context, event Event) {
+	if logger, ok := getLogger(ctx).(eventLogger); ok {
+		logger.Event(event)
+		return
+	}
+	logrus.Debugf("[%s] %s %s on host [%s]: %s %v", event.Phase, event.Action, event.Component, event.Host, event.Status, event.Duration)
+}
+
+// Track emits a started event and returns a func to emit the result once the step is done
+func Track(ctx context.Context, event Event) func(err error) {
+	start := time.Now()
+	event.Status = StatusStarted
+	Emit(ctx, event)
+	return func(err error) {
+		event.Status = StatusSucceeded
+		event.Duration = time.Since(start)
+		if err != nil {
+			event.Status = StatusFailed
+			event.Error = err.Error()
+		}
+		Emit(ctx, event)
+	}
+}
@@ -0,0 +1,50 @@
+package log
+
+import (
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+// JSONLogger writes log messages and events as JSON lines
+type JSONLogger struct {
+	logger *logrus.Logger
+}
+
+// NewJSONLogger returns a JSONLogger writing to out, using the level of the standard logger
+func NewJSONLogger(out io.Writer) *JSONLogger {
+	logger := logrus.New()
+	logger.Out = out
+	logger.Formatter = &logrus.JSONFormatter{}
+	logger.Level = logrus.GetLevel()
+	return &JSONLogger{logger: logger}
+}
+
+func (l *JSONLogger) Infof(msg string, args ...interface{}) {
+	l.logger.Infof(msg, args...)
+}
+
+func (l *JSONLogger) Warnf(msg string, args ...interface{}) {
+	l.logger.Warnf(msg, args...)
+}
+
+func (l *JSONLogger) Event(event Event) {
+	fields := logrus.Fields{
+		"phase":  event.Phase,
+		"action": event.Action,
+		"status": event.Status,
+	}
+	if len(event.Host) > 0 {
+		fields["host"] = event.Host
+	}
+	if len(event.Component) > 0 {
+		fields["component"] = event.Component
+	}
+	if event.Status != StatusStarted {
+		fields["duration"] = event.Duration.Seconds()
+	}
+	if len(event.Error) > 0 {
+		fields["error"] = event.Error
+	}
+	l.logger.WithFields(fields).Info("event")
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"regexp"
 
 	"github.com/mattn/go-colorable"
+	"github.com/rancher/rke/cluster"
 	"github.com/rancher/rke/cmd"
+	"github.com/rancher/rke/hosts"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
@@ -30,6 +33,19 @@ func mainErr() error {
 		if ctx.GlobalBool("debug") {
 			logrus.SetLevel(logrus.DebugLevel)
 		}
+		switch ctx.GlobalString("output") {
+		case cmd.OutputText:
+		case cmd.OutputJSON:
+			logrus.SetOutput(os.Stdout)
+			logrus.SetFormatter(&logrus.JSONFormatter{})
+		default:
+			return fmt.Errorf("Invalid output format [%s], must be one of [%s, %s]", ctx.GlobalString("output"), cmd.OutputText, cmd.OutputJSON)
+		}
+		switch ctx.GlobalString("state-backend") {
+		case cluster.LocalStateBackendName, cluster.S3StateBackendName, cluster.KubernetesStateBackendName:
+		default:
+			return fmt.Errorf("Invalid state backend [%s], must be one of [%s, %s, %s]", ctx.GlobalString("state-backend"), cluster.LocalStateBackendName, cluster.S3StateBackendName, cluster.KubernetesStateBackendName)
+		}
 		logrus.Debugf("RKE version %s", app.Version)
 		if released.MatchString(app.Version) {
 			return nil
@@ -37,6 +53,11 @@ func mainErr() error {
 		logrus.Warnf("This is not an officially supported version (%s) of RKE. Please download the latest official release at https://github.com/rancher/rke/releases/latest", app.Version)
 		return nil
 	}
+	app.After = func(ctx *cli.Context) error {
+		// the SSH connections are pooled between the steps of a command, they aren't needed once it's done
+		hosts.CloseSSHClients()
+		return nil
+	}
 	app.Author = "Rancher Labs, Inc."
 	app.Email = ""
 	app.Commands = []cli.Command{
@@ -46,12 +67,23 @@ func mainErr() error {
 		cmd.ConfigCommand(),
 		cmd.EtcdCommand(),
 		cmd.CertificateCommand(),
+		cmd.PlanCommand(),
+		cmd.StateCommand(),
+		cmd.EncryptCommand(),
+		cmd.ImagesCommand(),
 	}
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{
 			Name:  "debug,d",
 			Usage: "Debug logging",
 		},
+		cli.StringFlag{
+			Name:  "output,o",
+			Usage: "Log output format, text or json",
+			Value: cmd.OutputText,
+		},
 	}
+	app.Flags = append(app.Flags, cmd.StateFlags...)
+	app.Flags = append(app.Flags, cmd.SSHKeyFlags...)
 	return app.Run(os.Args)
 }
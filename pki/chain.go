@@ -0,0 +1,99 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"k8s.io/client-go/util/cert"
+)
+
+// ToCACertObject is ToCertObject for a CA certificate followed by its chain up to the root.
+// The certificate PEM holds the whole chain, so the chain is deployed everywhere the CA certificate is trusted.
+func ToCACertObject(componentName string, chain []*x509.Certificate, key crypto.Signer) CertificatePKI {
+	if len(chain) == 0 {
+		return ToCertObject(componentName, "", "", nil, key, nil)
+	}
+	certObj := ToCertObject(componentName, "", "", chain[0], key, nil)
+	for _, chainCert := range chain[1:] {
+		certObj.CertificatePEM += string(cert.EncodeCertPEM(chainCert))
+	}
+	return certObj
+}
+
+// GetCertificateChain returns the certificate followed by the rest of the chain kept in its PEM
+func GetCertificateChain(certObj CertificatePKI) []*x509.Certificate {
+	if certObj.Certificate == nil {
+		return nil
+	}
+	chain, err := cert.ParseCertsPEM([]byte(certObj.CertificatePEM))
+	if err != nil || len(chain) == 0 || !chain[0].Equal(certObj.Certificate) {
+		return []*x509.Certificate{certObj.Certificate}
+	}
+	return chain
+}
+
+// GenerateIntermediateCACert sets the cluster CA to the configured intermediate CA
+func GenerateIntermediateCACert(certs map[string]CertificatePKI, intermediateCA *v3.IntermediateCAConfig) error {
+	chain, err := cert.ParseCertsPEM([]byte(intermediateCA.Cert))
+	if err != nil {
+		return fmt.Errorf("Failed to parse intermediate CA certificate: %v", err)
+	}
+	key, err := ParsePrivateKeyPEM([]byte(intermediateCA.Key))
+	if err != nil {
+		return fmt.Errorf("Failed to parse intermediate CA key: %v", err)
+	}
+	if !chain[0].IsCA {
+		return fmt.Errorf("Intermediate CA certificate [%s] is not a CA certificate", chain[0].Subject.CommonName)
+	}
+	if err := validateKeyPair(chain[0], key); err != nil {
+		return fmt.Errorf("Intermediate CA key doesn't match its certificate: %v", err)
+	}
+	if err := verifyCertificateChain(chain[0], chain); err != nil {
+		return fmt.Errorf("Failed to verify intermediate CA certificate chain: %v", err)
+	}
+	certs[CACertName] = ToCACertObject(CACertName, chain, key)
+	return nil
+}
+
+// IsIntermediateCA returns true if the CA in the bundle is the configured intermediate CA
+func IsIntermediateCA(certs map[string]CertificatePKI, intermediateCA *v3.IntermediateCAConfig) bool {
+	chain, err := cert.ParseCertsPEM([]byte(intermediateCA.Cert))
+	if err != nil || certs[CACertName].Certificate == nil {
+		return false
+	}
+	return chain[0].Equal(certs[CACertName].Certificate)
+}
+
+// verifyCertificateChain verifies certificate up to the last certificate of the chain, the ones in between are intermediates
+func verifyCertificateChain(certificate *x509.Certificate, chain []*x509.Certificate) error {
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	roots.AddCert(chain[len(chain)-1])
+	for _, chainCert := range chain[:len(chain)-1] {
+		intermediates.AddCert(chainCert)
+	}
+	_, err := certificate.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+func validateKeyPair(certificate *x509.Certificate, key crypto.Signer) error {
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return err
+	}
+	certPublicKey, err := x509.MarshalPKIXPublicKey(certificate.PublicKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(publicKey, certPublicKey) {
+		return fmt.Errorf("public key mismatch")
+	}
+	return nil
+}
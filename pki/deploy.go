@@ -2,11 +2,11 @@ package pki
 
 import (
 	"context"
-	"crypto/rsa"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -202,12 +202,13 @@ func FetchCertificatesFromHost(ctx context.Context, extraHosts []*hosts.Host, ho
 		if err != nil {
 			return nil, err
 		}
-		parsedKey, err := cert.ParsePrivateKeyPEM([]byte(key))
+		parsedKey, err := ParsePrivateKeyPEM([]byte(key))
 		if err != nil {
 			return nil, err
 		}
 		certificate.Certificate = parsedCert[0]
-		certificate.Key = parsedKey.(*rsa.PrivateKey)
+		certificate.CertificatePEM = crt
+		certificate.Key = parsedKey
 		tmpCerts[certName] = certificate
 		logrus.Debugf("[certificates] Recovered certificate: %s", certName)
 	}
@@ -219,6 +220,47 @@ func FetchCertificatesFromHost(ctx context.Context, extraHosts []*hosts.Host, ho
 
 }
 
+// GetNodeCertificateNames returns the names of the certificates deployed on a node for its roles
+func GetNodeCertificateNames(rkeConfig v3.RancherKubernetesEngineConfig, nodeAddress string) []string {
+	certNames := []string{}
+	for certName := range GenerateRKENodeCerts(context.Background(), rkeConfig, nodeAddress, map[string]CertificatePKI{}) {
+		// the service account token is a key, its certificate is the kube-apiserver one
+		if certName == ServiceAccountTokenKeyName {
+			continue
+		}
+		certNames = append(certNames, certName)
+	}
+	sort.Strings(certNames)
+	return certNames
+}
+
+// FetchDeployedCertificates reads the certificates deployed on a host, the ones missing on the host are left out
+func FetchDeployedCertificates(ctx context.Context, host *hosts.Host, certNames []string, image string, prsMap map[string]v3.PrivateRegistry) (map[string]CertificatePKI, error) {
+	certs := make(map[string]CertificatePKI)
+	for _, certName := range certNames {
+		crt, err := FetchFileFromHost(ctx, GetCertPath(certName), image, host, prsMap, CertFetcherContainer, "certificates")
+		if err != nil {
+			if isFileNotFoundErr(err) {
+				logrus.Debugf("[certificates] Certificate [%s] is not deployed on host [%s]", certName, host.Address)
+				continue
+			}
+			return nil, err
+		}
+		parsedCert, err := cert.ParseCertsPEM([]byte(crt))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse certificate [%s] on host [%s]: %v", certName, host.Address, err)
+		}
+		certs[certName] = CertificatePKI{
+			Certificate:    parsedCert[0],
+			CertificatePEM: crt,
+		}
+	}
+	if err := docker.RemoveContainer(ctx, host.DClient, host.Address, CertFetcherContainer); err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
 func FetchFileFromHost(ctx context.Context, filePath, image string, host *hosts.Host, prsMap map[string]v3.PrivateRegistry, containerName, state string) (string, error) {
 	imageCfg := &container.Config{
 		Image: image,
@@ -0,0 +1,108 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"k8s.io/client-go/util/cert"
+)
+
+const (
+	RSAKeyAlgorithm     = "rsa"
+	ECDSAKeyAlgorithm   = "ecdsa"
+	DefaultKeyAlgorithm = RSAKeyAlgorithm
+
+	// ed25519 is rejected with its own message, Kubernetes and etcd can't use Ed25519 certificates
+	ed25519KeyAlgorithm = "ed25519"
+)
+
+// NewPrivateKey generates a private key, RSA 2048 or ECDSA P-256. An empty algorithm means RSA.
+func NewPrivateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case "", RSAKeyAlgorithm:
+		key, err := cert.NewPrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	case ECDSAKeyAlgorithm:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key algorithm [%s]", algorithm)
+}
+
+// GetPrivateKeyAlgorithm returns the algorithm of a private key, or an empty string if it's not supported
+func GetPrivateKeyAlgorithm(key crypto.Signer) string {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return RSAKeyAlgorithm
+	case *ecdsa.PrivateKey:
+		return ECDSAKeyAlgorithm
+	}
+	return ""
+}
+
+func ValidateKeyAlgorithm(algorithm string) error {
+	switch algorithm {
+	case "", RSAKeyAlgorithm, ECDSAKeyAlgorithm:
+		return nil
+	case ed25519KeyAlgorithm:
+		return fmt.Errorf("key algorithm [%s] is not supported by Kubernetes and etcd, must be one of [%s, %s]", algorithm, RSAKeyAlgorithm, ECDSAKeyAlgorithm)
+	}
+	return fmt.Errorf("unsupported key algorithm [%s], must be one of [%s, %s]", algorithm, RSAKeyAlgorithm, ECDSAKeyAlgorithm)
+}
+
+// EncodePrivateKeyPEM encodes RSA keys in PKCS#1 and ECDSA keys in SEC 1 format
+func EncodePrivateKeyPEM(key crypto.Signer) []byte {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return cert.EncodePrivateKeyPEM(k)
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: cert.ECPrivateKeyBlockType, Bytes: der})
+	}
+	return nil
+}
+
+// ParsePrivateKeyPEM parses the first RSA or ECDSA private key in keyPEM
+func ParsePrivateKeyPEM(keyPEM []byte) (crypto.Signer, error) {
+	key, err := cert.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok || len(GetPrivateKeyAlgorithm(signer)) == 0 {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// GetCAKeyAlgorithm returns the configured key algorithm of the CA certificates
+func GetCAKeyAlgorithm(rkeConfig v3.RancherKubernetesEngineConfig) string {
+	if rkeConfig.PKI == nil || len(rkeConfig.PKI.CAKeyAlgorithm) == 0 {
+		return DefaultKeyAlgorithm
+	}
+	return rkeConfig.PKI.CAKeyAlgorithm
+}
+
+// GetComponentKeyAlgorithm returns the configured key algorithm of the component certificates
+func GetComponentKeyAlgorithm(rkeConfig v3.RancherKubernetesEngineConfig) string {
+	if rkeConfig.PKI == nil || len(rkeConfig.PKI.KeyAlgorithm) == 0 {
+		return DefaultKeyAlgorithm
+	}
+	return rkeConfig.PKI.KeyAlgorithm
+}
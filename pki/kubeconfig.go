@@ -45,3 +45,24 @@ users:
     client-certificate-data: ` + base64.StdEncoding.EncodeToString([]byte(crt)) + `
     client-key-data: ` + base64.StdEncoding.EncodeToString([]byte(key)) + ``
 }
+
+func GetKubeConfigToken(kubernetesURL string, clusterName string, componentName string, caPath string, token string) string {
+	return `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    api-version: v1
+    certificate-authority: ` + caPath + `
+    server: "` + kubernetesURL + `"
+  name: "` + clusterName + `"
+contexts:
+- context:
+    cluster: "` + clusterName + `"
+    user: "` + componentName + `-` + clusterName + `"
+  name: "` + clusterName + `"
+current-context: "` + clusterName + `"
+users:
+- name: "` + componentName + `-` + clusterName + `"
+  user:
+    token: ` + token + ``
+}
@@ -2,12 +2,16 @@ package pki
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
+	"math/big"
 	"net"
 	"testing"
 
 	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"k8s.io/client-go/util/cert"
 )
 
 const (
@@ -46,6 +50,7 @@ func TestPKI(t *testing.T) {
 		KubeAPICertName,
 		KubeNodeCertName,
 		KubeProxyCertName,
+		KubeCNICertName,
 		KubeControllerCertName,
 		KubeSchedulerCertName,
 		KubeAdminCertName,
@@ -103,6 +108,230 @@ func TestPKI(t *testing.T) {
 	}
 }
 
+func TestPKIKeyAlgorithms(t *testing.T) {
+	rkeConfig := v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			v3.RKEConfigNode{
+				Address:          "1.1.1.1",
+				InternalAddress:  "192.168.1.5",
+				Role:             []string{"controlplane", "etcd"},
+				HostnameOverride: "server1",
+			},
+		},
+		Services: v3.RKEConfigServices{
+			KubeAPI: v3.KubeAPIService{
+				ServiceClusterIPRange: FakeClusterCidr,
+			},
+			Kubelet: v3.KubeletService{
+				ClusterDomain: FakeClusterDomain,
+			},
+		},
+		PKI: &v3.PKIConfig{
+			CAKeyAlgorithm: RSAKeyAlgorithm,
+			KeyAlgorithm:   ECDSAKeyAlgorithm,
+		},
+	}
+	certificateMap, err := GenerateRKECerts(context.Background(), rkeConfig, "", "")
+	if err != nil {
+		t.Fatalf("Failed To generate certificates: %v", err)
+	}
+	assertEqual(t, GetPrivateKeyAlgorithm(certificateMap[CACertName].Key), RSAKeyAlgorithm, "Unexpected CA key algorithm")
+	assertEqual(t, GetPrivateKeyAlgorithm(certificateMap[KubeAPICertName].Key), ECDSAKeyAlgorithm, "Unexpected kube-apiserver key algorithm")
+	assertEqual(t, GetPrivateKeyAlgorithm(certificateMap[ServiceAccountTokenKeyName].Key), ECDSAKeyAlgorithm, "Unexpected service account token key algorithm")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certificateMap[CACertName].Certificate)
+	opts := x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range []string{KubeAPICertName, KubeNodeCertName, KubeAdminCertName, GetEtcdCrtName("192.168.1.5")} {
+		if _, err := certificateMap[cert].Certificate.Verify(opts); err != nil {
+			t.Fatalf("Failed to verify certificate %s: %v", cert, err)
+		}
+	}
+	// keys must survive the round trip through the state file
+	for certName, certificate := range TransformPEMToObject(certificateMap) {
+		if certificateMap[certName].Key == nil {
+			continue
+		}
+		if certificate.Key == nil {
+			t.Fatalf("Failed to parse key of %s", certName)
+		}
+		assertEqual(t, GetPrivateKeyAlgorithm(certificate.Key), GetPrivateKeyAlgorithm(certificateMap[certName].Key), fmt.Sprintf("Key algorithm of %s changed", certName))
+	}
+}
+
+func TestPKIIntermediateCA(t *testing.T) {
+	rootCrt, rootKey, err := GenerateCACertAndKey("offline-root", nil, RSAKeyAlgorithm)
+	if err != nil {
+		t.Fatalf("Failed to generate root CA: %v", err)
+	}
+	intermediateKey, err := NewPrivateKey(ECDSAKeyAlgorithm)
+	if err != nil {
+		t.Fatalf("Failed to generate intermediate CA key: %v", err)
+	}
+	intermediateTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "rke-intermediate"},
+		NotBefore:             rootCrt.NotBefore,
+		NotAfter:              rootCrt.NotAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	intermediateDER, err := x509.CreateCertificate(rand.Reader, intermediateTmpl, rootCrt, intermediateKey.Public(), rootKey)
+	if err != nil {
+		t.Fatalf("Failed to sign intermediate CA: %v", err)
+	}
+	intermediateCrt, err := x509.ParseCertificate(intermediateDER)
+	if err != nil {
+		t.Fatalf("Failed to parse intermediate CA: %v", err)
+	}
+	rkeConfig := &v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			v3.RKEConfigNode{
+				Address:          "1.1.1.1",
+				InternalAddress:  "192.168.1.5",
+				Role:             []string{"controlplane", "etcd"},
+				HostnameOverride: "server1",
+			},
+		},
+		Services: v3.RKEConfigServices{
+			KubeAPI: v3.KubeAPIService{
+				ServiceClusterIPRange: FakeClusterCidr,
+			},
+			Kubelet: v3.KubeletService{
+				ClusterDomain: FakeClusterDomain,
+			},
+		},
+		PKI: &v3.PKIConfig{
+			IntermediateCA: &v3.IntermediateCAConfig{
+				Cert: string(cert.EncodeCertPEM(intermediateCrt)) + string(cert.EncodeCertPEM(rootCrt)),
+				Key:  string(EncodePrivateKeyPEM(intermediateKey)),
+			},
+		},
+	}
+	certificateMap, err := GenerateRKECerts(context.Background(), *rkeConfig, "", "")
+	if err != nil {
+		t.Fatalf("Failed To generate certificates: %v", err)
+	}
+	assertEqual(t, certificateMap[CACertName].Certificate.Equal(intermediateCrt), true, "CA certificate isn't the intermediate CA")
+	assertEqual(t, len(GetCertificateChain(certificateMap[CACertName])), 2, "CA certificate chain isn't kept")
+
+	// clients only trusting the offline root must accept the component certificates
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCrt)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediateCrt)
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range []string{KubeAPICertName, KubeNodeCertName, KubeAdminCertName, GetEtcdCrtName("192.168.1.5")} {
+		if _, err := certificateMap[cert].Certificate.Verify(opts); err != nil {
+			t.Fatalf("Failed to verify certificate %s: %v", cert, err)
+		}
+	}
+	if err := ValidateBundleContent(rkeConfig, TransformPEMToObject(certificateMap), "", ""); err != nil {
+		t.Fatalf("Failed to validate certificate bundle: %v", err)
+	}
+
+	// a component signed by another CA must fail the chain validation
+	otherCACrt, otherCAKey, err := GenerateCACertAndKey(CACertName, nil, RSAKeyAlgorithm)
+	if err != nil {
+		t.Fatalf("Failed to generate CA: %v", err)
+	}
+	otherCrt, otherKey, err := GenerateSignedCertAndKey(otherCACrt, otherCAKey, false, getDefaultCN(KubeSchedulerCertName), nil, nil, RSAKeyAlgorithm, nil)
+	if err != nil {
+		t.Fatalf("Failed to generate certificate: %v", err)
+	}
+	certificateMap[KubeSchedulerCertName] = ToCertObject(KubeSchedulerCertName, "", "", otherCrt, otherKey, nil)
+	if err := ValidateBundleContent(rkeConfig, certificateMap, "", ""); err == nil {
+		t.Fatalf("Expected a certificate from another CA to fail validation")
+	}
+}
+
+func TestPKIKubeletCertificates(t *testing.T) {
+	rkeConfig := v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			v3.RKEConfigNode{
+				Address:          "1.1.1.1",
+				Role:             []string{"controlplane", "etcd"},
+				HostnameOverride: "Server1",
+			},
+			v3.RKEConfigNode{
+				Address: "2.2.2.2",
+				Role:    []string{"worker"},
+			},
+		},
+		Services: v3.RKEConfigServices{
+			KubeAPI: v3.KubeAPIService{
+				ServiceClusterIPRange: FakeClusterCidr,
+			},
+		},
+	}
+	certificateMap, err := GenerateRKECerts(context.Background(), rkeConfig, "", "")
+	if err != nil {
+		t.Fatalf("Failed To generate certificates: %v", err)
+	}
+	expectedCNs := map[string]string{
+		"1.1.1.1": "system:node:server1",
+		"2.2.2.2": "system:node:2.2.2.2",
+	}
+	for address, commonName := range expectedCNs {
+		kubeletCert := certificateMap[GetKubeletCrtName(address)].Certificate
+		if kubeletCert == nil {
+			t.Fatalf("Kubelet certificate of node [%s] is not generated", address)
+		}
+		assertEqual(t, kubeletCert.Subject.CommonName, commonName, "")
+		assertEqual(t, len(kubeletCert.Subject.Organization), 1, "")
+		assertEqual(t, kubeletCert.Subject.Organization[0], KubeNodeOrganizationName, "")
+	}
+	if err := ValidateBundleContent(&rkeConfig, certificateMap, "", ""); err != nil {
+		t.Fatalf("Failed to validate certificates bundle: %v", err)
+	}
+
+	// a node only gets its own kubelet certificate
+	nodeCerts := GenerateRKENodeCerts(context.Background(), rkeConfig, "2.2.2.2", certificateMap)
+	if _, ok := nodeCerts[GetKubeletCrtName("2.2.2.2")]; !ok {
+		t.Fatalf("Kubelet certificate is not deployed to its node")
+	}
+	if _, ok := nodeCerts[GetKubeletCrtName("1.1.1.1")]; ok {
+		t.Fatalf("Kubelet certificate of another node is deployed to node [2.2.2.2]")
+	}
+	// the shared node identity isn't deployed to workers, kube-proxy and the CNI plugins have their own
+	if _, ok := nodeCerts[KubeNodeCertName]; ok {
+		t.Fatalf("Shared [%s] certificate is deployed to worker node [2.2.2.2]", KubeNodeCertName)
+	}
+	for _, certName := range []string{KubeProxyCertName, KubeCNICertName} {
+		if nodeCerts[certName].Certificate == nil {
+			t.Fatalf("Certificate [%s] is not deployed to worker node [2.2.2.2]", certName)
+		}
+	}
+	assertEqual(t, nodeCerts[KubeCNICertName].Certificate.Subject.CommonName, "system:kube-cni", "")
+	assertEqual(t, len(nodeCerts[KubeCNICertName].Certificate.Subject.Organization), 0, "CNI certificate has an organization")
+
+	// rotating a node certificate leaves the other ones untouched, removed nodes lose their certificate
+	otherCertPEM := certificateMap[GetKubeletCrtName("1.1.1.1")].CertificatePEM
+	rotatedCertPEM := certificateMap[GetKubeletCrtName("2.2.2.2")].CertificatePEM
+	if err := GenerateKubeletCertificate(context.Background(), certificateMap, rkeConfig, rkeConfig.Nodes[1], true); err != nil {
+		t.Fatalf("Failed to rotate kubelet certificate: %v", err)
+	}
+	assertEqual(t, certificateMap[GetKubeletCrtName("1.1.1.1")].CertificatePEM, otherCertPEM, "Kubelet certificate of node [1.1.1.1] was rotated")
+	if certificateMap[GetKubeletCrtName("2.2.2.2")].CertificatePEM == rotatedCertPEM {
+		t.Fatalf("Kubelet certificate of node [2.2.2.2] was not rotated")
+	}
+	rkeConfig.Nodes = rkeConfig.Nodes[:1]
+	if err := GenerateKubeletCertificates(context.Background(), certificateMap, rkeConfig, "", "", false); err != nil {
+		t.Fatalf("Failed to generate kubelet certificates: %v", err)
+	}
+	if _, ok := certificateMap[GetKubeletCrtName("2.2.2.2")]; ok {
+		t.Fatalf("Kubelet certificate of a removed node is kept")
+	}
+}
+
 func isStringInSlice(a string, list []string) bool {
 	for _, b := range list {
 		if b == a {
@@ -121,3 +350,26 @@ func assertEqual(t *testing.T, a interface{}, b interface{}, message string) {
 	}
 	t.Fatal(message)
 }
+
+func TestGetNodeCertificateNames(t *testing.T) {
+	rkeConfig := v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			{Address: "1.1.1.1", Role: []string{"controlplane", "etcd"}},
+			{Address: "2.2.2.2", Role: []string{"worker"}},
+		},
+	}
+	workerCerts := map[string]bool{}
+	for _, certName := range GetNodeCertificateNames(rkeConfig, "2.2.2.2") {
+		workerCerts[certName] = true
+	}
+	for _, certName := range []string{CACertName, KubeProxyCertName, KubeCNICertName, GetKubeletCrtName("2.2.2.2")} {
+		if !workerCerts[certName] {
+			t.Fatalf("Expected certificate [%s] on the worker node, got %v", certName, workerCerts)
+		}
+	}
+	for _, certName := range []string{KubeAPICertName, KubeNodeCertName, ServiceAccountTokenKeyName, GetEtcdCrtName("1.1.1.1"), GetKubeletCrtName("1.1.1.1")} {
+		if workerCerts[certName] {
+			t.Fatalf("Expected no certificate [%s] on the worker node, got %v", certName, workerCerts)
+		}
+	}
+}
@@ -2,10 +2,11 @@ package pki
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto"
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 
 	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/log"
@@ -34,11 +35,11 @@ func GenerateKubeAPICertificate(ctx context.Context, certs map[string]Certificat
 		return nil
 	}
 	log.Infof(ctx, "[certificates] Generating Kubernetes API server certificates")
-	var serviceKey *rsa.PrivateKey
+	var serviceKey crypto.Signer
 	if !rotate {
 		serviceKey = certs[KubeAPICertName].Key
 	}
-	kubeAPICrt, kubeAPIKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, KubeAPICertName, kubeAPIAltNames, serviceKey, nil)
+	kubeAPICrt, kubeAPIKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, KubeAPICertName, kubeAPIAltNames, serviceKey, GetComponentKeyAlgorithm(rkeConfig), nil)
 	if err != nil {
 		return err
 	}
@@ -69,7 +70,7 @@ func GenerateKubeAPICSR(ctx context.Context, certs map[string]CertificatePKI, rk
 		return nil
 	}
 	log.Infof(ctx, "[certificates] Generating Kubernetes API server csr")
-	kubeAPICSR, kubeAPIKey, err := GenerateCertSigningRequestAndKey(true, KubeAPICertName, kubeAPIAltNames, certs[KubeAPICertName].Key, nil)
+	kubeAPICSR, kubeAPIKey, err := GenerateCertSigningRequestAndKey(true, KubeAPICertName, kubeAPIAltNames, certs[KubeAPICertName].Key, GetComponentKeyAlgorithm(rkeConfig), nil)
 	if err != nil {
 		return err
 	}
@@ -88,11 +89,11 @@ func GenerateKubeControllerCertificate(ctx context.Context, certs map[string]Cer
 		return nil
 	}
 	log.Infof(ctx, "[certificates] Generating Kube Controller certificates")
-	var serviceKey *rsa.PrivateKey
+	var serviceKey crypto.Signer
 	if !rotate {
 		serviceKey = certs[KubeControllerCertName].Key
 	}
-	kubeControllerCrt, kubeControllerKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, getDefaultCN(KubeControllerCertName), nil, serviceKey, nil)
+	kubeControllerCrt, kubeControllerKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, getDefaultCN(KubeControllerCertName), nil, serviceKey, GetComponentKeyAlgorithm(rkeConfig), nil)
 	if err != nil {
 		return err
 	}
@@ -108,7 +109,7 @@ func GenerateKubeControllerCSR(ctx context.Context, certs map[string]Certificate
 		return nil
 	}
 	log.Infof(ctx, "[certificates] Generating Kube Controller csr")
-	kubeControllerCSR, kubeControllerKey, err := GenerateCertSigningRequestAndKey(false, getDefaultCN(KubeControllerCertName), nil, certs[KubeControllerCertName].Key, nil)
+	kubeControllerCSR, kubeControllerKey, err := GenerateCertSigningRequestAndKey(false, getDefaultCN(KubeControllerCertName), nil, certs[KubeControllerCertName].Key, GetComponentKeyAlgorithm(rkeConfig), nil)
 	if err != nil {
 		return err
 	}
@@ -127,11 +128,11 @@ func GenerateKubeSchedulerCertificate(ctx context.Context, certs map[string]Cert
 		return nil
 	}
 	log.Infof(ctx, "[certificates] Generating Kube Scheduler certificates")
-	var serviceKey *rsa.PrivateKey
+	var serviceKey crypto.Signer
 	if !rotate {
 		serviceKey = certs[KubeSchedulerCertName].Key
 	}
-	kubeSchedulerCrt, kubeSchedulerKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, getDefaultCN(KubeSchedulerCertName), nil, serviceKey, nil)
+	kubeSchedulerCrt, kubeSchedulerKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, getDefaultCN(KubeSchedulerCertName), nil, serviceKey, GetComponentKeyAlgorithm(rkeConfig), nil)
 	if err != nil {
 		return err
 	}
@@ -147,7 +148,7 @@ func GenerateKubeSchedulerCSR(ctx context.Context, certs map[string]CertificateP
 		return nil
 	}
 	log.Infof(ctx, "[certificates] Generating Kube Scheduler csr")
-	kubeSchedulerCSR, kubeSchedulerKey, err := GenerateCertSigningRequestAndKey(false, getDefaultCN(KubeSchedulerCertName), nil, certs[KubeSchedulerCertName].Key, nil)
+	kubeSchedulerCSR, kubeSchedulerKey, err := GenerateCertSigningRequestAndKey(false, getDefaultCN(KubeSchedulerCertName), nil, certs[KubeSchedulerCertName].Key, GetComponentKeyAlgorithm(rkeConfig), nil)
 	if err != nil {
 		return err
 	}
@@ -166,11 +167,11 @@ func GenerateKubeProxyCertificate(ctx context.Context, certs map[string]Certific
 		return nil
 	}
 	log.Infof(ctx, "[certificates] Generating Kube Proxy certificates")
-	var serviceKey *rsa.PrivateKey
+	var serviceKey crypto.Signer
 	if !rotate {
 		serviceKey = certs[KubeProxyCertName].Key
 	}
-	kubeProxyCrt, kubeProxyKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, getDefaultCN(KubeProxyCertName), nil, serviceKey, nil)
+	kubeProxyCrt, kubeProxyKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, getDefaultCN(KubeProxyCertName), nil, serviceKey, GetComponentKeyAlgorithm(rkeConfig), nil)
 	if err != nil {
 		return err
 	}
@@ -186,7 +187,7 @@ func GenerateKubeProxyCSR(ctx context.Context, certs map[string]CertificatePKI,
 		return nil
 	}
 	log.Infof(ctx, "[certificates] Generating Kube Proxy csr")
-	kubeProxyCSR, kubeProxyKey, err := GenerateCertSigningRequestAndKey(false, getDefaultCN(KubeProxyCertName), nil, certs[KubeProxyCertName].Key, nil)
+	kubeProxyCSR, kubeProxyKey, err := GenerateCertSigningRequestAndKey(false, getDefaultCN(KubeProxyCertName), nil, certs[KubeProxyCertName].Key, GetComponentKeyAlgorithm(rkeConfig), nil)
 	if err != nil {
 		return err
 	}
@@ -194,6 +195,45 @@ func GenerateKubeProxyCSR(ctx context.Context, certs map[string]CertificatePKI,
 	return nil
 }
 
+// GenerateKubeCNICertificate generates the client certificate the CNI plugins use on every node,
+// it's bound to its own ClusterRole instead of the node identity
+func GenerateKubeCNICertificate(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string, rotate bool) error {
+	caCrt := certs[CACertName].Certificate
+	caKey := certs[CACertName].Key
+	if caCrt == nil || caKey == nil {
+		return fmt.Errorf("CA Certificate or Key is empty")
+	}
+	if certs[KubeCNICertName].Certificate != nil && !rotate {
+		return nil
+	}
+	log.Infof(ctx, "[certificates] Generating CNI certificates")
+	var serviceKey crypto.Signer
+	if !rotate {
+		serviceKey = certs[KubeCNICertName].Key
+	}
+	kubeCNICrt, kubeCNIKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, getDefaultCN(KubeCNICertName), nil, serviceKey, GetComponentKeyAlgorithm(rkeConfig), nil)
+	if err != nil {
+		return err
+	}
+	certs[KubeCNICertName] = ToCertObject(KubeCNICertName, "", "", kubeCNICrt, kubeCNIKey, nil)
+	return nil
+}
+
+func GenerateKubeCNICSR(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig) error {
+	kubeCNICrt := certs[KubeCNICertName].Certificate
+	kubeCNICSRPEM := certs[KubeCNICertName].CSRPEM
+	if kubeCNICSRPEM != "" {
+		return nil
+	}
+	log.Infof(ctx, "[certificates] Generating CNI csr")
+	kubeCNICSR, kubeCNIKey, err := GenerateCertSigningRequestAndKey(false, getDefaultCN(KubeCNICertName), nil, certs[KubeCNICertName].Key, GetComponentKeyAlgorithm(rkeConfig), nil)
+	if err != nil {
+		return err
+	}
+	certs[KubeCNICertName] = ToCertObject(KubeCNICertName, "", "", kubeCNICrt, kubeCNIKey, kubeCNICSR)
+	return nil
+}
+
 func GenerateKubeNodeCertificate(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string, rotate bool) error {
 	// generate kubelet certificate
 	caCrt := certs[CACertName].Certificate
@@ -205,11 +245,11 @@ func GenerateKubeNodeCertificate(ctx context.Context, certs map[string]Certifica
 		return nil
 	}
 	log.Infof(ctx, "[certificates] Generating Node certificate")
-	var serviceKey *rsa.PrivateKey
+	var serviceKey crypto.Signer
 	if !rotate {
 		serviceKey = certs[KubeProxyCertName].Key
 	}
-	nodeCrt, nodeKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, KubeNodeCommonName, nil, serviceKey, []string{KubeNodeOrganizationName})
+	nodeCrt, nodeKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, KubeNodeCommonName, nil, serviceKey, GetComponentKeyAlgorithm(rkeConfig), []string{KubeNodeOrganizationName})
 	if err != nil {
 		return err
 	}
@@ -225,7 +265,7 @@ func GenerateKubeNodeCSR(ctx context.Context, certs map[string]CertificatePKI, r
 		return nil
 	}
 	log.Infof(ctx, "[certificates] Generating Node csr and key")
-	nodeCSR, nodeKey, err := GenerateCertSigningRequestAndKey(false, KubeNodeCommonName, nil, certs[KubeNodeCertName].Key, []string{KubeNodeOrganizationName})
+	nodeCSR, nodeKey, err := GenerateCertSigningRequestAndKey(false, KubeNodeCommonName, nil, certs[KubeNodeCertName].Key, GetComponentKeyAlgorithm(rkeConfig), []string{KubeNodeOrganizationName})
 	if err != nil {
 		return err
 	}
@@ -233,6 +273,67 @@ func GenerateKubeNodeCSR(ctx context.Context, certs map[string]CertificatePKI, r
 	return nil
 }
 
+// GenerateKubeletCertificates generates a client certificate per node for the kubelet, with the node identity
+// the Node authorizer and the NodeRestriction admission plugin use to limit each kubelet to its own node.
+// Certificates of nodes that were removed from the cluster are dropped.
+func GenerateKubeletCertificates(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string, rotate bool) error {
+	kubeletNames := map[string]bool{}
+	for _, node := range GetKubeletCertNodes(rkeConfig) {
+		if err := GenerateKubeletCertificate(ctx, certs, rkeConfig, node, rotate); err != nil {
+			return err
+		}
+		kubeletNames[GetKubeletCrtName(node.Address)] = true
+	}
+	for certName := range certs {
+		if strings.HasPrefix(certName, KubeletCertName+"-") && !kubeletNames[certName] {
+			delete(certs, certName)
+		}
+	}
+	return nil
+}
+
+// GenerateKubeletCertificate generates the kubelet client certificate of a node, it's regenerated if the node was renamed
+func GenerateKubeletCertificate(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, node v3.RKEConfigNode, rotate bool) error {
+	caCrt := certs[CACertName].Certificate
+	caKey := certs[CACertName].Key
+	if caCrt == nil || caKey == nil {
+		return fmt.Errorf("CA Certificate or Key is empty")
+	}
+	kubeletName := GetKubeletCrtName(node.Address)
+	commonName := GetKubeletCommonName(node)
+	if kubeletCrt := certs[kubeletName].Certificate; kubeletCrt != nil && kubeletCrt.Subject.CommonName == commonName && !rotate {
+		return nil
+	}
+	log.Infof(ctx, "[certificates] Generating kubelet certificate for node [%s]", node.Address)
+	var serviceKey crypto.Signer
+	if !rotate {
+		serviceKey = certs[kubeletName].Key
+	}
+	kubeletCrt, kubeletKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, commonName, nil, serviceKey, GetComponentKeyAlgorithm(rkeConfig), []string{KubeNodeOrganizationName})
+	if err != nil {
+		return err
+	}
+	certs[kubeletName] = ToCertObject(kubeletName, commonName, KubeNodeOrganizationName, kubeletCrt, kubeletKey, nil)
+	return nil
+}
+
+func GenerateKubeletCSRs(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig) error {
+	for _, node := range GetKubeletCertNodes(rkeConfig) {
+		kubeletName := GetKubeletCrtName(node.Address)
+		if certs[kubeletName].CSRPEM != "" {
+			continue
+		}
+		commonName := GetKubeletCommonName(node)
+		log.Infof(ctx, "[certificates] Generating kubelet csr and key for node [%s]", node.Address)
+		kubeletCSR, kubeletKey, err := GenerateCertSigningRequestAndKey(false, commonName, nil, certs[kubeletName].Key, GetComponentKeyAlgorithm(rkeConfig), []string{KubeNodeOrganizationName})
+		if err != nil {
+			return err
+		}
+		certs[kubeletName] = ToCertObject(kubeletName, commonName, KubeNodeOrganizationName, certs[kubeletName].Certificate, kubeletKey, kubeletCSR)
+	}
+	return nil
+}
+
 func GenerateKubeAdminCertificate(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string, rotate bool) error {
 	// generate Admin certificate and key
 	log.Infof(ctx, "[certificates] Generating admin certificates and kubeconfig")
@@ -246,11 +347,11 @@ func GenerateKubeAdminCertificate(ctx context.Context, certs map[string]Certific
 		configPath = ClusterConfig
 	}
 	localKubeConfigPath := GetLocalKubeConfig(configPath, configDir)
-	var serviceKey *rsa.PrivateKey
+	var serviceKey crypto.Signer
 	if !rotate {
 		serviceKey = certs[KubeAdminCertName].Key
 	}
-	kubeAdminCrt, kubeAdminKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, KubeAdminCertName, nil, serviceKey, []string{KubeAdminOrganizationName})
+	kubeAdminCrt, kubeAdminKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, KubeAdminCertName, nil, serviceKey, GetComponentKeyAlgorithm(rkeConfig), []string{KubeAdminOrganizationName})
 	if err != nil {
 		return err
 	}
@@ -260,9 +361,9 @@ func GenerateKubeAdminCertificate(ctx context.Context, certs map[string]Certific
 			"https://"+cpHosts[0].Address+":6443",
 			rkeConfig.ClusterName,
 			KubeAdminCertName,
-			string(cert.EncodeCertPEM(caCrt)),
+			certs[CACertName].CertificatePEM,
 			string(cert.EncodeCertPEM(kubeAdminCrt)),
-			string(cert.EncodePrivateKeyPEM(kubeAdminKey)))
+			string(EncodePrivateKeyPEM(kubeAdminKey)))
 		kubeAdminCertObj.Config = kubeAdminConfig
 		kubeAdminCertObj.ConfigPath = localKubeConfigPath
 	} else {
@@ -279,7 +380,7 @@ func GenerateKubeAdminCSR(ctx context.Context, certs map[string]CertificatePKI,
 	if kubeAdminCSRPEM != "" {
 		return nil
 	}
-	kubeAdminCSR, kubeAdminKey, err := GenerateCertSigningRequestAndKey(false, KubeAdminCertName, nil, certs[KubeAdminCertName].Key, []string{KubeAdminOrganizationName})
+	kubeAdminCSR, kubeAdminKey, err := GenerateCertSigningRequestAndKey(false, KubeAdminCertName, nil, certs[KubeAdminCertName].Key, GetComponentKeyAlgorithm(rkeConfig), []string{KubeAdminOrganizationName})
 	if err != nil {
 		return err
 	}
@@ -300,11 +401,11 @@ func GenerateAPIProxyClientCertificate(ctx context.Context, certs map[string]Cer
 		return nil
 	}
 	log.Infof(ctx, "[certificates] Generating Kubernetes API server proxy client certificates")
-	var serviceKey *rsa.PrivateKey
+	var serviceKey crypto.Signer
 	if !rotate {
 		serviceKey = certs[APIProxyClientCertName].Key
 	}
-	apiserverProxyClientCrt, apiserverProxyClientKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, APIProxyClientCertName, nil, serviceKey, nil)
+	apiserverProxyClientCrt, apiserverProxyClientKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, APIProxyClientCertName, nil, serviceKey, GetComponentKeyAlgorithm(rkeConfig), nil)
 	if err != nil {
 		return err
 	}
@@ -320,7 +421,7 @@ func GenerateAPIProxyClientCSR(ctx context.Context, certs map[string]Certificate
 		return nil
 	}
 	log.Infof(ctx, "[certificates] Generating Kubernetes API server proxy client csr")
-	apiserverProxyClientCSR, apiserverProxyClientKey, err := GenerateCertSigningRequestAndKey(true, APIProxyClientCertName, nil, certs[APIProxyClientCertName].Key, nil)
+	apiserverProxyClientCSR, apiserverProxyClientKey, err := GenerateCertSigningRequestAndKey(true, APIProxyClientCertName, nil, certs[APIProxyClientCertName].Key, GetComponentKeyAlgorithm(rkeConfig), nil)
 	if err != nil {
 		return err
 	}
@@ -333,11 +434,11 @@ func GenerateExternalEtcdCertificates(ctx context.Context, certs map[string]Cert
 	if err != nil {
 		return err
 	}
-	clientKey, err := cert.ParsePrivateKeyPEM([]byte(rkeConfig.Services.Etcd.Key))
+	clientKey, err := ParsePrivateKeyPEM([]byte(rkeConfig.Services.Etcd.Key))
 	if err != nil {
 		return err
 	}
-	certs[EtcdClientCertName] = ToCertObject(EtcdClientCertName, "", "", clientCert[0], clientKey.(*rsa.PrivateKey), nil)
+	certs[EtcdClientCertName] = ToCertObject(EtcdClientCertName, "", "", clientCert[0], clientKey, nil)
 
 	caCert, err := cert.ParseCertsPEM([]byte(rkeConfig.Services.Etcd.CACert))
 	if err != nil {
@@ -392,12 +493,12 @@ func GenerateEtcdCertificates(ctx context.Context, certs map[string]CertificateP
 				}
 			}
 		}
-		var serviceKey *rsa.PrivateKey
+		var serviceKey crypto.Signer
 		if !rotate {
 			serviceKey = certs[etcdName].Key
 		}
 		log.Infof(ctx, "[certificates] Generating etcd-%s certificate and key", host.InternalAddress)
-		etcdCrt, etcdKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, EtcdCertName, etcdAltNames, serviceKey, nil)
+		etcdCrt, etcdKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, EtcdCertName, etcdAltNames, serviceKey, GetComponentKeyAlgorithm(rkeConfig), nil)
 		if err != nil {
 			return err
 		}
@@ -422,7 +523,7 @@ func GenerateEtcdCSRs(ctx context.Context, certs map[string]CertificatePKI, rkeC
 			return nil
 		}
 		log.Infof(ctx, "[certificates] Generating etcd-%s csr and key", host.InternalAddress)
-		etcdCSR, etcdKey, err := GenerateCertSigningRequestAndKey(true, EtcdCertName, etcdAltNames, certs[etcdName].Key, nil)
+		etcdCSR, etcdKey, err := GenerateCertSigningRequestAndKey(true, EtcdCertName, etcdAltNames, certs[etcdName].Key, GetComponentKeyAlgorithm(rkeConfig), nil)
 		if err != nil {
 			return err
 		}
@@ -446,7 +547,7 @@ func GenerateServiceTokenKey(ctx context.Context, certs map[string]CertificatePK
 	if certs[ServiceAccountTokenKeyName].Key == nil {
 		privateAPIKey = certs[KubeAPICertName].Key
 	}
-	tokenCrt, tokenKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, ServiceAccountTokenKeyName, nil, privateAPIKey, nil)
+	tokenCrt, tokenKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, ServiceAccountTokenKeyName, nil, privateAPIKey, GetComponentKeyAlgorithm(rkeConfig), nil)
 	if err != nil {
 		return fmt.Errorf("Failed to generate private key for service account token: %v", err)
 	}
@@ -454,18 +555,22 @@ func GenerateServiceTokenKey(ctx context.Context, certs map[string]CertificatePK
 	return nil
 }
 
-func GenerateRKECACerts(ctx context.Context, certs map[string]CertificatePKI, configPath, configDir string) error {
-	if err := GenerateRKEMasterCACert(ctx, certs, configPath, configDir); err != nil {
+func GenerateRKECACerts(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string) error {
+	if err := GenerateRKEMasterCACert(ctx, certs, rkeConfig, configPath, configDir); err != nil {
 		return err
 	}
-	return GenerateRKERequestHeaderCACert(ctx, certs, configPath, configDir)
+	return GenerateRKERequestHeaderCACert(ctx, certs, rkeConfig, configPath, configDir)
 }
 
-func GenerateRKEMasterCACert(ctx context.Context, certs map[string]CertificatePKI, configPath, configDir string) error {
+func GenerateRKEMasterCACert(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string) error {
+	if rkeConfig.PKI != nil && rkeConfig.PKI.IntermediateCA != nil {
+		log.Infof(ctx, "[certificates] Using intermediate CA certificate as kubernetes CA")
+		return GenerateIntermediateCACert(certs, rkeConfig.PKI.IntermediateCA)
+	}
 	// generate kubernetes CA certificate and key
 	log.Infof(ctx, "[certificates] Generating CA kubernetes certificates")
 
-	caCrt, caKey, err := GenerateCACertAndKey(CACertName, nil)
+	caCrt, caKey, err := GenerateCACertAndKey(CACertName, nil, GetCAKeyAlgorithm(rkeConfig))
 	if err != nil {
 		return err
 	}
@@ -473,10 +578,10 @@ func GenerateRKEMasterCACert(ctx context.Context, certs map[string]CertificatePK
 	return nil
 }
 
-func GenerateRKERequestHeaderCACert(ctx context.Context, certs map[string]CertificatePKI, configPath, configDir string) error {
+func GenerateRKERequestHeaderCACert(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string) error {
 	// generate request header client CA certificate and key
 	log.Infof(ctx, "[certificates] Generating Kubernetes API server aggregation layer requestheader client CA certificates")
-	requestHeaderCACrt, requestHeaderCAKey, err := GenerateCACertAndKey(RequestHeaderCACertName, nil)
+	requestHeaderCACrt, requestHeaderCAKey, err := GenerateCACertAndKey(RequestHeaderCACertName, nil, GetCAKeyAlgorithm(rkeConfig))
 	if err != nil {
 		return err
 	}
@@ -491,7 +596,9 @@ func GenerateRKEServicesCerts(ctx context.Context, certs map[string]CertificateP
 		GenerateKubeControllerCertificate,
 		GenerateKubeSchedulerCertificate,
 		GenerateKubeProxyCertificate,
+		GenerateKubeCNICertificate,
 		GenerateKubeNodeCertificate,
+		GenerateKubeletCertificates,
 		GenerateKubeAdminCertificate,
 		GenerateAPIProxyClientCertificate,
 		GenerateEtcdCertificates,
@@ -513,7 +620,9 @@ func GenerateRKEServicesCSRs(ctx context.Context, certs map[string]CertificatePK
 		GenerateKubeControllerCSR,
 		GenerateKubeSchedulerCSR,
 		GenerateKubeProxyCSR,
+		GenerateKubeCNICSR,
 		GenerateKubeNodeCSR,
+		GenerateKubeletCSRs,
 		GenerateKubeAdminCSR,
 		GenerateAPIProxyClientCSR,
 		GenerateEtcdCSRs,
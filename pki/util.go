@@ -1,6 +1,7 @@
 package pki
 
 import (
+	"crypto"
 	cryptorand "crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -27,18 +28,19 @@ import (
 
 func GenerateSignedCertAndKey(
 	caCrt *x509.Certificate,
-	caKey *rsa.PrivateKey,
+	caKey crypto.Signer,
 	serverCrt bool,
 	commonName string,
 	altNames *cert.AltNames,
-	reusedKey *rsa.PrivateKey,
-	orgs []string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	reusedKey crypto.Signer,
+	keyAlgorithm string,
+	orgs []string) (*x509.Certificate, crypto.Signer, error) {
 	// Generate a generic signed certificate
-	var rootKey *rsa.PrivateKey
+	var rootKey crypto.Signer
 	var err error
 	rootKey = reusedKey
 	if reusedKey == nil {
-		rootKey, err = cert.NewPrivateKey()
+		rootKey, err = NewPrivateKey(keyAlgorithm)
 		if err != nil {
 			return nil, nil, fmt.Errorf("Failed to generate private key for %s certificate: %v", commonName, err)
 		}
@@ -67,14 +69,15 @@ func GenerateCertSigningRequestAndKey(
 	serverCrt bool,
 	commonName string,
 	altNames *cert.AltNames,
-	reusedKey *rsa.PrivateKey,
-	orgs []string) ([]byte, *rsa.PrivateKey, error) {
+	reusedKey crypto.Signer,
+	keyAlgorithm string,
+	orgs []string) ([]byte, crypto.Signer, error) {
 	// Generate a generic signed certificate
-	var rootKey *rsa.PrivateKey
+	var rootKey crypto.Signer
 	var err error
 	rootKey = reusedKey
 	if reusedKey == nil {
-		rootKey, err = cert.NewPrivateKey()
+		rootKey, err = NewPrivateKey(keyAlgorithm)
 		if err != nil {
 			return nil, nil, fmt.Errorf("Failed to generate private key for %s certificate: %v", commonName, err)
 		}
@@ -100,11 +103,11 @@ func GenerateCertSigningRequestAndKey(
 	return clientCSR, rootKey, nil
 }
 
-func GenerateCACertAndKey(commonName string, privateKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey, error) {
+func GenerateCACertAndKey(commonName string, privateKey crypto.Signer, keyAlgorithm string) (*x509.Certificate, crypto.Signer, error) {
 	var err error
 	rootKey := privateKey
 	if rootKey == nil {
-		rootKey, err = cert.NewPrivateKey()
+		rootKey, err = NewPrivateKey(keyAlgorithm)
 		if err != nil {
 			return nil, nil, fmt.Errorf("Failed to generate private key for CA certificate: %v", err)
 		}
@@ -112,7 +115,7 @@ func GenerateCACertAndKey(commonName string, privateKey *rsa.PrivateKey) (*x509.
 	caConfig := cert.Config{
 		CommonName: commonName,
 	}
-	kubeCACert, err := cert.NewSelfSignedCACert(caConfig, rootKey)
+	kubeCACert, err := newSelfSignedCACert(caConfig, rootKey)
 	if err != nil {
 		return nil, nil, fmt.Errorf("Failed to generate CA certificate: %v", err)
 	}
@@ -184,12 +187,16 @@ func (c *CertificatePKI) ToEnv() []string {
 }
 
 func (c *CertificatePKI) CertToEnv() string {
-	encodedCrt := cert.EncodeCertPEM(c.Certificate)
-	return fmt.Sprintf("%s=%s", c.EnvName, string(encodedCrt))
+	// the PEM of a CA certificate can hold its chain
+	encodedCrt := c.CertificatePEM
+	if len(encodedCrt) == 0 {
+		encodedCrt = string(cert.EncodeCertPEM(c.Certificate))
+	}
+	return fmt.Sprintf("%s=%s", c.EnvName, encodedCrt)
 }
 
 func (c *CertificatePKI) KeyToEnv() string {
-	encodedKey := cert.EncodePrivateKeyPEM(c.Key)
+	encodedKey := EncodePrivateKeyPEM(c.Key)
 	return fmt.Sprintf("%s=%s", c.KeyEnvName, string(encodedKey))
 }
 
@@ -214,6 +221,29 @@ func GetEtcdCrtName(address string) string {
 	return fmt.Sprintf("%s-%s", EtcdCertName, newAddress)
 }
 
+// GetKubeletCrtName returns the name of the kubelet client certificate of a node
+func GetKubeletCrtName(address string) string {
+	newAddress := strings.Replace(address, ".", "-", -1)
+	return fmt.Sprintf("%s-%s", KubeletCertName, newAddress)
+}
+
+// GetKubeletCertNodes returns the nodes that get a kubelet certificate, none when the kubelets request their own with TLS bootstrap
+func GetKubeletCertNodes(rkeConfig v3.RancherKubernetesEngineConfig) []v3.RKEConfigNode {
+	if rkeConfig.Services.Kubelet.TLSBootstrap {
+		return nil
+	}
+	return rkeConfig.Nodes
+}
+
+// GetKubeletCommonName returns the node identity of a kubelet, as expected by the Node authorizer
+func GetKubeletCommonName(node v3.RKEConfigNode) string {
+	nodeName := node.HostnameOverride
+	if len(nodeName) == 0 {
+		nodeName = node.Address
+	}
+	return fmt.Sprintf("%s:%s", KubeNodeCommonName, strings.ToLower(nodeName))
+}
+
 func GetCertPath(name string) string {
 	return fmt.Sprintf("%s%s.pem", CertPathPrefix, name)
 }
@@ -238,7 +268,7 @@ func GetConfigTempPath(name string) string {
 	return fmt.Sprintf("%skubecfg-%s.yaml", TempCertPath, name)
 }
 
-func ToCertObject(componentName, commonName, ouName string, certificate *x509.Certificate, key *rsa.PrivateKey, csrASN1 []byte) CertificatePKI {
+func ToCertObject(componentName, commonName, ouName string, certificate *x509.Certificate, key crypto.Signer, csrASN1 []byte) CertificatePKI {
 	var config, configPath, configEnvName, certificatePEM, keyPEM string
 	var csr *x509.CertificateRequest
 	var csrPEM []byte
@@ -255,7 +285,7 @@ func ToCertObject(componentName, commonName, ouName string, certificate *x509.Ce
 		certificatePEM = string(cert.EncodeCertPEM(certificate))
 	}
 	if key != nil {
-		keyPEM = string(cert.EncodePrivateKeyPEM(key))
+		keyPEM = string(EncodePrivateKeyPEM(key))
 	}
 	if csrASN1 != nil {
 		csr, _ = x509.ParseCertificateRequest(csrASN1)
@@ -302,6 +332,7 @@ func getControlCertKeys() []string {
 		KubeControllerCertName,
 		KubeSchedulerCertName,
 		KubeProxyCertName,
+		KubeCNICertName,
 		KubeNodeCertName,
 		EtcdClientCertName,
 		EtcdClientCACertName,
@@ -310,11 +341,12 @@ func getControlCertKeys() []string {
 	}
 }
 
+// getWorkerCertKeys leaves out the shared kube-node certificate, kubelets on workers only get their own identity
 func getWorkerCertKeys() []string {
 	return []string{
 		CACertName,
 		KubeProxyCertName,
-		KubeNodeCertName,
+		KubeCNICertName,
 	}
 }
 
@@ -322,6 +354,7 @@ func getEtcdCertKeys(rkeNodes []v3.RKEConfigNode, etcdRole string) []string {
 	certList := []string{
 		CACertName,
 		KubeProxyCertName,
+		KubeCNICertName,
 		KubeNodeCertName,
 	}
 	etcdHosts := hosts.NodesToHosts(rkeNodes, etcdRole)
@@ -373,7 +406,7 @@ func getTempPath(s string) string {
 func populateCertMap(tmpCerts map[string]CertificatePKI, localConfigPath string, extraHosts []*hosts.Host) map[string]CertificatePKI {
 	certs := make(map[string]CertificatePKI)
 	// CACert
-	certs[CACertName] = ToCertObject(CACertName, "", "", tmpCerts[CACertName].Certificate, tmpCerts[CACertName].Key, nil)
+	certs[CACertName] = ToCACertObject(CACertName, GetCertificateChain(tmpCerts[CACertName]), tmpCerts[CACertName].Key)
 	// KubeAPI
 	certs[KubeAPICertName] = ToCertObject(KubeAPICertName, "", "", tmpCerts[KubeAPICertName].Certificate, tmpCerts[KubeAPICertName].Key, nil)
 	// kubeController
@@ -402,8 +435,42 @@ func populateCertMap(tmpCerts map[string]CertificatePKI, localConfigPath string,
 	return certs
 }
 
+// Overriding k8s.io/client-go/util/cert.NewSelfSignedCACert function to support ECDSA keys
+func newSelfSignedCACert(cfg cert.Config, key crypto.Signer) (*x509.Certificate, error) {
+	serial, err := cryptorand.Int(cryptorand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   cfg.CommonName,
+			Organization: cfg.Organization,
+		},
+		NotBefore:             now.UTC(),
+		NotAfter:              now.Add(duration365d * 10).UTC(),
+		KeyUsage:              getKeyUsage(key) | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDERBytes, err := x509.CreateCertificate(cryptorand.Reader, &tmpl, &tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(certDERBytes)
+}
+
+// getKeyUsage only sets key encipherment for RSA keys, the others can't encipher
+func getKeyUsage(key crypto.Signer) x509.KeyUsage {
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		return x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	}
+	return x509.KeyUsageDigitalSignature
+}
+
 // Overriding k8s.io/client-go/util/cert.NewSignedCert function to extend the expiration date to 10 years instead of 1 year
-func newSignedCert(cfg cert.Config, key *rsa.PrivateKey, caCert *x509.Certificate, caKey *rsa.PrivateKey) (*x509.Certificate, error) {
+func newSignedCert(cfg cert.Config, key crypto.Signer, caCert *x509.Certificate, caKey crypto.Signer) (*x509.Certificate, error) {
 	serial, err := cryptorand.Int(cryptorand.Reader, new(big.Int).SetInt64(math.MaxInt64))
 	if err != nil {
 		return nil, err
@@ -425,7 +492,7 @@ func newSignedCert(cfg cert.Config, key *rsa.PrivateKey, caCert *x509.Certificat
 		SerialNumber: serial,
 		NotBefore:    caCert.NotBefore,
 		NotAfter:     time.Now().Add(duration365d * 10).UTC(),
-		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		KeyUsage:     getKeyUsage(key),
 		ExtKeyUsage:  cfg.Usages,
 	}
 	certDERBytes, err := x509.CreateCertificate(cryptorand.Reader, &certTmpl, caCert, key.Public(), caKey)
@@ -435,7 +502,7 @@ func newSignedCert(cfg cert.Config, key *rsa.PrivateKey, caCert *x509.Certificat
 	return x509.ParseCertificate(certDERBytes)
 }
 
-func newCertSigningRequest(cfg cert.Config, key *rsa.PrivateKey) ([]byte, error) {
+func newCertSigningRequest(cfg cert.Config, key crypto.Signer) ([]byte, error) {
 	if len(cfg.CommonName) == 0 {
 		return nil, errors.New("must specify a CommonName")
 	}
@@ -477,17 +544,14 @@ func deepEqualIPsAltNames(oldIPs, newIPs []net.IP) bool {
 }
 
 func TransformPEMToObject(in map[string]CertificatePKI) map[string]CertificatePKI {
-	var certificate *x509.Certificate
 	out := map[string]CertificatePKI{}
 	for k, v := range in {
+		var certificate *x509.Certificate
 		certs, _ := cert.ParseCertsPEM([]byte(v.CertificatePEM))
-		key, _ := cert.ParsePrivateKeyPEM([]byte(v.KeyPEM))
+		key, _ := ParsePrivateKeyPEM([]byte(v.KeyPEM))
 		if len(certs) > 0 {
 			certificate = certs[0]
 		}
-		if key != nil {
-			key = key.(*rsa.PrivateKey)
-		}
 		o := CertificatePKI{
 			ConfigEnvName:  v.ConfigEnvName,
 			Name:           v.Name,
@@ -504,7 +568,7 @@ func TransformPEMToObject(in map[string]CertificatePKI) map[string]CertificatePK
 			KeyPEM:         v.KeyPEM,
 		}
 		if key != nil {
-			o.Key = key.(*rsa.PrivateKey)
+			o.Key = key
 		}
 
 		out[k] = o
@@ -537,7 +601,12 @@ func ReadCSRsAndKeysFromDir(certDir string) (map[string]CertificatePKI, error) {
 			if err != nil {
 				return nil, err
 			}
-			certMap[certName] = ToCertObject(certName, getCommonName(certName), getOUName(certName), nil, key, csrASN1)
+			commonName := getCommonName(certName)
+			// kubelet certificates are named after their node
+			if csr, err := x509.ParseCertificateRequest(csrASN1); err == nil && strings.HasPrefix(certName, KubeletCertName+"-") {
+				commonName = csr.Subject.CommonName
+			}
+			certMap[certName] = ToCertObject(certName, commonName, getOUName(certName), nil, key, csrASN1)
 		}
 	}
 
@@ -557,8 +626,8 @@ func ReadCertsAndKeysFromDir(certDir string) (map[string]CertificatePKI, error)
 
 	for _, file := range files {
 		logrus.Debugf("[certificates] reading file %s from directory [%s]", file.Name(), certDir)
-		// fetching cert
-		cert, err := getCertFromFile(certDir, file.Name())
+		// fetching cert, CA certificates can be followed by their chain
+		chain, err := getCertChainFromFile(certDir, file.Name())
 		if err != nil {
 			continue
 		}
@@ -568,7 +637,22 @@ func ReadCertsAndKeysFromDir(certDir string) (map[string]CertificatePKI, error)
 		if err != nil {
 			continue
 		}
-		certMap[certName] = ToCertObject(certName, getCommonName(certName), getOUName(certName), cert, key, nil)
+		if certName == CACertName || certName == RequestHeaderCACertName {
+			caCertObj := ToCACertObject(certName, chain, key)
+			caCertObj.CommonName = getCommonName(certName)
+			certMap[certName] = caCertObj
+			continue
+		}
+		var cert *x509.Certificate
+		commonName := getCommonName(certName)
+		if len(chain) > 0 {
+			cert = chain[0]
+			// kubelet certificates are named after their node
+			if strings.HasPrefix(certName, KubeletCertName+"-") {
+				commonName = cert.Subject.CommonName
+			}
+		}
+		certMap[certName] = ToCertObject(certName, commonName, getOUName(certName), cert, key, nil)
 	}
 
 	return certMap, nil
@@ -589,33 +673,35 @@ func getOUName(certName string) string {
 		return KubeNodeOrganizationName
 	case KubeAdminCertName:
 		return KubeAdminOrganizationName
-	default:
-		return ""
 	}
+	if strings.HasPrefix(certName, KubeletCertName+"-") {
+		return KubeNodeOrganizationName
+	}
+	return ""
 }
 
-func getCertFromFile(certDir string, fileName string) (*x509.Certificate, error) {
-	var certificate *x509.Certificate
+func getCertChainFromFile(certDir string, fileName string) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
 	certPEM, _ := ioutil.ReadFile(filepath.Join(certDir, fileName))
 	if len(certPEM) > 0 {
-		certificates, err := cert.ParseCertsPEM(certPEM)
+		var err error
+		certificates, err = cert.ParseCertsPEM(certPEM)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read certificate [%s]: %v", fileName, err)
 		}
-		certificate = certificates[0]
 	}
-	return certificate, nil
+	return certificates, nil
 }
 
-func getKeyFromFile(certDir string, fileName string) (*rsa.PrivateKey, error) {
-	var key *rsa.PrivateKey
+func getKeyFromFile(certDir string, fileName string) (crypto.Signer, error) {
+	var key crypto.Signer
 	keyPEM, _ := ioutil.ReadFile(filepath.Join(certDir, fileName))
 	if len(keyPEM) > 0 {
-		keyInterface, err := cert.ParsePrivateKeyPEM(keyPEM)
+		parsedKey, err := ParsePrivateKeyPEM(keyPEM)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read key [%s]: %v", fileName, err)
 		}
-		key = keyInterface.(*rsa.PrivateKey)
+		key = parsedKey
 	}
 	return key, nil
 }
@@ -672,9 +758,15 @@ func ValidateBundleContent(rkeConfig *v3.RancherKubernetesEngineConfig, certBund
 	if certBundle[CACertName].Certificate == nil {
 		return fmt.Errorf("Failed to find master CA certificate")
 	}
+	// kube-controller-manager signs the certificates the kubelets request
+	if rkeConfig.Services.Kubelet.TLSBootstrap && certBundle[CACertName].Key == nil {
+		return fmt.Errorf("Failed to find master CA key, it's required to sign kubelet certificates with kubelet TLS bootstrap")
+	}
 	if certBundle[RequestHeaderCACertName].Certificate == nil {
 		logrus.Warnf("Failed to find RequestHeader CA certificate, using master CA certificate")
-		certBundle[RequestHeaderCACertName] = ToCertObject(RequestHeaderCACertName, RequestHeaderCACertName, "", certBundle[CACertName].Certificate, nil, nil)
+		requestHeaderCACertObj := ToCACertObject(RequestHeaderCACertName, GetCertificateChain(certBundle[CACertName]), nil)
+		requestHeaderCACertObj.CommonName = RequestHeaderCACertName
+		certBundle[RequestHeaderCACertName] = requestHeaderCACertObj
 	}
 	// make sure all components exists
 	ComponentsCerts := []string{
@@ -682,6 +774,7 @@ func ValidateBundleContent(rkeConfig *v3.RancherKubernetesEngineConfig, certBund
 		KubeControllerCertName,
 		KubeSchedulerCertName,
 		KubeProxyCertName,
+		KubeCNICertName,
 		KubeNodeCertName,
 		KubeAdminCertName,
 		APIProxyClientCertName,
@@ -698,6 +791,15 @@ func ValidateBundleContent(rkeConfig *v3.RancherKubernetesEngineConfig, certBund
 			return fmt.Errorf("Failed to find etcd [%s] Certificate or Key", etcdName)
 		}
 	}
+	for _, node := range GetKubeletCertNodes(*rkeConfig) {
+		kubeletName := GetKubeletCrtName(node.Address)
+		if certBundle[kubeletName].Certificate == nil || certBundle[kubeletName].Key == nil {
+			return fmt.Errorf("Failed to find kubelet [%s] Certificate or Key, it can be generated with 'rke cert generate-csr'", kubeletName)
+		}
+		if commonName := certBundle[kubeletName].Certificate.Subject.CommonName; commonName != GetKubeletCommonName(node) {
+			return fmt.Errorf("Kubelet certificate [%s] common name is [%s], expected [%s]", kubeletName, commonName, GetKubeletCommonName(node))
+		}
+	}
 	// Configure kubeconfig
 	cpHosts := hosts.NodesToHosts(rkeConfig.Nodes, controlRole)
 	localKubeConfigPath := GetLocalKubeConfig(configPath, configDir)
@@ -707,9 +809,9 @@ func ValidateBundleContent(rkeConfig *v3.RancherKubernetesEngineConfig, certBund
 			"https://"+cpHosts[0].Address+":6443",
 			rkeConfig.ClusterName,
 			KubeAdminCertName,
-			string(cert.EncodeCertPEM(certBundle[CACertName].Certificate)),
+			certBundle[CACertName].CertificatePEM,
 			string(cert.EncodeCertPEM(certBundle[KubeAdminCertName].Certificate)),
-			string(cert.EncodePrivateKeyPEM(certBundle[KubeAdminCertName].Key)))
+			string(EncodePrivateKeyPEM(certBundle[KubeAdminCertName].Key)))
 		kubeAdminCertObj.Config = kubeAdminConfig
 		kubeAdminCertObj.ConfigPath = localKubeConfigPath
 		certBundle[KubeAdminCertName] = kubeAdminCertObj
@@ -718,13 +820,17 @@ func ValidateBundleContent(rkeConfig *v3.RancherKubernetesEngineConfig, certBund
 }
 
 func validateCAIssuer(rkeConfig *v3.RancherKubernetesEngineConfig, certBundle map[string]CertificatePKI) error {
-	// make sure all certs are signed by CA cert
-	caCert := certBundle[CACertName].Certificate
+	// make sure all certs chain up to the root through the CA cert
+	caChain := GetCertificateChain(certBundle[CACertName])
+	if err := verifyCertificateChain(caChain[0], caChain); err != nil {
+		return fmt.Errorf("Failed to verify the custom CA certificate chain: %v", err)
+	}
 	ComponentsCerts := []string{
 		KubeAPICertName,
 		KubeControllerCertName,
 		KubeSchedulerCertName,
 		KubeProxyCertName,
+		KubeCNICertName,
 		KubeNodeCertName,
 		KubeAdminCertName,
 	}
@@ -733,14 +839,17 @@ func validateCAIssuer(rkeConfig *v3.RancherKubernetesEngineConfig, certBundle ma
 		etcdName := GetEtcdCrtName(host.InternalAddress)
 		ComponentsCerts = append(ComponentsCerts, etcdName)
 	}
+	for _, node := range GetKubeletCertNodes(*rkeConfig) {
+		ComponentsCerts = append(ComponentsCerts, GetKubeletCrtName(node.Address))
+	}
 	for _, componentCert := range ComponentsCerts {
-		if certBundle[componentCert].Certificate.Issuer.CommonName != caCert.Subject.CommonName {
-			return fmt.Errorf("Component [%s] is not signed by the custom CA certificate", componentCert)
+		if err := verifyCertificateChain(certBundle[componentCert].Certificate, caChain); err != nil {
+			return fmt.Errorf("Component [%s] is not signed by the custom CA certificate: %v", componentCert, err)
 		}
 	}
-	requestHeaderCACert := certBundle[RequestHeaderCACertName].Certificate
-	if certBundle[APIProxyClientCertName].Certificate.Issuer.CommonName != requestHeaderCACert.Subject.CommonName {
-		return fmt.Errorf("Component [%s] is not signed by the custom Request Header CA certificate", APIProxyClientCertName)
+	requestHeaderCAChain := GetCertificateChain(certBundle[RequestHeaderCACertName])
+	if err := verifyCertificateChain(certBundle[APIProxyClientCertName].Certificate, requestHeaderCAChain); err != nil {
+		return fmt.Errorf("Component [%s] is not signed by the custom Request Header CA certificate: %v", APIProxyClientCertName, err)
 	}
 	return nil
 }
@@ -2,7 +2,9 @@ package services
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/rancher/rke/docker"
 	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/log"
 	"github.com/rancher/rke/pki"
@@ -16,24 +18,15 @@ func RunControlPlane(ctx context.Context, controlHosts []*hosts.Host, localConnD
 		return nil
 	}
 	log.Infof(ctx, "[%s] Building up Controller Plane..", ControlRole)
-	var errgrp errgroup.Group
-
-	hostsQueue := util.GetObjectQueue(controlHosts)
-	for w := 0; w < WorkerThreads; w++ {
-		errgrp.Go(func() error {
-			var errList []error
-			for host := range hostsQueue {
-				runHost := host.(*hosts.Host)
-				err := doDeployControlHost(ctx, runHost, localConnDialerFactory, prsMap, cpNodePlanMap[runHost.Address].Processes, alpineImage, certMap)
-				if err != nil {
-					errList = append(errList, err)
-				}
-			}
-			return util.ErrList(errList)
-		})
-	}
-	if err := errgrp.Wait(); err != nil {
-		return err
+	// control hosts are deployed one at a time so a bad upgrade can't take down all of them,
+	// the run stops at the first host that fails its healthchecks and the remaining hosts are left untouched
+	for _, host := range controlHosts {
+		done := log.Track(ctx, log.Event{Phase: log.PhaseControlPlane, Host: host.Address, Action: log.ActionDeploy})
+		err := doDeployControlHost(ctx, host, localConnDialerFactory, prsMap, cpNodePlanMap[host.Address].Processes, alpineImage, certMap)
+		done(err)
+		if err != nil {
+			return err
+		}
 	}
 	log.Infof(ctx, "[%s] Successfully started Controller Plane..", ControlRole)
 	return nil
@@ -128,14 +121,53 @@ func doDeployControlHost(ctx context.Context, host *hosts.Host, localConnDialerF
 	if err := runSidekick(ctx, host, prsMap, processMap[SidekickContainerName]); err != nil {
 		return err
 	}
+	// the replaced containers are kept until all the components on the host are healthy
+	keptContainers := []string{}
 	// run kubeapi
-	if err := runKubeAPI(ctx, host, localConnDialerFactory, prsMap, processMap[KubeAPIContainerName], alpineImage, certMap); err != nil {
-		return err
+	keptOld, err := runKubeAPI(ctx, host, localConnDialerFactory, prsMap, processMap[KubeAPIContainerName], alpineImage, certMap)
+	if keptOld {
+		keptContainers = append(keptContainers, KubeAPIContainerName)
+	}
+	if err != nil {
+		return rollbackControlHost(ctx, host, keptContainers, err)
 	}
 	// run kubecontroller
-	if err := runKubeController(ctx, host, localConnDialerFactory, prsMap, processMap[KubeControllerContainerName], alpineImage); err != nil {
-		return err
+	keptOld, err = runKubeController(ctx, host, localConnDialerFactory, prsMap, processMap[KubeControllerContainerName], alpineImage)
+	if keptOld {
+		keptContainers = append(keptContainers, KubeControllerContainerName)
+	}
+	if err != nil {
+		return rollbackControlHost(ctx, host, keptContainers, err)
 	}
 	// run scheduler
-	return runScheduler(ctx, host, localConnDialerFactory, prsMap, processMap[SchedulerContainerName], alpineImage)
+	keptOld, err = runScheduler(ctx, host, localConnDialerFactory, prsMap, processMap[SchedulerContainerName], alpineImage)
+	if keptOld {
+		keptContainers = append(keptContainers, SchedulerContainerName)
+	}
+	if err != nil {
+		return rollbackControlHost(ctx, host, keptContainers, err)
+	}
+	for _, containerName := range keptContainers {
+		if err := docker.RemoveOldContainer(ctx, host.DClient, containerName, host.Address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rollbackControlHost(ctx context.Context, host *hosts.Host, keptContainers []string, deployErr error) error {
+	if len(keptContainers) == 0 {
+		return deployErr
+	}
+	log.Warnf(ctx, "[%s] Failed to upgrade host [%s], restoring previous containers: %v", ControlRole, host.Address, deployErr)
+	var errList []error
+	for i := len(keptContainers) - 1; i >= 0; i-- {
+		if err := docker.RestoreOldContainer(ctx, host.DClient, keptContainers[i], host.Address); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	if err := util.ErrList(errList); err != nil {
+		return fmt.Errorf("[%s] Failed to upgrade host [%s]: %v, failed to restore previous containers: %v", ControlRole, host.Address, deployErr, err)
+	}
+	return fmt.Errorf("[%s] Failed to upgrade host [%s], previous containers restored: %v", ControlRole, host.Address, deployErr)
 }
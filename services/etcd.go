@@ -2,9 +2,11 @@ package services
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -48,31 +50,18 @@ func RunEtcdPlane(
 		if updateWorkersOnly {
 			continue
 		}
-		etcdProcess := etcdNodePlanMap[host.Address].Processes[EtcdContainerName]
-		imageCfg, hostCfg, _ := GetProcessConfig(etcdProcess)
-		if err := docker.DoRunContainer(ctx, host.DClient, imageCfg, hostCfg, EtcdContainerName, host.Address, ETCDRole, prsMap); err != nil {
-			return err
-		}
-		if *es.Snapshot == true {
-			if err := RunEtcdSnapshotSave(ctx, host, prsMap, util.DefaultRKETools, EtcdSnapshotContainerName, false, es); err != nil {
-				return err
-			}
-			if err := pki.SaveBackupBundleOnHost(ctx, host, util.DefaultRKETools, EtcdSnapshotPath, prsMap); err != nil {
-				return err
-			}
-		} else {
-			if err := docker.DoRemoveContainer(ctx, host.DClient, EtcdSnapshotContainerName, host.Address); err != nil {
-				return err
-			}
-		}
-		if err := createLogLink(ctx, host, EtcdContainerName, ETCDRole, alpineImage, prsMap); err != nil {
+		done := log.Track(ctx, log.Event{Phase: log.PhaseEtcd, Host: host.Address, Component: EtcdContainerName, Action: log.ActionDeploy})
+		err := doDeployEtcdHost(ctx, host, etcdNodePlanMap[host.Address].Processes[EtcdContainerName], prsMap, alpineImage, es)
+		done(err)
+		if err != nil {
 			return err
 		}
 	}
 	log.Infof(ctx, "[%s] Successfully started etcd plane.. Checking etcd cluster health", ETCDRole)
 	clientCert := cert.EncodeCertPEM(certMap[pki.KubeNodeCertName].Certificate)
-	clientkey := cert.EncodePrivateKeyPEM(certMap[pki.KubeNodeCertName].Key)
+	clientkey := pki.EncodePrivateKeyPEM(certMap[pki.KubeNodeCertName].Key)
 	var healthy bool
+	done := log.Track(ctx, log.Event{Phase: log.PhaseEtcd, Component: EtcdContainerName, Action: log.ActionHealthcheck})
 	for _, host := range etcdHosts {
 		_, _, healthCheckURL := GetProcessConfig(etcdNodePlanMap[host.Address].Processes[EtcdContainerName])
 		if healthy = isEtcdHealthy(ctx, localConnDialerFactory, host, clientCert, clientkey, healthCheckURL); healthy {
@@ -80,11 +69,34 @@ func RunEtcdPlane(
 		}
 	}
 	if !healthy {
-		return fmt.Errorf("[etcd] Etcd Cluster is not healthy")
+		err := fmt.Errorf("[etcd] Etcd Cluster is not healthy")
+		done(err)
+		return err
 	}
+	done(nil)
 	return nil
 }
 
+func doDeployEtcdHost(ctx context.Context, host *hosts.Host, etcdProcess v3.Process, prsMap map[string]v3.PrivateRegistry, alpineImage string, es v3.ETCDService) error {
+	imageCfg, hostCfg, _ := GetProcessConfig(etcdProcess)
+	if err := docker.DoRunContainer(ctx, host.DClient, imageCfg, hostCfg, EtcdContainerName, host.Address, ETCDRole, prsMap); err != nil {
+		return err
+	}
+	if *es.Snapshot == true {
+		if err := RunEtcdSnapshotSave(ctx, host, prsMap, util.DefaultRKETools, EtcdSnapshotContainerName, false, es); err != nil {
+			return err
+		}
+		if err := pki.SaveBackupBundleOnHost(ctx, host, util.DefaultRKETools, EtcdSnapshotPath, prsMap); err != nil {
+			return err
+		}
+	} else {
+		if err := docker.DoRemoveContainer(ctx, host.DClient, EtcdSnapshotContainerName, host.Address); err != nil {
+			return err
+		}
+	}
+	return createLogLink(ctx, host, EtcdContainerName, ETCDRole, alpineImage, prsMap)
+}
+
 func RestartEtcdPlane(ctx context.Context, etcdHosts []*hosts.Host) error {
 	log.Infof(ctx, "[%s] Restarting up etcd plane..", ETCDRole)
 	var errgrp errgroup.Group
@@ -521,6 +533,9 @@ func GetEtcdSnapshotChecksum(ctx context.Context, etcdHost *hosts.Host, prsMap m
 			"/opt/rke/:/opt/rke/:z",
 		}}
 
+	if err := docker.DoRemoveContainer(ctx, etcdHost.DClient, EtcdChecksumContainerName, etcdHost.Address); err != nil {
+		return checksum, err
+	}
 	if err := docker.DoRunContainer(ctx, etcdHost.DClient, imageCfg, hostCfg, EtcdChecksumContainerName, etcdHost.Address, ETCDRole, prsMap); err != nil {
 		return checksum, err
 	}
@@ -655,3 +670,125 @@ func DownloadEtcdSnapshotFromBackupServer(ctx context.Context, etcdHost *hosts.H
 	}
 	return docker.RemoveContainer(ctx, etcdHost.DClient, etcdHost.Address, EtcdDownloadBackupContainerName)
 }
+
+// EtcdSnapshot describes a snapshot file stored on an etcd host or in the s3 bucket
+type EtcdSnapshot struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// EtcdSnapshotStatus is the output of etcdctl snapshot status
+type EtcdSnapshotStatus struct {
+	Hash      uint32 `json:"hash"`
+	Revision  int64  `json:"revision"`
+	TotalKey  int    `json:"totalKey"`
+	TotalSize int64  `json:"totalSize"`
+}
+
+func ListEtcdSnapshots(ctx context.Context, etcdHost *hosts.Host, prsMap map[string]v3.PrivateRegistry, alpineImage string) ([]EtcdSnapshot, error) {
+	log.Infof(ctx, "[etcd] Listing snapshots on host [%s]", etcdHost.Address)
+	imageCfg := &container.Config{
+		Cmd: []string{
+			"sh", "-c", fmt.Sprintf("if [ -d %s ]; then find %s -maxdepth 1 -type f -exec stat -c '%%s|%%Y|%%n' {} \\; ; fi", EtcdSnapshotPath, EtcdSnapshotPath),
+		},
+		Image: alpineImage,
+	}
+	hostCfg := &container.HostConfig{
+		Binds: []string{
+			"/opt/rke/:/opt/rke/:z",
+		},
+		RestartPolicy: container.RestartPolicy{Name: "no"},
+	}
+	if err := docker.DoRemoveContainer(ctx, etcdHost.DClient, EtcdSnapshotListContainerName, etcdHost.Address); err != nil {
+		return nil, err
+	}
+	if err := docker.DoRunContainer(ctx, etcdHost.DClient, imageCfg, hostCfg, EtcdSnapshotListContainerName, etcdHost.Address, ETCDRole, prsMap); err != nil {
+		return nil, err
+	}
+	status, err := docker.WaitForContainer(ctx, etcdHost.DClient, etcdHost.Address, EtcdSnapshotListContainerName)
+	if err != nil {
+		return nil, err
+	}
+	stderr, stdout, err := docker.GetContainerLogsStdoutStderr(ctx, etcdHost.DClient, EtcdSnapshotListContainerName, "all", false)
+	if err != nil {
+		return nil, err
+	}
+	if err := docker.RemoveContainer(ctx, etcdHost.DClient, etcdHost.Address, EtcdSnapshotListContainerName); err != nil {
+		return nil, err
+	}
+	if status != 0 {
+		return nil, fmt.Errorf("Failed to list snapshots on host [%s], exit code [%d]: %v", etcdHost.Address, status, stderr)
+	}
+
+	snapshots := []EtcdSnapshot{}
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		fields := strings.SplitN(line, "|", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			logrus.Debugf("[etcd] Failed to parse size of snapshot [%s] on host [%s]: %v", fields[2], etcdHost.Address, err)
+			continue
+		}
+		modTime, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			logrus.Debugf("[etcd] Failed to parse timestamp of snapshot [%s] on host [%s]: %v", fields[2], etcdHost.Address, err)
+			continue
+		}
+		snapshots = append(snapshots, EtcdSnapshot{
+			Name:    path.Base(fields[2]),
+			Size:    size,
+			ModTime: time.Unix(modTime, 0),
+		})
+	}
+	return snapshots, nil
+}
+
+func InspectEtcdSnapshot(ctx context.Context, etcdHost *hosts.Host, prsMap map[string]v3.PrivateRegistry, etcdImage, snapshotName string) (EtcdSnapshotStatus, error) {
+	var snapshotStatus EtcdSnapshotStatus
+	log.Infof(ctx, "[etcd] Inspecting snapshot [%s] on host [%s]", snapshotName, etcdHost.Address)
+	if strings.Contains(snapshotName, "/") {
+		return snapshotStatus, fmt.Errorf("malformed snapshot name: %v", snapshotName)
+	}
+	snapshotPath := fmt.Sprintf("%s%s", EtcdSnapshotPath, snapshotName)
+	statusCmd := fmt.Sprintf("/usr/local/bin/etcdctl snapshot status %s -w json", snapshotPath)
+	if strings.HasSuffix(snapshotName, "."+EtcdSnapshotCompressedExtension) {
+		// compressed snapshots are extracted inside the container, the host copy is not touched
+		statusCmd = fmt.Sprintf("unzip -p %s > /tmp/snapshot.db && /usr/local/bin/etcdctl snapshot status /tmp/snapshot.db -w json", snapshotPath)
+	}
+	imageCfg := &container.Config{
+		Cmd: []string{
+			"sh", "-c", fmt.Sprintf("if [ -f %s ]; then %s; else echo 'snapshot file does not exist' >&2; exit 1; fi", snapshotPath, statusCmd),
+		},
+		Env:   []string{"ETCDCTL_API=3"},
+		Image: etcdImage,
+	}
+	hostCfg := &container.HostConfig{
+		Binds: []string{
+			"/opt/rke/:/opt/rke/:z",
+		},
+		RestartPolicy: container.RestartPolicy{Name: "no"},
+	}
+	if err := docker.DoRemoveContainer(ctx, etcdHost.DClient, EtcdSnapshotStatusContainerName, etcdHost.Address); err != nil {
+		return snapshotStatus, err
+	}
+	if err := docker.DoRunContainer(ctx, etcdHost.DClient, imageCfg, hostCfg, EtcdSnapshotStatusContainerName, etcdHost.Address, ETCDRole, prsMap); err != nil {
+		return snapshotStatus, err
+	}
+	status, stdout, stderr, err := docker.GetContainerOutput(ctx, etcdHost.DClient, EtcdSnapshotStatusContainerName, etcdHost.Address)
+	if removeErr := docker.RemoveContainer(ctx, etcdHost.DClient, etcdHost.Address, EtcdSnapshotStatusContainerName); removeErr != nil {
+		log.Warnf(ctx, "Failed to remove container [%s]: %v", EtcdSnapshotStatusContainerName, removeErr)
+	}
+	if err != nil {
+		return snapshotStatus, err
+	}
+	if status != 0 {
+		return snapshotStatus, fmt.Errorf("Failed to inspect snapshot [%s] on host [%s], exit code [%d]: %v", snapshotName, etcdHost.Address, status, stderr)
+	}
+	if err := json.Unmarshal([]byte(stdout), &snapshotStatus); err != nil {
+		return snapshotStatus, fmt.Errorf("Failed to parse status of snapshot [%s] on host [%s]: %v", snapshotName, etcdHost.Address, err)
+	}
+	return snapshotStatus, nil
+}
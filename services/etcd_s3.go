@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rancher/rke/log"
+	"github.com/rancher/rke/util"
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+const (
+	// s3SnapshotDelimiter limits the listing to the root of the bucket, the snapshots are uploaded there while
+	// the objects of the s3 state backend are in its folder
+	s3SnapshotDelimiter = "/"
+)
+
+// stateObjectSuffixes are the state and lock objects of the s3 state backend, they're never snapshots
+var stateObjectSuffixes = []string{".rkestate", ".rkestate.lock"}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// ListEtcdSnapshotsFromS3 lists the snapshots at the root of the bucket, the other objects of the bucket are left out
+func ListEtcdSnapshotsFromS3(ctx context.Context, s3Backend *v3.S3BackupConfig) ([]EtcdSnapshot, error) {
+	log.Infof(ctx, "[etcd] Listing snapshots in s3 bucket [%s]", s3Backend.BucketName)
+	snapshots := []EtcdSnapshot{}
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("delimiter", s3SnapshotDelimiter)
+	for {
+		resp, err := util.DoS3Request(ctx, s3Backend, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read s3 response: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Failed to list s3 bucket [%s], status [%s]: %s", s3Backend.BucketName, resp.Status, body)
+		}
+		var result s3ListBucketResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("Failed to parse s3 bucket listing: %v", err)
+		}
+		for _, object := range result.Contents {
+			if !isS3EtcdSnapshotKey(object.Key) {
+				continue
+			}
+			snapshots = append(snapshots, EtcdSnapshot{
+				Name:    object.Key,
+				Size:    object.Size,
+				ModTime: object.LastModified,
+			})
+		}
+		if !result.IsTruncated || len(result.NextContinuationToken) == 0 {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	return snapshots, nil
+}
+
+// RemoveEtcdSnapshotFromS3 removes a snapshot and its compressed copy, keys that can't be snapshots are refused
+func RemoveEtcdSnapshotFromS3(ctx context.Context, s3Backend *v3.S3BackupConfig, name string) error {
+	if !isS3EtcdSnapshotKey(name) {
+		return fmt.Errorf("Refusing to remove [%s] from s3 bucket [%s], it's not an etcd snapshot", name, s3Backend.BucketName)
+	}
+	log.Infof(ctx, "[etcd] Removing snapshot [%s] from s3 bucket [%s]", name, s3Backend.BucketName)
+	for _, objectKey := range []string{name, fmt.Sprintf("%s.%s", name, EtcdSnapshotCompressedExtension)} {
+		resp, err := util.DoS3Request(ctx, s3Backend, http.MethodDelete, objectKey, url.Values{}, nil, nil)
+		if err != nil {
+			return err
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("Failed to remove snapshot [%s] from s3 bucket [%s], status [%s]: %s", objectKey, s3Backend.BucketName, resp.Status, body)
+		}
+	}
+	return nil
+}
+
+// isS3EtcdSnapshotKey is true for the object keys that can be snapshots: the snapshots are at the root of the bucket
+// and aren't named like the objects of the s3 state backend
+func isS3EtcdSnapshotKey(key string) bool {
+	if len(key) == 0 || strings.Contains(key, s3SnapshotDelimiter) {
+		return false
+	}
+	for _, suffix := range stateObjectSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+// the pages of a ListObjectsV2 response, the first one is truncated
+var s3ListBucketPages = map[string]string{
+	"": `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Name>backups</Name>
+  <Prefix></Prefix>
+  <KeyCount>2</KeyCount>
+  <MaxKeys>2</MaxKeys>
+  <IsTruncated>true</IsTruncated>
+  <NextContinuationToken>1ueGcxLPRx1Tr/XYExHnhbYLgveDs2J/wm36Hy4vbOwM=</NextContinuationToken>
+  <Contents>
+    <Key>snapshot-1</Key>
+    <LastModified>2018-08-01T10:00:00.000Z</LastModified>
+    <ETag>&quot;fba9dede5f27731c9771645a39863328&quot;</ETag>
+    <Size>1024</Size>
+    <StorageClass>STANDARD</StorageClass>
+  </Contents>
+  <Contents>
+    <Key>snapshot-1.zip</Key>
+    <LastModified>2018-08-01T10:00:01.000Z</LastModified>
+    <ETag>&quot;6805f2cfc46c0f04559748bb039d69ae&quot;</ETag>
+    <Size>256</Size>
+    <StorageClass>STANDARD</StorageClass>
+  </Contents>
+</ListBucketResult>`,
+	"1ueGcxLPRx1Tr/XYExHnhbYLgveDs2J/wm36Hy4vbOwM=": `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Name>backups</Name>
+  <KeyCount>1</KeyCount>
+  <MaxKeys>2</MaxKeys>
+  <IsTruncated>false</IsTruncated>
+  <Contents>
+    <Key>snapshot-2</Key>
+    <LastModified>2018-08-02T10:00:00.000Z</LastModified>
+    <Size>2048</Size>
+  </Contents>
+  <Contents>
+    <Key>cluster.rkestate</Key>
+    <LastModified>2018-08-02T10:00:00.000Z</LastModified>
+    <Size>512</Size>
+  </Contents>
+  <Contents>
+    <Key>clusters/cluster.rkestate</Key>
+    <LastModified>2018-08-02T10:00:00.000Z</LastModified>
+    <Size>512</Size>
+  </Contents>
+</ListBucketResult>`,
+}
+
+func TestListEtcdSnapshotsFromS3(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/backups/" || req.URL.Query().Get("list-type") != "2" || req.URL.Query().Get("delimiter") != "/" || len(req.Header.Get("Authorization")) == 0 {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		page, ok := s3ListBucketPages[req.URL.Query().Get("continuation-token")]
+		if !ok {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/xml")
+		rw.Write([]byte(page))
+	}))
+	defer server.Close()
+	s3Backend := &v3.S3BackupConfig{
+		Endpoint:   server.URL,
+		BucketName: "backups",
+		AccessKey:  "access",
+		SecretKey:  "secret",
+	}
+	snapshots, err := ListEtcdSnapshotsFromS3(context.Background(), s3Backend)
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	expected := []EtcdSnapshot{
+		{Name: "snapshot-1", Size: 1024, ModTime: time.Date(2018, 8, 1, 10, 0, 0, 0, time.UTC)},
+		{Name: "snapshot-1.zip", Size: 256, ModTime: time.Date(2018, 8, 1, 10, 0, 1, 0, time.UTC)},
+		{Name: "snapshot-2", Size: 2048, ModTime: time.Date(2018, 8, 2, 10, 0, 0, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(snapshots, expected) {
+		t.Fatalf("Expected snapshots %+v, got %+v", expected, snapshots)
+	}
+}
+
+func TestListEtcdSnapshotsFromS3Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusForbidden)
+		rw.Write([]byte(`<Error><Code>SignatureDoesNotMatch</Code></Error>`))
+	}))
+	defer server.Close()
+	s3Backend := &v3.S3BackupConfig{Endpoint: server.URL, BucketName: "backups"}
+	if _, err := ListEtcdSnapshotsFromS3(context.Background(), s3Backend); err == nil {
+		t.Fatalf("Expected an error for a failed listing")
+	}
+}
+
+func TestRemoveEtcdSnapshotFromS3(t *testing.T) {
+	removed := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodDelete {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		removed = append(removed, req.URL.Path)
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	s3Backend := &v3.S3BackupConfig{Endpoint: server.URL, BucketName: "backups"}
+	for _, name := range []string{"clusters/cluster.rkestate", "cluster.rkestate", "cluster.rkestate.lock", ""} {
+		if err := RemoveEtcdSnapshotFromS3(context.Background(), s3Backend, name); err == nil {
+			t.Errorf("Expected removing [%s] to be refused", name)
+		}
+	}
+	if len(removed) != 0 {
+		t.Fatalf("Expected no object to be removed, got %v", removed)
+	}
+	if err := RemoveEtcdSnapshotFromS3(context.Background(), s3Backend, "snapshot-1"); err != nil {
+		t.Fatalf("Failed to remove snapshot: %v", err)
+	}
+	if expected := []string{"/backups/snapshot-1", "/backups/snapshot-1.zip"}; !reflect.DeepEqual(removed, expected) {
+		t.Fatalf("Expected objects %v to be removed, got %v", expected, removed)
+	}
+}
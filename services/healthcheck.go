@@ -26,6 +26,13 @@ const (
 )
 
 func runHealthcheck(ctx context.Context, host *hosts.Host, serviceName string, localConnDialerFactory hosts.DialerFactory, url string, certMap map[string]pki.CertificatePKI) error {
+	done := log.Track(ctx, log.Event{Phase: getServicePhase(serviceName), Host: host.Address, Component: serviceName, Action: log.ActionHealthcheck})
+	err := doHealthcheck(ctx, host, serviceName, localConnDialerFactory, url, certMap)
+	done(err)
+	return err
+}
+
+func doHealthcheck(ctx context.Context, host *hosts.Host, serviceName string, localConnDialerFactory hosts.DialerFactory, url string, certMap map[string]pki.CertificatePKI) error {
 	log.Infof(ctx, "[healthcheck] Start Healthcheck on service [%s] on host [%s]", serviceName, host.Address)
 	var x509Pair tls.Certificate
 
@@ -35,7 +42,7 @@ func runHealthcheck(ctx context.Context, host *hosts.Host, serviceName string, l
 	}
 	if serviceName == KubeletContainerName {
 		certificate := cert.EncodeCertPEM(certMap[pki.KubeNodeCertName].Certificate)
-		key := cert.EncodePrivateKeyPEM(certMap[pki.KubeNodeCertName].Key)
+		key := pki.EncodePrivateKeyPEM(certMap[pki.KubeNodeCertName].Key)
 		x509Pair, err = tls.X509KeyPair(certificate, key)
 		if err != nil {
 			return err
@@ -43,7 +50,7 @@ func runHealthcheck(ctx context.Context, host *hosts.Host, serviceName string, l
 	}
 	if serviceName == KubeAPIContainerName {
 		certificate := cert.EncodeCertPEM(certMap[pki.KubeAPICertName].Certificate)
-		key := cert.EncodePrivateKeyPEM(certMap[pki.KubeAPICertName].Key)
+		key := pki.EncodePrivateKeyPEM(certMap[pki.KubeAPICertName].Key)
 		x509Pair, err = tls.X509KeyPair(certificate, key)
 		if err != nil {
 			return err
@@ -118,3 +125,14 @@ func getPortFromURL(url string) (int, error) {
 	}
 	return intPort, nil
 }
+
+func getServicePhase(serviceName string) string {
+	switch serviceName {
+	case KubeAPIContainerName, KubeControllerContainerName, SchedulerContainerName:
+		return log.PhaseControlPlane
+	case EtcdContainerName:
+		return log.PhaseEtcd
+	default:
+		return log.PhaseWorkerPlane
+	}
+}
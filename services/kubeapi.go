@@ -9,16 +9,17 @@ import (
 	"github.com/rancher/types/apis/management.cattle.io/v3"
 )
 
-func runKubeAPI(ctx context.Context, host *hosts.Host, df hosts.DialerFactory, prsMap map[string]v3.PrivateRegistry, kubeAPIProcess v3.Process, alpineImage string, certMap map[string]pki.CertificatePKI) error {
+func runKubeAPI(ctx context.Context, host *hosts.Host, df hosts.DialerFactory, prsMap map[string]v3.PrivateRegistry, kubeAPIProcess v3.Process, alpineImage string, certMap map[string]pki.CertificatePKI) (bool, error) {
 
 	imageCfg, hostCfg, healthCheckURL := GetProcessConfig(kubeAPIProcess)
-	if err := docker.DoRunContainer(ctx, host.DClient, imageCfg, hostCfg, KubeAPIContainerName, host.Address, ControlRole, prsMap); err != nil {
-		return err
+	keptOld, err := docker.DoRunContainerKeepOld(ctx, host.DClient, imageCfg, hostCfg, KubeAPIContainerName, host.Address, ControlRole, prsMap)
+	if err != nil {
+		return keptOld, err
 	}
 	if err := runHealthcheck(ctx, host, KubeAPIContainerName, df, healthCheckURL, certMap); err != nil {
-		return err
+		return keptOld, err
 	}
-	return createLogLink(ctx, host, KubeAPIContainerName, ControlRole, alpineImage, prsMap)
+	return keptOld, createLogLink(ctx, host, KubeAPIContainerName, ControlRole, alpineImage, prsMap)
 }
 
 func removeKubeAPI(ctx context.Context, host *hosts.Host) error {
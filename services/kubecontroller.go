@@ -8,15 +8,16 @@ import (
 	"github.com/rancher/types/apis/management.cattle.io/v3"
 )
 
-func runKubeController(ctx context.Context, host *hosts.Host, df hosts.DialerFactory, prsMap map[string]v3.PrivateRegistry, controllerProcess v3.Process, alpineImage string) error {
+func runKubeController(ctx context.Context, host *hosts.Host, df hosts.DialerFactory, prsMap map[string]v3.PrivateRegistry, controllerProcess v3.Process, alpineImage string) (bool, error) {
 	imageCfg, hostCfg, healthCheckURL := GetProcessConfig(controllerProcess)
-	if err := docker.DoRunContainer(ctx, host.DClient, imageCfg, hostCfg, KubeControllerContainerName, host.Address, ControlRole, prsMap); err != nil {
-		return err
+	keptOld, err := docker.DoRunContainerKeepOld(ctx, host.DClient, imageCfg, hostCfg, KubeControllerContainerName, host.Address, ControlRole, prsMap)
+	if err != nil {
+		return keptOld, err
 	}
 	if err := runHealthcheck(ctx, host, KubeControllerContainerName, df, healthCheckURL, nil); err != nil {
-		return err
+		return keptOld, err
 	}
-	return createLogLink(ctx, host, KubeControllerContainerName, ControlRole, alpineImage, prsMap)
+	return keptOld, createLogLink(ctx, host, KubeControllerContainerName, ControlRole, alpineImage, prsMap)
 }
 
 func removeKubeController(ctx context.Context, host *hosts.Host) error {
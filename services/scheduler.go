@@ -8,15 +8,16 @@ import (
 	"github.com/rancher/types/apis/management.cattle.io/v3"
 )
 
-func runScheduler(ctx context.Context, host *hosts.Host, df hosts.DialerFactory, prsMap map[string]v3.PrivateRegistry, schedulerProcess v3.Process, alpineImage string) error {
+func runScheduler(ctx context.Context, host *hosts.Host, df hosts.DialerFactory, prsMap map[string]v3.PrivateRegistry, schedulerProcess v3.Process, alpineImage string) (bool, error) {
 	imageCfg, hostCfg, healthCheckURL := GetProcessConfig(schedulerProcess)
-	if err := docker.DoRunContainer(ctx, host.DClient, imageCfg, hostCfg, SchedulerContainerName, host.Address, ControlRole, prsMap); err != nil {
-		return err
+	keptOld, err := docker.DoRunContainerKeepOld(ctx, host.DClient, imageCfg, hostCfg, SchedulerContainerName, host.Address, ControlRole, prsMap)
+	if err != nil {
+		return keptOld, err
 	}
 	if err := runHealthcheck(ctx, host, SchedulerContainerName, df, healthCheckURL, nil); err != nil {
-		return err
+		return keptOld, err
 	}
-	return createLogLink(ctx, host, SchedulerContainerName, ControlRole, alpineImage, prsMap)
+	return keptOld, createLogLink(ctx, host, SchedulerContainerName, ControlRole, alpineImage, prsMap)
 }
 
 func removeScheduler(ctx context.Context, host *hosts.Host) error {
@@ -2,13 +2,21 @@ package services
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
+	"github.com/rancher/rke/docker"
 	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/k8s"
 	"github.com/rancher/rke/log"
 	"github.com/rancher/rke/pki"
 	"github.com/rancher/rke/util"
 	"github.com/rancher/types/apis/management.cattle.io/v3"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
 )
 
 const (
@@ -26,7 +34,9 @@ func RunWorkerPlane(ctx context.Context, allHosts []*hosts.Host, localConnDialer
 			var errList []error
 			for host := range hostsQueue {
 				runHost := host.(*hosts.Host)
+				done := log.Track(ctx, log.Event{Phase: log.PhaseWorkerPlane, Host: runHost.Address, Action: log.ActionDeploy})
 				err := doDeployWorkerPlaneHost(ctx, runHost, localConnDialerFactory, prsMap, workerNodePlanMap[runHost.Address].Processes, certMap, updateWorkersOnly, alpineImage)
+				done(err)
 				if err != nil {
 					errList = append(errList, err)
 				}
@@ -42,6 +52,193 @@ func RunWorkerPlane(ctx context.Context, allHosts []*hosts.Host, localConnDialer
 	return nil
 }
 
+// UpgradeWorkerPlane upgrades the worker components in batches, hosts in a batch are cordoned (and drained) before their
+// components are replaced and uncordoned once the node is ready again. The rollout stops at the first failed batch.
+func UpgradeWorkerPlane(ctx context.Context, kubeClient *kubernetes.Clientset, allHosts []*hosts.Host, localConnDialerFactory hosts.DialerFactory, prsMap map[string]v3.PrivateRegistry, workerNodePlanMap map[string]v3.RKEConfigNodePlan, certMap map[string]pki.CertificatePKI, updateWorkersOnly bool, alpineImage string, upgradeStrategy *v3.NodeUpgradeStrategy) error {
+	log.Infof(ctx, "[%s] Upgrading Worker Plane..", WorkerRole)
+	workerHosts := []*hosts.Host{}
+	for _, host := range allHosts {
+		// only the worker hosts run workloads, the max_unavailable budget is a share of them
+		if host.IsWorker {
+			workerHosts = append(workerHosts, host)
+		}
+	}
+	maxUnavailable, err := GetMaxUnavailable(upgradeStrategy.MaxUnavailable, len(workerHosts))
+	if err != nil {
+		return err
+	}
+
+	toUpgradeHosts := []*hosts.Host{}
+	inPlaceHosts := []*hosts.Host{}
+	for _, host := range allHosts {
+		if !host.IsWorker || (updateWorkersOnly && !host.UpdateWorker) {
+			inPlaceHosts = append(inPlaceHosts, host)
+			continue
+		}
+		upgradable, err := isWorkerPlaneUpgradable(ctx, host, workerNodePlanMap[host.Address].Processes)
+		if err != nil {
+			return err
+		}
+		_, err = k8s.GetNode(kubeClient, host.HostnameOverride)
+		// nodes that are not registered yet have no workloads to protect
+		if !upgradable || apierrors.IsNotFound(err) {
+			inPlaceHosts = append(inPlaceHosts, host)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to get node [%s]: %v", host.HostnameOverride, err)
+		}
+		toUpgradeHosts = append(toUpgradeHosts, host)
+	}
+	if err := RunWorkerPlane(ctx, inPlaceHosts, localConnDialerFactory, prsMap, workerNodePlanMap, certMap, updateWorkersOnly, alpineImage); err != nil {
+		return err
+	}
+	if len(toUpgradeHosts) == 0 {
+		return nil
+	}
+
+	log.Infof(ctx, "[%s] Upgrading [%d] hosts, at most [%d] unavailable at a time", WorkerRole, len(toUpgradeHosts), maxUnavailable)
+	for start := 0; start < len(toUpgradeHosts); {
+		// the batch is sized before each round, so the nodes that became unavailable during the rollout
+		// (including the upgraded ones) shrink the next batches
+		unavailable, err := countUnavailableNodes(kubeClient, workerHosts, toUpgradeHosts[start:])
+		if err != nil {
+			return err
+		}
+		batchSize := maxUnavailable - unavailable
+		if batchSize <= 0 {
+			return fmt.Errorf("[%s] Can't upgrade worker plane, [%d] worker nodes are unavailable and max_unavailable is [%d]", WorkerRole, unavailable, maxUnavailable)
+		}
+		end := start + batchSize
+		if end > len(toUpgradeHosts) {
+			end = len(toUpgradeHosts)
+		}
+		logrus.Debugf("[%s] Upgrading hosts [%d-%d] of [%d], [%d] worker nodes are unavailable", WorkerRole, start+1, end, len(toUpgradeHosts), unavailable)
+		var errgrp errgroup.Group
+		for _, host := range toUpgradeHosts[start:end] {
+			runHost := host
+			errgrp.Go(func() error {
+				done := log.Track(ctx, log.Event{Phase: log.PhaseWorkerPlane, Host: runHost.Address, Action: log.ActionUpgrade})
+				err := upgradeWorkerPlaneHost(ctx, kubeClient, runHost, localConnDialerFactory, prsMap, workerNodePlanMap[runHost.Address].Processes, certMap, updateWorkersOnly, alpineImage, upgradeStrategy)
+				done(err)
+				return err
+			})
+		}
+		if err := errgrp.Wait(); err != nil {
+			return fmt.Errorf("[%s] Stopping worker plane upgrade: %v", WorkerRole, err)
+		}
+		start = end
+	}
+	log.Infof(ctx, "[%s] Successfully upgraded Worker Plane..", WorkerRole)
+	return nil
+}
+
+func upgradeWorkerPlaneHost(ctx context.Context, kubeClient *kubernetes.Clientset, host *hosts.Host, localConnDialerFactory hosts.DialerFactory, prsMap map[string]v3.PrivateRegistry, processMap map[string]v3.Process, certMap map[string]pki.CertificatePKI, updateWorkersOnly bool, alpineImage string, upgradeStrategy *v3.NodeUpgradeStrategy) error {
+	node, err := k8s.GetNode(kubeClient, host.HostnameOverride)
+	if err != nil {
+		return fmt.Errorf("Failed to get node [%s]: %v", host.HostnameOverride, err)
+	}
+	// nodes cordoned before the upgrade are left cordoned
+	wasCordoned := node.Spec.Unschedulable
+	if !wasCordoned {
+		log.Infof(ctx, "[%s] Cordoning node [%s]", WorkerRole, host.HostnameOverride)
+		if err := k8s.CordonUncordon(kubeClient, host.HostnameOverride, true); err != nil {
+			return err
+		}
+	}
+	if upgradeStrategy.Drain {
+		log.Infof(ctx, "[%s] Draining node [%s]", WorkerRole, host.HostnameOverride)
+		if err := k8s.DrainNode(kubeClient, host.HostnameOverride, upgradeStrategy.DrainInput); err != nil {
+			return err
+		}
+	}
+	if err := doDeployWorkerPlaneHost(ctx, host, localConnDialerFactory, prsMap, processMap, certMap, updateWorkersOnly, alpineImage); err != nil {
+		return err
+	}
+	log.Infof(ctx, "[%s] Waiting for node [%s] to be ready", WorkerRole, host.HostnameOverride)
+	if err := k8s.WaitForNodeReady(kubeClient, host.HostnameOverride, upgradeStrategy.NodeReadyTimeout); err != nil {
+		return err
+	}
+	if !wasCordoned {
+		log.Infof(ctx, "[%s] Uncordoning node [%s]", WorkerRole, host.HostnameOverride)
+		return k8s.CordonUncordon(kubeClient, host.HostnameOverride, false)
+	}
+	return nil
+}
+
+func isWorkerPlaneUpgradable(ctx context.Context, host *hosts.Host, processMap map[string]v3.Process) (bool, error) {
+	containerNames := []string{SidekickContainerName, KubeletContainerName, KubeproxyContainerName}
+	if !host.IsControl {
+		containerNames = append(containerNames, NginxProxyContainerName)
+	}
+	for _, containerName := range containerNames {
+		exists, err := docker.IsContainerRunning(ctx, host.DClient, host.Address, containerName, true)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			return true, nil
+		}
+		imageCfg, hostCfg, _ := GetProcessConfig(processMap[containerName])
+		upgradable, err := docker.IsContainerUpgradable(ctx, host.DClient, imageCfg, hostCfg, containerName, host.Address, WorkerRole)
+		if err != nil {
+			return false, err
+		}
+		if upgradable {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// countUnavailableNodes counts the worker nodes that are not ready or cordoned, leaving out the ones still to upgrade
+func countUnavailableNodes(kubeClient *kubernetes.Clientset, workerHosts, toUpgradeHosts []*hosts.Host) (int, error) {
+	toUpgrade := make(map[string]bool)
+	for _, host := range toUpgradeHosts {
+		toUpgrade[host.Address] = true
+	}
+	unavailable := 0
+	for _, host := range workerHosts {
+		if toUpgrade[host.Address] {
+			continue
+		}
+		node, err := k8s.GetNode(kubeClient, host.HostnameOverride)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return 0, fmt.Errorf("Failed to get node [%s]: %v", host.HostnameOverride, err)
+		}
+		if !k8s.IsNodeReady(*node) || node.Spec.Unschedulable {
+			unavailable++
+		}
+	}
+	return unavailable, nil
+}
+
+// GetMaxUnavailable converts max_unavailable to a number of nodes, percentages are rounded down. The result is at least
+// one node and at most all the nodes.
+func GetMaxUnavailable(maxUnavailable string, hostCount int) (int, error) {
+	parsed := intstr.Parse(maxUnavailable)
+	if parsed.Type == intstr.String && !strings.HasSuffix(parsed.StrVal, "%") {
+		return 0, fmt.Errorf("Invalid max_unavailable [%s], must be a number or a percentage", maxUnavailable)
+	}
+	value, err := intstr.GetValueFromIntOrPercent(&parsed, hostCount, false)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid max_unavailable [%s]: %v", maxUnavailable, err)
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("Invalid max_unavailable [%s], can't be negative", maxUnavailable)
+	}
+	if value > hostCount {
+		value = hostCount
+	}
+	if value == 0 {
+		value = 1
+	}
+	return value, nil
+}
+
 func doDeployWorkerPlaneHost(ctx context.Context, host *hosts.Host, localConnDialerFactory hosts.DialerFactory, prsMap map[string]v3.PrivateRegistry, processMap map[string]v3.Process, certMap map[string]pki.CertificatePKI, updateWorkersOnly bool, alpineImage string) error {
 	if updateWorkersOnly {
 		if !host.UpdateWorker {
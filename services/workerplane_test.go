@@ -0,0 +1,44 @@
+package services
+
+import "testing"
+
+func TestGetMaxUnavailable(t *testing.T) {
+	tests := []struct {
+		maxUnavailable string
+		hostCount      int
+		expected       int
+		expectErr      bool
+	}{
+		{maxUnavailable: "10%", hostCount: 10, expected: 1},
+		{maxUnavailable: "25%", hostCount: 10, expected: 2},
+		{maxUnavailable: "29%", hostCount: 10, expected: 2},
+		{maxUnavailable: "50%", hostCount: 3, expected: 1},
+		{maxUnavailable: "10%", hostCount: 5, expected: 1},
+		{maxUnavailable: "100%", hostCount: 7, expected: 7},
+		{maxUnavailable: "0%", hostCount: 10, expected: 1},
+		{maxUnavailable: "2", hostCount: 10, expected: 2},
+		{maxUnavailable: "0", hostCount: 10, expected: 1},
+		{maxUnavailable: "12", hostCount: 10, expected: 10},
+		{maxUnavailable: "150%", hostCount: 4, expected: 4},
+		{maxUnavailable: "1", hostCount: 0, expected: 1},
+		{maxUnavailable: "-1", hostCount: 10, expectErr: true},
+		{maxUnavailable: "ten", hostCount: 10, expectErr: true},
+		{maxUnavailable: "%", hostCount: 10, expectErr: true},
+	}
+	for _, test := range tests {
+		value, err := GetMaxUnavailable(test.maxUnavailable, test.hostCount)
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("Expected an error for max_unavailable [%s], got [%d]", test.maxUnavailable, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Failed to get max_unavailable [%s] of [%d] hosts: %v", test.maxUnavailable, test.hostCount, err)
+			continue
+		}
+		if value != test.expected {
+			t.Errorf("Expected max_unavailable [%s] of [%d] hosts to be [%d], got [%d]", test.maxUnavailable, test.hostCount, test.expected, value)
+		}
+	}
+}
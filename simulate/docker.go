@@ -0,0 +1,334 @@
+package simulate
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	simulatedDockerVersion = "18.09.2"
+	kubeletContainerName   = "kubelet"
+	etcdContainerName      = "etcd"
+)
+
+var dockerAPIVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+type simHost struct {
+	sim        *Simulator
+	address    string
+	containers map[string]*simContainer
+	images     map[string]bool
+	docker     *pipeListener
+	localConn  *pipeListener
+}
+
+type simContainer struct {
+	id         string
+	name       string
+	created    time.Time
+	config     *container.Config
+	hostConfig *container.HostConfig
+	running    bool
+}
+
+// configWrapper is the body of a container create request
+type configWrapper struct {
+	*container.Config
+	HostConfig *container.HostConfig
+}
+
+func newSimHost(sim *Simulator, address string) *simHost {
+	host := &simHost{
+		sim:        sim,
+		address:    address,
+		containers: map[string]*simContainer{},
+		images:     map[string]bool{},
+	}
+	host.docker = servePipe(http.HandlerFunc(host.serveDocker), nil)
+	host.localConn = serveLocalConn(host)
+	return host
+}
+
+func (h *simHost) close() {
+	h.docker.Close()
+	h.localConn.Close()
+}
+
+// getContainer looks up a container by name or id, the simulator lock must be held
+func (h *simHost) getContainer(nameOrID string) *simContainer {
+	if c, ok := h.containers[strings.TrimPrefix(nameOrID, "/")]; ok {
+		return c
+	}
+	for _, c := range h.containers {
+		if c.id == nameOrID {
+			return c
+		}
+	}
+	return nil
+}
+
+func (h *simHost) serveDocker(rw http.ResponseWriter, req *http.Request) {
+	path := dockerAPIVersionPrefix.ReplaceAllString(req.URL.Path, "")
+	// image archives are streamed to all the hosts at once, they're read without holding the simulator lock
+	if path == "/images/load" {
+		h.loadImages(rw, req)
+		return
+	}
+	h.sim.Lock()
+	defer h.sim.Unlock()
+
+	switch {
+	case path == "/_ping":
+		rw.Write([]byte("OK"))
+	case path == "/info":
+		writeJSON(rw, http.StatusOK, types.Info{
+			Name:            h.address,
+			ServerVersion:   simulatedDockerVersion,
+			OperatingSystem: "Simulated Linux",
+			DockerRootDir:   "/var/lib/docker",
+			Architecture:    "x86_64",
+			CgroupDriver:    "cgroupfs",
+		})
+	case path == "/containers/json":
+		h.listContainers(rw, req)
+	case path == "/containers/create":
+		h.createContainer(rw, req)
+	case path == "/images/create":
+		image := req.URL.Query().Get("fromImage")
+		if tag := req.URL.Query().Get("tag"); len(tag) > 0 {
+			image = image + ":" + tag
+		}
+		h.images[image] = true
+		h.sim.record("[%s] pull image [%s]", h.address, image)
+		writeJSON(rw, http.StatusOK, map[string]string{"status": "Downloaded newer image for " + image})
+	case strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/json"):
+		image := strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json")
+		if !h.images[image] && !h.images[image+":latest"] {
+			writeDockerError(rw, http.StatusNotFound, "No such image: "+image)
+			return
+		}
+		writeJSON(rw, http.StatusOK, types.ImageInspect{ID: image, Config: &container.Config{}})
+	case strings.HasPrefix(path, "/containers/"):
+		h.serveContainer(rw, req, strings.TrimPrefix(path, "/containers/"))
+	default:
+		writeDockerError(rw, http.StatusNotFound, fmt.Sprintf("page not found: %s", path))
+	}
+}
+
+// loadImages adds the images tagged in the manifest of a docker save archive
+func (h *simHost) loadImages(rw http.ResponseWriter, req *http.Request) {
+	manifest := []struct {
+		RepoTags []string
+	}{}
+	archive := tar.NewReader(req.Body)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			writeDockerError(rw, http.StatusBadRequest, fmt.Sprintf("invalid image archive: %v", err))
+			return
+		}
+		if header.Name != "manifest.json" {
+			continue
+		}
+		if err := json.NewDecoder(archive).Decode(&manifest); err != nil {
+			writeDockerError(rw, http.StatusBadRequest, fmt.Sprintf("invalid image archive manifest: %v", err))
+			return
+		}
+	}
+	io.Copy(ioutil.Discard, req.Body)
+
+	h.sim.Lock()
+	delay := h.sim.imageLoadDelay
+	h.sim.Unlock()
+	rw.Header().Set("Content-Type", "application/json")
+	// like the daemon, a quiet load only answers once the layers are loaded, otherwise their progress is streamed
+	quiet := req.URL.Query().Get("quiet") == "1"
+	if !quiet {
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(map[string]string{"status": "Loading layer"})
+		if flusher, ok := rw.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	time.Sleep(delay)
+
+	h.sim.Lock()
+	defer h.sim.Unlock()
+	if quiet {
+		rw.WriteHeader(http.StatusOK)
+	}
+	for _, image := range manifest {
+		for _, tag := range image.RepoTags {
+			h.images[tag] = true
+			h.sim.record("[%s] load image [%s]", h.address, tag)
+			json.NewEncoder(rw).Encode(map[string]string{"stream": "Loaded image: " + tag + "\n"})
+		}
+	}
+}
+
+func (h *simHost) serveContainer(rw http.ResponseWriter, req *http.Request, path string) {
+	parts := strings.SplitN(path, "/", 2)
+	c := h.getContainer(parts[0])
+	if c == nil {
+		writeDockerError(rw, http.StatusNotFound, "No such container: "+parts[0])
+		return
+	}
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	switch {
+	case action == "" && req.Method == http.MethodDelete:
+		delete(h.containers, c.name)
+		h.sim.record("[%s] remove container [%s]", h.address, c.name)
+		rw.WriteHeader(http.StatusNoContent)
+	case action == "json":
+		writeJSON(rw, http.StatusOK, c.inspect())
+	case action == "start":
+		h.startContainer(c)
+		rw.WriteHeader(http.StatusNoContent)
+	case action == "restart":
+		h.startContainer(c)
+		h.sim.record("[%s] restart container [%s]", h.address, c.name)
+		rw.WriteHeader(http.StatusNoContent)
+	case action == "stop":
+		c.running = false
+		h.sim.record("[%s] stop container [%s]", h.address, c.name)
+		rw.WriteHeader(http.StatusNoContent)
+	case action == "rename":
+		newName := req.URL.Query().Get("name")
+		delete(h.containers, c.name)
+		h.sim.record("[%s] rename container [%s] to [%s]", h.address, c.name, newName)
+		c.name = newName
+		h.containers[newName] = c
+		rw.WriteHeader(http.StatusNoContent)
+	case action == "logs":
+		// the simulated containers don't log anything, an empty log passes the port checks
+		rw.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+		rw.WriteHeader(http.StatusOK)
+	default:
+		writeDockerError(rw, http.StatusNotFound, fmt.Sprintf("page not found: %s", action))
+	}
+}
+
+func (h *simHost) listContainers(rw http.ResponseWriter, req *http.Request) {
+	all := req.URL.Query().Get("all") == "1"
+	containers := []types.Container{}
+	for _, c := range h.containers {
+		if !all && !c.running {
+			continue
+		}
+		containers = append(containers, types.Container{
+			ID:      c.id,
+			Names:   []string{"/" + c.name},
+			Image:   c.config.Image,
+			Created: c.created.Unix(),
+			State:   c.state(),
+		})
+	}
+	writeJSON(rw, http.StatusOK, containers)
+}
+
+func (h *simHost) createContainer(rw http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("name")
+	if h.getContainer(name) != nil {
+		writeDockerError(rw, http.StatusConflict, fmt.Sprintf("Conflict. The container name \"/%s\" is already in use", name))
+		return
+	}
+	body := configWrapper{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeDockerError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.Config == nil {
+		body.Config = &container.Config{}
+	}
+	if body.HostConfig == nil {
+		body.HostConfig = &container.HostConfig{}
+	}
+	c := &simContainer{
+		id:         h.sim.newID(),
+		name:       name,
+		created:    time.Now(),
+		config:     body.Config,
+		hostConfig: body.HostConfig,
+	}
+	h.containers[name] = c
+	h.sim.record("[%s] create container [%s] with image [%s]", h.address, name, c.config.Image)
+	writeJSON(rw, http.StatusCreated, container.ContainerCreateCreatedBody{ID: c.id})
+}
+
+// startContainer runs a container, the one-time containers don't have a restart policy and exit right away
+func (h *simHost) startContainer(c *simContainer) {
+	c.running = len(c.hostConfig.RestartPolicy.Name) > 0 && c.hostConfig.RestartPolicy.Name != "no"
+	if !c.running {
+		return
+	}
+	switch c.name {
+	case kubeletContainerName:
+		h.sim.registerNode(h.address, c.args())
+	case etcdContainerName:
+		h.sim.startEtcdMember(c.args())
+	}
+}
+
+func (c *simContainer) args() []string {
+	return append(append([]string{}, c.config.Entrypoint...), c.config.Cmd...)
+}
+
+func (c *simContainer) state() string {
+	if c.running {
+		return "running"
+	}
+	return "exited"
+}
+
+func (c *simContainer) inspect() types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:      c.id,
+			Created: c.created.Format(time.RFC3339Nano),
+			Name:    "/" + c.name,
+			Image:   c.config.Image,
+			State: &types.ContainerState{
+				Status:  c.state(),
+				Running: c.running,
+			},
+			HostConfig: c.hostConfig,
+		},
+		Config: c.config,
+	}
+}
+
+// getArg returns the value of a --name=value argument
+func getArg(args []string, name string) string {
+	prefix := "--" + name + "="
+	for _, arg := range args {
+		if strings.HasPrefix(arg, prefix) {
+			return strings.TrimPrefix(arg, prefix)
+		}
+	}
+	return ""
+}
+
+func writeJSON(rw http.ResponseWriter, status int, obj interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(obj)
+}
+
+func writeDockerError(rw http.ResponseWriter, status int, message string) {
+	writeJSON(rw, status, types.ErrorResponse{Message: message})
+}
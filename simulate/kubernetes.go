@@ -0,0 +1,311 @@
+package simulate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+const (
+	simulatedKubernetesVersion = "v1.14.3"
+	addonDeployJobSuffix       = "-deploy-job"
+	addonDeleteJobSuffix       = "-delete-job"
+	hostnameLabel              = "kubernetes.io/hostname"
+)
+
+// kubeKey identifies an object, the API path is /api/v1 for the core group and /apis/group/version otherwise
+type kubeKey struct {
+	apiPath   string
+	resource  string
+	namespace string
+	name      string
+}
+
+type kubeRequest struct {
+	kubeKey
+	apiVersion  string
+	group       string
+	subresource string
+}
+
+type kubeTransport struct {
+	sim *Simulator
+}
+
+func (s *Simulator) wrapTransport(rt http.RoundTripper) http.RoundTripper {
+	return &kubeTransport{sim: s}
+}
+
+func (t *kubeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rw := httptest.NewRecorder()
+	t.sim.serveKubernetes(rw, req)
+	resp := rw.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func (s *Simulator) serveKubernetes(rw http.ResponseWriter, req *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	if req.URL.Path == "/version" {
+		writeJSON(rw, http.StatusOK, version.Info{Major: "1", Minor: "14", GitVersion: simulatedKubernetesVersion})
+		return
+	}
+	kubeReq, err := parseKubeRequest(req.URL.Path)
+	if err != nil {
+		writeKubeError(rw, apierrors.NewBadRequest(err.Error()))
+		return
+	}
+	existing, exists := s.kubeObjects[kubeReq.kubeKey]
+	switch {
+	case req.Method == http.MethodGet && len(kubeReq.name) == 0:
+		writeJSON(rw, http.StatusOK, s.listKubeObjects(kubeReq))
+	case req.Method == http.MethodGet:
+		if !exists {
+			writeKubeError(rw, newNotFound(kubeReq))
+			return
+		}
+		writeJSON(rw, http.StatusOK, existing)
+	case req.Method == http.MethodPost && kubeReq.subresource == "eviction":
+		if !exists {
+			writeKubeError(rw, newNotFound(kubeReq))
+			return
+		}
+		delete(s.kubeObjects, kubeReq.kubeKey)
+		s.record("[k8s] evict %s", kubeReq.objectName())
+		writeJSON(rw, http.StatusCreated, metav1.Status{TypeMeta: statusTypeMeta, Status: metav1.StatusSuccess})
+	case req.Method == http.MethodPost || req.Method == http.MethodPut:
+		obj := map[string]interface{}{}
+		if err := json.NewDecoder(req.Body).Decode(&obj); err != nil {
+			writeKubeError(rw, apierrors.NewBadRequest(err.Error()))
+			return
+		}
+		metadata, _ := obj["metadata"].(map[string]interface{})
+		if metadata == nil {
+			writeKubeError(rw, apierrors.NewBadRequest("object metadata is missing"))
+			return
+		}
+		if req.Method == http.MethodPost {
+			kubeReq.name, _ = metadata["name"].(string)
+		}
+		existing, exists = s.kubeObjects[kubeReq.kubeKey]
+		if req.Method == http.MethodPost && exists {
+			writeKubeError(rw, apierrors.NewAlreadyExists(schema.GroupResource{Group: kubeReq.group, Resource: kubeReq.resource}, kubeReq.name))
+			return
+		}
+		if req.Method == http.MethodPut && !exists {
+			writeKubeError(rw, newNotFound(kubeReq))
+			return
+		}
+		if len(kubeReq.namespace) > 0 {
+			metadata["namespace"] = kubeReq.namespace
+		}
+		s.nextID++
+		metadata["resourceVersion"] = strconv.Itoa(s.nextID)
+		obj["apiVersion"] = kubeReq.apiVersion
+		if kubeReq.resource == "jobs" {
+			// jobs complete right away, the addon jobs apply the manifest of the addon config map
+			obj["status"] = map[string]interface{}{
+				"conditions": []interface{}{map[string]interface{}{"type": "Complete", "status": "True"}},
+			}
+			s.recordAddonJob(kubeReq.name)
+		}
+		s.kubeObjects[kubeReq.kubeKey] = obj
+		status := http.StatusOK
+		if req.Method == http.MethodPost {
+			s.record("[k8s] create %s", kubeReq.objectName())
+			status = http.StatusCreated
+		} else {
+			s.record("[k8s] update %s", kubeReq.objectName())
+		}
+		writeJSON(rw, status, obj)
+	case req.Method == http.MethodDelete && len(kubeReq.name) > 0:
+		if !exists {
+			writeKubeError(rw, newNotFound(kubeReq))
+			return
+		}
+		delete(s.kubeObjects, kubeReq.kubeKey)
+		s.record("[k8s] delete %s", kubeReq.objectName())
+		writeJSON(rw, http.StatusOK, metav1.Status{TypeMeta: statusTypeMeta, Status: metav1.StatusSuccess})
+	default:
+		writeKubeError(rw, apierrors.NewMethodNotSupported(schema.GroupResource{Group: kubeReq.group, Resource: kubeReq.resource}, req.Method))
+	}
+}
+
+// registerNode adds the node of a started kubelet the way the kubelet registers itself.
+// The simulator lock must be held.
+func (s *Simulator) registerNode(address string, kubeletArgs []string) {
+	hostname := getArg(kubeletArgs, "hostname-override")
+	if len(hostname) == 0 {
+		hostname = address
+	}
+	key := kubeKey{apiPath: "/api/v1", resource: "nodes", name: hostname}
+	if _, ok := s.kubeObjects[key]; ok {
+		return
+	}
+	taints := []interface{}{}
+	if registerTaints := getArg(kubeletArgs, "register-with-taints"); len(registerTaints) > 0 {
+		for _, taint := range strings.Split(registerTaints, ",") {
+			keyValue, effect := taint, ""
+			if i := strings.LastIndex(taint, ":"); i >= 0 {
+				keyValue, effect = taint[:i], taint[i+1:]
+			}
+			keyValueParts := strings.SplitN(keyValue, "=", 2)
+			taintObj := map[string]interface{}{"key": keyValueParts[0], "effect": effect}
+			if len(keyValueParts) == 2 {
+				taintObj["value"] = keyValueParts[1]
+			}
+			taints = append(taints, taintObj)
+		}
+	}
+	s.nextID++
+	s.kubeObjects[key] = map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Node",
+		"metadata": map[string]interface{}{
+			"name":            hostname,
+			"resourceVersion": strconv.Itoa(s.nextID),
+			"labels":          map[string]interface{}{hostnameLabel: hostname},
+			"annotations":     map[string]interface{}{"volumes.kubernetes.io/controller-managed-attach-detach": "true"},
+		},
+		"spec": map[string]interface{}{"taints": taints},
+		"status": map[string]interface{}{
+			"addresses":  []interface{}{map[string]interface{}{"type": "InternalIP", "address": address}},
+			"conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "True"}},
+		},
+	}
+	s.record("[k8s] register node [%s]", hostname)
+}
+
+// recordAddonJob records the objects of the addon manifest applied or deleted by an addon job,
+// the simulator lock must be held
+func (s *Simulator) recordAddonJob(jobName string) {
+	action, addonName := "", ""
+	switch {
+	case strings.HasSuffix(jobName, addonDeployJobSuffix):
+		action, addonName = "apply", strings.TrimSuffix(jobName, addonDeployJobSuffix)
+	case strings.HasSuffix(jobName, addonDeleteJobSuffix):
+		action, addonName = "delete", strings.TrimSuffix(jobName, addonDeleteJobSuffix)
+	default:
+		return
+	}
+	configMap, ok := s.kubeObjects[kubeKey{apiPath: "/api/v1", resource: "configmaps", namespace: metav1.NamespaceSystem, name: addonName}]
+	if !ok {
+		return
+	}
+	data, _ := configMap["data"].(map[string]interface{})
+	manifest, _ := data[addonName].(string)
+	decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader([]byte(manifest)), 4096)
+	for {
+		obj := map[string]interface{}{}
+		if err := decoder.Decode(&obj); err != nil {
+			if err != io.EOF {
+				s.record("[k8s] %s addon [%s]: invalid manifest: %v", action, addonName, err)
+			}
+			return
+		}
+		items, _ := obj["items"].([]interface{})
+		if len(items) == 0 {
+			items = []interface{}{obj}
+		}
+		for _, item := range items {
+			if itemObj, ok := item.(map[string]interface{}); ok && len(itemObj) > 0 {
+				s.record("[k8s] %s %s from addon [%s]", action, describeObject(itemObj), addonName)
+			}
+		}
+	}
+}
+
+func (s *Simulator) listKubeObjects(kubeReq kubeRequest) map[string]interface{} {
+	keys := []kubeKey{}
+	for key := range s.kubeObjects {
+		if key.apiPath == kubeReq.apiPath && key.resource == kubeReq.resource &&
+			(len(kubeReq.namespace) == 0 || key.namespace == kubeReq.namespace) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].namespace+"/"+keys[i].name < keys[j].namespace+"/"+keys[j].name
+	})
+	items := []interface{}{}
+	for _, key := range keys {
+		items = append(items, s.kubeObjects[key])
+	}
+	return map[string]interface{}{
+		"apiVersion": kubeReq.apiVersion,
+		"metadata":   map[string]interface{}{"resourceVersion": strconv.Itoa(s.nextID)},
+		"items":      items,
+	}
+}
+
+// parseKubeRequest splits an API path like /apis/batch/v1/namespaces/kube-system/jobs/name
+func parseKubeRequest(path string) (kubeRequest, error) {
+	kubeReq := kubeRequest{}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(segments) >= 3 && segments[0] == "api":
+		kubeReq.apiPath = "/api/" + segments[1]
+		kubeReq.apiVersion = segments[1]
+		segments = segments[2:]
+	case len(segments) >= 4 && segments[0] == "apis":
+		kubeReq.apiPath = "/apis/" + segments[1] + "/" + segments[2]
+		kubeReq.apiVersion = segments[1] + "/" + segments[2]
+		kubeReq.group = segments[1]
+		segments = segments[3:]
+	default:
+		return kubeReq, fmt.Errorf("the server could not find the requested resource [%s]", path)
+	}
+	if segments[0] == "namespaces" && len(segments) >= 3 {
+		kubeReq.namespace = segments[1]
+		segments = segments[2:]
+	}
+	kubeReq.resource = segments[0]
+	if len(segments) > 1 {
+		kubeReq.name = segments[1]
+	}
+	if len(segments) > 2 {
+		kubeReq.subresource = segments[2]
+	}
+	return kubeReq, nil
+}
+
+func (r kubeRequest) objectName() string {
+	if len(r.namespace) > 0 {
+		return fmt.Sprintf("%s [%s/%s]", r.resource, r.namespace, r.name)
+	}
+	return fmt.Sprintf("%s [%s]", r.resource, r.name)
+}
+
+func describeObject(obj map[string]interface{}) string {
+	kind, _ := obj["kind"].(string)
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	if namespace, _ := metadata["namespace"].(string); len(namespace) > 0 {
+		return fmt.Sprintf("%s [%s/%s]", kind, namespace, name)
+	}
+	return fmt.Sprintf("%s [%s]", kind, name)
+}
+
+var statusTypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+
+func newNotFound(kubeReq kubeRequest) *apierrors.StatusError {
+	return apierrors.NewNotFound(schema.GroupResource{Group: kubeReq.group, Resource: kubeReq.resource}, kubeReq.name)
+}
+
+func writeKubeError(rw http.ResponseWriter, err *apierrors.StatusError) {
+	status := err.ErrStatus
+	status.TypeMeta = statusTypeMeta
+	writeJSON(rw, int(status.Code), status)
+}
@@ -0,0 +1,65 @@
+package simulate
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// pipeListener hands in-memory connections to an http server, each dial returns the client end of a pipe
+type pipeListener struct {
+	tlsConfig *tls.Config
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// servePipe serves the handler on in-memory connections, TLS is served as well when a TLS config is given
+func servePipe(handler http.Handler, tlsConfig *tls.Config) *pipeListener {
+	l := &pipeListener{
+		tlsConfig: tlsConfig,
+		conns:     make(chan net.Conn),
+		closed:    make(chan struct{}),
+	}
+	go http.Serve(l, handler)
+	return l
+}
+
+func (l *pipeListener) Dial(network, address string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("Failed to dial [%s]: simulated host is closed", address)
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		if l.tlsConfig != nil {
+			return &sniffConn{Conn: conn, reader: bufio.NewReader(conn), tlsConfig: l.tlsConfig}, nil
+		}
+		return conn, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("simulated host is closed")
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
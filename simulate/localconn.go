@@ -0,0 +1,150 @@
+package simulate
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	etcdclient "github.com/coreos/etcd/client"
+)
+
+const tlsHandshakeRecordType = 0x16
+
+// serveLocalConn serves the local connection of a host, it answers the healthchecks of all the
+// services and the etcd members API over plain HTTP or TLS
+func serveLocalConn(h *simHost) *pipeListener {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/health", func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, map[string]string{"health": "true"})
+	})
+	mux.HandleFunc("/v2/members", h.sim.serveEtcdMembers)
+	mux.HandleFunc("/v2/members/", h.sim.serveEtcdMembers)
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{h.sim.cert}}
+	return servePipe(mux, tlsConfig)
+}
+
+func (s *Simulator) serveEtcdMembers(rw http.ResponseWriter, req *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	memberID := strings.Trim(strings.TrimPrefix(req.URL.Path, "/v2/members"), "/")
+	switch {
+	case req.Method == http.MethodGet && len(memberID) == 0:
+		members := []etcdclient.Member{}
+		for _, member := range s.etcdMembers {
+			members = append(members, *member)
+		}
+		writeJSON(rw, http.StatusOK, map[string][]etcdclient.Member{"members": members})
+	case req.Method == http.MethodPost && len(memberID) == 0:
+		addRequest := struct {
+			PeerURLs []string `json:"peerURLs"`
+		}{}
+		if err := json.NewDecoder(req.Body).Decode(&addRequest); err != nil || len(addRequest.PeerURLs) == 0 {
+			writeJSON(rw, http.StatusBadRequest, map[string]string{"message": "invalid member add request"})
+			return
+		}
+		if s.getEtcdMember(addRequest.PeerURLs[0]) != nil {
+			writeJSON(rw, http.StatusConflict, map[string]string{"message": "peerURL exists"})
+			return
+		}
+		member := s.addEtcdMember("", addRequest.PeerURLs[0], "")
+		writeJSON(rw, http.StatusCreated, member)
+	case req.Method == http.MethodDelete && len(memberID) > 0:
+		for i, member := range s.etcdMembers {
+			if member.ID == memberID {
+				s.etcdMembers = append(s.etcdMembers[:i], s.etcdMembers[i+1:]...)
+				s.record("[etcd] remove member [%s] with peer URL [%s]", member.Name, strings.Join(member.PeerURLs, ","))
+				rw.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		writeJSON(rw, http.StatusNotFound, map[string]string{"message": "member not found"})
+	default:
+		writeJSON(rw, http.StatusMethodNotAllowed, map[string]string{"message": "method not allowed"})
+	}
+}
+
+// startEtcdMember names the member of a started etcd container, the member is added if it's a new cluster.
+// The simulator lock must be held.
+func (s *Simulator) startEtcdMember(args []string) {
+	name := getArg(args, "name")
+	peerURL := getArg(args, "initial-advertise-peer-urls")
+	clientURL := getArg(args, "advertise-client-urls")
+	if member := s.getEtcdMember(peerURL); member != nil {
+		member.Name = name
+		member.ClientURLs = strings.Split(clientURL, ",")
+		return
+	}
+	s.addEtcdMember(name, peerURL, clientURL)
+}
+
+// addEtcdMember adds a member to the simulated etcd cluster, the simulator lock must be held
+func (s *Simulator) addEtcdMember(name, peerURL, clientURL string) *etcdclient.Member {
+	member := &etcdclient.Member{
+		ID:       s.newID()[48:],
+		Name:     name,
+		PeerURLs: []string{peerURL},
+	}
+	if len(clientURL) > 0 {
+		member.ClientURLs = strings.Split(clientURL, ",")
+	}
+	s.etcdMembers = append(s.etcdMembers, member)
+	s.record("[etcd] add member [%s] with peer URL [%s]", name, peerURL)
+	return member
+}
+
+// getEtcdMember returns the member with a peer URL, the simulator lock must be held
+func (s *Simulator) getEtcdMember(peerURL string) *etcdclient.Member {
+	for _, member := range s.etcdMembers {
+		for _, url := range member.PeerURLs {
+			if url == peerURL {
+				return member
+			}
+		}
+	}
+	return nil
+}
+
+// sniffConn serves TLS when the client starts with a handshake and plain HTTP otherwise,
+// the healthchecks use both on the same dialer
+type sniffConn struct {
+	net.Conn
+	reader    *bufio.Reader
+	tlsConfig *tls.Config
+	once      sync.Once
+	conn      net.Conn
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *sniffConn) detect() {
+	c.once.Do(func() {
+		c.conn = &bufferedConn{Conn: c.Conn, reader: c.reader}
+		if b, err := c.reader.Peek(1); err == nil && b[0] == tlsHandshakeRecordType {
+			c.conn = tls.Server(c.conn, c.tlsConfig)
+		}
+	})
+}
+
+func (c *sniffConn) Read(b []byte) (int, error) {
+	c.detect()
+	return c.conn.Read(b)
+}
+
+func (c *sniffConn) Write(b []byte) (int, error) {
+	c.detect()
+	return c.conn.Write(b)
+}
@@ -0,0 +1,133 @@
+package simulate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	etcdclient "github.com/coreos/etcd/client"
+	"github.com/rancher/rke/hosts"
+)
+
+// Simulator fakes the cluster hosts and the Kubernetes API in memory. Every host gets a fake Docker API
+// and a fake local connection serving the healthchecks and the etcd members API, the Kubernetes client
+// is pointed to an in-memory object store. All the changes done to the fakes are recorded in the action log.
+type Simulator struct {
+	sync.Mutex
+	hosts       map[string]*simHost
+	etcdMembers []*etcdclient.Member
+	kubeObjects map[kubeKey]map[string]interface{}
+	actions     []string
+	cert        tls.Certificate
+	nextID      int
+	// imageLoadDelay is how long the image loads take once the archive is received
+	imageLoadDelay time.Duration
+}
+
+// NewSimulator returns a simulator with no containers, etcd members or Kubernetes objects
+func NewSimulator() (*Simulator, error) {
+	cert, err := newServingCertificate()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate simulator serving certificate: %v", err)
+	}
+	return &Simulator{
+		hosts:       map[string]*simHost{},
+		kubeObjects: map[kubeKey]map[string]interface{}{},
+		cert:        cert,
+	}, nil
+}
+
+// DialersOptions returns the dialers to use so the cluster operations run against the simulator
+func (s *Simulator) DialersOptions() hosts.DialersOptions {
+	return hosts.GetDialerOptions(s.dockerDialerFactory, s.localConnDialerFactory, s.wrapTransport)
+}
+
+// SetImageLoadDelay makes the image loads take delay once the archive is received, like the daemon loading
+// the layers of large images
+func (s *Simulator) SetImageLoadDelay(delay time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.imageLoadDelay = delay
+}
+
+// Actions returns the recorded actions in the order they happened
+func (s *Simulator) Actions() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string{}, s.actions...)
+}
+
+// ResetActions clears the action log, the simulated hosts and Kubernetes objects are kept
+func (s *Simulator) ResetActions() {
+	s.Lock()
+	defer s.Unlock()
+	s.actions = nil
+}
+
+// Close stops the fake servers of the simulated hosts
+func (s *Simulator) Close() {
+	s.Lock()
+	defer s.Unlock()
+	for _, host := range s.hosts {
+		host.close()
+	}
+}
+
+// record adds an action to the log, the simulator lock must be held
+func (s *Simulator) record(format string, args ...interface{}) {
+	s.actions = append(s.actions, fmt.Sprintf(format, args...))
+}
+
+// newID returns a unique hex id, the simulator lock must be held
+func (s *Simulator) newID() string {
+	s.nextID++
+	return fmt.Sprintf("%064x", s.nextID)
+}
+
+// getHost returns the simulated host for an address, it's created on first use
+func (s *Simulator) getHost(address string) *simHost {
+	s.Lock()
+	defer s.Unlock()
+	host, ok := s.hosts[address]
+	if !ok {
+		host = newSimHost(s, address)
+		s.hosts[address] = host
+	}
+	return host
+}
+
+func (s *Simulator) dockerDialerFactory(h *hosts.Host) (func(network, address string) (net.Conn, error), error) {
+	return s.getHost(h.Address).docker.Dial, nil
+}
+
+func (s *Simulator) localConnDialerFactory(h *hosts.Host) (func(network, address string) (net.Conn, error), error) {
+	return s.getHost(h.Address).localConn.Dial, nil
+}
+
+func newServingCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rke-simulator"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: key}, nil
+}
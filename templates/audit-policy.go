@@ -0,0 +1,12 @@
+package templates
+
+const (
+	AuditPolicy = `
+apiVersion: audit.k8s.io/v1beta1
+kind: Policy
+omitStages:
+- RequestReceived
+rules:
+- level: Metadata
+`
+)
@@ -1,6 +1,7 @@
 package templates
 
 const (
+	// SystemNodeClusterRoleBinding is the upstream default without subjects, kubelets are authorized by the Node authorizer
 	SystemNodeClusterRoleBinding = `
 apiVersion: rbac.authorization.k8s.io/v1
 kind: ClusterRoleBinding
@@ -13,10 +14,74 @@ metadata:
 roleRef:
   apiGroup: rbac.authorization.k8s.io
   kind: ClusterRole
-  name: system:node
+  name: system:node`
+
+	KubeletBootstrapClusterRoleBinding = `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: rke-kubelet-bootstrap
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: system:node-bootstrapper
+subjects:
+- kind: Group
+  name: system:bootstrappers
+  apiGroup: rbac.authorization.k8s.io`
+
+	KubeletRenewApproveClusterRoleBinding = `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: rke-kubelet-renew-approve
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: system:certificates.k8s.io:certificatesigningrequests:selfnodeclient
 subjects:
 - kind: Group
   name: system:nodes
+  apiGroup: rbac.authorization.k8s.io`
+
+	CNIClusterRole = `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: rke-cni
+rules:
+- apiGroups: [""]
+  resources:
+  - pods
+  verbs:
+  - get
+  - patch
+- apiGroups: [""]
+  resources:
+  - namespaces
+  - nodes
+  verbs:
+  - get
+- apiGroups: ["crd.projectcalico.org"]
+  resources:
+  - clusterinformations
+  - ippools
+  verbs:
+  - get
+  - list`
+
+	CNIClusterRoleBinding = `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: rke-cni
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: rke-cni
+subjects:
+- kind: User
+  name: system:kube-cni
   apiGroup: rbac.authorization.k8s.io`
 
 	JobDeployerServiceAccount = `
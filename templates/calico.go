@@ -92,9 +92,6 @@ subjects:
 - kind: ServiceAccount
   name: calico-node
   namespace: kube-system
-- apiGroup: rbac.authorization.k8s.io
-  kind: Group
-  name: system:nodes
 {{end}}
 ## end rbac here
 
@@ -172,6 +169,8 @@ spec:
         # priority scheduling and that its resources are reserved
         # if it ever gets evicted.
         scheduler.alpha.kubernetes.io/critical-pod: ''
+        # the CNI config is only written when the pods start, a kubeconfig change has to roll them
+        rke.cattle.io/cni-kubeconfig: "{{.KubeCfg}}"
     spec:
       affinity:
         nodeAffinity:
@@ -642,9 +641,6 @@ subjects:
 - kind: ServiceAccount
   name: calico-node
   namespace: kube-system
-- apiGroup: rbac.authorization.k8s.io
-  kind: Group
-  name: system:nodes
 {{end}}
 ## end rbac here
 
@@ -727,6 +723,8 @@ spec:
         # priority scheduling and that its resources are reserved
         # if it ever gets evicted.
         scheduler.alpha.kubernetes.io/critical-pod: ''
+        # the CNI config is only written when the pods start, a kubeconfig change has to roll them
+        rke.cattle.io/cni-kubeconfig: "{{.KubeCfg}}"
     spec:
       affinity:
         nodeAffinity:
@@ -1166,9 +1164,6 @@ subjects:
 - kind: ServiceAccount
   name: calico-kube-controllers
   namespace: kube-system
-- apiGroup: rbac.authorization.k8s.io
-  kind: Group
-  name: system:nodes
 ---
 # Include a clusterrole for the calico-node DaemonSet,
 # and bind it to the calico-node serviceaccount.
@@ -1314,9 +1309,6 @@ subjects:
 - kind: ServiceAccount
   name: calico-node
   namespace: kube-system
-- apiGroup: rbac.authorization.k8s.io
-  kind: Group
-  name: system:nodes
 {{end}}
 ---
 # Source: calico/templates/calico-config.yaml
@@ -1578,6 +1570,8 @@ spec:
         # priority scheduling and that its resources are reserved
         # if it ever gets evicted.
         scheduler.alpha.kubernetes.io/critical-pod: ''
+        # the CNI config is only written when the pods start, a kubeconfig change has to roll them
+        rke.cattle.io/cni-kubeconfig: "{{.KubeCfg}}"
     spec:
       nodeSelector:
         beta.kubernetes.io/os: linux
@@ -132,9 +132,6 @@ subjects:
 - kind: ServiceAccount
   name: canal
   namespace: kube-system
-- apiGroup: rbac.authorization.k8s.io
-  kind: Group
-  name: system:nodes
 {{end}}
 
 # Canal Version v3.1.1
@@ -230,6 +227,8 @@ spec:
         k8s-app: canal
       annotations:
         scheduler.alpha.kubernetes.io/critical-pod: ''
+        # the CNI config is only written when the pods start, a kubeconfig change has to roll them
+        rke.cattle.io/cni-kubeconfig: "{{.KubeCfg}}"
     spec:
       affinity:
         nodeAffinity:
@@ -668,9 +667,6 @@ subjects:
 - kind: ServiceAccount
   name: calico-node
   namespace: kube-system
-- apiGroup: rbac.authorization.k8s.io
-  kind: Group
-  name: system:nodes
 ---
 # Flannel ClusterRole
 # Pulled from https://github.com/coreos/flannel/blob/master/Documentation/kube-flannel-rbac.yml
@@ -726,9 +722,6 @@ subjects:
 - kind: ServiceAccount
   name: canal
   namespace: kube-system
-- apiGroup: rbac.authorization.k8s.io
-  kind: Group
-  name: system:nodes
 {{end}}
 
 # Canal Version v3.1.1
@@ -824,6 +817,8 @@ spec:
         # priority scheduling and that its resources are reserved
         # if it ever gets evicted.
         scheduler.alpha.kubernetes.io/critical-pod: ''
+        # the CNI config is only written when the pods start, a kubeconfig change has to roll them
+        rke.cattle.io/cni-kubeconfig: "{{.KubeCfg}}"
     spec:
       affinity:
         nodeAffinity:
@@ -1269,9 +1264,6 @@ subjects:
 - kind: ServiceAccount
   name: calico-node
   namespace: kube-system
-- apiGroup: rbac.authorization.k8s.io
-  kind: Group
-  name: system:nodes
 ---
 # Flannel ClusterRole
 # Pulled from https://github.com/coreos/flannel/blob/master/Documentation/kube-flannel-rbac.yml
@@ -1327,9 +1319,6 @@ subjects:
 - kind: ServiceAccount
   name: canal
   namespace: kube-system
-- apiGroup: rbac.authorization.k8s.io
-  kind: Group
-  name: system:nodes
 {{end}}
 
 # Canal Version v3.1.1
@@ -1425,6 +1414,8 @@ spec:
         # priority scheduling and that its resources are reserved
         # if it ever gets evicted.
         scheduler.alpha.kubernetes.io/critical-pod: ''
+        # the CNI config is only written when the pods start, a kubeconfig change has to roll them
+        rke.cattle.io/cni-kubeconfig: "{{.KubeCfg}}"
     spec:
       affinity:
         nodeAffinity:
@@ -0,0 +1,140 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rancher/types/apis/management.cattle.io/v3"
+)
+
+const (
+	s3DefaultRegion     = "us-east-1"
+	s3RequestTimeout    = 30 * time.Second
+	s3SigningAlgorithm  = "AWS4-HMAC-SHA256"
+	s3AmzDateTimeFormat = "20060102T150405Z"
+	s3AmzDateFormat     = "20060102"
+)
+
+// DoS3Request sends a path style request signed with AWS signature version 4, the extra headers are not signed
+func DoS3Request(ctx context.Context, s3Backend *v3.S3BackupConfig, method, objectKey string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	if len(s3Backend.Endpoint) == 0 || len(s3Backend.BucketName) == 0 {
+		return nil, fmt.Errorf("invalid s3 configurations, endpoint and bucket name are required")
+	}
+	region := s3Backend.Region
+	if len(region) == 0 {
+		region = s3DefaultRegion
+	}
+	endpoint := s3Backend.Endpoint
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "https://" + endpoint
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse s3 endpoint [%s]: %v", s3Backend.Endpoint, err)
+	}
+	endpointURL.Path = "/" + s3Backend.BucketName + "/" + objectKey
+	endpointURL.RawPath = "/" + s3URIEncode(s3Backend.BucketName) + "/" + s3URIEncode(objectKey)
+	// SigV4 wants spaces encoded as %20, url.Values uses +
+	endpointURL.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+
+	req, err := http.NewRequest(method, endpointURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := sha256.Sum256(body)
+	signS3Request(req, s3Backend.AccessKey, s3Backend.SecretKey, region, hex.EncodeToString(payloadHash[:]), time.Now())
+
+	httpClient, err := getS3HTTPClient(s3Backend)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to s3 endpoint [%s]: %v", s3Backend.Endpoint, err)
+	}
+	return resp, nil
+}
+
+// signS3Request signs the host, x-amz-content-sha256 and x-amz-date headers of the request with AWS signature version 4,
+// the query of the request must already be sorted and encoded
+func signS3Request(req *http.Request, accessKey, secretKey, region, payloadHashHex string, signTime time.Time) {
+	signTime = signTime.UTC()
+	amzDateTime := signTime.Format(s3AmzDateTimeFormat)
+	req.Header.Set("x-amz-date", amzDateTime)
+	req.Header.Set("x-amz-content-sha256", payloadHashHex)
+
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, payloadHashHex, amzDateTime)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonicalHeaders, signedHeaders, payloadHashHex}, "\n")
+	scope := []string{signTime.Format(s3AmzDateFormat), region, "s3", "aws4_request"}
+	credentialScope := strings.Join(scope, "/")
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{s3SigningAlgorithm, amzDateTime, credentialScope, hex.EncodeToString(canonicalRequestHash[:])}, "\n")
+
+	signature := hex.EncodeToString(hmacSHA256(getS3SigningKey(secretKey, scope), stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", s3SigningAlgorithm, accessKey, credentialScope, signedHeaders, signature))
+}
+
+// getS3SigningKey derives the signing key of the credential scope, the date, region, service and terminator
+func getS3SigningKey(secretKey string, scope []string) []byte {
+	signingKey := []byte("AWS4" + secretKey)
+	for _, scopePart := range scope {
+		signingKey = hmacSHA256(signingKey, scopePart)
+	}
+	return signingKey
+}
+
+func getS3HTTPClient(s3Backend *v3.S3BackupConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if len(s3Backend.EndpointCA) > 0 {
+		certPool, err := x509.SystemCertPool()
+		if err != nil {
+			certPool = x509.NewCertPool()
+		}
+		if !certPool.AppendCertsFromPEM([]byte(s3Backend.EndpointCA)) {
+			return nil, fmt.Errorf("Failed to parse s3 endpoint CA certificate")
+		}
+		tlsConfig.RootCAs = certPool
+	}
+	return &http.Client{
+		Timeout: s3RequestTimeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3URIEncode encodes everything but the unreserved characters of RFC 3986, keeping slashes
+func s3URIEncode(path string) string {
+	var encoded strings.Builder
+	for _, b := range []byte(path) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' || b == '/' {
+			encoded.WriteByte(b)
+			continue
+		}
+		fmt.Fprintf(&encoded, "%%%02X", b)
+	}
+	return encoded.String()
+}